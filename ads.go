@@ -2,19 +2,45 @@ package adscraper
 
 import (
 	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/gkats/adscraper/brands"
+	"github.com/gkats/adscraper/compliance"
+	"github.com/gkats/adscraper/db"
 	"github.com/gkats/adscraper/keywords"
+	"github.com/gkats/adscraper/webhooks"
+)
+
+// Blocks of the results page ads are shown in.
+const (
+	BlockTop    = "top"
+	BlockBottom = "bottom"
+)
+
+// Limits on the ad fields posted by scrapers.
+const (
+	MaxHeadlineLength = 255
+	MaxPathLength     = 255
+	MaxDescLength     = 1000
 )
 
 type Ad struct {
-	ID        int64
-	H1        string
-	H2        string
+	ID int64
+	H1 string
+	H2 string
+	// Headlines are the headlines of ads posted as a list, the v2 way. H2 has
+	// the ones after the first joined with " - ".
+	Headlines []string
 	Path      string
 	Desc      string
 	Rest      sql.NullString
 	Raw       sql.NullString
 	Position  int
+	Block     string
+	PageId    int64
 	CreatedAt string
 	UpdatedAt string
 }
@@ -30,6 +56,24 @@ func (ad *Ad) SetRaw(s string) {
 	ad.Raw = sql.NullString{String: s, Valid: true}
 }
 
+// headlinesColumn returns the headlines column of the ad, NULL when it wasn't
+// posted with a list of headlines.
+func (ad *Ad) headlinesColumn() sql.NullString {
+	if len(ad.Headlines) == 0 {
+		return sql.NullString{}
+	}
+	b, _ := json.Marshal(ad.Headlines)
+	return sql.NullString{String: string(b), Valid: true}
+}
+
+func (ad *Ad) setHeadlines(column sql.NullString) error {
+	ad.Headlines = nil
+	if !column.Valid {
+		return nil
+	}
+	return json.Unmarshal([]byte(column.String), &ad.Headlines)
+}
+
 func (ad *Ad) GetRest() string {
 	if ad.Rest.Valid {
 		return ad.Rest.String
@@ -41,6 +85,24 @@ func (ad *Ad) SetRest(s string) {
 	ad.Rest = sql.NullString{String: s, Valid: true}
 }
 
+// Extensions returns the text of the ad's sitelinks, callouts and other
+// extensions.
+func (ad *Ad) Extensions() []string {
+	return extensions(ad.GetRest())
+}
+
+// Advertiser returns the host of the ad's visible URL without www., which
+// tells advertisers apart.
+func (ad *Ad) Advertiser() string {
+	return advertiser(ad.Path)
+}
+
+// advertiser returns the domain of the ad path, without "www.".
+func advertiser(path string) string {
+	host := strings.ToLower(strings.SplitN(path, "/", 2)[0])
+	return strings.TrimPrefix(host, "www.")
+}
+
 type AdKeyword struct {
 	ID            int64
 	AdId          int64
@@ -51,8 +113,57 @@ type AdKeyword struct {
 	UpdatedAt     string
 }
 
+type Observation struct {
+	ID         int64
+	AdId       int64
+	KeywordId  int64
+	PageId     int64
+	Position   int
+	Block      string
+	ObservedAt string
+	// Advertiser of the ad, only set by ExportObservations
+	Advertiser string
+}
+
 type AdWriter interface {
 	Upsert(*Ad, *keywords.Keyword) error
+	// ReplacePageAds swaps the ads observed on an archived page with ads
+	// parsed again from its body.
+	ReplacePageAds(p *Page, ads []*Ad) error
+	// EndScrape records that a scrape of the keyword ended. Ads of its
+	// previous scrape that weren't upserted since have disappeared. Scrapes
+	// without ads, usually failed ones, are ignored.
+	EndScrape(*keywords.Keyword) error
+}
+
+type AdReader interface {
+	Find(int64) (*Ad, error)
+	// Search returns a page of ads matching q and the cursor of the next
+	// page, empty on the last one.
+	Search(q AdQuery) ([]Ad, string, error)
+	// ExportAds calls fn with every ad matching q, in ID order, ignoring its
+	// sort, cursor and limit. Ads are read as fn goes, so exports don't have
+	// to fit in memory. fn can't use the store.
+	ExportAds(q AdQuery, fn func(*Ad) error) error
+}
+
+type ObservationReader interface {
+	GetAdKeywords(adId int64) ([]AdKeyword, error)
+	GetObservations(adId int64) ([]Observation, error)
+	// ExportObservations calls fn with every observation matching the
+	// sighting filters of q, of an ad matching the rest, like ExportAds.
+	// They come in ID order, or by keyword and time when q.Sort is
+	// ObservationsByKeyword.
+	ExportObservations(q AdQuery, fn func(*Observation) error) error
+	// LastObservedAt returns when an ad was last observed, empty when none
+	// has been.
+	LastObservedAt() (string, error)
+}
+
+type AdReaderWriter interface {
+	AdReader
+	AdWriter
+	ObservationReader
 }
 
 func newAdKeyword(a *Ad, k *keywords.Keyword) *AdKeyword {
@@ -60,39 +171,212 @@ func newAdKeyword(a *Ad, k *keywords.Keyword) *AdKeyword {
 }
 
 func NewWriter(s Store) AdWriter {
-	return &adsStore{s}
+	return newAdsStore(s)
+}
+
+func NewReader(s Store) AdReader {
+	return newAdsStore(s)
+}
+
+func NewReaderWriter(s Store) AdReaderWriter {
+	return newAdsStore(s)
+}
+
+func newAdsStore(s Store) *adsStore {
+	return &adsStore{Store: s, brands: &cache{}, rules: &cache{}}
 }
 
 type adsStore struct {
 	Store
+	// brands are the brands ads are checked for bidding on as they're
+	// stored, and rules the compliance rules they're checked against, see
+	// NewRepositories
+	brands *cache
+	rules  *cache
+}
+
+// ingestion is what the ads stored together for a keyword are checked
+// against, read once for all of them.
+type ingestion struct {
+	brands []brands.Brand
+	rules  []compliance.Rule
+	// keyword is the keyword the way events show it, only read when there
+	// are brands
+	keyword webhooks.Keyword
+}
+
+func (s *adsStore) ingestion(tx db.Querier, k *keywords.Keyword) (*ingestion, error) {
+	bs, err := s.brands.get(func() (interface{}, error) { return brands.List(tx) })
+	if err != nil {
+		return nil, err
+	}
+	rs, err := s.rules.get(func() (interface{}, error) { return compliance.Rules(tx) })
+	if err != nil {
+		return nil, err
+	}
+	in := &ingestion{brands: bs.([]brands.Brand), rules: rs.([]compliance.Rule)}
+	if len(in.brands) > 0 {
+		if in.keyword, err = eventKeyword(tx, k); err != nil {
+			return nil, err
+		}
+	}
+	return in, nil
 }
 
 func (s *adsStore) Upsert(ad *Ad, k *keywords.Keyword) error {
-	if existing, err := s.findAdByH1H2Desc(ad.H1, ad.H2, ad.Desc); err != nil {
+	tx, err := s.Begin()
+	if err != nil {
+		return err
+	}
+	in, err := s.ingestion(tx, k)
+	if err == nil {
+		err = upsert(tx, ad, k, false, in)
+	}
+	if err != nil {
+		tx.Rollback()
 		return err
-	} else if existing != nil {
-		existing.Position = ad.Position
-		return s.save(existing, k)
 	}
-	return s.save(ad, k)
+	return tx.Commit()
 }
 
-func (s *adsStore) save(ad *Ad, k *keywords.Keyword) error {
+func (s *adsStore) EndScrape(k *keywords.Keyword) error {
 	tx, err := s.Begin()
 	if err != nil {
 		return err
 	}
-	if ad.ID == 0 {
+	if err = endScrape(tx, k); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *adsStore) ReplacePageAds(p *Page, ads []*Ad) error {
+	tx, err := s.Begin()
+	if err != nil {
+		return err
+	}
+	in, err := s.ingestion(tx, &keywords.Keyword{ID: p.KeywordId})
+	if err == nil {
+		err = replacePageAds(tx, p, ads, in)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func replacePageAds(tx db.Querier, p *Page, ads []*Ad, in *ingestion) error {
+	old, err := getObservations(tx, "page_id", p.ID)
+	if err != nil {
+		return err
+	}
+	for _, o := range old {
+		_, err = tx.Exec(
+			`
+    UPDATE ad_keywords
+    SET position_count = position_count - 1
+    WHERE ad_id = $1
+    AND keyword_id = $2
+    AND position = $3
+    `,
+			o.AdId, o.KeywordId, o.Position,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err = tx.Exec(`DELETE FROM ad_keywords WHERE position_count < 1`); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM observations WHERE page_id = $1`, p.ID); err != nil {
+		return err
+	}
+
+	k := &keywords.Keyword{ID: p.KeywordId}
+	for _, ad := range ads {
+		ad.PageId = p.ID
+		if err = upsert(tx, ad, k, true, in); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsert stores an ad observation. Ads are identified by their headlines and
+// description. When replace is set, the other fields of an existing ad are
+// overwritten too. Brand bidding is detected for both, but webhook events are
+// only emitted for new observations, not replaced ones.
+func upsert(tx db.Querier, ad *Ad, k *keywords.Keyword, replace bool, in *ingestion) error {
+	existing, err := findAdByH1H2Desc(tx, ad.H1, ad.H2, ad.Desc)
+	if err != nil {
+		return err
+	}
+
+	if existing == nil {
 		err = tx.QueryRow(
 			`
-	    INSERT INTO ads (headline1, headline2, path, description, rest, raw)
-	    VALUES($1, $2, $3, $4, $5, $6)
-	    RETURNING id
+	    INSERT INTO ads (headline1, headline2, headlines, path, description, rest, raw)
+	    VALUES($1, $2, $3, $4, $5, $6, $7)
+	    RETURNING id, created_at, updated_at
+	    `,
+			ad.H1, ad.H2, ad.headlinesColumn(), ad.Path, ad.Desc, ad.GetRest(), ad.GetRaw(),
+		).Scan(&ad.ID, &ad.CreatedAt, &ad.UpdatedAt)
+	} else {
+		ad.ID, ad.CreatedAt, ad.UpdatedAt = existing.ID, existing.CreatedAt, existing.UpdatedAt
+		// Ads first posted with two headlines may be posted as a list later
+		if len(ad.Headlines) > 0 && len(existing.Headlines) == 0 {
+			_, err = tx.Exec(`UPDATE ads SET headlines = $1 WHERE id = $2`, ad.headlinesColumn(), ad.ID)
+		}
+		if err == nil && replace {
+			_, err = tx.Exec(
+				`
+	    UPDATE ads
+	    SET path = $1, rest = $2, raw = $3, updated_at = CURRENT_TIMESTAMP
+	    WHERE id = $4
 	    `,
-			ad.H1, ad.H2, ad.Path, ad.Desc, ad.GetRest(), ad.GetRaw(),
-		).Scan(&ad.ID)
+				ad.Path, ad.GetRest(), ad.GetRaw(), ad.ID,
+			)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	events := make([]string, 0)
+	if !replace {
+		if existing == nil {
+			events = append(events, webhooks.NewAd)
+		}
+		if d := ad.Advertiser(); d != "" {
+			res, err := tx.Exec(
+				`
+    INSERT INTO keyword_advertisers (keyword_id, advertiser)
+    VALUES ($1, $2)
+    ON CONFLICT (keyword_id, advertiser) DO NOTHING
+    `,
+				k.ID, d,
+			)
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return err
+			} else if n > 0 {
+				events = append(events, webhooks.NewAdvertiser)
+			}
+		}
+		_, err = tx.Exec(
+			`
+    INSERT INTO keyword_ads (keyword_id, ad_id, in_current_scrape)
+    VALUES ($1, $2, TRUE)
+    ON CONFLICT (keyword_id, ad_id)
+    DO UPDATE SET in_current_scrape = TRUE
+    `,
+			k.ID, ad.ID,
+		)
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
 	}
@@ -103,24 +387,217 @@ func (s *adsStore) save(ad *Ad, k *keywords.Keyword) error {
     INSERT INTO ad_keywords (ad_id, keyword_id, position)
     VALUES($1, $2, $3)
     ON CONFLICT (ad_id, keyword_id, position)
-    DO UPDATE SET position_count = EXCLUDED.position_count + 1
+    DO UPDATE SET position_count = ad_keywords.position_count + 1, updated_at = CURRENT_TIMESTAMP
     RETURNING id
     `,
 		ak.AdId, ak.KeywordId, ak.Position,
 	).Scan(&ak.ID)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
-	tx.Commit()
+
+	_, err = tx.Exec(
+		`
+    INSERT INTO observations (ad_id, keyword_id, page_id, position, block, observed_at)
+    VALUES($1, $2, $3, $4, $5, COALESCE((SELECT fetched_at FROM pages WHERE id = $3), CURRENT_TIMESTAMP))
+    `,
+		ad.ID, k.ID, nullId(ad.PageId), ad.Position, ad.Block,
+	)
+	if err != nil {
+		return err
+	}
+	if err = detectBrandBidding(tx, ad, k, in, !replace); err != nil {
+		return err
+	}
+	// Ads are told apart by their copy, only new and replaced ones need
+	// checking
+	if existing == nil || replace {
+		if err = checkCompliance(tx, ad, in.rules); err != nil {
+			return err
+		}
+	}
+	return emit(tx, events, ad, k)
+}
+
+// detectBrandBidding records the brand-bidding incidents of the ad seen for k
+// and, when alert is set, emits brand.bidding for the new ones.
+func detectBrandBidding(tx db.Querier, ad *Ad, k *keywords.Keyword, in *ingestion, alert bool) error {
+	for _, inc := range brands.Detect(in.brands, in.keyword.Value, ad.Advertiser()) {
+		inc.KeywordId, inc.AdId, inc.Position, inc.Block, inc.Evidence = k.ID, ad.ID, ad.Position, ad.Block, ad.GetRaw()
+		created, err := brands.Record(tx, &inc, time.Now())
+		if err != nil {
+			return err
+		}
+		if created && alert {
+			if err = webhooks.Emit(tx, newBrandBiddingEvent(&inc, ad, in.keyword)); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
-func (s *adsStore) findAdByH1H2Desc(h1 string, h2 string, desc string) (*Ad, error) {
-	ad := &Ad{}
-	err := s.QueryRow(
+// checkCompliance records the violations of the compliance rules by the ad.
+func checkCompliance(tx db.Querier, ad *Ad, rs []compliance.Rule) error {
+	if len(rs) == 0 {
+		return nil
+	}
+	for _, v := range compliance.Check(rs, adCopy(ad)) {
+		v.AdId = ad.ID
+		if _, err := compliance.Record(tx, &v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// endScrape emits ad.disappeared for the ads in the previous scrape of the
+// keyword but not in the current one, which becomes the previous one. A
+// current scrape without ads is more likely a CAPTCHA or a page that couldn't
+// be parsed than every ad gone, so the previous one is kept.
+func endScrape(tx db.Querier, k *keywords.Keyword) error {
+	var scraped bool
+	err := tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM keyword_ads WHERE keyword_id = $1 AND in_current_scrape)`,
+		k.ID,
+	).Scan(&scraped)
+	if err != nil || !scraped {
+		return err
+	}
+
+	rows, err := tx.Query(
 		`
-    SELECT id, headline1, headline2, description, path, rest, raw, created_at, updated_at
+    SELECT ads.id, ads.headline1, ads.headline2, ads.description, ads.path, ads.created_at, ads.updated_at
+    FROM keyword_ads ka
+    JOIN ads ON ads.id = ka.ad_id
+    WHERE ka.keyword_id = $1
+    AND ka.in_last_scrape
+    AND NOT ka.in_current_scrape
+    ORDER BY ads.id ASC
+    `,
+		k.ID,
+	)
+	if err != nil {
+		return err
+	}
+	gone := make([]Ad, 0)
+	for rows.Next() {
+		ad := Ad{}
+		if err = rows.Scan(&ad.ID, &ad.H1, &ad.H2, &ad.Desc, &ad.Path, &ad.CreatedAt, &ad.UpdatedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		gone = append(gone, ad)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM keyword_ads WHERE keyword_id = $1 AND NOT in_current_scrape`, k.ID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`UPDATE keyword_ads SET in_last_scrape = TRUE, in_current_scrape = FALSE WHERE keyword_id = $1`,
+		k.ID,
+	)
+	if err != nil {
+		return err
+	}
+	for i := range gone {
+		if err = emit(tx, []string{webhooks.AdDisappeared}, &gone[i], k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emit queues webhook deliveries of events about the ad seen for k.
+func emit(tx db.Querier, events []string, ad *Ad, k *keywords.Keyword) error {
+	if len(events) == 0 {
+		return nil
+	}
+	if subscribed, err := webhooks.Subscribed(tx); err != nil || !subscribed {
+		return err
+	}
+	ek, err := eventKeyword(tx, k)
+	if err != nil {
+		return err
+	}
+	return emitEvents(events, ad, ek, func(e *webhooks.Event) error {
+		return webhooks.Emit(tx, e)
+	})
+}
+
+// eventKeyword returns k with its value and groups, the way events show it.
+func eventKeyword(tx db.Querier, k *keywords.Keyword) (webhooks.Keyword, error) {
+	ek := webhooks.Keyword{ID: k.ID, Groups: []string{}}
+	if err := tx.QueryRow(`SELECT value FROM keywords WHERE id = $1`, k.ID).Scan(&ek.Value); err != nil {
+		return ek, err
+	}
+	rows, err := tx.Query(
+		`
+    SELECT l.name
+    FROM keyword_labels kl
+    JOIN labels l ON l.id = kl.label_id
+    WHERE kl.keyword_id = $1
+    AND l.kind = $2
+    ORDER BY l.name
+    `,
+		k.ID, keywords.Group,
+	)
+	if err != nil {
+		return ek, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return ek, err
+		}
+		ek.Groups = append(ek.Groups, name)
+	}
+	return ek, rows.Err()
+}
+
+func emitEvents(events []string, ad *Ad, k webhooks.Keyword, emit func(*webhooks.Event) error) error {
+	occurredAt := time.Now().UTC().Format(time.RFC3339)
+	for _, event := range events {
+		e := &webhooks.Event{
+			Type:       event,
+			OccurredAt: occurredAt,
+			Keyword:    k,
+			Advertiser: ad.Advertiser(),
+			Ad:         newStoredAdJSON(ad),
+		}
+		if err := emit(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newBrandBiddingEvent(inc *brands.Incident, ad *Ad, k webhooks.Keyword) *webhooks.Event {
+	return &webhooks.Event{
+		Type:       webhooks.BrandBidding,
+		OccurredAt: time.Now().UTC().Format(time.RFC3339),
+		Keyword:    k,
+		Advertiser: ad.Advertiser(),
+		Ad:         newStoredAdJSON(ad),
+		Brand:      inc.Brand,
+		Incident:   newIncidentJSON(inc),
+	}
+}
+
+func nullId(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id > 0}
+}
+
+func findAdByH1H2Desc(q db.Querier, h1 string, h2 string, desc string) (*Ad, error) {
+	ad, headlines := &Ad{}, sql.NullString{}
+	err := q.QueryRow(
+		`
+    SELECT id, headline1, headline2, headlines, description, path, rest, raw, created_at, updated_at
     FROM ads
     WHERE headline1 = $1
     AND headline2 = $2
@@ -128,11 +605,326 @@ func (s *adsStore) findAdByH1H2Desc(h1 string, h2 string, desc string) (*Ad, err
     `,
 		h1, h2, desc,
 	).Scan(
-		&ad.ID, &ad.H1, &ad.H2, &ad.Desc, &ad.Path, &ad.Rest, &ad.Raw,
+		&ad.ID, &ad.H1, &ad.H2, &headlines, &ad.Desc, &ad.Path, &ad.Rest, &ad.Raw,
+		&ad.CreatedAt, &ad.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return ad, ad.setHeadlines(headlines)
+}
+
+func (s *adsStore) Find(id int64) (*Ad, error) {
+	ad, headlines := &Ad{}, sql.NullString{}
+	err := s.QueryRow(
+		`
+    SELECT id, headline1, headline2, headlines, description, path, rest, raw, created_at, updated_at
+    FROM ads
+    WHERE id = $1
+    `,
+		id,
+	).Scan(
+		&ad.ID, &ad.H1, &ad.H2, &headlines, &ad.Desc, &ad.Path, &ad.Rest, &ad.Raw,
 		&ad.CreatedAt, &ad.UpdatedAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return ad, ad.setHeadlines(headlines)
+}
+
+func (s *adsStore) Search(q AdQuery) ([]Ad, string, error) {
+	ads := make([]Ad, 0)
+	o, err := q.order()
+	if err != nil {
+		return ads, "", err
+	}
+
+	args := make([]interface{}, 0)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	ts := func(expr string) string {
+		return db.Timestamp(s.Dialect(), expr)
+	}
+
+	where := adFilters(&q, arg)
+	if q.sighted() {
+		where = append(where, `EXISTS (
+      SELECT 1 FROM observations o
+      WHERE o.ad_id = ads.id
+      AND `+strings.Join(sightingFilters(&q, arg, ts), " AND ")+`
+    )`)
+	}
+
+	cmp, dir := ">", "ASC"
+	if o.desc {
+		cmp, dir = "<", "DESC"
+	}
+	if c := o.after; c != nil {
+		if o.field == "id" {
+			where = append(where, "id "+cmp+" "+arg(c.ID))
+		} else {
+			col, v := ts(o.column), ts(arg(c.Value))
+			where = append(where, "("+col+" "+cmp+" "+v+" OR ("+col+" = "+v+" AND id "+cmp+" "+arg(c.ID)+"))")
+		}
+	}
+
+	query := `
+    SELECT id, headline1, headline2, headlines, description, path, created_at, updated_at
+    FROM ads
+    `
+	if len(where) > 0 {
+		query += "WHERE " + strings.Join(where, "\n    AND ") + "\n    "
+	}
+	query += "ORDER BY " + o.column + " " + dir + ", id " + dir + "\n    LIMIT " + arg(q.limit()+1)
+
+	rows, err := s.Query(query, args...)
+	if err != nil {
+		return ads, "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		ad, headlines := Ad{}, sql.NullString{}
+		err = rows.Scan(&ad.ID, &ad.H1, &ad.H2, &headlines, &ad.Desc, &ad.Path, &ad.CreatedAt, &ad.UpdatedAt)
+		if err == nil {
+			err = ad.setHeadlines(headlines)
+		}
+		if err != nil {
+			return ads, "", err
+		}
+		ads = append(ads, ad)
+	}
+	if err = rows.Err(); err != nil {
+		return ads, "", err
+	}
+	ads, next := o.page(ads, q.limit())
+	return ads, next, nil
+}
+
+func (s *adsStore) ExportAds(q AdQuery, fn func(*Ad) error) error {
+	args := make([]interface{}, 0)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	ts := func(expr string) string {
+		return db.Timestamp(s.Dialect(), expr)
+	}
+
+	where := adFilters(&q, arg)
+	if q.sighted() {
+		where = append(where, `EXISTS (
+      SELECT 1 FROM observations o
+      WHERE o.ad_id = ads.id
+      AND `+strings.Join(sightingFilters(&q, arg, ts), " AND ")+`
+    )`)
+	}
+	query := `
+    SELECT id, headline1, headline2, headlines, description, path, rest, created_at, updated_at
+    FROM ads
+    `
+	if len(where) > 0 {
+		query += "WHERE " + strings.Join(where, "\n    AND ") + "\n    "
+	}
+	query += "ORDER BY id ASC"
+
+	rows, err := s.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		ad, headlines := Ad{}, sql.NullString{}
+		err = rows.Scan(&ad.ID, &ad.H1, &ad.H2, &headlines, &ad.Desc, &ad.Path, &ad.Rest, &ad.CreatedAt, &ad.UpdatedAt)
+		if err == nil {
+			err = ad.setHeadlines(headlines)
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(&ad); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *adsStore) ExportObservations(q AdQuery, fn func(*Observation) error) error {
+	args := make([]interface{}, 0)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	ts := func(expr string) string {
+		return db.Timestamp(s.Dialect(), expr)
+	}
+
+	where := append(adFilters(&q, arg), sightingFilters(&q, arg, ts)...)
+	query := `
+    SELECT o.id, o.ad_id, o.keyword_id, o.page_id, o.position, o.block, o.observed_at, ads.path
+    FROM observations o
+    JOIN ads ON ads.id = o.ad_id
+    `
+	if len(where) > 0 {
+		query += "WHERE " + strings.Join(where, "\n    AND ") + "\n    "
+	}
+	if q.Sort == ObservationsByKeyword {
+		query += "ORDER BY o.keyword_id ASC, o.observed_at ASC, o.id ASC"
+	} else {
+		query += "ORDER BY o.id ASC"
+	}
+
+	rows, err := s.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		o := Observation{}
+		pageId, path := sql.NullInt64{}, ""
+		if err = rows.Scan(&o.ID, &o.AdId, &o.KeywordId, &pageId, &o.Position, &o.Block, &o.ObservedAt, &path); err != nil {
+			return err
+		}
+		o.PageId, o.Advertiser = pageId.Int64, advertiser(path)
+		if err = fn(&o); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// sightingFilters returns the conditions on observations o for the keyword,
+// label, date, block and position filters of q.
+func sightingFilters(q *AdQuery, arg func(interface{}) string, ts func(string) string) []string {
+	sightings := make([]string, 0)
+	if q.KeywordId > 0 {
+		sightings = append(sightings, "o.keyword_id = "+arg(q.KeywordId))
+	}
+	if !q.From.IsZero() {
+		sightings = append(sightings, ts("o.observed_at")+" >= "+ts(arg(q.From.UTC())))
+	}
+	if !q.To.IsZero() {
+		sightings = append(sightings, ts("o.observed_at")+" < "+ts(arg(q.To.UTC())))
+	}
+	if q.Block != "" {
+		sightings = append(sightings, "o.block = "+arg(q.Block))
+	}
+	if q.MinPosition > 0 {
+		sightings = append(sightings, "o.position >= "+arg(q.MinPosition))
+	}
+	if q.MaxPosition > 0 {
+		sightings = append(sightings, "o.position <= "+arg(q.MaxPosition))
+	}
+	for kind, name := range map[string]string{keywords.Group: q.Group, keywords.Tag: q.Tag} {
+		if name != "" {
+			sightings = append(sightings, `o.keyword_id IN (
+        SELECT kl.keyword_id FROM keyword_labels kl
+        JOIN labels l ON l.id = kl.label_id
+        JOIN keywords k ON k.id = kl.keyword_id
+        WHERE k.deleted_at IS NULL AND l.kind = `+arg(kind)+` AND l.name = `+arg(name)+`
+      )`)
+		}
+	}
+	return sightings
+}
+
+// adFilters returns the conditions on ads for the domain and text filters
+// of q.
+func adFilters(q *AdQuery, arg func(interface{}) string) []string {
+	where := make([]string, 0)
+	if q.Domain != "" {
+		d := strings.ToLower(q.Domain)
+		e := db.EscapeLike(d)
+		where = append(where, "(LOWER(ads.path) = "+arg(d)+" OR "+db.Like("LOWER(ads.path)", arg(e+"/%"))+
+			" OR "+db.Like("LOWER(ads.path)", arg("%."+e))+" OR "+db.Like("LOWER(ads.path)", arg("%."+e+"/%"))+")")
+	}
+	if q.Text != "" {
+		t := arg("%" + db.EscapeLike(strings.ToLower(q.Text)) + "%")
+		where = append(where, "("+db.Like("LOWER(ads.headline1)", t)+" OR "+db.Like("LOWER(ads.headline2)", t)+
+			" OR "+db.Like("LOWER(ads.description)", t)+")")
+	}
+	return where
+}
+
+func (s *adsStore) GetAdKeywords(adId int64) ([]AdKeyword, error) {
+	aks := make([]AdKeyword, 0)
+
+	rows, err := s.Query(
+		`
+    SELECT id, ad_id, keyword_id, position, position_count, created_at, updated_at
+    FROM ad_keywords
+    WHERE ad_id = $1
+    ORDER BY id ASC
+    `,
+		adId,
+	)
+	if err != nil {
+		return aks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		ak := AdKeyword{}
+		err = rows.Scan(
+			&ak.ID, &ak.AdId, &ak.KeywordId, &ak.Position, &ak.PositionCount,
+			&ak.CreatedAt, &ak.UpdatedAt,
+		)
+		if err != nil {
+			return aks, err
+		}
+		aks = append(aks, ak)
+	}
+	return aks, rows.Err()
+}
+
+func (s *adsStore) GetObservations(adId int64) ([]Observation, error) {
+	return getObservations(s, "ad_id", adId)
+}
+
+func (s *adsStore) LastObservedAt() (string, error) {
+	var observedAt string
+	err := s.Store.QueryRow(`SELECT observed_at FROM observations ORDER BY observed_at DESC LIMIT 1`).Scan(&observedAt)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return observedAt, err
+}
+
+func getObservations(q db.Querier, column string, id int64) ([]Observation, error) {
+	obs := make([]Observation, 0)
+
+	rows, err := q.Query(
+		`
+    SELECT id, ad_id, keyword_id, page_id, position, block, observed_at
+    FROM observations
+    WHERE `+column+` = $1
+    ORDER BY id ASC
+    `,
+		id,
+	)
+	if err != nil {
+		return obs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		o := Observation{}
+		pageId := sql.NullInt64{}
+		err = rows.Scan(&o.ID, &o.AdId, &o.KeywordId, &pageId, &o.Position, &o.Block, &o.ObservedAt)
+		if err != nil {
+			return obs, err
+		}
+		o.PageId = pageId.Int64
+		obs = append(obs, o)
 	}
-	return ad, err
+	return obs, rows.Err()
 }
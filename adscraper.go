@@ -1,31 +1,99 @@
 package adscraper
 
 import (
-	"net/http"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/gkats/adscraper/keywords"
 )
 
+// ParserVersion is the parser used for newly scraped pages.
+const ParserVersion = "v2"
+
+type Parser func(io.Reader) ([]*Ad, error)
+
+// parsers holds every parser version, so archived pages can be parsed again
+// after a selector changes. Add a new version rather than changing an
+// existing one.
+var parsers = map[string]Parser{
+	"v1": extract,
+	"v2": extractWithBlock,
+}
+
 func NewURL(s string) string {
 	return "https://www.google.com/search?q=" + strings.Replace(s, " ", "+", -1)
 }
 
 func Scrape(url string) ([]*Ad, error) {
+	_, ads, err := ScrapePage(url)
+	return ads, err
+}
+
+// ScrapePage fetches the results page at url and returns it along with the
+// ads parsed from it.
+func ScrapePage(url string) (*Page, []*Ad, error) {
+	return ScrapeDevicePage(url, keywords.Desktop)
+}
+
+// ScrapeDevicePage is ScrapePage searching from a keywords.Desktop or
+// keywords.Mobile device.
+func ScrapeDevicePage(url string, device string) (*Page, []*Ad, error) {
+	page, err := FetchDevicePage(url, device)
+	if err != nil {
+		return nil, nil, err
+	}
+	ads, err := Parse(page.ParserVersion, bytes.NewReader(page.Body))
+	return page, ads, err
+}
+
+// FetchDevicePage fetches the results page at url without parsing it. Pages
+// that can't be fetched return a *StatusError.
+func FetchDevicePage(url string, device string) (*Page, error) {
 	c := &crawler{}
+	if device == keywords.Mobile {
+		c.userAgent = MobileUA
+	}
 
 	res, err := c.Fetch(url)
 	if err != nil {
+		if res != nil {
+			res.Body.Close()
+		}
 		return nil, err
 	}
 	defer res.Body.Close()
-	return extract(res)
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Page{ParserVersion: ParserVersion, Body: body}, nil
+}
+
+func GetParser(version string) (Parser, error) {
+	parse, ok := parsers[version]
+	if !ok {
+		return nil, fmt.Errorf("Unknown parser version %q", version)
+	}
+	return parse, nil
 }
 
-func extract(r *http.Response) ([]*Ad, error) {
+func Parse(version string, r io.Reader) ([]*Ad, error) {
+	parse, err := GetParser(version)
+	if err != nil {
+		return nil, err
+	}
+	return parse(r)
+}
+
+func extract(r io.Reader) ([]*Ad, error) {
 	var ads = make([]*Ad, 0)
 
-	doc, err := goquery.NewDocumentFromResponse(r)
+	doc, err := goquery.NewDocumentFromReader(r)
 	if err != nil {
 		return ads, err
 	}
@@ -36,6 +104,33 @@ func extract(r *http.Response) ([]*Ad, error) {
 	return ads, err
 }
 
+// extractWithBlock also records the block, top or bottom of the results,
+// each ad was shown in.
+func extractWithBlock(r io.Reader) ([]*Ad, error) {
+	var ads = make([]*Ad, 0)
+
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return ads, err
+	}
+	doc.Find(".ads-ad").Each(func(i int, sel *goquery.Selection) {
+		ad := extractAd(i+1, sel)
+		ad.Block = extractBlock(sel)
+		ads = append(ads, ad)
+	})
+
+	return ads, err
+}
+
+func extractBlock(sel *goquery.Selection) string {
+	if sel.Closest("#tads").Length() > 0 {
+		return BlockTop
+	} else if sel.Closest("#tadsb, #bottomads").Length() > 0 {
+		return BlockBottom
+	}
+	return ""
+}
+
 func extractAd(pos int, sel *goquery.Selection) *Ad {
 	ad := &Ad{}
 
@@ -74,3 +169,33 @@ func innerHTML(sel *goquery.Selection) string {
 	})
 	return strings.Join(html, "")
 }
+
+// extensions returns the text of the extensions, like sitelinks and
+// callouts, in the HTML following an ad description.
+func extensions(rest string) []string {
+	exts := make([]string, 0)
+	if rest == "" {
+		return exts
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(rest))
+	if err != nil {
+		return exts
+	}
+	// Hidden bubbles, like opening hours and the "Why this ad" notice
+	doc.Find(".g-bblc").Remove()
+
+	add := func(sel *goquery.Selection) {
+		if text := strings.Join(strings.Fields(normalize(sel.Text())), " "); text != "" {
+			exts = append(exts, text)
+		}
+	}
+	doc.Find("body").Children().Each(func(i int, sel *goquery.Selection) {
+		if items := sel.Find("li"); items.Length() > 0 {
+			items.Each(func(i int, li *goquery.Selection) { add(li) })
+		} else {
+			add(sel)
+		}
+	})
+	return exts
+}
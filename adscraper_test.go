@@ -34,18 +34,24 @@ func TestScrape(t *testing.T) {
 		{"Flash Sale has started. Earn -25% more in Reebok products!", ads[0].Desc},
 		{"<div class=\"ellip\">Free returns - Official Store</div>", ads[0].GetRest()},
 		{1, ads[0].Position},
+		{adscraper.BlockTop, ads[0].Block},
+		{1, len(ads[0].Extensions())},
+		{"Free returns - Official Store", ads[0].Extensions()[0]},
 		// Second ad
 		{"Reebok Women's", ads[1].H1},
 		{"Latest Arrivals Are Here - cosmossport.gr", ads[1].H2},
 		{"www.cosmossport.gr/reebok/womens", ads[1].Path},
 		{"The latest arrivals in Reebok women's are at Cosmos Sport!", ads[1].Desc},
 		{2, ads[1].Position},
+		{adscraper.BlockTop, ads[1].Block},
+		{"Pay with e-banking · Free returns · Free delivery", ads[1].Extensions()[0]},
 		// Third ad
 		{"New Νike Basketball Shoes", ads[2].H1},
 		{"Catch the 10day Offer", ads[2].H2},
 		{"www.zakcret.gr/nike/basket", ads[2].Path},
 		{"New Releases in Nike Basketball Shoes. View the Collection, Buy Online!", ads[2].Desc},
 		{3, ads[2].Position},
+		{adscraper.BlockBottom, ads[2].Block},
 	}
 
 	for i, tc := range testCases {
@@ -68,6 +74,45 @@ func TestScrape(t *testing.T) {
 	}
 }
 
+func TestFetchStatusErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/search":
+			http.Redirect(w, r, "/sorry/index", http.StatusFound)
+		case "/sorry/index":
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	_, captcha := adscraper.FetchDevicePage(ts.URL+"/search", "")
+	_, notFound := adscraper.FetchDevicePage(ts.URL+"/missing", "")
+	captchaErr, _ := captcha.(*adscraper.StatusError)
+	notFoundErr, _ := notFound.(*adscraper.StatusError)
+	if captchaErr == nil || notFoundErr == nil {
+		t.Fatalf("Expected status errors, got %v and %v", captcha, notFound)
+	}
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{http.StatusTooManyRequests, captchaErr.StatusCode},
+		{true, captchaErr.Captcha},
+		{true, captchaErr.Temporary()},
+		{http.StatusNotFound, notFoundErr.StatusCode},
+		{false, notFoundErr.Captcha},
+		{false, notFoundErr.Temporary()},
+	}
+	for i, tc := range testCases {
+		if tc.got != tc.want {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
+
 func TestScrapeSplitsH1AndH2Correctly(t *testing.T) {
 	// TODO check what happens with the 30char limits
 }
@@ -0,0 +1,229 @@
+package adscraper
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gkats/adscraper/analytics"
+	"github.com/gkats/adscraper/keywords"
+)
+
+// Limits of the analytics' date range.
+const (
+	DefaultAnalyticsDays = 30
+	MaxAnalyticsDays     = 366
+)
+
+// exportSERPs groups the observations matching q into the results pages they
+// were made on and calls fn with each of them, a keyword at a time. Pages are
+// grouped as the observations are read, so only one is kept at a time.
+func exportSERPs(r ObservationReader, q AdQuery, fn func(*analytics.SERP)) error {
+	g := analytics.NewGrouper(fn)
+	q.Sort = ObservationsByKeyword
+	err := r.ExportObservations(q, func(o *Observation) error {
+		at, err := time.Parse(time.RFC3339Nano, o.ObservedAt)
+		if err != nil {
+			return err
+		}
+		g.Add(analytics.Observation{
+			KeywordId:  o.KeywordId,
+			AdId:       o.AdId,
+			PageId:     o.PageId,
+			Advertiser: o.Advertiser,
+			Position:   o.Position,
+			Block:      o.Block,
+			ObservedAt: at,
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	g.Flush()
+	return nil
+}
+
+type advertiserStatsJSON struct {
+	Advertiser      string         `json:"advertiser"`
+	SERPs           int            `json:"serps"`
+	ImpressionShare float64        `json:"impressionShare"`
+	AveragePosition float64        `json:"averagePosition"`
+	TopRate         float64        `json:"topRate"`
+	Positions       map[string]int `json:"positions"`
+	FirstSeenAt     string         `json:"firstSeenAt"`
+	LastSeenAt      string         `json:"lastSeenAt"`
+}
+
+// newAdvertiserStatsJSON returns the stats of the advertisers on domain and
+// its subdomains, or of all of them when domain is empty.
+func newAdvertiserStatsJSON(stats []analytics.Stats, domain string) []advertiserStatsJSON {
+	sj := make([]advertiserStatsJSON, 0, len(stats))
+	for _, s := range stats {
+		if domain != "" && !matchesDomain(s.Advertiser, domain) {
+			continue
+		}
+		positions := make(map[string]int)
+		for p, n := range s.Positions {
+			positions[strconv.Itoa(p)] = n
+		}
+		sj = append(sj, advertiserStatsJSON{
+			Advertiser:      s.Advertiser,
+			SERPs:           s.SERPs,
+			ImpressionShare: s.ImpressionShare,
+			AveragePosition: s.AveragePosition,
+			TopRate:         s.TopRate,
+			Positions:       positions,
+			FirstSeenAt:     s.FirstSeenAt.UTC().Format(time.RFC3339),
+			LastSeenAt:      s.LastSeenAt.UTC().Format(time.RFC3339),
+		})
+	}
+	return sj
+}
+
+type shareOfVoiceJSON struct {
+	From        string                `json:"from"`
+	To          string                `json:"to"`
+	SERPs       int                   `json:"serps"`
+	Advertisers []advertiserStatsJSON `json:"advertisers"`
+}
+
+type keywordPositionsJSON struct {
+	KeywordId   int64                 `json:"keywordId"`
+	Keyword     string                `json:"keyword"`
+	SERPs       int                   `json:"serps"`
+	Advertisers []advertiserStatsJSON `json:"advertisers"`
+}
+
+type positionsJSON struct {
+	From     string                 `json:"from"`
+	To       string                 `json:"to"`
+	Keywords []keywordPositionsJSON `json:"keywords"`
+}
+
+// analyticsHandler reads the results pages reports are computed from.
+type analyticsHandler struct {
+	observationReader ObservationReader
+	keywordsReader    keywords.Reader
+}
+
+// newAnalyticsQuery reads the keywords and the date range of a report. Only
+// the keyword filters apply to observations, so every results page counts,
+// not only the ones an advertiser is on. The range is the last 30 days by
+// default.
+func newAnalyticsQuery(params url.Values) (AdQuery, error) {
+	q := AdQuery{Group: params.Get("group"), Tag: params.Get("tag")}
+	var err error
+	if q.KeywordId, err = int64Param(params, "keywordId"); err != nil {
+		return q, err
+	}
+	if q.From, err = timeParam(params, "from", false); err != nil {
+		return q, err
+	}
+	if q.To, err = timeParam(params, "to", true); err != nil {
+		return q, err
+	}
+	if q.To.IsZero() {
+		q.To = time.Now().UTC()
+	}
+	if q.From.IsZero() {
+		q.From = q.To.AddDate(0, 0, -DefaultAnalyticsDays)
+	}
+	if !q.From.Before(q.To) || q.To.Sub(q.From) > MaxAnalyticsDays*24*time.Hour {
+		return q, fmt.Errorf("Invalid range from %v to %v", q.From, q.To)
+	}
+	return q, nil
+}
+
+// report returns the report of the results pages matching the request's
+// filters and its date range, or the response to send when there's none to
+// compute.
+func (h *analyticsHandler) report(r *http.Request) (*analytics.Report, *AdQuery, response) {
+	params := r.URL.Query()
+	q, err := newAnalyticsQuery(params)
+	if err != nil {
+		return nil, nil, badRequest()
+	}
+	if v := params.Get("keyword"); v != "" {
+		k, err := h.keywordsReader.FindByValue(v)
+		if err != nil {
+			return nil, nil, internalServerError(err)
+		} else if k == nil {
+			return analytics.NewReport(), &q, nil
+		}
+		q.KeywordId = k.ID
+	}
+
+	report := analytics.NewReport()
+	if err = exportSERPs(h.observationReader, q, report.Add); err != nil {
+		return nil, nil, internalServerError(err)
+	}
+	return report, &q, nil
+}
+
+type shareOfVoiceHandler struct {
+	analyticsHandler
+}
+
+// ServeHTTP reports the share of the results pages of the keywords each
+// advertiser appeared on, at what average position and how often at the top.
+func (h *shareOfVoiceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report, q, res := h.report(r)
+	if res != nil {
+		writeResponse(w, res)
+		return
+	}
+	sov := report.ShareOfVoice()
+	writeResponse(w, ok(&shareOfVoiceJSON{
+		From:        q.From.Format(time.RFC3339),
+		To:          q.To.Format(time.RFC3339),
+		SERPs:       sov.SERPs,
+		Advertisers: newAdvertiserStatsJSON(sov.Advertisers, r.URL.Query().Get("advertiser")),
+	}))
+}
+
+func shareOfVoice(r *Repositories) http.Handler {
+	return &shareOfVoiceHandler{analyticsHandler{observationReader: r.Ads, keywordsReader: r.Keywords}}
+}
+
+type positionsHandler struct {
+	analyticsHandler
+}
+
+// ServeHTTP reports the share of voice of each keyword on its own.
+func (h *positionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report, q, res := h.report(r)
+	if res != nil {
+		writeResponse(w, res)
+		return
+	}
+	kps := report.Positions()
+	body := &positionsJSON{
+		From:     q.From.Format(time.RFC3339),
+		To:       q.To.Format(time.RFC3339),
+		Keywords: make([]keywordPositionsJSON, 0, len(kps)),
+	}
+	for _, kp := range kps {
+		k, err := h.keywordsReader.Find(kp.KeywordId)
+		if err != nil {
+			writeResponse(w, internalServerError(err))
+			return
+		}
+		kj := keywordPositionsJSON{
+			KeywordId:   kp.KeywordId,
+			SERPs:       kp.SERPs,
+			Advertisers: newAdvertiserStatsJSON(kp.Advertisers, r.URL.Query().Get("advertiser")),
+		}
+		if k != nil {
+			kj.Keyword = k.Value
+		}
+		body.Keywords = append(body.Keywords, kj)
+	}
+	writeResponse(w, ok(body))
+}
+
+func positions(r *Repositories) http.Handler {
+	return &positionsHandler{analyticsHandler{observationReader: r.Ads, keywordsReader: r.Keywords}}
+}
@@ -0,0 +1,260 @@
+// Package analytics computes how advertisers show up on the results pages of
+// keywords: the share of pages they appear on, at what position and how often
+// at the top.
+package analytics
+
+import (
+	"sort"
+	"time"
+)
+
+// Blocks of the results page ads are shown in, like the ads' Block.
+const (
+	BlockTop    = "top"
+	BlockBottom = "bottom"
+)
+
+// ScrapeGap splits a keyword's observations into results pages when they
+// don't come from archived pages. Scrapers post the ads of a page within
+// seconds and scrape a keyword again minutes later at the earliest.
+const ScrapeGap = time.Minute
+
+// Observation is an ad seen on a keyword's results page.
+type Observation struct {
+	KeywordId int64
+	AdId      int64
+	// PageId is the archived page the ad was parsed from, zero when the page
+	// wasn't archived.
+	PageId     int64
+	Advertiser string
+	Position   int
+	Block      string
+	ObservedAt time.Time
+}
+
+// SERP is the results page of a single scrape of a keyword.
+type SERP struct {
+	KeywordId    int64
+	PageId       int64
+	At           time.Time
+	Observations []Observation
+	last         time.Time
+}
+
+// SERPs groups observations, in the order they were made, into the results
+// pages they were seen on: by archived page, or by how close together they
+// were made for the same keyword. Pages are in the order they were scraped.
+func SERPs(obs []Observation) []SERP {
+	serps := make([]SERP, 0)
+	current := make(map[int64]int)
+	for _, o := range obs {
+		i, ok := current[o.KeywordId]
+		if !ok || o.PageId != serps[i].PageId || o.ObservedAt.Sub(serps[i].last) > ScrapeGap {
+			i = len(serps)
+			current[o.KeywordId] = i
+			serps = append(serps, SERP{KeywordId: o.KeywordId, PageId: o.PageId, At: o.ObservedAt})
+		}
+		serps[i].last = o.ObservedAt
+		serps[i].Observations = append(serps[i].Observations, o)
+	}
+	return serps
+}
+
+// Grouper groups observations into results pages like SERPs, as they're
+// read. Observations must come ordered by keyword and time, so that a page is
+// complete once an observation of another one comes and only that one needs
+// to be kept.
+type Grouper struct {
+	fn   func(*SERP)
+	serp *SERP
+}
+
+// NewGrouper returns a Grouper calling fn with each results page once it's
+// complete.
+func NewGrouper(fn func(*SERP)) *Grouper {
+	return &Grouper{fn: fn}
+}
+
+func (g *Grouper) Add(o Observation) {
+	if g.serp == nil || o.KeywordId != g.serp.KeywordId || o.PageId != g.serp.PageId || o.ObservedAt.Sub(g.serp.last) > ScrapeGap {
+		g.Flush()
+		g.serp = &SERP{KeywordId: o.KeywordId, PageId: o.PageId, At: o.ObservedAt}
+	}
+	g.serp.last = o.ObservedAt
+	g.serp.Observations = append(g.serp.Observations, o)
+}
+
+// Flush calls fn with the last results page, which may still get
+// observations otherwise.
+func (g *Grouper) Flush() {
+	if g.serp != nil {
+		g.fn(g.serp)
+		g.serp = nil
+	}
+}
+
+// Stats is how an advertiser showed up on a set of results pages. An
+// appearance is a page with at least one of the advertiser's ads, at the
+// position of the highest of them.
+type Stats struct {
+	Advertiser string
+	// SERPs counts the pages the advertiser appeared on.
+	SERPs int
+	// ImpressionShare is the share of all the pages the advertiser appeared
+	// on.
+	ImpressionShare float64
+	AveragePosition float64
+	// TopRate is the share of appearances with an ad at the top of the page.
+	TopRate float64
+	// Positions counts appearances by position.
+	Positions   map[int]int
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time
+	top         int
+	positions   int
+}
+
+// accumulator adds up the stats of advertisers a results page at a time.
+type accumulator struct {
+	serps        int
+	byAdvertiser map[string]*Stats
+}
+
+func newAccumulator() *accumulator {
+	return &accumulator{byAdvertiser: make(map[string]*Stats)}
+}
+
+func (a *accumulator) add(serp *SERP) {
+	a.serps++
+	best := make(map[string]Observation)
+	top := make(map[string]bool)
+	for _, o := range serp.Observations {
+		s, ok := a.byAdvertiser[o.Advertiser]
+		if !ok {
+			s = &Stats{Advertiser: o.Advertiser, Positions: make(map[int]int), FirstSeenAt: o.ObservedAt}
+			a.byAdvertiser[o.Advertiser] = s
+		}
+		if o.ObservedAt.Before(s.FirstSeenAt) {
+			s.FirstSeenAt = o.ObservedAt
+		}
+		if o.ObservedAt.After(s.LastSeenAt) {
+			s.LastSeenAt = o.ObservedAt
+		}
+		if b, ok := best[o.Advertiser]; !ok || o.Position < b.Position {
+			best[o.Advertiser] = o
+		}
+		top[o.Advertiser] = top[o.Advertiser] || o.Block != BlockBottom
+	}
+	for advertiser, o := range best {
+		s := a.byAdvertiser[advertiser]
+		s.SERPs++
+		s.positions += o.Position
+		s.Positions[o.Position]++
+		if top[advertiser] {
+			s.top++
+		}
+	}
+}
+
+// stats returns the stats of every advertiser, the one with the largest
+// impression share first.
+func (a *accumulator) stats() []Stats {
+	stats := make([]Stats, 0, len(a.byAdvertiser))
+	for _, s := range a.byAdvertiser {
+		s.ImpressionShare = float64(s.SERPs) / float64(a.serps)
+		s.AveragePosition = float64(s.positions) / float64(s.SERPs)
+		s.TopRate = float64(s.top) / float64(s.SERPs)
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].SERPs != stats[j].SERPs {
+			return stats[i].SERPs > stats[j].SERPs
+		}
+		return stats[i].Advertiser < stats[j].Advertiser
+	})
+	return stats
+}
+
+// Advertisers returns the stats of every advertiser on the pages, the one
+// with the largest impression share first.
+func Advertisers(serps []SERP) []Stats {
+	a := newAccumulator()
+	for i := range serps {
+		a.add(&serps[i])
+	}
+	return a.stats()
+}
+
+// ShareOfVoice is how advertisers showed up on the results pages of a set of
+// keywords, like a keyword group.
+type ShareOfVoice struct {
+	SERPs       int
+	Advertisers []Stats
+}
+
+func NewShareOfVoice(serps []SERP) *ShareOfVoice {
+	r := NewReport()
+	for i := range serps {
+		r.Add(&serps[i])
+	}
+	return r.ShareOfVoice()
+}
+
+// KeywordPositions is how advertisers showed up on a keyword's results
+// pages.
+type KeywordPositions struct {
+	KeywordId   int64
+	SERPs       int
+	Advertisers []Stats
+}
+
+// Positions breaks down the stats of advertisers by keyword, in keyword ID
+// order.
+func Positions(serps []SERP) []KeywordPositions {
+	r := NewReport()
+	for i := range serps {
+		r.Add(&serps[i])
+	}
+	return r.Positions()
+}
+
+// Report is the share of voice and the positions of a set of results pages
+// added one at a time, so the pages don't need to be kept.
+type Report struct {
+	all       *accumulator
+	byKeyword map[int64]*accumulator
+}
+
+func NewReport() *Report {
+	return &Report{all: newAccumulator(), byKeyword: make(map[int64]*accumulator)}
+}
+
+func (r *Report) Add(serp *SERP) {
+	r.all.add(serp)
+	a, ok := r.byKeyword[serp.KeywordId]
+	if !ok {
+		a = newAccumulator()
+		r.byKeyword[serp.KeywordId] = a
+	}
+	a.add(serp)
+}
+
+func (r *Report) ShareOfVoice() *ShareOfVoice {
+	return &ShareOfVoice{SERPs: r.all.serps, Advertisers: r.all.stats()}
+}
+
+// Positions is Positions of the pages added.
+func (r *Report) Positions() []KeywordPositions {
+	ids := make([]int64, 0, len(r.byKeyword))
+	for id := range r.byKeyword {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	kps := make([]KeywordPositions, 0, len(ids))
+	for _, id := range ids {
+		a := r.byKeyword[id]
+		kps = append(kps, KeywordPositions{KeywordId: id, SERPs: a.serps, Advertisers: a.stats()})
+	}
+	return kps
+}
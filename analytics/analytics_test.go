@@ -0,0 +1,170 @@
+package analytics_test
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gkats/adscraper/analytics"
+)
+
+// fixture reads the observations of two keywords: keyword 1 scraped twice,
+// keyword 2 once while keyword 1 was being scraped and then on two archived
+// pages, 20 seconds apart.
+func fixture(t *testing.T) []analytics.Observation {
+	f, err := os.Open("testdata/observations.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obs := make([]analytics.Observation, 0)
+	for _, r := range records[1:] {
+		keywordId, _ := strconv.ParseInt(r[0], 10, 64)
+		adId, _ := strconv.ParseInt(r[1], 10, 64)
+		pageId, _ := strconv.ParseInt(r[2], 10, 64)
+		position, _ := strconv.Atoi(r[4])
+		at, err := time.Parse(time.RFC3339, r[6])
+		if err != nil {
+			t.Fatal(err)
+		}
+		obs = append(obs, analytics.Observation{
+			KeywordId:  keywordId,
+			AdId:       adId,
+			PageId:     pageId,
+			Advertiser: r[3],
+			Position:   position,
+			Block:      r[5],
+			ObservedAt: at,
+		})
+	}
+	return obs
+}
+
+func stats(ss []analytics.Stats) string {
+	lines := make([]string, 0)
+	for _, s := range ss {
+		lines = append(lines, fmt.Sprintf(
+			"%v %v %.2f %.2f %.2f %v %v %v",
+			s.Advertiser, s.SERPs, s.ImpressionShare, s.AveragePosition, s.TopRate, s.Positions,
+			s.FirstSeenAt.Format(time.RFC3339), s.LastSeenAt.Format(time.RFC3339),
+		))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestSERPs(t *testing.T) {
+	serps := analytics.SERPs(fixture(t))
+	pages := make([]string, 0)
+	for _, serp := range serps {
+		pages = append(pages, fmt.Sprintf("%v/%v/%v", serp.KeywordId, serp.PageId, len(serp.Observations)))
+	}
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{"1/0/3 2/0/2 1/0/2 2/7/1 2/8/1", strings.Join(pages, " ")},
+		{"2026-10-01T10:00:30Z", serps[1].At.Format(time.RFC3339)},
+		{0, len(analytics.SERPs(nil))},
+	}
+	for i, tc := range testCases {
+		if tc.want != tc.got {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
+
+func TestGrouper(t *testing.T) {
+	obs := fixture(t)
+	sort.SliceStable(obs, func(i, j int) bool {
+		if obs[i].KeywordId != obs[j].KeywordId {
+			return obs[i].KeywordId < obs[j].KeywordId
+		}
+		return obs[i].ObservedAt.Before(obs[j].ObservedAt)
+	})
+	pages := make([]string, 0)
+	report := analytics.NewReport()
+	g := analytics.NewGrouper(func(serp *analytics.SERP) {
+		pages = append(pages, fmt.Sprintf("%v/%v/%v", serp.KeywordId, serp.PageId, len(serp.Observations)))
+		report.Add(serp)
+	})
+	for _, o := range obs {
+		g.Add(o)
+	}
+	g.Flush()
+	serps := analytics.SERPs(fixture(t))
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{"1/0/3 1/0/2 2/0/2 2/7/1 2/8/1", strings.Join(pages, " ")},
+		{stats(analytics.NewShareOfVoice(serps).Advertisers), stats(report.ShareOfVoice().Advertisers)},
+		{stats(analytics.Positions(serps)[1].Advertisers), stats(report.Positions()[1].Advertisers)},
+		{0, len(analytics.NewReport().ShareOfVoice().Advertisers)},
+	}
+	for i, tc := range testCases {
+		if tc.want != tc.got {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
+
+func TestShareOfVoice(t *testing.T) {
+	sov := analytics.NewShareOfVoice(analytics.SERPs(fixture(t)))
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{5, sov.SERPs},
+		{strings.Join([]string{
+			"nike.com 3 0.60 1.33 1.00 map[1:2 2:1] 2026-10-01T10:00:02Z 2026-10-02T09:00:20Z",
+			"reebok.com 3 0.60 2.00 0.67 map[1:1 2:1 3:1] 2026-10-01T10:00:00Z 2026-10-01T11:00:01Z",
+			"adidas.com 2 0.40 1.00 1.00 map[1:2] 2026-10-01T10:00:30Z 2026-10-02T09:00:00Z",
+		}, "\n"), stats(sov.Advertisers)},
+		{0, len(analytics.NewShareOfVoice(nil).Advertisers)},
+	}
+	for i, tc := range testCases {
+		if tc.want != tc.got {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
+
+func TestPositions(t *testing.T) {
+	kps := analytics.Positions(analytics.SERPs(fixture(t)))
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{2, len(kps)},
+		{"1 2", fmt.Sprintf("%v %v", kps[0].KeywordId, kps[0].SERPs)},
+		{strings.Join([]string{
+			"nike.com 2 1.00 1.50 1.00 map[1:1 2:1] 2026-10-01T10:00:02Z 2026-10-01T11:00:00Z",
+			"reebok.com 2 1.00 2.00 0.50 map[1:1 3:1] 2026-10-01T10:00:00Z 2026-10-01T11:00:01Z",
+		}, "\n"), stats(kps[0].Advertisers)},
+		{"2 3", fmt.Sprintf("%v %v", kps[1].KeywordId, kps[1].SERPs)},
+		{strings.Join([]string{
+			"adidas.com 2 0.67 1.00 1.00 map[1:2] 2026-10-01T10:00:30Z 2026-10-02T09:00:00Z",
+			"nike.com 1 0.33 1.00 1.00 map[1:1] 2026-10-02T09:00:20Z 2026-10-02T09:00:20Z",
+			"reebok.com 1 0.33 2.00 1.00 map[2:1] 2026-10-01T10:00:31Z 2026-10-01T10:00:31Z",
+		}, "\n"), stats(kps[1].Advertisers)},
+	}
+	for i, tc := range testCases {
+		if tc.want != tc.got {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gkats/adscraper/db"
+)
+
+var (
+	ErrNotFound     = errors.New("API key not found")
+	ErrInvalidScope = errors.New("Scope must be " + strings.Join(Scopes, ", "))
+)
+
+// Scopes of API keys. Scraper keys read keywords and write observations,
+// analyst keys read everything and admin keys can do anything.
+const (
+	Scraper = "scraper"
+	Analyst = "analyst"
+	Admin   = "admin"
+)
+
+var Scopes = []string{Scraper, Analyst, Admin}
+
+// LastUsedPrecision is how often the time a key was last used at is updated,
+// so requests don't all write to the database.
+const LastUsedPrecision = time.Minute
+
+// tokenPrefix starts every token, so leaked ones are easy to search for.
+const tokenPrefix = "ask_"
+
+type Key struct {
+	ID   int64
+	Name string
+	// Prefix is the start of the token, enough to tell keys apart.
+	Prefix     string
+	Scope      string
+	CreatedAt  string
+	LastUsedAt string
+	RevokedAt  string
+}
+
+// Allows reports whether the key has one of the scopes. Admin keys are
+// allowed everything.
+func (k *Key) Allows(scopes ...string) bool {
+	if k.Scope == Admin {
+		return true
+	}
+	for _, s := range scopes {
+		if k.Scope == s {
+			return true
+		}
+	}
+	return false
+}
+
+type Reader interface {
+	// Authenticate returns the key with the token, nil when there's none or
+	// it's been revoked. It records when the key was last used, to the
+	// LastUsedPrecision.
+	Authenticate(token string) (*Key, error)
+	List() ([]Key, error)
+}
+
+func NewReader(s Store) Reader {
+	return &repository{s}
+}
+
+type Writer interface {
+	// Create stores a new key and returns it with its token. Only the hash of
+	// the token is stored, it can't be shown again.
+	Create(name string, scope string) (*Key, string, error)
+	Revoke(id int64) (*Key, error)
+}
+
+func NewWriter(s Store) Writer {
+	return &repository{s}
+}
+
+type ReaderWriter interface {
+	Reader
+	Writer
+}
+
+func NewReaderWriter(s Store) ReaderWriter {
+	return &repository{s}
+}
+
+type Store = db.Store
+
+type repository struct {
+	Store
+}
+
+const columns = `id, name, prefix, scope, created_at, last_used_at, revoked_at`
+
+func scan(s interface{ Scan(...interface{}) error }, k *Key) error {
+	lastUsedAt, revokedAt := sql.NullString{}, sql.NullString{}
+	err := s.Scan(&k.ID, &k.Name, &k.Prefix, &k.Scope, &k.CreatedAt, &lastUsedAt, &revokedAt)
+	k.LastUsedAt, k.RevokedAt = lastUsedAt.String, revokedAt.String
+	return err
+}
+
+func validate(name string, scope string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("Name can't be blank")
+	}
+	for _, s := range Scopes {
+		if s == scope {
+			return nil
+		}
+	}
+	return ErrInvalidScope
+}
+
+// newToken returns a random token and its prefix.
+func newToken() (string, string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, token[:len(tokenPrefix)+8], nil
+}
+
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (r *repository) Create(name string, scope string) (*Key, string, error) {
+	if err := validate(name, scope); err != nil {
+		return nil, "", err
+	}
+	token, prefix, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+	k := &Key{}
+	err = scan(r.Store.QueryRow(
+		`
+    INSERT INTO api_keys (name, prefix, hash, scope)
+    VALUES ($1, $2, $3, $4)
+    RETURNING `+columns+`
+    `,
+		strings.TrimSpace(name), prefix, hash(token), scope,
+	), k)
+	return k, token, err
+}
+
+func (r *repository) Revoke(id int64) (*Key, error) {
+	k := &Key{}
+	err := scan(r.Store.QueryRow(
+		`
+    UPDATE api_keys
+    SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP)
+    WHERE id = $1
+    RETURNING `+columns+`
+    `,
+		id,
+	), k)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return k, err
+}
+
+func (r *repository) Authenticate(token string) (*Key, error) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return nil, nil
+	}
+	ts := func(expr string) string {
+		return db.Timestamp(r.Dialect(), expr)
+	}
+	k := &Key{}
+	err := scan(r.Store.QueryRow(
+		`
+    UPDATE api_keys
+    SET last_used_at = CURRENT_TIMESTAMP
+    WHERE hash = $1
+    AND revoked_at IS NULL
+    AND (last_used_at IS NULL OR `+ts("last_used_at")+` < `+ts("$2")+`)
+    RETURNING `+columns+`
+    `,
+		hash(token),
+		time.Now().UTC().Add(-LastUsedPrecision),
+	), k)
+	if err == sql.ErrNoRows {
+		// Used less than LastUsedPrecision ago
+		err = scan(r.Store.QueryRow(
+			`SELECT `+columns+` FROM api_keys WHERE hash = $1 AND revoked_at IS NULL`,
+			hash(token),
+		), k)
+	}
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return k, err
+}
+
+func (r *repository) List() ([]Key, error) {
+	rows, err := r.Store.Query(`SELECT ` + columns + ` FROM api_keys ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ks := make([]Key, 0)
+	for rows.Next() {
+		k := Key{}
+		if err = scan(rows, &k); err != nil {
+			return ks, err
+		}
+		ks = append(ks, k)
+	}
+	return ks, rows.Err()
+}
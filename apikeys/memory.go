@@ -0,0 +1,85 @@
+package apikeys
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewMemoryReaderWriter returns a ReaderWriter that keeps keys in memory. It's
+// safe for concurrent use and meant for tests.
+func NewMemoryReaderWriter() ReaderWriter {
+	return &memory{hashes: make(map[string]int)}
+}
+
+type memory struct {
+	mu   sync.Mutex
+	keys []Key
+	// hashes holds the index of each key by the hash of its token
+	hashes map[string]int
+}
+
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+func (m *memory) Create(name string, scope string) (*Key, string, error) {
+	if err := validate(name, scope); err != nil {
+		return nil, "", err
+	}
+	token, prefix, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := Key{
+		ID:        int64(len(m.keys) + 1),
+		Name:      strings.TrimSpace(name),
+		Prefix:    prefix,
+		Scope:     scope,
+		CreatedAt: now(),
+	}
+	m.hashes[hash(token)] = len(m.keys)
+	m.keys = append(m.keys, k)
+	return &k, token, nil
+}
+
+func (m *memory) Revoke(id int64) (*Key, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > int64(len(m.keys)) {
+		return nil, ErrNotFound
+	}
+	k := &m.keys[id-1]
+	if k.RevokedAt == "" {
+		k.RevokedAt = now()
+	}
+	revoked := *k
+	return &revoked, nil
+}
+
+func (m *memory) Authenticate(token string) (*Key, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.hashes[hash(token)]
+	if !ok || m.keys[i].RevokedAt != "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, m.keys[i].LastUsedAt); err != nil || time.Since(t) >= LastUsedPrecision {
+		m.keys[i].LastUsedAt = now()
+	}
+	k := m.keys[i]
+	return &k, nil
+}
+
+func (m *memory) List() ([]Key, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append(make([]Key, 0, len(m.keys)), m.keys...), nil
+}
@@ -0,0 +1,182 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+var (
+	ErrNotFound = errors.New("blob not found")
+	ErrCorrupt  = errors.New("blob content doesn't match its hash")
+
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// BlobStore keeps opaque blobs by key.
+type BlobStore interface {
+	Put(key string, r io.Reader) error
+	Get(key string) (io.ReadCloser, error)
+	Exists(key string) (bool, error)
+}
+
+// Archive stores documents compressed, addressed by the SHA-256 of their
+// uncompressed content. Storing the same document twice is a no-op.
+type Archive struct {
+	blobs       BlobStore
+	compression string
+}
+
+func New(blobs BlobStore, compression string) (*Archive, error) {
+	if compression != Gzip && compression != Zstd {
+		return nil, fmt.Errorf("Unknown compression %q", compression)
+	}
+	return &Archive{blobs: blobs, compression: compression}, nil
+}
+
+type Config struct {
+	URL         string
+	Compression string
+}
+
+func (c *Config) Flags(fs *flag.FlagSet) {
+	fs.StringVar(&c.URL, "archive", os.Getenv("ARCHIVE_URL"), "Where to archive fetched pages. Either a directory path, 'file:///path/to/dir' or 's3://bucket/prefix?endpoint=host:port&secure=false' for S3-compatible storage. When empty, pages aren't archived.")
+	fs.StringVar(&c.Compression, "archive-compression", Zstd, "Compression for archived pages, 'zstd' or 'gzip'.")
+}
+
+// Open returns the Archive configured by c, or nil if c.URL is empty.
+func Open(c Config) (*Archive, error) {
+	if c.URL == "" {
+		return nil, nil
+	}
+	blobs, err := OpenStore(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	return New(blobs, c.Compression)
+}
+
+// OpenStore returns the BlobStore for a directory path, a file:// URL or an
+// s3:// URL. S3 credentials are read from the AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY (or MINIO_ACCESS_KEY and MINIO_SECRET_KEY)
+// environment variables.
+func OpenStore(u string) (BlobStore, error) {
+	if strings.HasPrefix(u, "s3://") {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return nil, err
+		}
+		return NewS3Store(parsed)
+	}
+	return NewDirStore(strings.TrimPrefix(u, "file://")), nil
+}
+
+func Hash(doc []byte) string {
+	sum := sha256.Sum256(doc)
+	return hex.EncodeToString(sum[:])
+}
+
+func key(hash string) string {
+	return hash[:2] + "/" + hash
+}
+
+// Put archives doc and returns its hash.
+func (a *Archive) Put(doc []byte) (string, error) {
+	hash := Hash(doc)
+	if ok, err := a.blobs.Exists(key(hash)); err != nil || ok {
+		return hash, err
+	}
+
+	compressed, err := a.compress(doc)
+	if err != nil {
+		return hash, err
+	}
+	return hash, a.blobs.Put(key(hash), bytes.NewReader(compressed))
+}
+
+// Get returns the archived document with the given hash. Documents are
+// decompressed according to their content, so changing the compression
+// doesn't affect the existing archive.
+func (a *Archive) Get(hash string) ([]byte, error) {
+	if len(hash) < 2 {
+		return nil, ErrNotFound
+	}
+	r, err := a.blobs.Get(key(hash))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	compressed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := decompress(compressed)
+	if err != nil {
+		return nil, err
+	}
+	if Hash(doc) != hash {
+		return nil, ErrCorrupt
+	}
+	return doc, nil
+}
+
+func (a *Archive) compress(doc []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+	if a.compression == Gzip {
+		w = gzip.NewWriter(buf)
+	} else {
+		zw, err := zstd.NewWriter(buf)
+		if err != nil {
+			return nil, err
+		}
+		w = zw
+	}
+
+	if _, err := w.Write(doc); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(compressed []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(compressed, gzipMagic):
+		r, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case bytes.HasPrefix(compressed, zstdMagic):
+		r, err := zstd.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+	return nil, errors.New("Unknown blob compression")
+}
@@ -0,0 +1,86 @@
+package archive_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gkats/adscraper/archive"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	doc := []byte(strings.Repeat("<li class=\"ads-ad\">Women Shoes | Reebok GR - Reebok.com</li>", 100))
+
+	for _, compression := range []string{archive.Zstd, archive.Gzip} {
+		dir := t.TempDir()
+		a, err := archive.Open(archive.Config{URL: dir, Compression: compression})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hash, err := a.Put(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash != archive.Hash(doc) {
+			t.Errorf("(%v) Expected hash %v, got %v", compression, archive.Hash(doc), hash)
+		}
+		if again, _ := a.Put(doc); again != hash {
+			t.Errorf("(%v) Expected the same hash for the same content, got %v", compression, again)
+		}
+
+		blob, err := ioutil.ReadFile(filepath.Join(dir, hash[:2], hash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blob) >= len(doc) {
+			t.Errorf("(%v) Expected blob to be compressed, got %v bytes", compression, len(blob))
+		}
+
+		got, err := a.Get(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, doc) {
+			t.Errorf("(%v) Expected archived document back", compression)
+		}
+	}
+}
+
+func TestArchiveReadsAnyCompression(t *testing.T) {
+	blobs := archive.NewMemoryStore()
+	gz, _ := archive.New(blobs, archive.Gzip)
+	zst, _ := archive.New(blobs, archive.Zstd)
+
+	hash, err := gz.Put([]byte("<html></html>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := zst.Get(hash); err != nil || string(got) != "<html></html>" {
+		t.Errorf("Expected gzip blob to be readable, got %q (%v)", got, err)
+	}
+}
+
+func TestArchiveErrors(t *testing.T) {
+	dir := t.TempDir()
+	a, _ := archive.New(archive.NewDirStore(dir), archive.Gzip)
+
+	if _, err := a.Get(archive.Hash([]byte("missing"))); err != archive.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	hash, _ := a.Put([]byte("original"))
+	b, _ := archive.New(archive.NewDirStore(dir), archive.Gzip)
+	other, _ := b.Put([]byte("tampered"))
+	os.Rename(filepath.Join(dir, other[:2], other), filepath.Join(dir, hash[:2], hash))
+	if _, err := a.Get(hash); err != archive.ErrCorrupt {
+		t.Errorf("Expected ErrCorrupt, got %v", err)
+	}
+
+	if _, err := archive.New(archive.NewMemoryStore(), "lz4"); err == nil {
+		t.Errorf("Expected unknown compression to fail")
+	}
+}
@@ -0,0 +1,47 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// NewMemoryStore returns a BlobStore that keeps blobs in memory. It's safe
+// for concurrent use and meant for tests and dry runs.
+func NewMemoryStore() BlobStore {
+	return &memoryStore{blobs: make(map[string][]byte)}
+}
+
+type memoryStore struct {
+	mu    sync.RWMutex
+	blobs map[string][]byte
+}
+
+func (s *memoryStore) Put(key string, r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.blobs[key] = b
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryStore) Get(key string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.blobs[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *memoryStore) Exists(key string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.blobs[key]
+	return ok, nil
+}
@@ -0,0 +1,87 @@
+package archive
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// NewS3Store returns a BlobStore backed by an S3-compatible bucket, as
+// described by an 's3://bucket/prefix' URL. The endpoint defaults to AWS
+// and can be pointed elsewhere, MinIO for example, with the endpoint,
+// secure and region query parameters.
+func NewS3Store(u *url.URL) (BlobStore, error) {
+	q := u.Query()
+	endpoint := q.Get("endpoint")
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+		}),
+		Secure: q.Get("secure") != "false",
+		Region: q.Get("region"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3Store{
+		client: client,
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+	}, nil
+}
+
+type s3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func (s *s3Store) object(key string) string {
+	return path.Join(s.prefix, key)
+}
+
+func (s *s3Store) Put(key string, r io.Reader) error {
+	_, err := s.client.PutObject(
+		context.Background(), s.bucket, s.object(key), r, -1,
+		minio.PutObjectOptions{ContentType: "application/octet-stream"},
+	)
+	return err
+}
+
+func (s *s3Store) Get(key string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(context.Background(), s.bucket, s.object(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy, stat to surface missing objects right away
+	if _, err = obj.Stat(); err != nil {
+		obj.Close()
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *s3Store) Exists(key string) (bool, error) {
+	_, err := s.client.StatObject(context.Background(), s.bucket, s.object(key), minio.StatObjectOptions{})
+	if isNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func isNotFound(err error) bool {
+	return err != nil && minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
@@ -0,0 +1,482 @@
+package adscraper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gkats/adscraper/brands"
+	"github.com/gkats/adscraper/keywords"
+	"github.com/gorilla/mux"
+)
+
+// Limits of the brand-bidding incidents listed.
+const (
+	DefaultIncidentsLimit = 100
+	MaxIncidentsLimit     = 1000
+)
+
+type brandJSON struct {
+	ID         int64    `json:"id"`
+	Advertiser string   `json:"advertiser"`
+	Terms      []string `json:"terms"`
+	CreatedAt  string   `json:"createdAt"`
+	UpdatedAt  string   `json:"updatedAt"`
+}
+
+func newBrandJSON(b *brands.Brand) brandJSON {
+	return brandJSON{
+		ID:         b.ID,
+		Advertiser: b.Advertiser,
+		Terms:      b.Terms,
+		CreatedAt:  b.CreatedAt,
+		UpdatedAt:  b.UpdatedAt,
+	}
+}
+
+type brandParamsJSON struct {
+	Advertiser string   `json:"advertiser"`
+	Terms      []string `json:"terms"`
+}
+
+func (p *brandParamsJSON) validate(update bool) []fieldError {
+	errs := make([]fieldError, 0)
+	if update {
+		// The advertiser can't change
+	} else if a := strings.TrimSpace(p.Advertiser); a == "" {
+		errs = append(errs, fieldError{Field: "advertiser", Message: "can't be blank"})
+	} else if strings.ContainsAny(a, "/ ") {
+		errs = append(errs, fieldError{Field: "advertiser", Message: "must be a domain, like example.com"})
+	}
+	if len(p.Terms) == 0 {
+		errs = append(errs, fieldError{Field: "terms", Message: "can't be empty"})
+	}
+	for i, t := range p.Terms {
+		if strings.TrimSpace(t) == "" {
+			errs = append(errs, fieldError{Field: fmt.Sprintf("terms[%v]", i), Message: "can't be blank"})
+		}
+	}
+	return errs
+}
+
+// ToBrand returns the brand with whitespace in its terms collapsed, keeping
+// the first of terms that only differ in case since they match the same
+// keywords.
+func (p *brandParamsJSON) ToBrand() *brands.Brand {
+	terms := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, t := range p.Terms {
+		if t = strings.Join(strings.Fields(t), " "); !seen[strings.ToLower(t)] {
+			seen[strings.ToLower(t)] = true
+			terms = append(terms, t)
+		}
+	}
+	return &brands.Brand{
+		Advertiser: strings.TrimPrefix(strings.TrimSpace(p.Advertiser), "www."),
+		Terms:      terms,
+	}
+}
+
+type incidentJSON struct {
+	ID        int64  `json:"id"`
+	BrandId   int64  `json:"brandId"`
+	Brand     string `json:"brand"`
+	Term      string `json:"term"`
+	KeywordId int64  `json:"keywordId"`
+	Keyword   string `json:"keyword,omitempty"`
+	AdId      int64  `json:"adId"`
+	// Advertiser is the advertiser bidding on the brand.
+	Advertiser  string `json:"advertiser"`
+	Position    int    `json:"position"`
+	Block       string `json:"block"`
+	Evidence    string `json:"evidence"`
+	FirstSeenAt string `json:"firstSeenAt"`
+	LastSeenAt  string `json:"lastSeenAt"`
+}
+
+func newIncidentJSON(i *brands.Incident) incidentJSON {
+	return incidentJSON{
+		ID:          i.ID,
+		BrandId:     i.BrandId,
+		Brand:       i.Brand,
+		Term:        i.Term,
+		KeywordId:   i.KeywordId,
+		AdId:        i.AdId,
+		Advertiser:  i.Bidder,
+		Position:    i.Position,
+		Block:       i.Block,
+		Evidence:    i.Evidence,
+		FirstSeenAt: i.FirstSeenAt,
+		LastSeenAt:  i.LastSeenAt,
+	}
+}
+
+// BrandBiddingReport is what DetectBrandBidding went through.
+type BrandBiddingReport struct {
+	Observations int
+	Incidents    int
+	New          int
+}
+
+func (r *BrandBiddingReport) String() string {
+	return fmt.Sprintf("%v observations, %v brand-bidding incidents, %v new", r.Observations, r.Incidents, r.New)
+}
+
+// DetectBrandBidding records the brand-bidding incidents of the observations
+// matching q, for brands added after the ads were ingested. Incidents are
+// recorded like they are on ingestion, but new ones don't raise alerts.
+func DetectBrandBidding(r *Repositories, q AdQuery) (*BrandBiddingReport, error) {
+	report := &BrandBiddingReport{}
+	bs, err := r.Brands.List()
+	if err != nil || len(bs) == 0 {
+		return report, err
+	}
+	obs := make([]Observation, 0)
+	err = r.Ads.ExportObservations(q, func(o *Observation) error {
+		obs = append(obs, *o)
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	// Ads are only looked up for the keywords with a brand in them
+	ads, values := newAdCache(r.Ads), make(map[int64]string)
+	for _, o := range obs {
+		report.Observations++
+		v, ok := values[o.KeywordId]
+		if !ok {
+			k, err := r.Keywords.Find(o.KeywordId)
+			if err != nil {
+				return report, err
+			} else if k != nil && hasBrand(bs, k.Value) {
+				v = k.Value
+			}
+			values[o.KeywordId] = v
+		}
+		if v == "" {
+			continue
+		}
+		ad, err := ads.find(o.AdId)
+		if err != nil {
+			return report, err
+		}
+		at, err := time.Parse(time.RFC3339Nano, o.ObservedAt)
+		if err != nil {
+			return report, err
+		}
+		for _, inc := range brands.Detect(bs, v, ad.Advertiser()) {
+			inc.KeywordId, inc.AdId, inc.Position, inc.Block, inc.Evidence = o.KeywordId, o.AdId, o.Position, o.Block, ad.GetRaw()
+			created, err := r.Brands.Record(&inc, at)
+			if err != nil {
+				return report, err
+			}
+			report.Incidents++
+			if created {
+				report.New++
+			}
+		}
+	}
+	return report, nil
+}
+
+func hasBrand(bs []brands.Brand, keyword string) bool {
+	for i := range bs {
+		if bs[i].Match(keyword) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+type indexBrandsHandler struct {
+	brandsReader brands.Reader
+}
+
+func (h *indexBrandsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bs, err := h.brandsReader.List()
+	if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	}
+	bsJSON := make([]brandJSON, 0, len(bs))
+	for i := range bs {
+		bsJSON = append(bsJSON, newBrandJSON(&bs[i]))
+	}
+	writeResponse(w, ok(bsJSON))
+}
+
+func indexBrands(r *Repositories) http.Handler {
+	return &indexBrandsHandler{brandsReader: r.Brands}
+}
+
+type createBrandHandler struct {
+	brandsWriter brands.Writer
+}
+
+// ServeHTTP adds the dictionary of an advertiser's brand. Brand bidding is
+// detected for the ads ingested from then on, see DetectBrandBidding for the
+// ones before.
+func (h *createBrandHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := &brandParamsJSON{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		writeResponse(w, decodeError(err))
+		return
+	}
+	if errs := params.validate(false); len(errs) > 0 {
+		writeResponse(w, invalid(errs))
+		return
+	}
+
+	b := params.ToBrand()
+	if err := h.brandsWriter.Create(b); err == brands.ErrExists {
+		writeResponse(w, conflict())
+		return
+	} else if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	}
+	writeResponse(w, &successResponse{status: http.StatusCreated, body: newBrandJSON(b)})
+}
+
+func createBrand(r *Repositories) http.Handler {
+	return &createBrandHandler{brandsWriter: r.Brands}
+}
+
+type showBrandHandler struct {
+	brandsReader brands.Reader
+}
+
+func (h *showBrandHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+	b, err := h.brandsReader.Find(id)
+	if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	} else if b == nil {
+		writeResponse(w, notFound())
+		return
+	}
+	writeResponse(w, ok(newBrandJSON(b)))
+}
+
+func showBrand(r *Repositories) http.Handler {
+	return &showBrandHandler{brandsReader: r.Brands}
+}
+
+type updateBrandHandler struct {
+	brandsWriter brands.Writer
+}
+
+// ServeHTTP replaces the terms of a brand. Its advertiser can't change.
+func (h *updateBrandHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+	params := &brandParamsJSON{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		writeResponse(w, decodeError(err))
+		return
+	}
+	if errs := params.validate(true); len(errs) > 0 {
+		writeResponse(w, invalid(errs))
+		return
+	}
+
+	b := params.ToBrand()
+	b.ID = id
+	if err = h.brandsWriter.Update(b); err == brands.ErrNotFound {
+		writeResponse(w, notFound())
+		return
+	} else if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	}
+	writeResponse(w, ok(newBrandJSON(b)))
+}
+
+func updateBrand(r *Repositories) http.Handler {
+	return &updateBrandHandler{brandsWriter: r.Brands}
+}
+
+type deleteBrandHandler struct {
+	brandsWriter brands.Writer
+}
+
+func (h *deleteBrandHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+	b, err := h.brandsWriter.Delete(id)
+	if err == brands.ErrNotFound {
+		writeResponse(w, notFound())
+		return
+	} else if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	}
+	writeResponse(w, ok(newBrandJSON(b)))
+}
+
+func deleteBrand(r *Repositories) http.Handler {
+	return &deleteBrandHandler{brandsWriter: r.Brands}
+}
+
+type indexIncidentsHandler struct {
+	brandsReader   brands.Reader
+	keywordsReader keywords.Reader
+}
+
+// newIncidentQuery reads the filters of the incidents listed. brand and
+// keyword are looked up by the handler.
+func newIncidentQuery(params url.Values) (brands.IncidentQuery, error) {
+	q := brands.IncidentQuery{Bidder: strings.TrimPrefix(params.Get("advertiser"), "www.")}
+	var err error
+	if q.BrandId, err = int64Param(params, "brandId"); err != nil {
+		return q, err
+	}
+	if q.KeywordId, err = int64Param(params, "keywordId"); err != nil {
+		return q, err
+	}
+	if q.From, err = timeParam(params, "from", false); err != nil {
+		return q, err
+	}
+	if q.To, err = timeParam(params, "to", true); err != nil {
+		return q, err
+	}
+	limit, err := int64Param(params, "limit")
+	if err != nil || limit > MaxIncidentsLimit {
+		return q, fmt.Errorf("Invalid limit %v", limit)
+	} else if limit == 0 {
+		limit = DefaultIncidentsLimit
+	}
+	q.Limit = int(limit)
+	return q, nil
+}
+
+// ServeHTTP lists the incidents of advertisers bidding on other advertisers'
+// brands, the most recently seen first, with the ad's raw HTML as evidence.
+func (h *indexIncidentsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q, err := newIncidentQuery(params)
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+	if b, err := findBrand(h.brandsReader, params.Get("brand")); err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	} else if b != nil {
+		q.BrandId = b.ID
+	} else if params.Get("brand") != "" {
+		writeResponse(w, ok([]incidentJSON{}))
+		return
+	}
+	if v := params.Get("keyword"); v != "" {
+		k, err := h.keywordsReader.FindByValue(v)
+		if err != nil {
+			writeResponse(w, internalServerError(err))
+			return
+		} else if k == nil {
+			writeResponse(w, ok([]incidentJSON{}))
+			return
+		}
+		q.KeywordId = k.ID
+	}
+	is, err := h.brandsReader.Incidents(q)
+	if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	}
+
+	values := make(map[int64]string)
+	isJSON := make([]incidentJSON, 0, len(is))
+	for i := range is {
+		ij := newIncidentJSON(&is[i])
+		if ij.Keyword, err = keywordValue(h.keywordsReader, values, ij.KeywordId); err != nil {
+			writeResponse(w, internalServerError(err))
+			return
+		}
+		isJSON = append(isJSON, ij)
+	}
+	writeResponse(w, ok(isJSON))
+}
+
+// findBrand returns the brand of the advertiser, nil when it has none.
+func findBrand(r brands.Reader, advertiser string) (*brands.Brand, error) {
+	if advertiser == "" {
+		return nil, nil
+	}
+	bs, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	advertiser = strings.ToLower(strings.TrimPrefix(advertiser, "www."))
+	for i := range bs {
+		if bs[i].Advertiser == advertiser {
+			return &bs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// keywordValue looks up the value of a keyword, once.
+func keywordValue(r keywords.Reader, values map[int64]string, id int64) (string, error) {
+	if v, ok := values[id]; ok {
+		return v, nil
+	}
+	k, err := r.Find(id)
+	if err != nil {
+		return "", err
+	} else if k != nil {
+		values[id] = k.Value
+	}
+	return values[id], nil
+}
+
+func indexIncidents(r *Repositories) http.Handler {
+	return &indexIncidentsHandler{brandsReader: r.Brands, keywordsReader: r.Keywords}
+}
+
+type showIncidentHandler struct {
+	brandsReader   brands.Reader
+	keywordsReader keywords.Reader
+}
+
+func (h *showIncidentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+	i, err := h.brandsReader.FindIncident(id)
+	if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	} else if i == nil {
+		writeResponse(w, notFound())
+		return
+	}
+	ij := newIncidentJSON(i)
+	if ij.Keyword, err = keywordValue(h.keywordsReader, make(map[int64]string), i.KeywordId); err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	}
+	writeResponse(w, ok(ij))
+}
+
+func showIncident(r *Repositories) http.Handler {
+	return &showIncidentHandler{brandsReader: r.Brands, keywordsReader: r.Keywords}
+}
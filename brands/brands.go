@@ -0,0 +1,437 @@
+// Package brands detects advertisers bidding on keywords with another
+// advertiser's brand in them, like a competitor's ad shown for "reebok women
+// shoes".
+package brands
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gkats/adscraper/db"
+)
+
+var (
+	ErrNotFound = errors.New("Brand not found")
+	ErrExists   = errors.New("The advertiser already has a brand")
+)
+
+// Brand is the dictionary of an advertiser's brand: the terms that make a
+// keyword theirs.
+type Brand struct {
+	ID int64
+	// Advertiser is the domain of the brand's owner. Its subdomains are the
+	// owner's too.
+	Advertiser string
+	Terms      []string
+	CreatedAt  string
+	UpdatedAt  string
+	DeletedAt  string
+}
+
+// Owns reports whether the advertiser is the brand's owner.
+func (b *Brand) Owns(advertiser string) bool {
+	d := strings.ToLower(b.Advertiser)
+	return advertiser == d || strings.HasSuffix(advertiser, "."+d)
+}
+
+// Match returns the first of the brand's terms in the keyword, as whole words
+// and ignoring case, like reebok in "Reebok women shoes" but not in
+// "reeboks". It's empty when there's none.
+func (b *Brand) Match(keyword string) string {
+	kw := words(keyword)
+	for _, t := range b.Terms {
+		tw := words(t)
+		if len(tw) == 0 {
+			continue
+		}
+		for i := 0; i+len(tw) <= len(kw); i++ {
+			if strings.Join(kw[i:i+len(tw)], " ") == strings.Join(tw, " ") {
+				return t
+			}
+		}
+	}
+	return ""
+}
+
+// words splits text into lowercase words, dropping punctuation.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Incident is an advertiser's ad shown for a keyword with another
+// advertiser's brand in it. There's one for each brand, keyword and ad, seen
+// from FirstSeenAt to LastSeenAt.
+type Incident struct {
+	ID      int64
+	BrandId int64
+	// Brand is the advertiser whose brand was bid on.
+	Brand     string
+	Term      string
+	KeywordId int64
+	AdId      int64
+	// Bidder is the advertiser of the ad.
+	Bidder   string
+	Position int
+	Block    string
+	// Evidence is the raw HTML of the ad when it was last seen, kept even
+	// after retention prunes the ad's.
+	Evidence    string
+	FirstSeenAt string
+	LastSeenAt  string
+}
+
+// Detect returns the incidents of an ad of the advertiser shown for the
+// keyword, one for each brand with a term in the keyword that the advertiser
+// doesn't own.
+func Detect(brands []Brand, keyword string, advertiser string) []Incident {
+	incidents := make([]Incident, 0)
+	if advertiser == "" {
+		return incidents
+	}
+	for _, b := range brands {
+		if b.Owns(advertiser) {
+			continue
+		}
+		if t := b.Match(keyword); t != "" {
+			incidents = append(incidents, Incident{BrandId: b.ID, Brand: b.Advertiser, Term: t, Bidder: advertiser})
+		}
+	}
+	return incidents
+}
+
+// IncidentQuery filters incidents. Zero values match everything.
+type IncidentQuery struct {
+	BrandId int64
+	// Bidder matches incidents of the domain and its subdomains.
+	Bidder    string
+	KeywordId int64
+	// From and To match incidents seen in between.
+	From  time.Time
+	To    time.Time
+	Limit int
+}
+
+type Reader interface {
+	// Find returns the brand with the id, nil when there's none or it's been
+	// deleted.
+	Find(id int64) (*Brand, error)
+	List() ([]Brand, error)
+	// FindIncident returns the incident with the id, nil when there's none or
+	// its brand has been deleted.
+	FindIncident(id int64) (*Incident, error)
+	// Incidents returns the incidents matching q, the most recently seen
+	// first.
+	Incidents(q IncidentQuery) ([]Incident, error)
+}
+
+func NewReader(s Store) Reader {
+	return &repository{s}
+}
+
+type Writer interface {
+	// Create stores a brand. Advertisers have a single brand, ErrExists is
+	// returned when there's one already.
+	Create(*Brand) error
+	// Update replaces the terms of a brand.
+	Update(*Brand) error
+	// Delete deletes a brand. Its incidents aren't listed anymore.
+	Delete(id int64) (*Brand, error)
+	// Record stores an incident seen at a time and reports whether it's new,
+	// see Record.
+	Record(inc *Incident, at time.Time) (bool, error)
+}
+
+func NewWriter(s Store) Writer {
+	return &repository{s}
+}
+
+type ReaderWriter interface {
+	Reader
+	Writer
+}
+
+func NewReaderWriter(s Store) ReaderWriter {
+	return &repository{s}
+}
+
+type Store = db.Store
+
+type repository struct {
+	Store
+}
+
+const columns = `id, advertiser, terms, created_at, updated_at, deleted_at`
+
+func scan(s interface{ Scan(...interface{}) error }, b *Brand) error {
+	terms, deletedAt := "", sql.NullString{}
+	err := s.Scan(&b.ID, &b.Advertiser, &terms, &b.CreatedAt, &b.UpdatedAt, &deletedAt)
+	b.Terms, b.DeletedAt = lines(terms), deletedAt.String
+	return err
+}
+
+func lines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, "\n")
+}
+
+func (r *repository) Create(b *Brand) error {
+	tx, err := r.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b.Advertiser = strings.ToLower(b.Advertiser)
+	var exists bool
+	err = tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM brands WHERE advertiser = $1 AND deleted_at IS NULL)`,
+		b.Advertiser,
+	).Scan(&exists)
+	if err != nil {
+		return err
+	} else if exists {
+		return ErrExists
+	}
+	err = scan(tx.QueryRow(
+		`INSERT INTO brands (advertiser, terms) VALUES ($1, $2) RETURNING `+columns,
+		b.Advertiser, strings.Join(b.Terms, "\n"),
+	), b)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *repository) Find(id int64) (*Brand, error) {
+	b := &Brand{}
+	err := scan(r.Store.QueryRow(`SELECT `+columns+` FROM brands WHERE id = $1 AND deleted_at IS NULL`, id), b)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return b, err
+}
+
+func (r *repository) List() ([]Brand, error) {
+	return List(r.Store)
+}
+
+// List is Reader.List with q, usually the transaction of the ads brand
+// bidding is detected for.
+func List(q db.Querier) ([]Brand, error) {
+	rows, err := q.Query(`SELECT ` + columns + ` FROM brands WHERE deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bs := make([]Brand, 0)
+	for rows.Next() {
+		b := Brand{}
+		if err = scan(rows, &b); err != nil {
+			return bs, err
+		}
+		bs = append(bs, b)
+	}
+	return bs, rows.Err()
+}
+
+func (r *repository) Update(b *Brand) error {
+	err := scan(r.Store.QueryRow(
+		`
+    UPDATE brands
+    SET terms = $1, updated_at = CURRENT_TIMESTAMP
+    WHERE id = $2
+    AND deleted_at IS NULL
+    RETURNING `+columns+`
+    `,
+		strings.Join(b.Terms, "\n"), b.ID,
+	), b)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r *repository) Delete(id int64) (*Brand, error) {
+	b := &Brand{}
+	err := scan(r.Store.QueryRow(
+		`
+    UPDATE brands
+    SET deleted_at = CURRENT_TIMESTAMP
+    WHERE id = $1
+    AND deleted_at IS NULL
+    RETURNING `+columns+`
+    `,
+		id,
+	), b)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+const incidentColumns = `i.id, i.brand_id, b.advertiser, i.term, i.keyword_id, i.ad_id, i.bidder, i.position,
+    i.block, i.evidence, i.first_seen_at, i.last_seen_at`
+
+func scanIncident(s interface{ Scan(...interface{}) error }, i *Incident) error {
+	return s.Scan(
+		&i.ID, &i.BrandId, &i.Brand, &i.Term, &i.KeywordId, &i.AdId, &i.Bidder, &i.Position,
+		&i.Block, &i.Evidence, &i.FirstSeenAt, &i.LastSeenAt,
+	)
+}
+
+func (r *repository) FindIncident(id int64) (*Incident, error) {
+	return findIncident(r.Store, id)
+}
+
+func findIncident(q db.Querier, id int64) (*Incident, error) {
+	i := &Incident{}
+	err := scanIncident(q.QueryRow(
+		`
+    SELECT `+incidentColumns+`
+    FROM brand_incidents i
+    JOIN brands b ON b.id = i.brand_id
+    WHERE i.id = $1
+    AND b.deleted_at IS NULL
+    `,
+		id,
+	), i)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return i, err
+}
+
+func (r *repository) Incidents(q IncidentQuery) ([]Incident, error) {
+	ts := func(expr string) string {
+		return db.Timestamp(r.Dialect(), expr)
+	}
+	where, args := []string{"b.deleted_at IS NULL"}, make([]interface{}, 0)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if q.BrandId > 0 {
+		where = append(where, "i.brand_id = "+arg(q.BrandId))
+	}
+	if d := strings.ToLower(q.Bidder); d != "" {
+		where = append(where, "(i.bidder = "+arg(d)+" OR "+db.Like("i.bidder", arg("%."+db.EscapeLike(d)))+")")
+	}
+	if q.KeywordId > 0 {
+		where = append(where, "i.keyword_id = "+arg(q.KeywordId))
+	}
+	if !q.From.IsZero() {
+		where = append(where, ts("i.last_seen_at")+" >= "+ts(arg(q.From.UTC())))
+	}
+	if !q.To.IsZero() {
+		where = append(where, ts("i.first_seen_at")+" <= "+ts(arg(q.To.UTC())))
+	}
+	limit := ""
+	if q.Limit > 0 {
+		limit = "LIMIT " + arg(q.Limit)
+	}
+	rows, err := r.Store.Query(
+		`
+    SELECT `+incidentColumns+`
+    FROM brand_incidents i
+    JOIN brands b ON b.id = i.brand_id
+    WHERE `+strings.Join(where, " AND ")+`
+    ORDER BY `+ts("i.last_seen_at")+` DESC, i.id DESC
+    `+limit,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	is := make([]Incident, 0)
+	for rows.Next() {
+		i := Incident{}
+		if err = scanIncident(rows, &i); err != nil {
+			return is, err
+		}
+		is = append(is, i)
+	}
+	return is, rows.Err()
+}
+
+func (r *repository) Record(inc *Incident, at time.Time) (bool, error) {
+	tx, err := r.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	created, err := Record(tx, inc, at)
+	if err != nil {
+		return false, err
+	}
+	return created, tx.Commit()
+}
+
+// Record is Writer.Record with q, usually the transaction storing the
+// observation of the ad. Incidents seen again are kept as they were last
+// seen, so recording old observations doesn't overwrite newer evidence.
+func Record(q db.Querier, inc *Incident, at time.Time) (bool, error) {
+	at = at.UTC()
+	var id int64
+	err := q.QueryRow(
+		`
+    INSERT INTO brand_incidents (brand_id, keyword_id, ad_id, term, bidder, position, block, evidence, first_seen_at, last_seen_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+    ON CONFLICT (brand_id, keyword_id, ad_id) DO NOTHING
+    RETURNING id
+    `,
+		inc.BrandId, inc.KeywordId, inc.AdId, inc.Term, inc.Bidder, inc.Position, inc.Block, inc.Evidence, at,
+	).Scan(&id)
+	created := err == nil
+	if err == sql.ErrNoRows {
+		// Sightings can be recorded out of order, the latest one's details
+		// are kept
+		_, err = q.Exec(
+			`
+    UPDATE brand_incidents
+    SET term = $1, bidder = $2, position = $3, block = $4, evidence = $5, last_seen_at = $6
+    WHERE brand_id = $7 AND keyword_id = $8 AND ad_id = $9
+    AND last_seen_at <= $6
+    `,
+			inc.Term, inc.Bidder, inc.Position, inc.Block, inc.Evidence, at, inc.BrandId, inc.KeywordId, inc.AdId,
+		)
+		if err == nil {
+			_, err = q.Exec(
+				`
+    UPDATE brand_incidents
+    SET first_seen_at = $1
+    WHERE brand_id = $2 AND keyword_id = $3 AND ad_id = $4
+    AND first_seen_at > $1
+    `,
+				at, inc.BrandId, inc.KeywordId, inc.AdId,
+			)
+		}
+		if err == nil {
+			err = q.QueryRow(
+				`SELECT id FROM brand_incidents WHERE brand_id = $1 AND keyword_id = $2 AND ad_id = $3`,
+				inc.BrandId, inc.KeywordId, inc.AdId,
+			).Scan(&id)
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	found, err := findIncident(q, id)
+	if err != nil {
+		return false, err
+	} else if found != nil {
+		*inc = *found
+	}
+	return created, nil
+}
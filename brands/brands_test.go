@@ -0,0 +1,107 @@
+package brands_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gkats/adscraper/brands"
+	"github.com/gkats/adscraper/db/dbtest"
+)
+
+const seed = `
+INSERT INTO keywords (value) VALUES ('reebok women shoes'), ('nike basketball');
+INSERT INTO ads (headline1, headline2, path, description) VALUES ('Women Shoes', '', 'nike.com', 'Just do it');
+`
+
+func TestDetect(t *testing.T) {
+	bs := []brands.Brand{
+		{ID: 1, Advertiser: "reebok.com", Terms: []string{"reebok", "rbk"}},
+		{ID: 2, Advertiser: "new-balance.com", Terms: []string{"New Balance"}},
+	}
+	format := func(is []brands.Incident) string {
+		lines := make([]string, 0)
+		for _, i := range is {
+			lines = append(lines, fmt.Sprintf("%v %v %v %v", i.BrandId, i.Brand, i.Term, i.Bidder))
+		}
+		return strings.Join(lines, ",")
+	}
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{"reebok", bs[0].Match("Reebok women shoes")},
+		{"rbk", bs[0].Match("buy rbk-classic")},
+		{"", bs[0].Match("reeboks")},
+		{"New Balance", bs[1].Match("new  balance 990")},
+		{"", bs[1].Match("balance new")},
+		{true, bs[0].Owns("shop.reebok.com")},
+		{false, bs[0].Owns("notreebok.com")},
+		{"1 reebok.com reebok nike.com", format(brands.Detect(bs, "reebok women shoes", "nike.com"))},
+		{"", format(brands.Detect(bs, "reebok women shoes", "reebok.com"))},
+		{"", format(brands.Detect(bs, "reebok women shoes", ""))},
+		{"1 reebok.com reebok adidas.com,2 new-balance.com New Balance adidas.com", format(brands.Detect(bs, "reebok vs new balance", "adidas.com"))},
+	}
+	for i, tc := range testCases {
+		if tc.want != tc.got {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	repos := map[string]brands.ReaderWriter{
+		"memory": brands.NewMemoryReaderWriter(),
+		"sqlite": brands.NewReaderWriter(dbtest.NewStore(t, seed)),
+	}
+	for name, r := range repos {
+		t.Run(name, func(t *testing.T) {
+			reebok := &brands.Brand{Advertiser: "Reebok.com", Terms: []string{"reebok", "Reebok, Inc."}}
+			if err := r.Create(reebok); err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now().UTC().Truncate(time.Second)
+			incident := func(block string, evidence string) *brands.Incident {
+				return &brands.Incident{BrandId: reebok.ID, Term: "reebok", KeywordId: 1, AdId: 1, Bidder: "nike.com", Position: 1, Block: block, Evidence: evidence}
+			}
+			first, second, older := incident("top", "<div>1</div>"), incident("bottom", "<div>2</div>"), incident("top", "<div>0</div>")
+			created, err := r.Record(first, now.Add(-time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, _ := r.Record(second, now)
+			r.Record(older, now.Add(-2*time.Hour))
+			found, _ := r.FindIncident(first.ID)
+			is, _ := r.Incidents(brands.IncidentQuery{Bidder: "nike.com", From: now.Add(-time.Minute)})
+			none, _ := r.Incidents(brands.IncidentQuery{To: now.Add(-3 * time.Hour)})
+			existing := r.Create(&brands.Brand{Advertiser: "reebok.com", Terms: []string{"rbk"}})
+			stored, _ := r.Find(reebok.ID)
+			r.Delete(reebok.ID)
+			deleted, _ := r.FindIncident(first.ID)
+
+			testCases := []struct {
+				want interface{}
+				got  interface{}
+			}{
+				{"[reebok Reebok, Inc.]", fmt.Sprint(stored.Terms)},
+				{true, created},
+				{false, again},
+				{"reebok.com", first.Brand},
+				{"bottom <div>2</div>", found.Block + " " + found.Evidence},
+				{true, found.FirstSeenAt < found.LastSeenAt},
+				{"bottom", older.Block},
+				{1, len(is)},
+				{0, len(none)},
+				{brands.ErrExists, existing},
+				{(*brands.Incident)(nil), deleted},
+			}
+			for i, tc := range testCases {
+				if tc.want != tc.got {
+					t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package brands
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewMemoryReaderWriter returns a ReaderWriter that keeps brands and their
+// incidents in memory. It's safe for concurrent use and meant for tests.
+func NewMemoryReaderWriter() ReaderWriter {
+	return &memory{}
+}
+
+type memory struct {
+	mu        sync.Mutex
+	brands    []Brand
+	incidents []Incident
+}
+
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (m *memory) Create(b *Brand) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b.Advertiser = strings.ToLower(b.Advertiser)
+	for _, existing := range m.brands {
+		if existing.DeletedAt == "" && existing.Advertiser == b.Advertiser {
+			return ErrExists
+		}
+	}
+	b.ID = int64(len(m.brands) + 1)
+	b.Terms = append([]string{}, b.Terms...)
+	b.CreatedAt = timestamp(time.Now())
+	b.UpdatedAt, b.DeletedAt = b.CreatedAt, ""
+	m.brands = append(m.brands, *b)
+	return nil
+}
+
+func (m *memory) find(id int64) *Brand {
+	if id < 1 || id > int64(len(m.brands)) || m.brands[id-1].DeletedAt != "" {
+		return nil
+	}
+	return &m.brands[id-1]
+}
+
+func (m *memory) Find(id int64) (*Brand, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if b := m.find(id); b != nil {
+		found := *b
+		return &found, nil
+	}
+	return nil, nil
+}
+
+func (m *memory) List() ([]Brand, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bs := make([]Brand, 0)
+	for _, b := range m.brands {
+		if b.DeletedAt == "" {
+			bs = append(bs, b)
+		}
+	}
+	return bs, nil
+}
+
+func (m *memory) Update(b *Brand) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := m.find(b.ID)
+	if stored == nil {
+		return ErrNotFound
+	}
+	stored.Terms = append([]string{}, b.Terms...)
+	stored.UpdatedAt = timestamp(time.Now())
+	*b = *stored
+	return nil
+}
+
+func (m *memory) Delete(id int64) (*Brand, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.find(id)
+	if b == nil {
+		return nil, ErrNotFound
+	}
+	b.DeletedAt = timestamp(time.Now())
+	deleted := *b
+	return &deleted, nil
+}
+
+func (m *memory) FindIncident(id int64) (*Incident, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > int64(len(m.incidents)) || m.find(m.incidents[id-1].BrandId) == nil {
+		return nil, nil
+	}
+	i := m.incidents[id-1]
+	return &i, nil
+}
+
+func (m *memory) Incidents(q IncidentQuery) ([]Incident, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	is := make([]Incident, 0)
+	d := strings.ToLower(q.Bidder)
+	for _, i := range m.incidents {
+		first, _ := time.Parse(time.RFC3339, i.FirstSeenAt)
+		last, _ := time.Parse(time.RFC3339, i.LastSeenAt)
+		switch {
+		case m.find(i.BrandId) == nil:
+		case q.BrandId > 0 && i.BrandId != q.BrandId:
+		case d != "" && i.Bidder != d && !strings.HasSuffix(i.Bidder, "."+d):
+		case q.KeywordId > 0 && i.KeywordId != q.KeywordId:
+		case !q.From.IsZero() && last.Before(q.From.Truncate(time.Second)):
+		case !q.To.IsZero() && first.After(q.To):
+		default:
+			is = append(is, i)
+		}
+	}
+	sort.SliceStable(is, func(a, b int) bool {
+		if is[a].LastSeenAt != is[b].LastSeenAt {
+			return is[a].LastSeenAt > is[b].LastSeenAt
+		}
+		return is[a].ID > is[b].ID
+	})
+	if q.Limit > 0 && len(is) > q.Limit {
+		is = is[:q.Limit]
+	}
+	return is, nil
+}
+
+func (m *memory) Record(inc *Incident, at time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.find(inc.BrandId)
+	if b == nil {
+		return false, ErrNotFound
+	}
+	inc.Brand = b.Advertiser
+	for j := range m.incidents {
+		i := &m.incidents[j]
+		if i.BrandId != inc.BrandId || i.KeywordId != inc.KeywordId || i.AdId != inc.AdId {
+			continue
+		}
+		first, _ := time.Parse(time.RFC3339, i.FirstSeenAt)
+		last, _ := time.Parse(time.RFC3339, i.LastSeenAt)
+		if !at.Before(last) {
+			i.Term, i.Bidder, i.Position, i.Block, i.Evidence = inc.Term, inc.Bidder, inc.Position, inc.Block, inc.Evidence
+			i.LastSeenAt = timestamp(at)
+		} else if at.Before(first) {
+			i.FirstSeenAt = timestamp(at)
+		}
+		*inc = *i
+		return false, nil
+	}
+	inc.ID = int64(len(m.incidents) + 1)
+	inc.FirstSeenAt, inc.LastSeenAt = timestamp(at), timestamp(at)
+	m.incidents = append(m.incidents, *inc)
+	return true, nil
+}
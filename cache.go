@@ -0,0 +1,39 @@
+package adscraper
+
+import (
+	"sync"
+	"time"
+)
+
+// cacheTTL is how long a cached value is used at most. Writes through the
+// Repositories invalidate it right away, writes of other processes are seen
+// once it expires.
+const cacheTTL = time.Minute
+
+// cache holds a value read from the database until it's invalidated or
+// expires.
+type cache struct {
+	mu       sync.Mutex
+	value    interface{}
+	loadedAt time.Time
+}
+
+// get returns the cached value, read with load when there's none.
+func (c *cache) get(load func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.value == nil || time.Since(c.loadedAt) > cacheTTL {
+		v, err := load()
+		if err != nil {
+			return nil, err
+		}
+		c.value, c.loadedAt = v, time.Now()
+	}
+	return c.value, nil
+}
+
+func (c *cache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value = nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gkats/adscraper"
+	"github.com/gkats/adscraper/db"
+	"github.com/gkats/adscraper/parquet"
+)
+
+var adColumns = []parquet.Column{
+	{Name: "id", Type: parquet.Int64},
+	{Name: "headline1", Type: parquet.String},
+	{Name: "headline2", Type: parquet.String},
+	{Name: "description", Type: parquet.String},
+	{Name: "path", Type: parquet.String},
+	{Name: "created_at", Type: parquet.Timestamp},
+	{Name: "updated_at", Type: parquet.Timestamp},
+}
+
+var observationColumns = []parquet.Column{
+	{Name: "id", Type: parquet.Int64},
+	{Name: "ad_id", Type: parquet.Int64},
+	{Name: "keyword_id", Type: parquet.Int64},
+	{Name: "page_id", Type: parquet.Int64, Optional: true},
+	{Name: "position", Type: parquet.Int32},
+	{Name: "block", Type: parquet.String},
+	{Name: "observed_at", Type: parquet.Timestamp},
+}
+
+func main() {
+	var (
+		dbConfig     db.Config
+		out          string
+		tables       string
+		from, to     string
+		q            adscraper.AdQuery
+		rowGroupSize int
+	)
+	dbConfig.Flags(flag.CommandLine)
+	flag.StringVar(&out, "o", "", "The directory to write the Parquet files to.")
+	flag.StringVar(&tables, "tables", "ads,observations", "What to export, ads, observations or both.")
+	flag.StringVar(&from, "from", "", "Only export ads seen since this day, like 2017-05-01.")
+	flag.StringVar(&to, "to", "", "Only export ads seen until this day, included.")
+	flag.Int64Var(&q.KeywordId, "keyword", 0, "Only export ads seen for the keyword with this ID.")
+	flag.StringVar(&q.Group, "group", "", "Only export ads seen for keywords in the group.")
+	flag.StringVar(&q.Tag, "tag", "", "Only export ads seen for keywords with the tag.")
+	flag.StringVar(&q.Domain, "advertiser", "", "Only export ads with a visible URL on the domain or a subdomain.")
+	flag.StringVar(&q.Block, "block", "", "Only export ads seen in the top or bottom block.")
+	flag.StringVar(&q.Text, "q", "", "Only export ads with the text in the headlines or description.")
+	flag.IntVar(&rowGroupSize, "row-group-size", parquet.DefaultRowGroupSize, "Rows per Parquet row group, buffered in memory for each file.")
+	flag.Parse()
+	if out == "" {
+		fmt.Fprintf(os.Stderr, "You must provide the output directory. Run with --help to see usage instructions.\n")
+		os.Exit(1)
+	}
+	var err error
+	q.From, err = day(from)
+	handleError(err)
+	q.To, err = day(to)
+	handleError(err)
+	if !q.To.IsZero() {
+		q.To = q.To.AddDate(0, 0, 1)
+	}
+
+	store, err := db.NewStore(dbConfig)
+	handleError(err)
+	defer store.Close()
+	ads := adscraper.NewReaderWriter(store)
+
+	for _, table := range strings.Split(tables, ",") {
+		export, ok := exports[table]
+		if !ok {
+			handleError(fmt.Errorf("Unknown table %q", table))
+		}
+		p := parquet.NewPartitions(filepath.Join(out, table), export.columns)
+		p.RowGroupSize = rowGroupSize
+		handleError(export.write(ads, q, p))
+		handleError(p.Close())
+		for _, f := range p.Files {
+			fmt.Println(f)
+		}
+	}
+}
+
+var exports = map[string]struct {
+	columns []parquet.Column
+	write   func(ads adscraper.AdReaderWriter, q adscraper.AdQuery, p *parquet.Partitions) error
+}{
+	"ads":          {adColumns, writeAds},
+	"observations": {observationColumns, writeObservations},
+}
+
+// writeAds partitions ads by the day they were first seen.
+func writeAds(ads adscraper.AdReaderWriter, q adscraper.AdQuery, p *parquet.Partitions) error {
+	return ads.ExportAds(q, func(ad *adscraper.Ad) error {
+		createdAt, err := timestamp(ad.CreatedAt)
+		if err != nil {
+			return err
+		}
+		updatedAt, err := timestamp(ad.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		return p.Write(partition(createdAt), ad.ID, ad.H1, ad.H2, ad.Desc, ad.Path, createdAt, updatedAt)
+	})
+}
+
+func writeObservations(ads adscraper.AdReaderWriter, q adscraper.AdQuery, p *parquet.Partitions) error {
+	return ads.ExportObservations(q, func(o *adscraper.Observation) error {
+		observedAt, err := timestamp(o.ObservedAt)
+		if err != nil {
+			return err
+		}
+		var pageId interface{}
+		if o.PageId > 0 {
+			pageId = o.PageId
+		}
+		return p.Write(partition(observedAt), o.ID, o.AdId, o.KeywordId, pageId, o.Position, o.Block, observedAt)
+	})
+}
+
+// partition is the key of the partition rows from t go to.
+func partition(t time.Time) string {
+	return "date=" + t.UTC().Format("2006-01-02")
+}
+
+func timestamp(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func day(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func handleError(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
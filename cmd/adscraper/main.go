@@ -1,40 +1,212 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
+	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gkats/adscraper"
+	"github.com/gkats/adscraper/keywords"
 )
 
+type adsService interface {
+	PostPage(*adscraper.Page) error
+	PostAdKeywords(*adscraper.Ad, *keywords.Keyword) error
+	PatchKeyword(int64) error
+}
+
 func main() {
 	var (
-		hostUrl string
+		hostUrl      string
+		apiKey       string
+		group        string
+		dryRun       bool
+		every        time.Duration
+		metricsAddr  string
+		retries      int
+		retryBackoff time.Duration
 	)
 	flag.StringVar(&hostUrl, "h", "", "Base URL for the ads service host.")
+	flag.StringVar(&apiKey, "api-key", os.Getenv("ADSCRAPER_API_KEY"), "A scraper API key for the ads service. Defaults to the ADSCRAPER_API_KEY environment variable.")
+	flag.StringVar(&group, "group", "", "Only scrape keywords in this group. Run one scraper per group to schedule groups separately.")
+	flag.BoolVar(&dryRun, "dry-run", false, "Scrape without writing anything to the ads service. Ads are kept in memory and printed to stdout.")
+	flag.DurationVar(&every, "every", 0, "Keep running, scraping the due keywords this often. By default the scraper runs once and exits.")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics at /metrics on this address, like :9101. Only with -every.")
+	flag.IntVar(&retries, "retries", 2, "How many times to retry a results page that's rate limited or fails to load.")
+	flag.DurationVar(&retryBackoff, "retry-backoff", 10*time.Second, "How long to wait before the first retry, doubled for each one after it.")
 	flag.Parse()
 	if hostUrl == "" {
 		fmt.Fprintf(os.Stderr, "You must provide the ads service host URL. Run with --help to see usage instructions.\n")
 		os.Exit(1)
 	}
 
-	client := adscraper.NewClient(hostUrl)
-	ks, err := client.GetKeywords()
-	handleError(err)
+	client := adscraper.NewClient(hostUrl).WithAPIKey(apiKey)
+	fetch := client.GetKeywords
+	if group != "" {
+		fetch = func() ([]*keywords.Keyword, error) { return client.GetGroupKeywords(group) }
+	}
+	s := &scraper{fetch: fetch, service: client, metrics: newMetrics(), retries: retries, backoff: retryBackoff}
+	if dryRun {
+		s.dryRun = newDryRun()
+		s.service = s.dryRun
+	}
+
+	if every == 0 {
+		handleError(s.run())
+		return
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer cancel()
+	if metricsAddr != "" {
+		go func() {
+			log.Printf("Serving metrics on %v", metricsAddr)
+			log.Print(http.ListenAndServe(metricsAddr, s.metrics.handler()))
+		}()
+	}
+	for {
+		if err := s.run(); err != nil {
+			log.Printf("Scraping failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(every):
+		}
+	}
+}
+
+type scraper struct {
+	fetch   func() ([]*keywords.Keyword, error)
+	service adsService
+	dryRun  *dryRun
+	metrics *metrics
+	retries int
+	backoff time.Duration
+	// archiving stops once the ads service says it doesn't archive pages
+	archiveDisabled bool
+}
+
+// run scrapes the keywords that are due, stopping at the first error.
+func (s *scraper) run() error {
+	ks, err := s.fetch()
+	if err != nil {
+		return err
+	}
+	if s.dryRun != nil {
+		if ks, err = s.dryRun.load(ks); err != nil {
+			return err
+		}
+	}
 
 	// Scrape ads for each keyword
 	for _, k := range ks {
-		ads, err := adscraper.Scrape(adscraper.NewURL(k.Value))
-		handleError(err)
+		page, err := s.fetchPage(k)
+		if err != nil {
+			return err
+		}
+		ads, err := adscraper.Parse(page.ParserVersion, bytes.NewReader(page.Body))
+		if err != nil {
+			s.metrics.parseFailures.Inc()
+			return err
+		}
+		s.metrics.ads.Observe(float64(len(ads)))
+
+		// POST the page to the ads service archive
+		if !s.archiveDisabled {
+			page.KeywordId = k.ID
+			if err = s.service.PostPage(page); err == adscraper.ErrArchiveDisabled {
+				fmt.Fprintf(os.Stderr, "The ads service doesn't archive pages, skipping them.\n")
+				s.archiveDisabled = true
+			} else if err != nil {
+				return err
+			}
+		}
 
 		// POST each ad to the ads service
 		for _, ad := range ads {
-			handleError(client.PostAdKeywords(ad, k))
+			ad.PageId = page.ID
+			if err = s.service.PostAdKeywords(ad, k); err != nil {
+				return err
+			}
 		}
 		// PATCH to increment keyword scraped attributes
-		handleError(client.PatchKeyword(k.ID))
+		if err = s.service.PatchKeyword(k.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchPage fetches the results page of k, retrying with exponential backoff
+// while it's rate limited or the request fails.
+func (s *scraper) fetchPage(k *keywords.Keyword) (*adscraper.Page, error) {
+	device := k.Device
+	if device == "" {
+		device = keywords.Desktop
+	}
+	backoff := s.backoff
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		page, err := adscraper.FetchDevicePage(adscraper.NewURL(k.Value), k.Device)
+		s.metrics.observeFetch(device, time.Since(start), err)
+
+		statusErr, isStatus := err.(*adscraper.StatusError)
+		if err == nil || attempt >= s.retries || (isStatus && !statusErr.Temporary()) {
+			return page, err
+		}
+		s.metrics.retries.Inc()
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// dryRun stands in for the ads service, storing everything in memory.
+type dryRun struct {
+	*adscraper.Repositories
+}
+
+func newDryRun() *dryRun {
+	return &dryRun{adscraper.NewMemoryRepositories()}
+}
+
+func (d *dryRun) load(ks []*keywords.Keyword) ([]*keywords.Keyword, error) {
+	loaded := make([]*keywords.Keyword, 0, len(ks))
+	for _, k := range ks {
+		k, err := d.Keywords.Upsert(keywords.New(k.Value))
+		if err != nil {
+			return loaded, err
+		}
+		loaded = append(loaded, k)
+	}
+	return loaded, nil
+}
+
+func (d *dryRun) PostPage(p *adscraper.Page) error {
+	return d.Pages.Create(p)
+}
+
+func (d *dryRun) PostAdKeywords(ad *adscraper.Ad, k *keywords.Keyword) error {
+	if err := d.Ads.Upsert(ad, k); err != nil {
+		return err
+	}
+	fmt.Printf("%v\t%v\t%v - %v\t%v\n", k.Value, ad.Position, ad.H1, ad.H2, ad.Path)
+	return nil
+}
+
+func (d *dryRun) PatchKeyword(id int64) error {
+	k, err := d.Keywords.UpdateScraped(&keywords.Keyword{ID: id})
+	if err != nil {
+		return err
 	}
+	return d.Ads.EndScrape(k)
 }
 
 func handleError(err error) {
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gkats/adscraper"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type metrics struct {
+	registry      *prometheus.Registry
+	fetches       *prometheus.CounterVec
+	fetchDuration *prometheus.HistogramVec
+	captchas      prometheus.Counter
+	retries       prometheus.Counter
+	parseFailures prometheus.Counter
+	ads           prometheus.Histogram
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		fetches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "adscraper_scraper",
+			Name:      "fetches_total",
+			Help:      "Results pages fetched, by device and status code. Requests that got no response have code error.",
+		}, []string{"device", "code"}),
+		fetchDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "adscraper_scraper",
+			Name:      "fetch_duration_seconds",
+			Help:      "How long fetching a results page took, by device.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+		}, []string{"device"}),
+		captchas: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "adscraper_scraper",
+			Name:      "captchas_total",
+			Help:      "Searches redirected to a CAPTCHA.",
+		}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "adscraper_scraper",
+			Name:      "retries_total",
+			Help:      "Results pages fetched again after failing.",
+		}),
+		parseFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "adscraper_scraper",
+			Name:      "parse_failures_total",
+			Help:      "Results pages the ads couldn't be parsed from.",
+		}),
+		ads: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "adscraper_scraper",
+			Name:      "ads_per_keyword",
+			Help:      "Ads extracted from each results page.",
+			Buckets:   prometheus.LinearBuckets(0, 1, 12),
+		}),
+	}
+	m.registry.MustRegister(
+		m.fetches, m.fetchDuration, m.captchas, m.retries, m.parseFailures, m.ads,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+func (m *metrics) observeFetch(device string, d time.Duration, err error) {
+	m.fetchDuration.WithLabelValues(device).Observe(d.Seconds())
+	code := "200"
+	if statusErr, ok := err.(*adscraper.StatusError); ok {
+		code = strconv.Itoa(statusErr.StatusCode)
+		if statusErr.Captcha {
+			m.captchas.Inc()
+		}
+	} else if err != nil {
+		code = "error"
+	}
+	m.fetches.WithLabelValues(device, code).Inc()
+}
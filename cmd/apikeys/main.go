@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gkats/adscraper/apikeys"
+	"github.com/gkats/adscraper/db"
+)
+
+const usage = `Usage: apikeys <command> [flags]
+
+Commands:
+  create -name NAME -scope SCOPE   Create a key and print its token
+  list                             List every key
+  revoke -id ID                    Revoke a key
+
+Run apikeys <command> --help to see the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	var (
+		name     string
+		scope    string
+		id       int64
+		dbConfig db.Config
+	)
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	switch os.Args[1] {
+	case "create":
+		fs.StringVar(&name, "name", "", "What the key is for, like the host a scraper runs on.")
+		fs.StringVar(&scope, "scope", apikeys.Scraper, "One of "+strings.Join(apikeys.Scopes, ", ")+". Scraper keys read keywords and write observations, analyst keys read everything and admin keys can do anything.")
+	case "revoke":
+		fs.Int64Var(&id, "id", 0, "The ID of the key to revoke, as listed.")
+	case "list":
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+	dbConfig.Flags(fs)
+	fs.Parse(os.Args[2:])
+
+	store, err := db.NewStore(dbConfig)
+	handleError(err)
+	defer store.Close()
+	keys := apikeys.NewReaderWriter(store)
+
+	switch fs.Name() {
+	case "create":
+		k, token, err := keys.Create(name, scope)
+		handleError(err)
+		fmt.Fprintf(os.Stderr, "Created %v key %v (%v). The token can't be shown again:\n", k.Scope, k.ID, k.Name)
+		fmt.Println(token)
+	case "revoke":
+		k, err := keys.Revoke(id)
+		handleError(err)
+		fmt.Printf("Revoked key %v (%v) at %v\n", k.ID, k.Name, k.RevokedAt)
+	case "list":
+		ks, err := keys.List()
+		handleError(err)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tPREFIX\tSCOPE\tCREATED\tLAST USED\tREVOKED")
+		for _, k := range ks {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", k.ID, k.Name, k.Prefix, k.Scope, k.CreatedAt, k.LastUsedAt, k.RevokedAt)
+		}
+		w.Flush()
+	}
+}
+
+func handleError(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gkats/adscraper"
+	"github.com/gkats/adscraper/db"
+)
+
+func main() {
+	var (
+		dbConfig  db.Config
+		from, to  string
+		keywordId int64
+	)
+	dbConfig.Flags(flag.CommandLine)
+	flag.StringVar(&from, "from", "", "Only go through observations from this day on, like 2017-05-01.")
+	flag.StringVar(&to, "to", "", "Only go through observations until this day, like 2017-05-31.")
+	flag.Int64Var(&keywordId, "keyword", 0, "Only go through observations of the keyword with this ID.")
+	flag.Parse()
+
+	q := adscraper.AdQuery{KeywordId: keywordId}
+	var err error
+	if q.From, err = day(from); err == nil {
+		q.To, err = day(to)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Dates must be like 2017-05-01. Run with --help to see usage instructions.\n")
+		os.Exit(1)
+	}
+	if !q.To.IsZero() {
+		q.To = q.To.AddDate(0, 0, 1)
+	}
+
+	store, err := db.NewStore(dbConfig)
+	handleError(err)
+	defer store.Close()
+
+	r, err := adscraper.DetectBrandBidding(adscraper.NewRepositories(store), q)
+	handleError(err)
+	fmt.Println(r)
+}
+
+func day(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func handleError(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
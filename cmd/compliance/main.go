@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gkats/adscraper"
+	"github.com/gkats/adscraper/compliance"
+	"github.com/gkats/adscraper/db"
+)
+
+func main() {
+	var (
+		dbConfig   db.Config
+		rulesPath  string
+		check      bool
+		from, to   string
+		keywordId  int64
+		advertiser string
+	)
+	dbConfig.Flags(flag.CommandLine)
+	flag.StringVar(&rulesPath, "rules", "", "Replace the compliance rules with the ones in this YAML file. Empty keeps the stored rules.")
+	flag.BoolVar(&check, "check", true, "Check the stored ads against the rules.")
+	flag.StringVar(&from, "from", "", "Only check ads seen from this day on, like 2017-05-01.")
+	flag.StringVar(&to, "to", "", "Only check ads seen until this day, like 2017-05-31.")
+	flag.Int64Var(&keywordId, "keyword", 0, "Only check ads seen for the keyword with this ID.")
+	flag.StringVar(&advertiser, "advertiser", "", "Only check ads of this advertiser, like reebok.com.")
+	flag.Parse()
+
+	q := adscraper.AdQuery{KeywordId: keywordId, Domain: advertiser}
+	var err error
+	if q.From, err = day(from); err == nil {
+		q.To, err = day(to)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Dates must be like 2017-05-01. Run with --help to see usage instructions.\n")
+		os.Exit(1)
+	}
+	if !q.To.IsZero() {
+		q.To = q.To.AddDate(0, 0, 1)
+	}
+	var rules []compliance.Rule
+	if rulesPath != "" {
+		if rules, err = compliance.LoadRules(rulesPath); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", rulesPath, err)
+			os.Exit(1)
+		}
+	}
+
+	store, err := db.NewStore(dbConfig)
+	handleError(err)
+	defer store.Close()
+
+	repos := adscraper.NewRepositories(store)
+	if rules != nil {
+		handleError(repos.Compliance.SetRules(rules))
+		fmt.Printf("%v rules\n", len(rules))
+	}
+	if check {
+		r, err := adscraper.CheckCompliance(repos, q)
+		handleError(err)
+		fmt.Println(r)
+	}
+}
+
+func day(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func handleError(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gkats/adscraper/db"
+	"github.com/gkats/adscraper/keywords"
+)
+
+// values collects a flag given more than once.
+type values []string
+
+func (v *values) String() string {
+	return strings.Join(*v, " ")
+}
+
+func (v *values) Set(s string) error {
+	*v = append(*v, s)
+	return nil
+}
+
+// expand generates keywords from seeds and modifiers, then writes them to a
+// file or imports them into a group.
+func expand(args []string) {
+	var (
+		seeds     string
+		seedsFile string
+		modifiers string
+		lists     values
+		templates values
+		plurals   bool
+		reorder   bool
+		output    string
+		group     string
+		dryRun    bool
+		dbConfig  db.Config
+	)
+	fs := flag.NewFlagSet("expand", flag.ExitOnError)
+	fs.StringVar(&seeds, "seeds", "", "Comma separated seed terms.")
+	fs.StringVar(&seedsFile, "seeds-file", "", "Path to a file with a seed term per line.")
+	fs.StringVar(&modifiers, "modifiers", "", "Comma separated modifiers, like cheap,online. Short for -list modifier=...")
+	fs.Var(&lists, "list", "A named list of modifiers for templates, as name=a,b,c or name=@path/to/file with a value per line. Can be repeated.")
+	fs.Var(&templates, "template", "A template like '{modifier} {seed} in {city}', using {seed} and the list names. Can be repeated. Defaults to each seed alone, and each list before and after it.")
+	fs.BoolVar(&plurals, "plurals", false, "Add the seeds with their last word pluralized.")
+	fs.BoolVar(&reorder, "reorder", false, "Add the seeds with their words in every other order.")
+	fs.StringVar(&output, "o", "", "Write the keywords to this file, a keyword per line. Defaults to stdout.")
+	fs.StringVar(&group, "group", "", "Store the keywords in this group instead of writing them out.")
+	fs.BoolVar(&dryRun, "dry-run", false, "With -group, print the new and existing keywords without changing anything.")
+	dbConfig.Flags(fs)
+	fs.Parse(args)
+
+	e := &keywords.Expansion{Lists: make(map[string][]string), Templates: templates, Plurals: plurals, Reorder: reorder}
+	e.Seeds = split(seeds)
+	if seedsFile != "" {
+		lines, err := readLines(seedsFile)
+		handleError(err)
+		e.Seeds = append(e.Seeds, lines...)
+	}
+	if len(e.Seeds) == 0 {
+		fmt.Fprintf(os.Stderr, "You must provide seed terms. Run with --help to see usage instructions.\n")
+		os.Exit(1)
+	}
+	if modifiers != "" {
+		lists = append(lists, "modifier="+modifiers)
+	}
+	for _, l := range lists {
+		parts := strings.SplitN(l, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			handleError(fmt.Errorf("lists look like name=a,b,c, got %q", l))
+		}
+		name, vs := parts[0], split(parts[1])
+		if strings.HasPrefix(parts[1], "@") {
+			var err error
+			vs, err = readLines(strings.TrimPrefix(parts[1], "@"))
+			handleError(err)
+		}
+		e.Lists[name] = append(e.Lists[name], vs...)
+	}
+	handleError(e.Validate())
+	generated := e.Keywords()
+
+	if group == "" {
+		w := io.Writer(os.Stdout)
+		if output != "" {
+			f, err := os.Create(output)
+			handleError(err)
+			defer f.Close()
+			w = f
+		}
+		bw := bufio.NewWriter(w)
+		for _, k := range generated {
+			fmt.Fprintln(bw, k)
+		}
+		handleError(bw.Flush())
+		return
+	}
+
+	s, err := db.NewStore(dbConfig)
+	handleError(err)
+	defer Cleanup(s)
+	kw := keywords.NewReaderWriter(s)
+
+	records := make([]keywords.Record, 0, len(generated))
+	for _, k := range generated {
+		records = append(records, keywords.Record{Line: len(records) + 1, Keyword: keywords.New(k), Err: keywords.New(k).Validate()})
+	}
+	diff, err := keywords.Plan(kw, records, keywords.ImportOptions{Group: group})
+	handleError(err)
+	report(diff, dryRun)
+	if !dryRun {
+		handleError(diff.Apply(kw))
+	}
+	fmt.Println(diff)
+}
+
+func split(s string) []string {
+	vs := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
+
+func readLines(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if l := strings.TrimSpace(scanner.Text()); l != "" && !strings.HasPrefix(l, "#") {
+			lines = append(lines, l)
+		}
+	}
+	return lines, scanner.Err()
+}
@@ -1,53 +1,101 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
-	"github.com/gkats/adscraper/keywords"
 	"os"
+	"strings"
+
+	"github.com/gkats/adscraper/db"
+	"github.com/gkats/adscraper/keywords"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "expand" {
+		expand(os.Args[2:])
+		return
+	}
+
 	var (
 		filename string
-		dbUrl    string
+		format   string
+		group    string
+		tags     string
+		dryRun   bool
+		sync     bool
+		dbConfig db.Config
 	)
 	flag.StringVar(&filename, "f", "", "Absolute path to the keywords file.")
-	flag.StringVar(&dbUrl, "d", "", "The PostgreSQL database URL. Should be in 'user:password@host:port/database' format.")
+	flag.StringVar(&format, "format", "", "The keywords file format, one of lines, csv, tsv or jsonl. Guessed from the file extension by default, with lines for anything unknown.")
+	flag.StringVar(&group, "group", "", "Add the keywords to this group, usually the project or client they're for.")
+	flag.StringVar(&tags, "tags", "", "Comma separated tags to add to the keywords.")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print the new, existing, invalid and paused keywords without changing anything.")
+	flag.BoolVar(&sync, "sync", false, "Pause the active keywords missing from the file, only the ones in -group if it's set.")
+	dbConfig.Flags(flag.CommandLine)
 	flag.Parse()
 	if filename == "" {
 		fmt.Fprintf(os.Stderr, "You must provide a filename. Run with --help to see usage instructions.\n")
 		os.Exit(1)
 	}
+	if format == "" {
+		format = keywords.FormatOf(filename)
+	}
+
+	// Read and check the keywords file
+	f, err := os.Open(filename)
+	handleError(err)
+	records, err := keywords.Read(f, format)
+	f.Close()
+	handleError(err)
 
 	// Set up the store
-	ks, err := keywords.NewStore(dbUrl)
+	ks, err := db.NewStore(dbConfig)
 	handleError(err)
 	defer Cleanup(ks)
+	kw := keywords.NewReaderWriter(ks)
 
-	// Set up the writer service
-	kw := keywords.NewWriter(ks)
-
-	// Open the keywords file
-	f, err := os.Open(filename)
+	diff, err := keywords.Plan(kw, records, keywords.ImportOptions{
+		Group: group,
+		Tags:  strings.Split(tags, ","),
+		Sync:  sync,
+	})
 	handleError(err)
 
-	// Read file line by line and store each keyword
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		_, err := kw.Upsert(keywords.New(scanner.Text()))
-		handleError(err)
+	report(diff, dryRun)
+	if !dryRun {
+		handleError(diff.Apply(kw))
+	}
+	fmt.Println(diff)
+}
+
+// report prints the invalid keywords, and every change on dry runs.
+func report(diff *keywords.Diff, dryRun bool) {
+	for _, rec := range diff.Invalid {
+		fmt.Printf("invalid\tline %v: %v\n", rec.Line, rec.Err)
+	}
+	if !dryRun {
+		return
+	}
+	for _, k := range diff.New {
+		fmt.Printf("new\t%v\n", k.Value)
+	}
+	for _, k := range diff.Existing {
+		fmt.Printf("existing\t%v\n", k.Value)
+	}
+	for _, k := range diff.Paused {
+		fmt.Printf("paused\t%v\n", k.Value)
 	}
-	handleError(scanner.Err())
 }
 
 func Cleanup(s keywords.Store) error {
 	return s.Close()
 }
 
+// handleError exits on errors. Nothing is written unless the whole file is
+// imported.
 func handleError(err error) {
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
 	}
 }
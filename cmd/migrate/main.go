@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/gkats/adscraper/db"
+)
+
+func main() {
+	var (
+		dbConfig db.Config
+	)
+	dbConfig.Flags(flag.CommandLine)
+	flag.Parse()
+
+	store, err := db.NewStore(dbConfig)
+	handleError(err)
+	defer store.Close()
+
+	handleError(db.Migrate(store))
+}
+
+func handleError(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/gkats/adscraper/db"
+	"github.com/gkats/adscraper/retention"
+)
+
+func main() {
+	var (
+		dbConfig db.Config
+		policy   retention.Policy
+	)
+	dbConfig.Flags(flag.CommandLine)
+	policy.Flags(flag.CommandLine)
+	flag.Parse()
+
+	store, err := db.NewStore(dbConfig)
+	handleError(err)
+	defer store.Close()
+
+	pruner, err := retention.Open(store, policy)
+	handleError(err)
+
+	r, err := pruner.Run(time.Now())
+	handleError(err)
+	fmt.Println(r)
+}
+
+func handleError(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gkats/adscraper"
+	"github.com/gkats/adscraper/archive"
+	"github.com/gkats/adscraper/db"
+)
+
+func main() {
+	var (
+		dbConfig      db.Config
+		archiveConfig archive.Config
+		version       string
+		pageId        int64
+		keywordId     int64
+	)
+	dbConfig.Flags(flag.CommandLine)
+	archiveConfig.Flags(flag.CommandLine)
+	flag.StringVar(&version, "parser", adscraper.ParserVersion, "The parser version to run over the archived pages.")
+	flag.Int64Var(&pageId, "page", 0, "Only reparse the page with this ID.")
+	flag.Int64Var(&keywordId, "keyword", 0, "Only reparse pages of the keyword with this ID.")
+	flag.Parse()
+	if archiveConfig.URL == "" {
+		fmt.Fprintf(os.Stderr, "You must provide the archive URL. Run with --help to see usage instructions.\n")
+		os.Exit(1)
+	}
+	parse, err := adscraper.GetParser(version)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	store, err := db.NewStore(dbConfig)
+	handleError(err)
+	defer store.Close()
+
+	a, err := archive.Open(archiveConfig)
+	handleError(err)
+
+	ads := adscraper.NewReaderWriter(store)
+	pages := adscraper.NewPageReaderWriter(store, a)
+
+	if pageId > 0 {
+		p, err := pages.Find(pageId)
+		handleError(err)
+		if p == nil {
+			handleError(adscraper.ErrPageNotFound)
+		}
+		handleError(reparse(ads, pages, p, version, parse))
+		return
+	}
+
+	var after int64
+	for {
+		ps, err := pages.GetAfter(after, 100)
+		handleError(err)
+		if len(ps) == 0 {
+			return
+		}
+		for i := range ps {
+			if keywordId == 0 || ps[i].KeywordId == keywordId {
+				handleError(reparse(ads, pages, &ps[i], version, parse))
+			}
+			after = ps[i].ID
+		}
+	}
+}
+
+func reparse(ads adscraper.AdWriter, pages adscraper.PageReaderWriter, p *adscraper.Page, version string, parse adscraper.Parser) error {
+	if err := pages.ReadBody(p); err != nil {
+		return err
+	}
+	parsed, err := parse(bytes.NewReader(p.Body))
+	if err != nil {
+		return err
+	}
+	if err = ads.ReplacePageAds(p, parsed); err != nil {
+		return err
+	}
+	p.ParserVersion = version
+	if err = pages.UpdateParserVersion(p); err != nil {
+		return err
+	}
+	fmt.Printf("page %v: %v ads (%v)\n", p.ID, len(parsed), version)
+	return nil
+}
+
+func handleError(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
@@ -1,22 +1,97 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/gkats/adscraper"
+	"github.com/gkats/adscraper/apikeys"
+	"github.com/gkats/adscraper/archive"
+	"github.com/gkats/adscraper/db"
+	"github.com/gkats/adscraper/retention"
+	"github.com/gkats/adscraper/webhooks"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
 func main() {
 	var (
-		dbUrl string
+		dbConfig          db.Config
+		archiveConfig     archive.Config
+		policy            retention.Policy
+		retentionInterval time.Duration
+		webhooksInterval  time.Duration
+		mailer            webhooks.Mailer
+		auth              bool
+		serverConfig      adscraper.ServerConfig
+		maxIngestionAge   time.Duration
 	)
-	flag.StringVar(&dbUrl, "d", "", "The database URL. Should be in 'user:password@host:port/database' format.")
+	dbConfig.Flags(flag.CommandLine)
+	serverConfig.Flags(flag.CommandLine)
+	archiveConfig.Flags(flag.CommandLine)
+	policy.Flags(flag.CommandLine)
+	mailer.Flags(flag.CommandLine)
+	flag.DurationVar(&retentionInterval, "retention-interval", 24*time.Hour, "How often the retention policy is applied.")
+	flag.DurationVar(&webhooksInterval, "webhooks-interval", 10*time.Second, "How often due webhook deliveries are sent. Zero leaves them to another server.")
+	flag.DurationVar(&maxIngestionAge, "ready-max-ingestion-age", 0, "Report the server as not ready on /readyz when no ad has been observed for this long. Zero disables the check.")
+	flag.BoolVar(&auth, "auth", true, "Require API keys, created with the apikeys command. Only disable it on trusted networks.")
 	flag.Parse()
 
-	store, err := adscraper.NewStore(dbUrl)
+	store, err := db.NewStore(dbConfig)
 	handleError(err)
 	defer store.Close()
 
-	adscraper.NewServer(store).Listen(3000)
+	a, err := archive.Open(archiveConfig)
+	handleError(err)
+
+	if policy.Enabled() {
+		pruner, err := retention.Open(store, policy)
+		handleError(err)
+		stop := make(chan struct{})
+		defer close(stop)
+		go pruner.Every(retentionInterval, stop, func(r *retention.Report, err error) {
+			if err != nil {
+				log.Printf("Retention failed: %v", err)
+				return
+			}
+			log.Printf("Retention: %v", r)
+		})
+	}
+
+	if webhooksInterval > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		d := webhooks.NewDispatcher(webhooks.NewQueue(store))
+		if mailer.Enabled() {
+			d.Mail = mailer.Send
+		}
+		go d.Every(webhooksInterval, stop, func(r *webhooks.Report, err error) {
+			if err != nil {
+				log.Printf("Webhooks failed: %v", err)
+			} else if r.Delivered+r.Retried+r.Failed > 0 {
+				log.Printf("Webhooks: %v", r)
+			}
+		})
+	}
+
+	repos := adscraper.NewRepositories(store)
+	repos.Pages = adscraper.NewPageReaderWriter(store, a)
+	s := adscraper.NewServer(repos).CheckReadiness(adscraper.Readiness{Store: store, MaxIngestionAge: maxIngestionAge})
+	s.Metrics().MustRegister(collectors.NewDBStatsCollector(db.SQL(store), store.Dialect()))
+	if auth {
+		s.RequireAPIKeys(apikeys.NewReader(store))
+	}
+
+	// Drain in-flight requests on SIGTERM, the store is closed after them
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer cancel()
+	log.Printf("Listening on %v", serverConfig.Addr)
+	handleError(s.Listen(ctx, serverConfig))
+	log.Print("Shut down")
 }
 
 func handleError(err error) {
@@ -0,0 +1,301 @@
+package adscraper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gkats/adscraper/compliance"
+	"github.com/gorilla/mux"
+)
+
+// Limits of the compliance violations listed.
+const (
+	DefaultViolationsLimit = 100
+	MaxViolationsLimit     = 1000
+)
+
+// adCopy returns the text of the ad compliance rules check.
+func adCopy(ad *Ad) *compliance.Copy {
+	return &compliance.Copy{
+		Advertiser: ad.Advertiser(),
+		H1:         ad.H1,
+		H2:         ad.H2,
+		Desc:       ad.Desc,
+		Rest:       strings.Join(ad.Extensions(), "\n"),
+	}
+}
+
+// ComplianceReport is what CheckCompliance went through.
+type ComplianceReport struct {
+	Ads        int
+	Violations int
+	New        int
+}
+
+func (r *ComplianceReport) String() string {
+	return fmt.Sprintf("%v ads, %v violations, %v new", r.Ads, r.Violations, r.New)
+}
+
+// CheckCompliance records the violations of the compliance rules by the ads
+// matching q, for rules set after the ads were ingested. Violations already
+// recorded keep their status.
+func CheckCompliance(r *Repositories, q AdQuery) (*ComplianceReport, error) {
+	report := &ComplianceReport{}
+	rs, err := r.Compliance.Rules()
+	if err != nil || len(rs) == 0 {
+		return report, err
+	}
+	violations := make([]compliance.Violation, 0)
+	err = r.Ads.ExportAds(q, func(ad *Ad) error {
+		report.Ads++
+		for _, v := range compliance.Check(rs, adCopy(ad)) {
+			v.AdId = ad.ID
+			violations = append(violations, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for i := range violations {
+		created, err := r.Compliance.Record(&violations[i])
+		if err != nil {
+			return report, err
+		}
+		report.Violations++
+		if created {
+			report.New++
+		}
+	}
+	return report, nil
+}
+
+type ruleJSON struct {
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Severity    string   `json:"severity"`
+	Fields      []string `json:"fields"`
+	Pattern     string   `json:"pattern"`
+	Terms       []string `json:"terms"`
+	Allow       []string `json:"allow"`
+	CreatedAt   string   `json:"createdAt"`
+	UpdatedAt   string   `json:"updatedAt"`
+}
+
+func newRuleJSON(r *compliance.Rule) ruleJSON {
+	return ruleJSON{
+		ID:          r.ID,
+		Name:        r.Name,
+		Description: r.Description,
+		Severity:    r.Severity,
+		Fields:      r.Fields,
+		Pattern:     r.Pattern,
+		Terms:       r.Terms,
+		Allow:       r.Allow,
+		CreatedAt:   r.CreatedAt,
+		UpdatedAt:   r.UpdatedAt,
+	}
+}
+
+type violationJSON struct {
+	ID         int64  `json:"id"`
+	RuleId     int64  `json:"ruleId"`
+	Rule       string `json:"rule"`
+	Severity   string `json:"severity"`
+	AdId       int64  `json:"adId"`
+	Advertiser string `json:"advertiser"`
+	Field      string `json:"field"`
+	Match      string `json:"match"`
+	Status     string `json:"status"`
+	Note       string `json:"note"`
+	CreatedAt  string `json:"createdAt"`
+	UpdatedAt  string `json:"updatedAt"`
+}
+
+func newViolationJSON(v *compliance.Violation) violationJSON {
+	return violationJSON{
+		ID:         v.ID,
+		RuleId:     v.RuleId,
+		Rule:       v.Rule,
+		Severity:   v.Severity,
+		AdId:       v.AdId,
+		Advertiser: v.Advertiser,
+		Field:      v.Field,
+		Match:      v.Match,
+		Status:     v.Status,
+		Note:       v.Note,
+		CreatedAt:  v.CreatedAt,
+		UpdatedAt:  v.UpdatedAt,
+	}
+}
+
+// triageJSON changes the fields of a violation that are set.
+type triageJSON struct {
+	Status *string `json:"status"`
+	Note   *string `json:"note"`
+}
+
+func (p *triageJSON) validate() []fieldError {
+	errs := make([]fieldError, 0)
+	if p.Status != nil && !contains(compliance.Statuses, *p.Status) {
+		errs = append(errs, fieldError{Field: "status", Message: "must be one of " + strings.Join(compliance.Statuses, ", ")})
+	}
+	return errs
+}
+
+type indexRulesHandler struct {
+	complianceReader compliance.Reader
+}
+
+func (h *indexRulesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rs, err := h.complianceReader.Rules()
+	if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	}
+	rsJSON := make([]ruleJSON, 0, len(rs))
+	for i := range rs {
+		rsJSON = append(rsJSON, newRuleJSON(&rs[i]))
+	}
+	writeResponse(w, ok(rsJSON))
+}
+
+func indexRules(r *Repositories) http.Handler {
+	return &indexRulesHandler{complianceReader: r.Compliance}
+}
+
+type indexViolationsHandler struct {
+	complianceReader compliance.Reader
+}
+
+func newViolationQuery(params url.Values) (compliance.Query, error) {
+	q := compliance.Query{
+		Rule:       params.Get("rule"),
+		Severity:   params.Get("severity"),
+		Status:     params.Get("status"),
+		Advertiser: strings.TrimPrefix(params.Get("advertiser"), "www."),
+	}
+	if q.Severity != "" && !contains(compliance.Severities, q.Severity) {
+		return q, fmt.Errorf("Invalid severity %v", q.Severity)
+	}
+	if q.Status != "" && !contains(compliance.Statuses, q.Status) {
+		return q, fmt.Errorf("Invalid status %v", q.Status)
+	}
+	var err error
+	if q.AdId, err = int64Param(params, "adId"); err != nil {
+		return q, err
+	}
+	limit, err := int64Param(params, "limit")
+	if err != nil || limit > MaxViolationsLimit {
+		return q, fmt.Errorf("Invalid limit %v", limit)
+	} else if limit == 0 {
+		limit = DefaultViolationsLimit
+	}
+	q.Limit = int(limit)
+	return q, nil
+}
+
+// ServeHTTP lists the violations of the compliance rules, the most recent
+// first.
+func (h *indexViolationsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q, err := newViolationQuery(r.URL.Query())
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+	vs, err := h.complianceReader.List(q)
+	if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	}
+	vsJSON := make([]violationJSON, 0, len(vs))
+	for i := range vs {
+		vsJSON = append(vsJSON, newViolationJSON(&vs[i]))
+	}
+	writeResponse(w, ok(vsJSON))
+}
+
+func indexViolations(r *Repositories) http.Handler {
+	return &indexViolationsHandler{complianceReader: r.Compliance}
+}
+
+type showViolationHandler struct {
+	complianceReader compliance.Reader
+}
+
+func (h *showViolationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+	v, err := h.complianceReader.Find(id)
+	if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	} else if v == nil {
+		writeResponse(w, notFound())
+		return
+	}
+	writeResponse(w, ok(newViolationJSON(v)))
+}
+
+func showViolation(r *Repositories) http.Handler {
+	return &showViolationHandler{complianceReader: r.Compliance}
+}
+
+type triageViolationHandler struct {
+	complianceReader compliance.Reader
+	complianceWriter compliance.Writer
+}
+
+// ServeHTTP triages a violation, setting its status and a note on why.
+func (h *triageViolationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+	params := &triageJSON{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		writeResponse(w, decodeError(err))
+		return
+	}
+	if errs := params.validate(); len(errs) > 0 {
+		writeResponse(w, invalid(errs))
+		return
+	}
+
+	v, err := h.complianceReader.Find(id)
+	if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	} else if v == nil {
+		writeResponse(w, notFound())
+		return
+	}
+	if params.Status != nil {
+		v.Status = *params.Status
+	}
+	if params.Note != nil {
+		v.Note = strings.TrimSpace(*params.Note)
+	}
+	if err = h.complianceWriter.Triage(v); err == compliance.ErrNotFound {
+		writeResponse(w, notFound())
+		return
+	} else if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	}
+	writeResponse(w, ok(newViolationJSON(v)))
+}
+
+func triageViolation(r *Repositories) http.Handler {
+	return &triageViolationHandler{complianceReader: r.Compliance, complianceWriter: r.Compliance}
+}
@@ -0,0 +1,577 @@
+// Package compliance checks the copy of ads against rules, like ads claiming
+// results are "guaranteed" or advertisers using a trademark they don't own.
+// Rules are defined in YAML:
+//
+//	rules:
+//	  - name: guaranteed
+//	    description: Claims results are guaranteed
+//	    severity: high
+//	    fields: [h1, h2, desc]
+//	    terms: [guaranteed, risk free]
+//	  - name: unapproved-price
+//	    pattern: '\$\d+(\.\d\d)?'
+//	  - name: reebok-trademark
+//	    terms: [reebok]
+//	    allow: [reebok.com]
+//
+// An ad violates a rule when one of the rule's fields matches its pattern, a
+// regular expression, or has one of its terms, as whole words and ignoring
+// case. Ads of allowed advertisers don't violate it.
+package compliance
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gkats/adscraper/db"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrNotFound = errors.New("Violation not found")
+
+// Severities of rules.
+const (
+	Low    = "low"
+	Medium = "medium"
+	High   = "high"
+)
+
+var Severities = []string{Low, Medium, High}
+
+// Statuses violations are triaged to. Violations are open until they are.
+const (
+	Open      = "open"
+	Confirmed = "confirmed"
+	Dismissed = "dismissed"
+)
+
+var Statuses = []string{Open, Confirmed, Dismissed}
+
+// Fields of an ad rules check.
+const (
+	H1   = "h1"
+	H2   = "h2"
+	Desc = "desc"
+	Rest = "rest"
+)
+
+var Fields = []string{H1, H2, Desc, Rest}
+
+type Rule struct {
+	ID          int64  `yaml:"-"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	// Severity is Medium unless it's set.
+	Severity string `yaml:"severity"`
+	// Fields are all of them unless they're set.
+	Fields  []string `yaml:"fields"`
+	Pattern string   `yaml:"pattern"`
+	Terms   []string `yaml:"terms"`
+	// Allow is the domains of advertisers allowed to break the rule, like
+	// the owner of a trademark. Their subdomains are allowed too.
+	Allow     []string `yaml:"allow"`
+	CreatedAt string   `yaml:"-"`
+	UpdatedAt string   `yaml:"-"`
+
+	pattern *regexp.Regexp
+	terms   *regexp.Regexp
+}
+
+// compile checks the rule and prepares it for matching, with its defaults
+// set.
+func (r *Rule) compile() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return errors.New("Rules need a name")
+	}
+	if r.Severity = strings.ToLower(r.Severity); r.Severity == "" {
+		r.Severity = Medium
+	} else if !contains(Severities, r.Severity) {
+		return fmt.Errorf("Rule %q: severity must be one of %v", r.Name, strings.Join(Severities, ", "))
+	}
+	if len(r.Fields) == 0 {
+		r.Fields = Fields
+	}
+	fields := make([]string, 0, len(r.Fields))
+	for _, f := range r.Fields {
+		if f = strings.ToLower(f); !contains(Fields, f) {
+			return fmt.Errorf("Rule %q: fields must be %v", r.Name, strings.Join(Fields, ", "))
+		} else if !contains(fields, f) {
+			fields = append(fields, f)
+		}
+	}
+	r.Fields = fields
+	if r.Terms == nil {
+		r.Terms = []string{}
+	}
+	if r.Allow == nil {
+		r.Allow = []string{}
+	}
+	for i, d := range r.Allow {
+		r.Allow[i] = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(d)), "www.")
+	}
+
+	var err error
+	r.pattern, r.terms = nil, nil
+	if r.Pattern != "" {
+		if r.pattern, err = regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("Rule %q: %v", r.Name, err)
+		}
+	}
+	alternatives := make([]string, 0, len(r.Terms))
+	for i, t := range r.Terms {
+		words := strings.Fields(t)
+		if len(words) == 0 {
+			return fmt.Errorf("Rule %q: terms can't be blank", r.Name)
+		}
+		r.Terms[i] = strings.Join(words, " ")
+		for j := range words {
+			words[j] = regexp.QuoteMeta(words[j])
+		}
+		alternatives = append(alternatives, strings.Join(words, `\s+`))
+	}
+	if len(alternatives) > 0 {
+		// Terms match when they aren't part of a longer word
+		r.terms = regexp.MustCompile(`(?i)(?:^|[^\pL\pN])(` + strings.Join(alternatives, "|") + `)(?:[^\pL\pN]|$)`)
+	}
+	if r.pattern == nil && r.terms == nil {
+		return fmt.Errorf("Rule %q: rules need a pattern or terms", r.Name)
+	}
+	return nil
+}
+
+// Match returns the first text that breaks the rule, matching its pattern or
+// one of its terms. It's empty when there's none.
+func (r *Rule) Match(text string) string {
+	if r.pattern != nil {
+		if m := r.pattern.FindString(text); m != "" {
+			return m
+		}
+	}
+	if r.terms != nil {
+		if m := r.terms.FindStringSubmatch(text); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// Allows reports whether the advertiser is allowed to break the rule.
+func (r *Rule) Allows(advertiser string) bool {
+	for _, d := range r.Allow {
+		if advertiser == d || strings.HasSuffix(advertiser, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRules reads the rules of a YAML document, in the format of the
+// package's example. Unknown fields are errors, so typos don't make rules
+// silently match less.
+func ParseRules(r io.Reader) ([]Rule, error) {
+	doc := struct {
+		Rules []Rule `yaml:"rules"`
+	}{}
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&doc); err != nil && err != io.EOF {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i := range doc.Rules {
+		if err := doc.Rules[i].compile(); err != nil {
+			return nil, err
+		} else if names[doc.Rules[i].Name] {
+			return nil, fmt.Errorf("Rule %q is defined twice", doc.Rules[i].Name)
+		}
+		names[doc.Rules[i].Name] = true
+	}
+	if doc.Rules == nil {
+		doc.Rules = []Rule{}
+	}
+	return doc.Rules, nil
+}
+
+// LoadRules reads the rules of a YAML file, see ParseRules.
+func LoadRules(path string) ([]Rule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRules(bytes.NewReader(b))
+}
+
+// Copy is the text of an ad rules are checked against.
+type Copy struct {
+	Advertiser string
+	H1         string
+	H2         string
+	Desc       string
+	// Rest is the text of the ad's extensions, one per line.
+	Rest string
+}
+
+func (c *Copy) field(name string) string {
+	switch name {
+	case H1:
+		return c.H1
+	case H2:
+		return c.H2
+	case Desc:
+		return c.Desc
+	case Rest:
+		return c.Rest
+	}
+	return ""
+}
+
+// Violation is an ad breaking a rule in one of its fields.
+type Violation struct {
+	ID     int64
+	RuleId int64
+	// Rule is the name of the rule.
+	Rule       string
+	Severity   string
+	AdId       int64
+	Advertiser string
+	Field      string
+	// Match is the text of the field that breaks the rule.
+	Match     string
+	Status    string
+	Note      string
+	CreatedAt string
+	UpdatedAt string
+}
+
+// Check returns the violations of an ad's copy, one for each rule and field
+// it breaks.
+func Check(rules []Rule, c *Copy) []Violation {
+	violations := make([]Violation, 0)
+	for i := range rules {
+		r := &rules[i]
+		if r.Allows(c.Advertiser) {
+			continue
+		}
+		for _, f := range r.Fields {
+			if m := r.Match(c.field(f)); m != "" {
+				violations = append(violations, Violation{
+					RuleId: r.ID, Rule: r.Name, Severity: r.Severity, Advertiser: c.Advertiser, Field: f, Match: m, Status: Open,
+				})
+			}
+		}
+	}
+	return violations
+}
+
+// Query filters violations. Zero values match everything.
+type Query struct {
+	Rule     string
+	Severity string
+	Status   string
+	// Advertiser matches violations of the domain and its subdomains.
+	Advertiser string
+	AdId       int64
+	Limit      int
+}
+
+type Reader interface {
+	// Rules returns the rules ads are checked against.
+	Rules() ([]Rule, error)
+	// Find returns the violation with the id, nil when there's none or its
+	// rule has been removed.
+	Find(id int64) (*Violation, error)
+	// List returns the violations matching q, the most recent first.
+	List(q Query) ([]Violation, error)
+}
+
+func NewReader(s Store) Reader {
+	return &repository{s}
+}
+
+type Writer interface {
+	// SetRules replaces the rules ads are checked against. Rules are told
+	// apart by name, the violations of removed rules aren't listed anymore.
+	SetRules([]Rule) error
+	// Record stores a violation and reports whether it's new, see Record.
+	Record(*Violation) (bool, error)
+	// Triage sets the status and note of a violation.
+	Triage(v *Violation) error
+}
+
+func NewWriter(s Store) Writer {
+	return &repository{s}
+}
+
+type ReaderWriter interface {
+	Reader
+	Writer
+}
+
+func NewReaderWriter(s Store) ReaderWriter {
+	return &repository{s}
+}
+
+type Store = db.Store
+
+type repository struct {
+	Store
+}
+
+const ruleColumns = `id, name, description, severity, fields, pattern, terms, allow, created_at, updated_at`
+
+// Lists of rules are stored a value per line, since terms can have commas.
+func scanRule(s interface{ Scan(...interface{}) error }, r *Rule) error {
+	var fields, terms, allow string
+	err := s.Scan(&r.ID, &r.Name, &r.Description, &r.Severity, &fields, &r.Pattern, &terms, &allow, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	r.Fields, r.Terms, r.Allow = lines(fields), lines(terms), lines(allow)
+	return r.compile()
+}
+
+func lines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, "\n")
+}
+
+func (r *repository) Rules() ([]Rule, error) {
+	return Rules(r.Store)
+}
+
+// Rules is Reader.Rules with q, usually the transaction of the ads checked.
+func Rules(q db.Querier) ([]Rule, error) {
+	rows, err := q.Query(`SELECT ` + ruleColumns + ` FROM compliance_rules WHERE deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rs := make([]Rule, 0)
+	for rows.Next() {
+		rule := Rule{}
+		if err = scanRule(rows, &rule); err != nil {
+			return rs, err
+		}
+		rs = append(rs, rule)
+	}
+	return rs, rows.Err()
+}
+
+func (r *repository) SetRules(rules []Rule) error {
+	tx, err := r.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	names, args := make([]string, 0, len(rules)), make([]interface{}, 0, len(rules))
+	for i := range rules {
+		rule := &rules[i]
+		if err = rule.compile(); err != nil {
+			return err
+		}
+		err = scanRule(tx.QueryRow(
+			`
+    INSERT INTO compliance_rules (name, description, severity, fields, pattern, terms, allow)
+    VALUES ($1, $2, $3, $4, $5, $6, $7)
+    ON CONFLICT (name)
+    DO UPDATE SET description = $2, severity = $3, fields = $4, pattern = $5, terms = $6, allow = $7,
+      updated_at = CURRENT_TIMESTAMP, deleted_at = NULL
+    RETURNING `+ruleColumns+`
+    `,
+			rule.Name, rule.Description, rule.Severity, strings.Join(rule.Fields, "\n"), rule.Pattern,
+			strings.Join(rule.Terms, "\n"), strings.Join(rule.Allow, "\n"),
+		), rule)
+		if err != nil {
+			return err
+		}
+		args = append(args, rule.Name)
+		names = append(names, "$"+strconv.Itoa(len(args)))
+	}
+	removed := ""
+	if len(names) > 0 {
+		removed = "AND name NOT IN (" + strings.Join(names, ", ") + ")"
+	}
+	_, err = tx.Exec(`UPDATE compliance_rules SET deleted_at = CURRENT_TIMESTAMP WHERE deleted_at IS NULL `+removed, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const violationColumns = `v.id, v.rule_id, r.name, r.severity, v.ad_id, v.advertiser, v.field, v.matched,
+    v.status, v.note, v.created_at, v.updated_at`
+
+func scanViolation(s interface{ Scan(...interface{}) error }, v *Violation) error {
+	return s.Scan(
+		&v.ID, &v.RuleId, &v.Rule, &v.Severity, &v.AdId, &v.Advertiser, &v.Field, &v.Match,
+		&v.Status, &v.Note, &v.CreatedAt, &v.UpdatedAt,
+	)
+}
+
+func (r *repository) Find(id int64) (*Violation, error) {
+	return find(r.Store, id)
+}
+
+func find(q db.Querier, id int64) (*Violation, error) {
+	v := &Violation{}
+	err := scanViolation(q.QueryRow(
+		`
+    SELECT `+violationColumns+`
+    FROM violations v
+    JOIN compliance_rules r ON r.id = v.rule_id
+    WHERE v.id = $1
+    AND r.deleted_at IS NULL
+    `,
+		id,
+	), v)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return v, err
+}
+
+func (r *repository) List(q Query) ([]Violation, error) {
+	where, args := []string{"r.deleted_at IS NULL"}, make([]interface{}, 0)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if q.Rule != "" {
+		where = append(where, "r.name = "+arg(q.Rule))
+	}
+	if q.Severity != "" {
+		where = append(where, "r.severity = "+arg(q.Severity))
+	}
+	if q.Status != "" {
+		where = append(where, "v.status = "+arg(q.Status))
+	}
+	if d := strings.ToLower(q.Advertiser); d != "" {
+		where = append(where, "(v.advertiser = "+arg(d)+" OR "+db.Like("v.advertiser", arg("%."+db.EscapeLike(d)))+")")
+	}
+	if q.AdId > 0 {
+		where = append(where, "v.ad_id = "+arg(q.AdId))
+	}
+	limit := ""
+	if q.Limit > 0 {
+		limit = "LIMIT " + arg(q.Limit)
+	}
+	rows, err := r.Store.Query(
+		`
+    SELECT `+violationColumns+`
+    FROM violations v
+    JOIN compliance_rules r ON r.id = v.rule_id
+    WHERE `+strings.Join(where, " AND ")+`
+    ORDER BY v.id DESC
+    `+limit,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vs := make([]Violation, 0)
+	for rows.Next() {
+		v := Violation{}
+		if err = scanViolation(rows, &v); err != nil {
+			return vs, err
+		}
+		vs = append(vs, v)
+	}
+	return vs, rows.Err()
+}
+
+func (r *repository) Record(v *Violation) (bool, error) {
+	tx, err := r.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	created, err := Record(tx, v)
+	if err != nil {
+		return false, err
+	}
+	return created, tx.Commit()
+}
+
+// Record is Writer.Record with q, usually the transaction storing the ad.
+// Violations recorded again are left as they are, so checking ads again
+// doesn't reopen triaged ones.
+func Record(q db.Querier, v *Violation) (bool, error) {
+	var id int64
+	err := q.QueryRow(
+		`
+    INSERT INTO violations (rule_id, ad_id, advertiser, field, matched, status)
+    VALUES ($1, $2, $3, $4, $5, $6)
+    ON CONFLICT (rule_id, ad_id, field) DO NOTHING
+    RETURNING id
+    `,
+		v.RuleId, v.AdId, v.Advertiser, v.Field, v.Match, Open,
+	).Scan(&id)
+	created := err == nil
+	if err == sql.ErrNoRows {
+		err = q.QueryRow(
+			`SELECT id FROM violations WHERE rule_id = $1 AND ad_id = $2 AND field = $3`,
+			v.RuleId, v.AdId, v.Field,
+		).Scan(&id)
+	}
+	if err != nil {
+		return false, err
+	}
+	found, err := find(q, id)
+	if err != nil {
+		return false, err
+	} else if found != nil {
+		*v = *found
+	}
+	return created, nil
+}
+
+func (r *repository) Triage(v *Violation) error {
+	res, err := r.Store.Exec(
+		`
+    UPDATE violations
+    SET status = $1, note = $2, updated_at = CURRENT_TIMESTAMP
+    WHERE id = $3
+    AND rule_id IN (SELECT id FROM compliance_rules WHERE deleted_at IS NULL)
+    `,
+		v.Status, v.Note, v.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	found, err := r.Find(v.ID)
+	if err != nil {
+		return err
+	} else if found != nil {
+		*v = *found
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package compliance_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gkats/adscraper/compliance"
+	"github.com/gkats/adscraper/db/dbtest"
+)
+
+const rules = `
+rules:
+  - name: guaranteed
+    description: Claims results are guaranteed
+    severity: High
+    fields: [H1, desc]
+    terms: [guaranteed, "#1", risk  free]
+  - name: unapproved-price
+    pattern: '\$\d+(\.\d\d)?'
+  - name: reebok-trademark
+    terms: [reebok]
+    allow: [www.reebok.com]
+`
+
+const seed = `INSERT INTO ads (headline1, headline2, path, description) VALUES ('Guaranteed Results', '', 'nike.com', 'Just do it')`
+
+func TestCheck(t *testing.T) {
+	rs, err := compliance.ParseRules(strings.NewReader(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(c compliance.Copy) string {
+		lines := make([]string, 0)
+		for _, v := range compliance.Check(rs, &c) {
+			lines = append(lines, fmt.Sprintf("%v %v %v %v", v.Rule, v.Severity, v.Field, v.Match))
+		}
+		return strings.Join(lines, ",")
+	}
+	parse := func(yaml string) string {
+		_, err := compliance.ParseRules(strings.NewReader(yaml))
+		return fmt.Sprint(err)
+	}
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{"high [h1 desc] medium [h1 h2 desc rest]", fmt.Sprint(rs[0].Severity, " ", rs[0].Fields, " ", rs[1].Severity, " ", rs[1].Fields)},
+		{"guaranteed high h1 Guaranteed", check(compliance.Copy{Advertiser: "nike.com", H1: "Guaranteed Results"})},
+		{"guaranteed high desc #1", check(compliance.Copy{H2: "We're #1", Desc: "The #1 shoe"})},
+		{"guaranteed high desc Risk\n free", check(compliance.Copy{Desc: "Risk\n free returns"})},
+		{"", check(compliance.Copy{H1: "Unguaranteed #10", Desc: "riskfree"})},
+		{"unapproved-price medium rest $49.99", check(compliance.Copy{Rest: "Free shipping\nFrom $49.99"})},
+		{"reebok-trademark medium h2 Reebok", check(compliance.Copy{Advertiser: "nike.com", H2: "Better than Reebok"})},
+		{"", check(compliance.Copy{Advertiser: "shop.reebok.com", H2: "Reebok Classics"})},
+		{"<nil>", parse("")},
+		{`Rule "a": severity must be one of low, medium, high`, parse("rules:\n  - name: a\n    terms: [x]\n    severity: urgent")},
+		{`Rule "a": fields must be h1, h2, desc, rest`, parse("rules:\n  - name: a\n    terms: [x]\n    fields: [path]")},
+		{`Rule "a": rules need a pattern or terms`, parse("rules:\n  - name: a")},
+		{`Rule "a" is defined twice`, parse("rules:\n  - name: a\n    terms: [x]\n  - name: a\n    terms: [y]")},
+		{true, strings.Contains(parse("rules:\n  - name: a\n    term: [x]"), "field term not found")},
+		{true, strings.HasPrefix(parse("rules:\n  - name: a\n    pattern: '('"), `Rule "a": error parsing regexp`)},
+	}
+	for i, tc := range testCases {
+		if tc.want != tc.got {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	repos := map[string]compliance.ReaderWriter{
+		"memory": compliance.NewMemoryReaderWriter(),
+		"sqlite": compliance.NewReaderWriter(dbtest.NewStore(t, seed)),
+	}
+	for name, r := range repos {
+		t.Run(name, func(t *testing.T) {
+			rs, _ := compliance.ParseRules(strings.NewReader(rules))
+			if err := r.SetRules(rs); err != nil {
+				t.Fatal(err)
+			}
+			stored, _ := r.Rules()
+			vs := compliance.Check(stored, &compliance.Copy{Advertiser: "nike.com", H1: "Guaranteed Results", Desc: "From $49.99"})
+			for i := range vs {
+				vs[i].AdId = 1
+			}
+			created, err := r.Record(&vs[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorded := fmt.Sprintf("%v %v %v %v %v", vs[0].Rule, vs[0].Severity, vs[0].Field, vs[0].Match, vs[0].Status)
+			r.Record(&vs[1])
+			vs[0].Status, vs[0].Note = compliance.Dismissed, "Approved claim"
+			triaged := r.Triage(&vs[0])
+			again := vs[0]
+			againCreated, _ := r.Record(&again)
+			dismissed, _ := r.List(compliance.Query{Status: compliance.Dismissed, Advertiser: "nike.com"})
+			high, _ := r.List(compliance.Query{Severity: compliance.High})
+			all, _ := r.List(compliance.Query{})
+
+			// Removing a rule hides its violations, adding it back shows them
+			r.SetRules(rs[1:])
+			removed, _ := r.Find(vs[0].ID)
+			rules, _ := r.Rules()
+			r.SetRules(rs)
+			restored, _ := r.Find(vs[0].ID)
+			missing := r.Triage(&compliance.Violation{ID: 42, Status: compliance.Confirmed})
+
+			testCases := []struct {
+				want interface{}
+				got  interface{}
+			}{
+				{3, len(stored)},
+				{"[reebok.com]", fmt.Sprint(stored[2].Allow)},
+				{2, len(vs)},
+				{true, created},
+				{"guaranteed high h1 Guaranteed open", recorded},
+				{nil, triaged},
+				{false, againCreated},
+				{"dismissed Approved claim", again.Status + " " + again.Note},
+				{1, len(dismissed)},
+				{1, len(high)},
+				{"unapproved-price,guaranteed", all[0].Rule + "," + all[1].Rule},
+				{(*compliance.Violation)(nil), removed},
+				{2, len(rules)},
+				{"dismissed", restored.Status},
+				{compliance.ErrNotFound, missing},
+			}
+			for i, tc := range testCases {
+				if tc.want != tc.got {
+					t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package compliance
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewMemoryReaderWriter returns a ReaderWriter that keeps rules and their
+// violations in memory. It's safe for concurrent use and meant for tests.
+func NewMemoryReaderWriter() ReaderWriter {
+	return &memory{}
+}
+
+type memory struct {
+	mu         sync.Mutex
+	rules      []Rule
+	removed    map[int64]bool
+	violations []Violation
+}
+
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// rule returns the rule with the id, nil when there's none or it's been
+// removed.
+func (m *memory) rule(id int64) *Rule {
+	if id < 1 || id > int64(len(m.rules)) || m.removed[id] {
+		return nil
+	}
+	return &m.rules[id-1]
+}
+
+func (m *memory) Rules() ([]Rule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rs := make([]Rule, 0)
+	for _, r := range m.rules {
+		if !m.removed[r.ID] {
+			rs = append(rs, r)
+		}
+	}
+	return rs, nil
+}
+
+func (m *memory) SetRules(rules []Rule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := timestamp(time.Now())
+	removed := make(map[int64]bool)
+	for i := range m.rules {
+		removed[m.rules[i].ID] = true
+	}
+	for i := range rules {
+		r := &rules[i]
+		if err := r.compile(); err != nil {
+			return err
+		}
+		r.ID, r.CreatedAt = 0, now
+		for j := range m.rules {
+			if m.rules[j].Name == r.Name {
+				r.ID, r.CreatedAt = m.rules[j].ID, m.rules[j].CreatedAt
+			}
+		}
+		r.UpdatedAt = now
+		if r.ID == 0 {
+			r.ID = int64(len(m.rules) + 1)
+			m.rules = append(m.rules, *r)
+		} else {
+			m.rules[r.ID-1] = *r
+		}
+		delete(removed, r.ID)
+	}
+	m.removed = removed
+	return nil
+}
+
+// find returns the violation with the id with its rule's name and severity,
+// nil when there's none or its rule has been removed.
+func (m *memory) find(id int64) *Violation {
+	if id < 1 || id > int64(len(m.violations)) {
+		return nil
+	}
+	v := m.violations[id-1]
+	r := m.rule(v.RuleId)
+	if r == nil {
+		return nil
+	}
+	v.Rule, v.Severity = r.Name, r.Severity
+	return &v
+}
+
+func (m *memory) Find(id int64) (*Violation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.find(id), nil
+}
+
+func (m *memory) List(q Query) ([]Violation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	vs := make([]Violation, 0)
+	d := strings.ToLower(q.Advertiser)
+	for i := range m.violations {
+		v := m.find(m.violations[i].ID)
+		switch {
+		case v == nil:
+		case q.Rule != "" && v.Rule != q.Rule:
+		case q.Severity != "" && v.Severity != q.Severity:
+		case q.Status != "" && v.Status != q.Status:
+		case d != "" && v.Advertiser != d && !strings.HasSuffix(v.Advertiser, "."+d):
+		case q.AdId > 0 && v.AdId != q.AdId:
+		default:
+			vs = append(vs, *v)
+		}
+	}
+	sort.SliceStable(vs, func(a, b int) bool { return vs[a].ID > vs[b].ID })
+	if q.Limit > 0 && len(vs) > q.Limit {
+		vs = vs[:q.Limit]
+	}
+	return vs, nil
+}
+
+func (m *memory) Record(v *Violation) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.rule(v.RuleId) == nil {
+		return false, ErrNotFound
+	}
+	for i := range m.violations {
+		existing := &m.violations[i]
+		if existing.RuleId == v.RuleId && existing.AdId == v.AdId && existing.Field == v.Field {
+			*v = *m.find(existing.ID)
+			return false, nil
+		}
+	}
+	v.ID, v.Status, v.Note = int64(len(m.violations)+1), Open, ""
+	v.CreatedAt = timestamp(time.Now())
+	v.UpdatedAt = v.CreatedAt
+	m.violations = append(m.violations, *v)
+	*v = *m.find(v.ID)
+	return true, nil
+}
+
+func (m *memory) Triage(v *Violation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.find(v.ID) == nil {
+		return ErrNotFound
+	}
+	stored := &m.violations[v.ID-1]
+	stored.Status, stored.Note = v.Status, v.Note
+	stored.UpdatedAt = timestamp(time.Now())
+	*v = *m.find(v.ID)
+	return nil
+}
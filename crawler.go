@@ -1,29 +1,66 @@
 package adscraper
 
 import (
-	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 const UA = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/56.0.2924.87 Safari/537.36"
 
-type crawler struct{}
+// MobileUA is sent when searching from a mobile device.
+const MobileUA = "Mozilla/5.0 (Linux; Android 7.0; SM-G930V Build/NRD90M) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/56.0.2924.87 Mobile Safari/537.36"
+
+// StatusError is returned when a results page can't be fetched, usually
+// because of rate limiting.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	// Captcha is set when the search was redirected to a CAPTCHA.
+	Captcha bool
+}
+
+func (e *StatusError) Error() string {
+	if e.Captcha {
+		return "Received a CAPTCHA, status: " + e.Status
+	}
+	return "Received status: " + e.Status
+}
+
+// Temporary reports whether the request is worth retrying later.
+func (e *StatusError) Temporary() bool {
+	return e.Captcha || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+type crawler struct {
+	// userAgent defaults to UA
+	userAgent string
+}
 
 func (c *crawler) Fetch(url string) (*http.Response, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", UA)
+	ua := c.userAgent
+	if ua == "" {
+		ua = UA
+	}
+	req.Header.Set("User-Agent", ua)
 
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return res, err
 	}
 
-	// TODO check other status codes, and redirects
-	if res.StatusCode != 200 {
-		return res, errors.New("Received status: " + res.Status)
+	// Google redirects to /sorry/ when it wants a CAPTCHA solved
+	captcha := res.Request != nil && strings.HasPrefix(res.Request.URL.Path, "/sorry/")
+	if res.StatusCode != 200 || captcha {
+		status := res.Status
+		if status == "" {
+			status = strconv.Itoa(res.StatusCode)
+		}
+		return res, &StatusError{StatusCode: res.StatusCode, Status: status, Captcha: captcha}
 	}
 	return res, nil
 }
@@ -0,0 +1,284 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"flag"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	// load the postgresql and sqlite database/sql drivers
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
+var drivers = map[string]string{
+	Postgres: "postgres",
+	SQLite:   "sqlite3",
+}
+
+//go:embed migrate
+var migrations embed.FS
+
+// Querier is a Store or a transaction.
+type Querier interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+	QueryRow(string, ...interface{}) *sql.Row
+	Query(string, ...interface{}) (*sql.Rows, error)
+}
+
+type Store interface {
+	Querier
+	Close() error
+	PingContext(context.Context) error
+	Begin() (*sql.Tx, error)
+	Dialect() string
+}
+
+type store struct {
+	*sql.DB
+	dialect string
+}
+
+func (s *store) Dialect() string {
+	return s.dialect
+}
+
+type Config struct {
+	URL             string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// Flags registers the database connection flags on fs. The URL defaults to
+// the DATABASE_URL environment variable.
+func (c *Config) Flags(fs *flag.FlagSet) {
+	fs.StringVar(&c.URL, "d", os.Getenv("DATABASE_URL"), "The database URL. A PostgreSQL URL or key=value connection string, 'user:password@host:port/database', or 'sqlite://path/to/file.db' for SQLite. When empty, PostgreSQL is configured from the standard PG* environment variables.")
+	fs.IntVar(&c.MaxOpenConns, "db-max-open-conns", 0, "Maximum number of open database connections. Zero means unlimited.")
+	fs.IntVar(&c.MaxIdleConns, "db-max-idle-conns", 2, "Maximum number of idle database connections.")
+	fs.DurationVar(&c.ConnMaxLifetime, "db-conn-max-lifetime", 0, "Maximum amount of time a database connection may be reused. Zero means forever.")
+	fs.DurationVar(&c.ConnMaxIdleTime, "db-conn-max-idle-time", 0, "Maximum amount of time a database connection may be idle. Zero means forever.")
+}
+
+var keyValue = regexp.MustCompile(`^\s*\w+\s*=`)
+
+// Parse returns the dialect and driver data source name for a database URL.
+// URLs starting with sqlite:// point to a SQLite database file. Anything else
+// is a PostgreSQL database, either as a postgres:// URL, a key=value
+// connection string or in the legacy 'user:password@host:port/database'
+// format. Settings missing from a PostgreSQL URL are read from the PG*
+// environment variables by the driver.
+func Parse(u string) (dialect string, dsn string) {
+	for _, scheme := range []string{"sqlite://", "sqlite3://"} {
+		if strings.HasPrefix(u, scheme) {
+			return SQLite, sqliteDSN(strings.TrimPrefix(u, scheme))
+		}
+	}
+	if u == "" || keyValue.MatchString(u) ||
+		strings.HasPrefix(u, "postgres://") || strings.HasPrefix(u, "postgresql://") {
+		return Postgres, u
+	}
+	return Postgres, legacyDSN(u)
+}
+
+func sqliteDSN(file string) string {
+	sep := "?"
+	if strings.Contains(file, "?") {
+		sep = "&"
+	}
+	return "file:" + file + sep + "_foreign_keys=on&_busy_timeout=5000"
+}
+
+// legacyDSN keeps requiring SSL for the 'user:password@host:port/database'
+// format unless the URL or PGSSLMODE say otherwise.
+func legacyDSN(s string) string {
+	u, err := url.Parse("postgres://" + s)
+	if err != nil {
+		// Let the driver report the malformed URL
+		return "postgres://" + s
+	}
+	q := u.Query()
+	if q.Get("sslmode") == "" && os.Getenv("PGSSLMODE") == "" {
+		q.Set("sslmode", "require")
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// Open connects to the database described by c.
+func Open(c Config) (*sql.DB, string, error) {
+	dialect, dsn := Parse(c.URL)
+	conn, err := sql.Open(drivers[dialect], dsn)
+	if err != nil {
+		return nil, dialect, err
+	}
+
+	conn.SetMaxOpenConns(c.MaxOpenConns)
+	conn.SetMaxIdleConns(c.MaxIdleConns)
+	conn.SetConnMaxLifetime(c.ConnMaxLifetime)
+	conn.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+	if dialect == SQLite {
+		// SQLite has a single writer, funnel everything through one connection
+		// and keep it around, in-memory databases live as long as it does.
+		conn.SetMaxOpenConns(1)
+		conn.SetMaxIdleConns(1)
+		conn.SetConnMaxLifetime(0)
+		conn.SetConnMaxIdleTime(0)
+	}
+
+	if err = conn.Ping(); err != nil {
+		conn.Close()
+		return nil, dialect, err
+	}
+	return conn, dialect, nil
+}
+
+func NewStore(c Config) (Store, error) {
+	conn, dialect, err := Open(c)
+	if err != nil {
+		return nil, err
+	}
+	return &store{DB: conn, dialect: dialect}, nil
+}
+
+// Day returns the SQL expression truncating the timestamp column to its UTC
+// date.
+func Day(dialect string, column string) string {
+	if dialect == SQLite {
+		return "date(" + column + ")"
+	}
+	return "(" + column + " AT TIME ZONE 'UTC')::date"
+}
+
+// Timestamp returns expr as a timestamp that compares correctly with both
+// stored timestamps and timestamps bound as query parameters. SQLite keeps
+// them as text in several formats, so they're normalized first.
+func Timestamp(dialect string, expr string) string {
+	if dialect == SQLite {
+		return "datetime(" + expr + ")"
+	}
+	return expr
+}
+
+// Like returns the SQL condition matching expr against a LIKE pattern, with
+// literal text in the pattern escaped by EscapeLike.
+func Like(expr string, pattern string) string {
+	return expr + " LIKE " + pattern + ` ESCAPE '\'`
+}
+
+// EscapeLike escapes the LIKE wildcards in s, so "50%" only matches "50%".
+func EscapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Migrate applies the store dialect's migrations that haven't run yet, in
+// order.
+func Migrate(s Store) error {
+	_, err := s.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version VARCHAR PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+
+	files, err := fs.Glob(migrations, "migrate/"+s.Dialect()+"/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, f := range files {
+		version := strings.SplitN(path.Base(f), "_", 2)[0]
+
+		var n int
+		err = s.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE version = $1`, version).Scan(&n)
+		if err != nil {
+			return err
+		} else if n > 0 {
+			continue
+		}
+
+		if err = apply(s, f, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pending returns the versions of the migrations that haven't been applied
+// yet, oldest first.
+func Pending(s Store) ([]string, error) {
+	files, err := fs.Glob(migrations, "migrate/"+s.Dialect()+"/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	rows, err := s.Query(`SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var version string
+		if err = rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pending := make([]string, 0)
+	for _, f := range files {
+		if version := strings.SplitN(path.Base(f), "_", 2)[0]; !applied[version] {
+			pending = append(pending, version)
+		}
+	}
+	return pending, nil
+}
+
+func apply(s Store, file string, version string) error {
+	q, err := migrations.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(string(q)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err = tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SQL returns the database behind s, nil for stores not opened by NewStore.
+func SQL(s Store) *sql.DB {
+	if s, ok := s.(*store); ok {
+		return s.DB
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+// Package dbtest sets up databases for tests.
+package dbtest
+
+import (
+	"testing"
+
+	"github.com/gkats/adscraper/db"
+)
+
+// NewStore returns a migrated in-memory SQLite store, closed when the test
+// ends. The seed SQL is run in order, to fill it in.
+func NewStore(t testing.TB, seed ...string) db.Store {
+	t.Helper()
+	store, err := db.NewStore(db.Config{URL: "sqlite://:memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	if err = db.Migrate(store); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range seed {
+		if _, err = store.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
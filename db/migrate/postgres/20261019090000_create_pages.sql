@@ -0,0 +1,12 @@
+CREATE TABLE pages (
+  id SERIAL PRIMARY KEY,
+  keyword_id INTEGER NOT NULL REFERENCES keywords (id),
+  hash VARCHAR NOT NULL,
+  parser_version VARCHAR NOT NULL,
+  fetched_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX pages_keyword_id_index ON pages (keyword_id);
+CREATE INDEX pages_hash_index ON pages (hash);
\ No newline at end of file
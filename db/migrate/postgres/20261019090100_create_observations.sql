@@ -0,0 +1,12 @@
+CREATE TABLE observations (
+  id SERIAL PRIMARY KEY,
+  ad_id INTEGER NOT NULL REFERENCES ads (id),
+  keyword_id INTEGER NOT NULL REFERENCES keywords (id),
+  page_id INTEGER REFERENCES pages (id),
+  position INTEGER NOT NULL,
+  observed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX observations_ad_id_index ON observations (ad_id);
+CREATE INDEX observations_keyword_id_observed_at_index ON observations (keyword_id, observed_at);
+CREATE INDEX observations_page_id_index ON observations (page_id);
\ No newline at end of file
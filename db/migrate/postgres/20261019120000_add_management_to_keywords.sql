@@ -0,0 +1,4 @@
+ALTER TABLE keywords ADD COLUMN locale VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE keywords ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE keywords ADD COLUMN paused BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE keywords ADD COLUMN deleted_at TIMESTAMP;
\ No newline at end of file
@@ -0,0 +1,17 @@
+CREATE TABLE labels (
+  id SERIAL PRIMARY KEY,
+  kind VARCHAR NOT NULL,
+  name VARCHAR NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX labels_kind_name_index ON labels (kind, name);
+
+CREATE TABLE keyword_labels (
+  keyword_id INTEGER NOT NULL REFERENCES keywords (id),
+  label_id INTEGER NOT NULL REFERENCES labels (id),
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (keyword_id, label_id)
+);
+
+CREATE INDEX keyword_labels_label_id_index ON keyword_labels (label_id);
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE api_keys (
+  id SERIAL PRIMARY KEY,
+  name VARCHAR NOT NULL,
+  prefix VARCHAR NOT NULL,
+  hash VARCHAR NOT NULL,
+  scope VARCHAR NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  last_used_at TIMESTAMP WITH TIME ZONE,
+  revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX api_keys_hash_index ON api_keys (hash);
\ No newline at end of file
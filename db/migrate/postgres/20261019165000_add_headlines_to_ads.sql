@@ -0,0 +1 @@
+ALTER TABLE ads ADD COLUMN headlines TEXT;
\ No newline at end of file
@@ -0,0 +1,54 @@
+CREATE TABLE webhooks (
+  id SERIAL PRIMARY KEY,
+  url VARCHAR NOT NULL,
+  secret VARCHAR NOT NULL,
+  events VARCHAR NOT NULL DEFAULT '',
+  keyword_group VARCHAR NOT NULL DEFAULT '',
+  advertiser VARCHAR NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  deleted_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE webhook_deliveries (
+  id SERIAL PRIMARY KEY,
+  webhook_id INTEGER NOT NULL REFERENCES webhooks (id),
+  event VARCHAR NOT NULL,
+  payload TEXT NOT NULL,
+  status VARCHAR NOT NULL DEFAULT 'pending',
+  attempts INTEGER NOT NULL DEFAULT 0,
+  response_status INTEGER NOT NULL DEFAULT 0,
+  last_error VARCHAR NOT NULL DEFAULT '',
+  next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  delivered_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX webhook_deliveries_webhook_id_index ON webhook_deliveries (webhook_id);
+CREATE INDEX webhook_deliveries_status_next_attempt_at_index ON webhook_deliveries (status, next_attempt_at);
+
+CREATE TABLE keyword_ads (
+  keyword_id INTEGER NOT NULL REFERENCES keywords (id),
+  ad_id INTEGER NOT NULL REFERENCES ads (id),
+  in_last_scrape BOOLEAN NOT NULL DEFAULT FALSE,
+  in_current_scrape BOOLEAN NOT NULL DEFAULT FALSE,
+  PRIMARY KEY (keyword_id, ad_id)
+);
+
+CREATE TABLE keyword_advertisers (
+  keyword_id INTEGER NOT NULL REFERENCES keywords (id),
+  advertiser VARCHAR NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (keyword_id, advertiser)
+);
+
+INSERT INTO keyword_advertisers (keyword_id, advertiser)
+SELECT DISTINCT seen.keyword_id, seen.advertiser
+FROM (
+  SELECT o.keyword_id, regexp_replace(LOWER(split_part(ads.path, '/', 1)), '^www\.', '') AS advertiser
+  FROM (
+    SELECT ad_id, keyword_id FROM observations
+    UNION SELECT ad_id, keyword_id FROM daily_observations
+  ) o
+  JOIN ads ON ads.id = o.ad_id
+) seen
+WHERE seen.advertiser <> '';
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE ads (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  headline1 VARCHAR NOT NULL,
+  headline2 VARCHAR NOT NULL,
+  description VARCHAR NOT NULL,
+  path VARCHAR NOT NULL,
+  rest TEXT,
+  raw TEXT,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX ads_h1_h2_desc_index ON ads (headline1, headline2, description);
\ No newline at end of file
@@ -0,0 +1,10 @@
+CREATE TABLE keywords (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  value VARCHAR NOT NULL,
+  times_scraped INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  last_scraped_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX keywords_value_index ON keywords (value);
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE ad_keywords (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  ad_id INTEGER REFERENCES ads (id),
+  keyword_id INTEGER REFERENCES keywords (id),
+  position INTEGER NOT NULL,
+  position_count INTEGER NOT NULL DEFAULT 1,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX ad_keywords_ad_id_keyword_id_position_index ON ad_keywords (ad_id, keyword_id, position);
\ No newline at end of file
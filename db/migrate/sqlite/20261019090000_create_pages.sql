@@ -0,0 +1,12 @@
+CREATE TABLE pages (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  keyword_id INTEGER NOT NULL REFERENCES keywords (id),
+  hash VARCHAR NOT NULL,
+  parser_version VARCHAR NOT NULL,
+  fetched_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX pages_keyword_id_index ON pages (keyword_id);
+CREATE INDEX pages_hash_index ON pages (hash);
\ No newline at end of file
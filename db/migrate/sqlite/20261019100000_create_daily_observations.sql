@@ -0,0 +1,12 @@
+CREATE TABLE daily_observations (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  day DATE NOT NULL,
+  ad_id INTEGER NOT NULL REFERENCES ads (id),
+  keyword_id INTEGER NOT NULL REFERENCES keywords (id),
+  position INTEGER NOT NULL,
+  observations_count INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE UNIQUE INDEX daily_observations_day_ad_id_keyword_id_position_index ON daily_observations (day, ad_id, keyword_id, position);
+CREATE INDEX observations_observed_at_index ON observations (observed_at);
+CREATE INDEX ad_keywords_updated_at_index ON ad_keywords (updated_at);
\ No newline at end of file
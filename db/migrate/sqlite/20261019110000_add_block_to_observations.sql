@@ -0,0 +1 @@
+ALTER TABLE observations ADD COLUMN block VARCHAR NOT NULL DEFAULT '';
\ No newline at end of file
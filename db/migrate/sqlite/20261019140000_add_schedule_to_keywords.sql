@@ -0,0 +1,3 @@
+ALTER TABLE keywords ADD COLUMN cadence VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE keywords ADD COLUMN window_start VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE keywords ADD COLUMN window_end VARCHAR NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE keywords ADD COLUMN device VARCHAR NOT NULL DEFAULT '';
\ No newline at end of file
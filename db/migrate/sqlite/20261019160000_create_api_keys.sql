@@ -0,0 +1,12 @@
+CREATE TABLE api_keys (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name VARCHAR NOT NULL,
+  prefix VARCHAR NOT NULL,
+  hash VARCHAR NOT NULL,
+  scope VARCHAR NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  last_used_at TIMESTAMP,
+  revoked_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX api_keys_hash_index ON api_keys (hash);
\ No newline at end of file
@@ -0,0 +1,57 @@
+CREATE TABLE webhooks (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  url VARCHAR NOT NULL,
+  secret VARCHAR NOT NULL,
+  events VARCHAR NOT NULL DEFAULT '',
+  keyword_group VARCHAR NOT NULL DEFAULT '',
+  advertiser VARCHAR NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  deleted_at TIMESTAMP
+);
+
+CREATE TABLE webhook_deliveries (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  webhook_id INTEGER NOT NULL REFERENCES webhooks (id),
+  event VARCHAR NOT NULL,
+  payload TEXT NOT NULL,
+  status VARCHAR NOT NULL DEFAULT 'pending',
+  attempts INTEGER NOT NULL DEFAULT 0,
+  response_status INTEGER NOT NULL DEFAULT 0,
+  last_error VARCHAR NOT NULL DEFAULT '',
+  next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  delivered_at TIMESTAMP
+);
+
+CREATE INDEX webhook_deliveries_webhook_id_index ON webhook_deliveries (webhook_id);
+CREATE INDEX webhook_deliveries_status_next_attempt_at_index ON webhook_deliveries (status, next_attempt_at);
+
+CREATE TABLE keyword_ads (
+  keyword_id INTEGER NOT NULL REFERENCES keywords (id),
+  ad_id INTEGER NOT NULL REFERENCES ads (id),
+  in_last_scrape BOOLEAN NOT NULL DEFAULT FALSE,
+  in_current_scrape BOOLEAN NOT NULL DEFAULT FALSE,
+  PRIMARY KEY (keyword_id, ad_id)
+);
+
+CREATE TABLE keyword_advertisers (
+  keyword_id INTEGER NOT NULL REFERENCES keywords (id),
+  advertiser VARCHAR NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (keyword_id, advertiser)
+);
+
+INSERT INTO keyword_advertisers (keyword_id, advertiser)
+SELECT DISTINCT seen.keyword_id, seen.advertiser
+FROM (
+  SELECT keyword_id, CASE WHEN host LIKE 'www.%' THEN substr(host, 5) ELSE host END AS advertiser
+  FROM (
+    SELECT o.keyword_id, LOWER(CASE WHEN instr(ads.path, '/') > 0 THEN substr(ads.path, 1, instr(ads.path, '/') - 1) ELSE ads.path END) AS host
+    FROM (
+      SELECT ad_id, keyword_id FROM observations
+      UNION SELECT ad_id, keyword_id FROM daily_observations
+    ) o
+    JOIN ads ON ads.id = o.ad_id
+  ) hosts
+) seen
+WHERE seen.advertiser <> '';
\ No newline at end of file
@@ -0,0 +1,25 @@
+CREATE TABLE brands (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  advertiser VARCHAR NOT NULL,
+  terms VARCHAR NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  deleted_at TIMESTAMP
+);
+
+CREATE TABLE brand_incidents (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  brand_id INTEGER NOT NULL REFERENCES brands (id),
+  keyword_id INTEGER NOT NULL REFERENCES keywords (id),
+  ad_id INTEGER NOT NULL REFERENCES ads (id),
+  term VARCHAR NOT NULL,
+  bidder VARCHAR NOT NULL,
+  position INTEGER NOT NULL DEFAULT 0,
+  block VARCHAR NOT NULL DEFAULT '',
+  evidence TEXT NOT NULL DEFAULT '',
+  first_seen_at TIMESTAMP NOT NULL,
+  last_seen_at TIMESTAMP NOT NULL,
+  UNIQUE (brand_id, keyword_id, ad_id)
+);
+
+CREATE INDEX brand_incidents_last_seen_at_index ON brand_incidents (last_seen_at);
\ No newline at end of file
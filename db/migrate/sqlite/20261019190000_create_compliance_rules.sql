@@ -0,0 +1,29 @@
+CREATE TABLE compliance_rules (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name VARCHAR NOT NULL UNIQUE,
+  description VARCHAR NOT NULL DEFAULT '',
+  severity VARCHAR NOT NULL,
+  fields VARCHAR NOT NULL DEFAULT '',
+  pattern VARCHAR NOT NULL DEFAULT '',
+  terms TEXT NOT NULL DEFAULT '',
+  allow VARCHAR NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  deleted_at TIMESTAMP
+);
+
+CREATE TABLE violations (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  rule_id INTEGER NOT NULL REFERENCES compliance_rules (id),
+  ad_id INTEGER NOT NULL REFERENCES ads (id),
+  advertiser VARCHAR NOT NULL,
+  field VARCHAR NOT NULL,
+  matched VARCHAR NOT NULL,
+  status VARCHAR NOT NULL DEFAULT 'open',
+  note TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (rule_id, ad_id, field)
+);
+
+CREATE INDEX violations_status_index ON violations (status);
\ No newline at end of file
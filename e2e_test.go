@@ -0,0 +1,1137 @@
+package adscraper_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gkats/adscraper"
+	"github.com/gkats/adscraper/apikeys"
+	"github.com/gkats/adscraper/archive"
+	"github.com/gkats/adscraper/brands"
+	"github.com/gkats/adscraper/compliance"
+	"github.com/gkats/adscraper/db"
+	"github.com/gkats/adscraper/keywords"
+	"github.com/gkats/adscraper/webhooks"
+)
+
+func newSQLiteStore(t *testing.T) adscraper.Store {
+	store, err := db.NewStore(db.Config{
+		URL: "sqlite://" + filepath.Join(t.TempDir(), "adscraper.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	if err = db.Migrate(store); err != nil {
+		t.Fatal(err)
+	}
+	// Running the migrations twice must be a no-op
+	if err = db.Migrate(store); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func count(t *testing.T, store adscraper.Store, q string) int {
+	var n int
+	if err := store.QueryRow(q).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestEndToEndSQLite(t *testing.T) {
+	store := newSQLiteStore(t)
+
+	for _, v := range []string{"reebok women shoes", "nike basketball"} {
+		if _, err := keywords.NewWriter(store).Upsert(keywords.New(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	google := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, resultsHTML)
+	}))
+	defer google.Close()
+
+	ts := httptest.NewServer(adscraper.NewServer(adscraper.NewRepositories(store)).Handler())
+	defer ts.Close()
+	client := adscraper.NewClient(ts.URL)
+
+	ks, err := client.GetKeywords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ks) != 2 {
+		t.Fatalf("Expected 2 keywords, got %v", len(ks))
+	}
+
+	// Scrape the same results twice for the first keyword, once for the second
+	for _, k := range []*keywords.Keyword{ks[0], ks[0], ks[1]} {
+		ads, err := adscraper.Scrape(google.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ad := range ads {
+			if err = client.PostAdKeywords(ad, k); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = client.PatchKeyword(k.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		want int
+		q    string
+	}{
+		{3, "SELECT COUNT(*) FROM ads"},
+		{6, "SELECT COUNT(*) FROM ad_keywords"},
+		{9, "SELECT COUNT(*) FROM observations"},
+		{3, "SELECT COUNT(*) FROM ad_keywords WHERE position_count > 1"},
+		{1, "SELECT COUNT(*) FROM keywords WHERE times_scraped = 2"},
+		{2, "SELECT COUNT(*) FROM keywords WHERE last_scraped_at IS NOT NULL"},
+	}
+	for i, tc := range testCases {
+		if got := count(t, store, tc.q); got != tc.want {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, got)
+		}
+	}
+
+	ks, err = client.GetKeywords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ks) != 0 {
+		t.Errorf("Expected scraped keywords not to be due, got %v", len(ks))
+	}
+	scraped, _, err := keywords.NewReader(store).List(keywords.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range scraped {
+		if k.LastScrapedAt == "" {
+			t.Errorf("Expected keyword %v last scraped at not to be blank", k.Value)
+		}
+	}
+}
+
+func TestReparseSQLite(t *testing.T) {
+	store := newSQLiteStore(t)
+	k, err := keywords.NewWriter(store).Upsert(keywords.New("reebok women shoes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := adscraper.NewRepositories(store)
+	ts := httptest.NewServer(adscraper.NewServer(repos).Handler())
+	defer ts.Close()
+	client := adscraper.NewClient(ts.URL)
+
+	page := &adscraper.Page{KeywordId: k.ID, ParserVersion: adscraper.ParserVersion, Body: []byte(resultsHTML)}
+	if err = client.PostPage(page); err != adscraper.ErrArchiveDisabled {
+		t.Fatalf("Expected archive to be disabled, got %v", err)
+	}
+
+	a, err := archive.Open(archive.Config{URL: t.TempDir(), Compression: archive.Zstd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos.Pages = adscraper.NewPageReaderWriter(store, a)
+	archiving := httptest.NewServer(adscraper.NewServer(repos).Handler())
+	defer archiving.Close()
+	client = adscraper.NewClient(archiving.URL)
+	if err = client.PostPage(page); err != nil {
+		t.Fatal(err)
+	}
+	if page.ID == 0 || page.Hash != archive.Hash([]byte(resultsHTML)) {
+		t.Fatalf("Expected page to be archived, got %v %v", page.ID, page.Hash)
+	}
+
+	ads, err := adscraper.Parse(page.ParserVersion, strings.NewReader(resultsHTML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ad := range ads {
+		ad.PageId = page.ID
+		if err = client.PostAdKeywords(ad, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reparse the archived page with a "fixed" parser, which finds the first
+	// ad at a new position and drops the rest.
+	stored, err := repos.Pages.Find(page.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repos.Pages.ReadBody(stored); err != nil {
+		t.Fatal(err)
+	}
+	if string(stored.Body) != resultsHTML {
+		t.Fatalf("Expected the archived page body back")
+	}
+	reparsed, _ := adscraper.Parse(stored.ParserVersion, bytes.NewReader(stored.Body))
+	reparsed = reparsed[:1]
+	reparsed[0].Position = 4
+	reparsed[0].SetRaw("<li>fixed</li>")
+	if err = repos.Ads.ReplacePageAds(stored, reparsed); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		want int
+		q    string
+	}{
+		{3, "SELECT COUNT(*) FROM ads"},
+		{1, "SELECT COUNT(*) FROM ad_keywords"},
+		{1, "SELECT COUNT(*) FROM ad_keywords WHERE position = 4"},
+		{1, "SELECT COUNT(*) FROM observations"},
+		{1, "SELECT COUNT(*) FROM observations WHERE page_id IS NOT NULL"},
+		{1, "SELECT COUNT(*) FROM ads WHERE raw = '<li>fixed</li>'"},
+	}
+	for i, tc := range testCases {
+		if got := count(t, store, tc.q); got != tc.want {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, got)
+		}
+	}
+
+	obs, err := repos.Ads.GetObservations(reparsed[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(obs) != 1 || obs[0].PageId != page.ID || obs[0].ObservedAt == "" {
+		t.Errorf("Expected a single observation on page %v, got %v", page.ID, obs)
+	}
+}
+
+func TestArchiveNonUTF8Page(t *testing.T) {
+	store := newSQLiteStore(t)
+	k, err := keywords.NewWriter(store).Upsert(keywords.New("cafe creme"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := archive.Open(archive.Config{URL: t.TempDir(), Compression: archive.Gzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos := adscraper.NewRepositories(store)
+	repos.Pages = adscraper.NewPageReaderWriter(store, a)
+	ts := httptest.NewServer(adscraper.NewServer(repos).Handler())
+	defer ts.Close()
+
+	// Pages in ISO-8859-1 aren't valid UTF-8
+	body := []byte("<html><body><h3>Caf\xe9 cr\xe8me</h3></body></html>")
+	page := &adscraper.Page{KeywordId: k.ID, ParserVersion: adscraper.ParserVersion, Body: body}
+	if err = adscraper.NewClient(ts.URL).PostPage(page); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := repos.Pages.Find(page.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repos.Pages.ReadBody(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{archive.Hash(body), page.Hash},
+		{string(body), string(stored.Body)},
+	}
+	for i, tc := range testCases {
+		if tc.want != tc.got {
+			t.Errorf("(%v) Expected %q, got %q", i, tc.want, tc.got)
+		}
+	}
+}
+
+func TestAdsSearch(t *testing.T) {
+	stores := map[string]*adscraper.Repositories{
+		"memory": adscraper.NewMemoryRepositories(),
+		"sqlite": adscraper.NewRepositories(newSQLiteStore(t)),
+	}
+	yesterday := time.Now().UTC().AddDate(0, 0, -1)
+	tomorrow := yesterday.AddDate(0, 0, 2)
+
+	for name, repos := range stores {
+		k1, _ := repos.Keywords.Upsert(keywords.New("women shoes"))
+		k2, _ := repos.Keywords.Upsert(keywords.New("cheap flights"))
+		for _, s := range []struct {
+			ad *adscraper.Ad
+			k  *keywords.Keyword
+		}{
+			{&adscraper.Ad{H1: "Women Shoes", H2: "Reebok.com", Desc: "Flash Sale", Path: "www.reebok.com/gr", Position: 1, Block: adscraper.BlockTop}, k1},
+			{&adscraper.Ad{H1: "Running Shoes", H2: "Nike", Desc: "Just do it", Path: "nike.com", Position: 3, Block: adscraper.BlockBottom}, k1},
+			{&adscraper.Ad{H1: "Cheap Flights", H2: "Skyscanner", Desc: "Compare flights on sale", Path: "www.skyscanner.net/flights", Position: 2, Block: adscraper.BlockTop}, k2},
+		} {
+			if err := repos.Ads.Upsert(s.ad, s.k); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		testCases := []struct {
+			q    adscraper.AdQuery
+			want []int64
+		}{
+			{adscraper.AdQuery{}, []int64{3, 2, 1}},
+			{adscraper.AdQuery{Sort: "id"}, []int64{1, 2, 3}},
+			{adscraper.AdQuery{Sort: "-createdAt"}, []int64{3, 2, 1}},
+			{adscraper.AdQuery{Domain: "reebok.com"}, []int64{1}},
+			{adscraper.AdQuery{Domain: "NIKE.com"}, []int64{2}},
+			{adscraper.AdQuery{Domain: "eebok.com"}, []int64{}},
+			{adscraper.AdQuery{KeywordId: k2.ID}, []int64{3}},
+			{adscraper.AdQuery{Block: adscraper.BlockBottom}, []int64{2}},
+			{adscraper.AdQuery{MinPosition: 2}, []int64{3, 2}},
+			{adscraper.AdQuery{MinPosition: 2, MaxPosition: 2}, []int64{3}},
+			{adscraper.AdQuery{KeywordId: k1.ID, MaxPosition: 2}, []int64{1}},
+			{adscraper.AdQuery{Text: "SALE"}, []int64{3, 1}},
+			// LIKE wildcards are matched literally
+			{adscraper.AdQuery{Text: "s_le"}, []int64{}},
+			{adscraper.AdQuery{Text: "%"}, []int64{}},
+			{adscraper.AdQuery{Domain: "nike_com"}, []int64{}},
+			{adscraper.AdQuery{Domain: "%"}, []int64{}},
+			{adscraper.AdQuery{From: yesterday, To: tomorrow}, []int64{3, 2, 1}},
+			{adscraper.AdQuery{From: tomorrow}, []int64{}},
+			{adscraper.AdQuery{To: yesterday}, []int64{}},
+		}
+		for i, tc := range testCases {
+			ads, next, err := repos.Ads.Search(tc.q)
+			if err != nil {
+				t.Fatalf("%v (%v): %v", name, i, err)
+			}
+			got := make([]int64, 0)
+			for _, ad := range ads {
+				got = append(got, ad.ID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) || next != "" {
+				t.Errorf("%v (%v) Expected %v, got %v (next %q)", name, i, tc.want, got, next)
+			}
+		}
+
+		// Page through every sort one ad at a time
+		for _, sort := range []string{"id", "-id", "createdAt", "-updatedAt"} {
+			q := adscraper.AdQuery{Sort: sort, Limit: 1}
+			seen := make(map[int64]bool)
+			for page := 0; page < 3; page++ {
+				ads, next, err := repos.Ads.Search(q)
+				if err != nil {
+					t.Fatalf("%v %v: %v", name, sort, err)
+				}
+				if len(ads) != 1 || seen[ads[0].ID] {
+					t.Fatalf("%v %v: Expected a new ad on page %v, got %v", name, sort, page, ads)
+				}
+				seen[ads[0].ID] = true
+				if (next == "") != (page == 2) {
+					t.Fatalf("%v %v: Unexpected cursor %q on page %v", name, sort, next, page)
+				}
+				q.Cursor = next
+			}
+		}
+
+		cursor := func(sort string) string {
+			_, next, _ := repos.Ads.Search(adscraper.AdQuery{Sort: sort, Limit: 1})
+			return next
+		}
+		errorCases := []struct {
+			q    adscraper.AdQuery
+			want error
+		}{
+			{adscraper.AdQuery{Sort: "headline"}, adscraper.ErrInvalidSort},
+			{adscraper.AdQuery{Cursor: "junk"}, adscraper.ErrInvalidCursor},
+			{adscraper.AdQuery{Sort: "createdAt", Cursor: cursor("id")}, adscraper.ErrInvalidCursor},
+		}
+		for i, tc := range errorCases {
+			if _, _, err := repos.Ads.Search(tc.q); err != tc.want {
+				t.Errorf("%v (%v) Expected %v, got %v", name, i, tc.want, err)
+			}
+		}
+	}
+}
+
+func TestAdsExport(t *testing.T) {
+	stores := map[string]*adscraper.Repositories{
+		"memory": adscraper.NewMemoryRepositories(),
+		"sqlite": adscraper.NewRepositories(newSQLiteStore(t)),
+	}
+	errStop := errors.New("stop")
+
+	for name, repos := range stores {
+		k1, _ := repos.Keywords.Create(&keywords.Keyword{Value: "women shoes", Groups: []string{"acme"}})
+		k2, _ := repos.Keywords.Create(&keywords.Keyword{Value: "cheap flights"})
+		reebok := &adscraper.Ad{H1: "Women Shoes", H2: "Reebok.com", Desc: "Flash Sale", Path: "www.reebok.com/gr", Position: 1, Block: adscraper.BlockTop}
+		for _, s := range []struct {
+			ad *adscraper.Ad
+			k  *keywords.Keyword
+		}{
+			{reebok, k1},
+			{&adscraper.Ad{H1: "Running Shoes", H2: "Nike", Desc: "Just do it", Path: "nike.com", Position: 3, Block: adscraper.BlockBottom}, k1},
+			{reebok, k2},
+			{&adscraper.Ad{H1: "Cheap Flights", H2: "Skyscanner", Desc: "Compare flights on sale", Path: "www.skyscanner.net/flights", Position: 2, Block: adscraper.BlockTop}, k2},
+		} {
+			if err := repos.Ads.Upsert(s.ad, s.k); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		testCases := []struct {
+			q            adscraper.AdQuery
+			ads          []int64
+			observations []int64
+		}{
+			{adscraper.AdQuery{}, []int64{1, 2, 3}, []int64{1, 2, 3, 4}},
+			{adscraper.AdQuery{Sort: "-id", Limit: 1}, []int64{1, 2, 3}, []int64{1, 2, 3, 4}},
+			{adscraper.AdQuery{KeywordId: k2.ID}, []int64{1, 3}, []int64{3, 4}},
+			{adscraper.AdQuery{Group: "acme"}, []int64{1, 2}, []int64{1, 2}},
+			{adscraper.AdQuery{Domain: "reebok.com"}, []int64{1}, []int64{1, 3}},
+			{adscraper.AdQuery{Text: "sale", Block: adscraper.BlockTop, KeywordId: k2.ID}, []int64{1, 3}, []int64{3, 4}},
+			{adscraper.AdQuery{MinPosition: 2}, []int64{2, 3}, []int64{2, 4}},
+			{adscraper.AdQuery{From: time.Now().UTC().AddDate(0, 0, 1)}, []int64{}, []int64{}},
+		}
+		for i, tc := range testCases {
+			ads := make([]int64, 0)
+			err := repos.Ads.ExportAds(tc.q, func(ad *adscraper.Ad) error {
+				ads = append(ads, ad.ID)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("%v (%v): %v", name, i, err)
+			}
+			observations := make([]int64, 0)
+			err = repos.Ads.ExportObservations(tc.q, func(o *adscraper.Observation) error {
+				observations = append(observations, o.ID)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("%v (%v): %v", name, i, err)
+			}
+			if fmt.Sprint(ads) != fmt.Sprint(tc.ads) || fmt.Sprint(observations) != fmt.Sprint(tc.observations) {
+				t.Errorf("%v (%v) Expected ads %v and observations %v, got %v and %v", name, i, tc.ads, tc.observations, ads, observations)
+			}
+		}
+
+		// Errors stop the export
+		n := 0
+		err := repos.Ads.ExportAds(adscraper.AdQuery{}, func(ad *adscraper.Ad) error {
+			n++
+			return errStop
+		})
+		if err != errStop || n != 1 {
+			t.Errorf("%v Expected the export to stop at the first error, got %v after %v ads", name, err, n)
+		}
+	}
+}
+
+func TestKeywordsStores(t *testing.T) {
+	stores := map[string]keywords.ReaderWriter{
+		"memory": keywords.NewMemoryReaderWriter(),
+		"sqlite": keywords.NewReaderWriter(newSQLiteStore(t)),
+	}
+	paused := true
+
+	for name, ks := range stores {
+		_, err := ks.CreateMany([]*keywords.Keyword{
+			{Value: "book flights", Locale: "en", Priority: 3},
+			{Value: "Flight booking", Priority: 1},
+			{Value: "cheap hotels", Paused: true, Priority: 2},
+		})
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if _, err = ks.CreateMany([]*keywords.Keyword{{Value: "car rental"}, {Value: "cheap hotels"}}); err != keywords.ErrDuplicate {
+			t.Errorf("%v: Expected a duplicate, got %v", name, err)
+		}
+		if k, _ := ks.FindByValue("car rental"); k != nil {
+			t.Errorf("%v: Expected the failed batch to be rolled back, got %+v", name, k)
+		}
+		if _, err = ks.Update(&keywords.Keyword{ID: 2, Value: "book flights"}); err != keywords.ErrDuplicate {
+			t.Errorf("%v: Expected a duplicate, got %v", name, err)
+		}
+		if _, err = ks.Delete(1); err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if _, err = ks.Update(&keywords.Keyword{ID: 1, Value: "book flights"}); err != keywords.ErrNotFound {
+			t.Errorf("%v: Expected deleted keywords not to be updated, got %v", name, err)
+		}
+		if k, err := ks.Create(keywords.New("book flights")); err != nil || k.ID != 1 || k.Priority != 0 {
+			t.Errorf("%v: Expected the deleted keyword to be restored, got %+v (%v)", name, k, err)
+		}
+		ks.UpdateScraped(&keywords.Keyword{ID: 1})
+
+		testCases := []struct {
+			q    keywords.Query
+			want string
+		}{
+			{keywords.Query{}, "Flight booking,cheap hotels,book flights"},
+			{keywords.Query{Sort: "-id"}, "cheap hotels,Flight booking,book flights"},
+			{keywords.Query{Sort: "priority"}, "book flights,Flight booking,cheap hotels"},
+			{keywords.Query{Text: "FLIGHT"}, "Flight booking,book flights"},
+			{keywords.Query{Paused: &paused}, "cheap hotels"},
+			{keywords.Query{Locale: "en"}, ""},
+		}
+		for i, tc := range testCases {
+			got, next, err := ks.List(tc.q)
+			if err != nil {
+				t.Fatalf("%v (%v): %v", name, i, err)
+			}
+			values := make([]string, 0)
+			for _, k := range got {
+				values = append(values, k.Value)
+			}
+			if strings.Join(values, ",") != tc.want || next != "" {
+				t.Errorf("%v (%v) Expected %v, got %v (next %q)", name, i, tc.want, values, next)
+			}
+		}
+
+		for _, sort := range []string{"timesScraped", "-value", "priority", "id"} {
+			q := keywords.Query{Sort: sort, Limit: 1}
+			seen := make(map[int64]bool)
+			for page := 0; page < 3; page++ {
+				got, next, err := ks.List(q)
+				if err != nil {
+					t.Fatalf("%v %v: %v", name, sort, err)
+				}
+				if len(got) != 1 || seen[got[0].ID] || (next == "") != (page == 2) {
+					t.Fatalf("%v %v: Unexpected page %v: %v (next %q)", name, sort, page, got, next)
+				}
+				seen[got[0].ID] = true
+				q.Cursor = next
+			}
+		}
+
+		least, _ := ks.GetLeastScraped(10)
+		if len(least) != 2 || least[1].Value != "book flights" {
+			t.Errorf("%v: Expected paused keywords not to be scraped, got %v", name, least)
+		}
+	}
+}
+
+func TestKeywordLabels(t *testing.T) {
+	stores := map[string]*adscraper.Repositories{
+		"memory": adscraper.NewMemoryRepositories(),
+		"sqlite": adscraper.NewRepositories(newSQLiteStore(t)),
+	}
+
+	for name, repos := range stores {
+		ks := repos.Keywords
+		_, err := ks.CreateMany([]*keywords.Keyword{
+			{Value: "women shoes", Groups: []string{"acme"}, Tags: []string{"shoes", "brand", "shoes"}},
+			{Value: "cheap flights", Groups: []string{"globex"}},
+			{Value: "running shoes"},
+		})
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		for i := 0; i < 2; i++ {
+			if err = ks.AddLabels(3, []string{"acme"}, []string{"shoes"}); err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+		}
+		for _, k := range []*keywords.Keyword{{ID: 1}, {ID: 2}, {ID: 3}} {
+			k, _ = ks.Find(k.ID)
+			if err = repos.Ads.Upsert(&adscraper.Ad{H1: k.Value, Path: "example.com", Position: 1}, k); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		values := func(q keywords.Query) string {
+			got, _, err := ks.List(q)
+			if err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+			values := make([]string, 0)
+			for _, k := range got {
+				values = append(values, k.Value)
+			}
+			return strings.Join(values, ",")
+		}
+		ids := func(q adscraper.AdQuery) string {
+			ads, _, err := repos.Ads.Search(q)
+			if err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+			ids := make([]int64, 0)
+			for _, ad := range ads {
+				ids = append(ids, ad.ID)
+			}
+			return fmt.Sprint(ids)
+		}
+		found, _ := ks.Find(1)
+		// Nil labels are left alone, empty ones cleared
+		updated, _ := ks.Update(&keywords.Keyword{ID: 1, Value: "women shoes", Tags: []string{}})
+		groups, _ := ks.Labels(keywords.Group)
+		tags, _ := ks.Labels(keywords.Tag)
+
+		testCases := []struct {
+			want, got interface{}
+		}{
+			{"[acme] [brand shoes]", fmt.Sprint(found.Groups, found.Tags)},
+			{"[acme] []", fmt.Sprint(updated.Groups, updated.Tags)},
+			{"women shoes,running shoes", values(keywords.Query{Group: "acme", Sort: "id"})},
+			{"running shoes", values(keywords.Query{Tag: "shoes"})},
+			{"", values(keywords.Query{Group: "acme", Tag: "brand"})},
+			{"", values(keywords.Query{Group: "initech"})},
+			{"[{group acme 2} {group globex 1}]", fmt.Sprint(groups)},
+			{"[{tag brand 0} {tag shoes 1}]", fmt.Sprint(tags)},
+			{"[1 3]", ids(adscraper.AdQuery{Group: "acme", Sort: "id"})},
+			{"[2]", ids(adscraper.AdQuery{Group: "globex"})},
+			{"[3]", ids(adscraper.AdQuery{Tag: "shoes"})},
+			{"[]", ids(adscraper.AdQuery{Group: "globex", Tag: "shoes"})},
+		}
+		for i, tc := range testCases {
+			if tc.want != tc.got {
+				t.Errorf("%v (%v) Expected %v, got %v", name, i, tc.want, tc.got)
+			}
+		}
+	}
+}
+
+func TestKeywordsSchedule(t *testing.T) {
+	stores := map[string]keywords.ReaderWriter{
+		"memory": keywords.NewMemoryReaderWriter(),
+		"sqlite": keywords.NewReaderWriter(newSQLiteStore(t)),
+	}
+	later := time.Now().UTC().Add(2 * time.Hour)
+	window := func(from, to time.Duration) (string, string) {
+		return later.Add(from).Format("15:04"), later.Add(to).Format("15:04")
+	}
+	openStart, openEnd := window(-30*time.Minute, 30*time.Minute)
+	closedStart, closedEnd := window(time.Hour, 2*time.Hour)
+
+	for name, ks := range stores {
+		_, err := ks.CreateMany([]*keywords.Keyword{
+			{Value: "hourly", Cadence: "hourly"},
+			{Value: "daily", Cadence: "daily", Priority: 100},
+			{Value: "new"},
+			{Value: "important", Priority: 50},
+			{Value: "paused", Paused: true},
+			{Value: "open window", Cadence: "6h", WindowStart: openStart, WindowEnd: openEnd},
+			{Value: "closed window", WindowStart: closedStart, WindowEnd: closedEnd},
+		})
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		for _, id := range []int64{1, 2} {
+			if _, err = ks.UpdateScraped(&keywords.Keyword{ID: id}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		testCases := []struct {
+			q    keywords.Query
+			now  time.Time
+			want string
+		}{
+			{keywords.Query{}, later, "important,hourly,new,open window"},
+			{keywords.Query{Limit: 2}, later, "important,hourly"},
+			{keywords.Query{Text: "hour"}, later, "hourly"},
+			{keywords.Query{Text: "hour"}, later.Add(-90 * time.Minute), ""},
+			{keywords.Query{Text: "daily"}, later.Add(24 * time.Hour), "daily"},
+		}
+		for i, tc := range testCases {
+			got, err := ks.GetDue(tc.q, tc.now)
+			if err != nil {
+				t.Fatalf("%v (%v): %v", name, i, err)
+			}
+			values := make([]string, 0)
+			for _, k := range got {
+				values = append(values, k.Value)
+			}
+			if strings.Join(values, ",") != tc.want {
+				t.Errorf("%v (%v) Expected %v, got %v", name, i, tc.want, values)
+			}
+		}
+	}
+}
+
+func TestKeywordsImport(t *testing.T) {
+	files := []struct {
+		format  string
+		invalid int
+		body    string
+	}{
+		{keywords.Lines, 6, "flight+booking\n\n  Book  flights \n# hotels\nflight booking\n" + strings.Repeat("a", 300) + "\n"},
+		{keywords.CSV, 5, "keyword,locale,device,groups,tags,priority,cadence\nflight  booking,en,mobile,,\"travel,deals\",5,hourly\nbook flights,,,,,,\nflight booking,,,,,,\n" + strings.Repeat("a", 300) + ",,,,,,\n"},
+		{keywords.TSV, 5, "value\tlocale\tdevice\tgroup\ttags\tpriority\tcadence\nflight booking\ten\tmobile\t\ttravel;deals\t5\thourly\nbook flights\t\t\t\t\t\t\nflight booking\t\t\t\t\t\t\nhotels\t\t\t\t\tlots\t\n"},
+		{keywords.JSONL, 4, `{"value":"flight booking","locale":"en","device":"mobile","tags":["travel","deals"],"priority":5,"cadence":"hourly"}
+{"value":"book flights"}
+{"value":"Flight Booking"}
+{"value":"hotels","rooms":2}
+`},
+	}
+
+	for _, file := range files {
+		ks := keywords.NewReaderWriter(newSQLiteStore(t))
+		ks.CreateMany([]*keywords.Keyword{
+			{Value: "book flights", Priority: 3},
+			{Value: "car rental", Groups: []string{"acme"}},
+			{Value: "cheap hotels", Groups: []string{"globex"}},
+		})
+
+		records, err := keywords.Read(strings.NewReader(file.body), file.format)
+		if err != nil {
+			t.Fatalf("%v: %v", file.format, err)
+		}
+		o := keywords.ImportOptions{Group: "acme", Tags: []string{"imported"}, Sync: true}
+		diff, err := keywords.Plan(ks, records, o)
+		if err != nil {
+			t.Fatalf("%v: %v", file.format, err)
+		}
+		testCases := []struct {
+			want, got interface{}
+		}{
+			{"1 new, 1 existing, 1 invalid, 1 duplicates, 1 paused", diff.String()},
+			{file.invalid, diff.Invalid[0].Line},
+		}
+		if err = diff.Apply(ks); err != nil {
+			t.Fatalf("%v: %v", file.format, err)
+		}
+		booking, _ := ks.FindByValue("flight booking")
+		flights, _ := ks.FindByValue("book flights")
+		rental, _ := ks.FindByValue("car rental")
+		hotels, _ := ks.FindByValue("cheap hotels")
+		testCases = append(testCases, []struct{ want, got interface{} }{
+			{"[acme] [imported]", fmt.Sprint(flights.Groups, flights.Tags)},
+			{3, flights.Priority},
+			{true, rental.Paused},
+			{false, hotels.Paused},
+		}...)
+		if file.format != keywords.Lines {
+			testCases = append(testCases, []struct{ want, got interface{} }{
+				{"en mobile 5 hourly", fmt.Sprint(booking.Locale, " ", booking.Device, " ", booking.Priority, " ", booking.Cadence)},
+				{"[acme] [deals imported travel]", fmt.Sprint(booking.Groups, booking.Tags)},
+			}...)
+		}
+		for i, tc := range testCases {
+			if fmt.Sprint(tc.want) != fmt.Sprint(tc.got) {
+				t.Errorf("%v (%v) Expected %v, got %v", file.format, i, tc.want, tc.got)
+			}
+		}
+	}
+
+	// Nothing is imported when a keyword can't be written
+	ks := keywords.NewMemoryReaderWriter()
+	ks.CreateMany([]*keywords.Keyword{{Value: "book flights"}, {Value: "cheap hotels"}})
+	diff := &keywords.Diff{
+		New:      []*keywords.Keyword{{Value: "car rental"}},
+		Existing: []*keywords.Keyword{{ID: 1, Value: "cheap hotels"}},
+	}
+	if err := diff.Apply(ks); err != keywords.ErrDuplicate {
+		t.Errorf("Expected a duplicate, got %v", err)
+	}
+	if k, _ := ks.FindByValue("car rental"); k != nil {
+		t.Errorf("Expected the import to be rolled back, got %+v", k)
+	}
+	if _, err := keywords.Read(strings.NewReader("keyword,volume\n"), keywords.CSV); err == nil {
+		t.Error("Expected unknown columns to be an error")
+	}
+
+	// Only keywords.dat lines are escaped like in search URLs
+	for _, file := range []struct {
+		format string
+		body   string
+		want   string
+	}{
+		{keywords.Lines, "c%2B%2B+tutorial\n50%25+off\n", "[c++ tutorial 50% off]"},
+		{keywords.CSV, "keyword\nc++ tutorial\n50%25 off\n", "[c++ tutorial 50%25 off]"},
+		{keywords.JSONL, `{"value":"c++ tutorial"}` + "\n", "[c++ tutorial]"},
+	} {
+		records, err := keywords.Read(strings.NewReader(file.body), file.format)
+		if err != nil {
+			t.Fatalf("%v: %v", file.format, err)
+		}
+		values := make([]string, 0)
+		for _, rec := range records {
+			values = append(values, rec.Keyword.Value)
+		}
+		if got := fmt.Sprint(values); got != file.want {
+			t.Errorf("%v Expected %v, got %v", file.format, file.want, got)
+		}
+	}
+}
+
+func TestKeywordExpansion(t *testing.T) {
+	testCases := []struct {
+		e    keywords.Expansion
+		want string
+	}{
+		{keywords.Expansion{Seeds: []string{"book flight", "Book  Flight"}}, "book flight"},
+		{keywords.Expansion{Seeds: []string{"book flight", "hotel deal", "cheap stay"}, Plurals: true}, "book flight,book flights,hotel deal,hotel deals,cheap stay,cheap stays"},
+		{keywords.Expansion{Seeds: []string{"city bus", "lunch box", "berry"}, Plurals: true}, "city bus,city buses,lunch box,lunch boxes,berry,berries"},
+		{keywords.Expansion{Seeds: []string{"book flights", "boarding pass", "cheap hotels"}, Plurals: true}, "book flights,boarding pass,boarding passes,cheap hotels"},
+		{keywords.Expansion{Seeds: []string{"flight booking"}, Reorder: true}, "flight booking,booking flight"},
+		{keywords.Expansion{Seeds: []string{"book flight"}, Plurals: true, Reorder: true}, "book flight,book flights,flight book,flights book"},
+		{
+			keywords.Expansion{Seeds: []string{"flights"}, Lists: map[string][]string{"modifier": {"cheap", "online"}}},
+			"flights,cheap flights,online flights,flights cheap,flights online",
+		},
+		{
+			keywords.Expansion{
+				Seeds:     []string{"flights", "hotels"},
+				Lists:     map[string][]string{"modifier": {"cheap"}, "city": {"athens", "rome"}},
+				Templates: []string{"{modifier} {seed} in {city}", "{seed} {city}"},
+			},
+			"cheap flights in athens,cheap flights in rome,cheap hotels in athens,cheap hotels in rome,flights athens,flights rome,hotels athens,hotels rome",
+		},
+	}
+	for i, tc := range testCases {
+		if err := tc.e.Validate(); err != nil {
+			t.Fatalf("(%v) %v", i, err)
+		}
+		if got := strings.Join(tc.e.Keywords(), ","); got != tc.want {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, got)
+		}
+	}
+
+	e := keywords.Expansion{Seeds: []string{"flights"}, Templates: []string{"{seed} to {city}"}}
+	if err := e.Validate(); err == nil {
+		t.Error("Expected templates with unknown lists to be invalid")
+	}
+}
+
+func TestAPIKeysStores(t *testing.T) {
+	stores := map[string]apikeys.ReaderWriter{
+		"sqlite": apikeys.NewReaderWriter(newSQLiteStore(t)),
+		"memory": apikeys.NewMemoryReaderWriter(),
+	}
+	for name, keys := range stores {
+		if _, _, err := keys.Create("worker", "superuser"); err != apikeys.ErrInvalidScope {
+			t.Errorf("%v: expected an invalid scope, got %v", name, err)
+		}
+		k, token, err := keys.Create(" worker-1 ", apikeys.Scraper)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found, err := keys.Authenticate(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, _ := keys.Authenticate(token)
+		wrong, _ := keys.Authenticate(token + "x")
+		revoked, err := keys.Revoke(k.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		afterRevoke, _ := keys.Authenticate(token)
+		_, notFound := keys.Revoke(42)
+		ks, _ := keys.List()
+
+		testCases := []struct {
+			want interface{}
+			got  interface{}
+		}{
+			{"worker-1", k.Name},
+			{true, strings.HasPrefix(token, k.Prefix)},
+			{k.ID, found.ID},
+			{true, found.LastUsedAt != ""},
+			{found.LastUsedAt, again.LastUsedAt},
+			{(*apikeys.Key)(nil), wrong},
+			{true, revoked.RevokedAt != ""},
+			{(*apikeys.Key)(nil), afterRevoke},
+			{apikeys.ErrNotFound, notFound},
+			{1, len(ks)},
+			{false, strings.Contains(fmt.Sprintf("%+v", ks), token)},
+		}
+		for i, tc := range testCases {
+			if tc.got != tc.want {
+				t.Errorf("%v (%v) Expected %v, got %v", name, i, tc.want, tc.got)
+			}
+		}
+	}
+}
+
+func TestServerReadiness(t *testing.T) {
+	store := newSQLiteStore(t)
+	repos := adscraper.NewRepositories(store)
+	server := adscraper.NewServer(repos).CheckReadiness(adscraper.Readiness{Store: store, MaxIngestionAge: time.Hour})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, strings.TrimSpace(string(body))
+	}
+
+	healthCode, health := get("/healthz")
+	noAdsCode, noAds := get("/readyz")
+	k, _ := repos.Keywords.Upsert(keywords.New("reebok women shoes"))
+	if err := repos.Ads.Upsert(&adscraper.Ad{H1: "Women Shoes", Path: "www.reebok.com", Position: 1}, k); err != nil {
+		t.Fatal(err)
+	}
+	readyCode, ready := get("/readyz")
+	store.Exec(`DELETE FROM schema_migrations WHERE version = '20261019160000'`)
+	pendingCode, pending := get("/readyz")
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{http.StatusOK, healthCode},
+		{`{"status":"ok"}`, health},
+		{http.StatusServiceUnavailable, noAdsCode},
+		{`{"status":"unavailable","checks":{"database":"ok","ingestion":"no ads observed in the last 1h0m0s","migrations":"ok"}}`, noAds},
+		{http.StatusOK, readyCode},
+		{`{"status":"ok","checks":{"database":"ok","ingestion":"ok","migrations":"ok"}}`, ready},
+		{http.StatusServiceUnavailable, pendingCode},
+		{true, strings.Contains(pending, `"migrations":"pending 20261019160000"`)},
+	}
+	for i, tc := range testCases {
+		if tc.got != tc.want {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
+
+func TestWebhookEvents(t *testing.T) {
+	stores := map[string]*adscraper.Repositories{
+		"memory": adscraper.NewMemoryRepositories(),
+		"sqlite": adscraper.NewRepositories(newSQLiteStore(t)),
+	}
+	const secret = "whsec_0123456789abcdef"
+
+	for name, repos := range stores {
+		var mu sync.Mutex
+		received := make(map[string][]string)
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			body, _ := io.ReadAll(r.Body)
+			if err := webhooks.Verify(secret, r.Header.Get(webhooks.SignatureHeader), body, time.Minute); err != nil {
+				t.Errorf("%v %v", name, err)
+			}
+			e := struct {
+				webhooks.Event
+				Ad struct {
+					ID int64 `json:"id"`
+				} `json:"ad"`
+			}{}
+			json.Unmarshal(body, &e)
+			received[r.URL.Path] = append(received[r.URL.Path], fmt.Sprintf("%v %v %v %v", e.Type, e.Keyword.Value, e.Advertiser, e.Ad.ID))
+		}))
+		defer receiver.Close()
+
+		repos.Webhooks.Create(&webhooks.Webhook{URL: receiver.URL + "/all", Secret: secret})
+		repos.Webhooks.Create(&webhooks.Webhook{URL: receiver.URL + "/acme", Secret: secret, Group: "acme", Events: []string{webhooks.NewAdvertiser}})
+		k1, _ := repos.Keywords.Create(&keywords.Keyword{Value: "women shoes", Groups: []string{"acme"}})
+		k2, _ := repos.Keywords.Create(&keywords.Keyword{Value: "cheap flights"})
+
+		ts := httptest.NewServer(adscraper.NewServer(repos).Handler())
+		defer ts.Close()
+		client := adscraper.NewClient(ts.URL)
+		reebok := &adscraper.Ad{H1: "Women Shoes", H2: "Reebok.com", Desc: "Flash Sale", Path: "www.reebok.com/gr", Position: 1}
+		nike := &adscraper.Ad{H1: "Running Shoes", H2: "Nike", Desc: "Just do it", Path: "nike.com", Position: 2}
+		// Nike disappears from the second scrape of the first keyword, Reebok
+		// shows up for the second. The third scrape found no ads, like when
+		// it's shown a CAPTCHA, so Reebok doesn't disappear.
+		for _, scrape := range []struct {
+			k   *keywords.Keyword
+			ads []*adscraper.Ad
+		}{
+			{k1, []*adscraper.Ad{reebok, nike}},
+			{k1, []*adscraper.Ad{reebok}},
+			{k1, []*adscraper.Ad{}},
+			{k2, []*adscraper.Ad{reebok}},
+		} {
+			for _, ad := range scrape.ads {
+				if err := client.PostAdKeywords(ad, scrape.k); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := client.PatchKeyword(scrape.k.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		r, err := webhooks.NewDispatcher(repos.Webhooks).Run(time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		testCases := []struct {
+			want interface{}
+			got  interface{}
+		}{
+			{"delivered 8 events, 0 to retry, 0 failed", r.String()},
+			{[]string{
+				"ad.new women shoes reebok.com 1",
+				"advertiser.new women shoes reebok.com 1",
+				"ad.new women shoes nike.com 2",
+				"advertiser.new women shoes nike.com 2",
+				"ad.disappeared women shoes nike.com 2",
+				"advertiser.new cheap flights reebok.com 1",
+			}, received["/all"]},
+			{[]string{
+				"advertiser.new women shoes reebok.com 1",
+				"advertiser.new women shoes nike.com 2",
+			}, received["/acme"]},
+		}
+		for i, tc := range testCases {
+			if fmt.Sprint(tc.want) != fmt.Sprint(tc.got) {
+				t.Errorf("%v (%v) Expected %v, got %v", name, i, tc.want, tc.got)
+			}
+		}
+	}
+}
+
+func TestBrandBiddingSQLite(t *testing.T) {
+	store := newSQLiteStore(t)
+	repos := adscraper.NewRepositories(store)
+	k, _ := repos.Keywords.Create(&keywords.Keyword{Value: "reebok women shoes"})
+	incidents := func() int {
+		return count(t, store, `SELECT COUNT(*) FROM brand_incidents`)
+	}
+
+	// The ads store caches the brands, writes through the repositories
+	// update them
+	nike := &adscraper.Ad{H1: "Women Shoes", Desc: "Just do it", Path: "nike.com", Position: 1}
+	if err := repos.Ads.Upsert(nike, k); err != nil {
+		t.Fatal(err)
+	}
+	before := incidents()
+	reebok := &brands.Brand{Advertiser: "reebok.com", Terms: []string{"reebok"}}
+	if err := repos.Brands.Create(reebok); err != nil {
+		t.Fatal(err)
+	}
+	repos.Ads.Upsert(nike, k)
+	created := incidents()
+	reebok.Terms = []string{"rbk"}
+	if err := repos.Brands.Update(reebok); err != nil {
+		t.Fatal(err)
+	}
+	repos.Ads.Upsert(&adscraper.Ad{H1: "Women Shoes", Desc: "Impossible is nothing", Path: "adidas.com", Position: 2}, k)
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{0, before},
+		{1, created},
+		{1, incidents()},
+	}
+	for i, tc := range testCases {
+		if tc.want != tc.got {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
+
+func TestCompliance(t *testing.T) {
+	stores := map[string]*adscraper.Repositories{
+		"memory": adscraper.NewMemoryRepositories(),
+		"sqlite": adscraper.NewRepositories(newSQLiteStore(t)),
+	}
+	guaranteed, _ := compliance.ParseRules(strings.NewReader(`
+rules:
+  - name: guaranteed
+    severity: high
+    terms: [guaranteed]
+  - name: reebok-trademark
+    fields: [h1, h2, desc]
+    terms: [reebok]
+    allow: [reebok.com]
+`))
+	prices, _ := compliance.ParseRules(strings.NewReader(`
+rules:
+  - name: guaranteed
+    severity: high
+    terms: [guaranteed]
+  - name: unapproved-price
+    pattern: '\$\d+'
+`))
+
+	for name, repos := range stores {
+		if err := repos.Compliance.SetRules(guaranteed); err != nil {
+			t.Fatal(err)
+		}
+		k, _ := repos.Keywords.Create(&keywords.Keyword{Value: "women shoes"})
+		ts := httptest.NewServer(adscraper.NewServer(repos).Handler())
+		defer ts.Close()
+		client := adscraper.NewClient(ts.URL)
+		reebok := &adscraper.Ad{H1: "Reebok Women Shoes", H2: "Reebok.com", Desc: "Guaranteed Comfort", Path: "www.reebok.com", Position: 1}
+		reebok.SetRest(`<ul><li>From $49</li></ul>`)
+		nike := &adscraper.Ad{H1: "Better Than Reebok", H2: "Nike", Desc: "Just do it", Path: "nike.com", Position: 2}
+		for _, ad := range []*adscraper.Ad{reebok, nike, reebok} {
+			if err := client.PostAdKeywords(ad, k); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		format := func(path string) string {
+			resp, err := http.Get(ts.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			vs := make([]struct {
+				Rule       string `json:"rule"`
+				Advertiser string `json:"advertiser"`
+				Field      string `json:"field"`
+				Match      string `json:"match"`
+				Status     string `json:"status"`
+			}, 0)
+			json.NewDecoder(resp.Body).Decode(&vs)
+			lines := make([]string, 0)
+			for _, v := range vs {
+				lines = append(lines, fmt.Sprintf("%v %v %v %v %v", v.Rule, v.Advertiser, v.Field, v.Match, v.Status))
+			}
+			return strings.Join(lines, ",")
+		}
+		ingested := format("/v1/violations")
+		req, _ := http.NewRequest("PATCH", ts.URL+"/v1/violations/1", strings.NewReader(`{"status":"dismissed","note":"Approved claim"}`))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		// The new rule is checked against the ads ingested before
+		if err = repos.Compliance.SetRules(prices); err != nil {
+			t.Fatal(err)
+		}
+		r, err := adscraper.CheckCompliance(repos, adscraper.AdQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and the ads ingested after
+		adidas := &adscraper.Ad{H1: "Women Shoes", H2: "Adidas", Desc: "Now $30", Path: "adidas.com", Position: 3}
+		if err = client.PostAdKeywords(adidas, k); err != nil {
+			t.Fatal(err)
+		}
+
+		testCases := []struct {
+			want interface{}
+			got  interface{}
+		}{
+			{"reebok-trademark nike.com h1 Reebok open,guaranteed reebok.com desc Guaranteed open", ingested},
+			{http.StatusOK, resp.StatusCode},
+			{"2 ads, 2 violations, 1 new", r.String()},
+			{"unapproved-price adidas.com desc $30 open,unapproved-price reebok.com rest $49 open,guaranteed reebok.com desc Guaranteed dismissed", format("/v1/violations")},
+			{"guaranteed reebok.com desc Guaranteed dismissed", format("/v1/violations?status=dismissed&severity=high")},
+			{"", format("/v1/violations?advertiser=nike.com")},
+			{"unapproved-price adidas.com desc $30 open", format("/v1/violations?advertiser=adidas.com")},
+		}
+		for i, tc := range testCases {
+			if tc.want != tc.got {
+				t.Errorf("%v (%v) Expected %v, got %v", name, i, tc.want, tc.got)
+			}
+		}
+	}
+}
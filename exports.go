@@ -0,0 +1,219 @@
+package adscraper
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gkats/adscraper/keywords"
+)
+
+// Export formats
+const (
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+)
+
+// exportWriteTimeout is how long each chunk of an export has to be sent. The
+// server's write timeout is for the whole response, it would cut long exports
+// off.
+const exportWriteTimeout = 30 * time.Second
+
+var exportContentTypes = map[string]string{
+	ExportCSV:    "text/csv; charset=utf-8",
+	ExportNDJSON: "application/x-ndjson",
+}
+
+// exportFormat reads the format from the format parameter, or else the Accept
+// header. It's CSV by default.
+func exportFormat(r *http.Request) (string, bool) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		_, ok := exportContentTypes[f]
+		return f, ok
+	}
+	if strings.Contains(r.Header.Get("Accept"), exportContentTypes[ExportNDJSON]) {
+		return ExportNDJSON, true
+	}
+	return ExportCSV, true
+}
+
+// exportWriter writes rows as CSV records under a header, or as NDJSON
+// lines. Nothing is written before the first row, so errors up to then still
+// get an error response.
+type exportWriter struct {
+	w       io.Writer
+	format  string
+	header  []string
+	csv     *csv.Writer
+	written bool
+}
+
+func newExportWriter(w io.Writer, format string, header []string) *exportWriter {
+	return &exportWriter{w: w, format: format, header: header, csv: csv.NewWriter(w)}
+}
+
+// deadlineWriter gives each write to the response exportWriteTimeout to be
+// sent.
+type deadlineWriter struct {
+	http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (w *deadlineWriter) Write(b []byte) (int, error) {
+	w.rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *deadlineWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// write writes a row, as record in CSV and v in NDJSON.
+func (e *exportWriter) write(record []string, v interface{}) error {
+	if e.format == ExportNDJSON {
+		e.written = true
+		return json.NewEncoder(e.w).Encode(v)
+	}
+	if !e.written {
+		e.written = true
+		if err := e.csv.Write(e.header); err != nil {
+			return err
+		}
+	}
+	return e.csv.Write(record)
+}
+
+// flush writes what's buffered, and the CSV header of empty exports.
+func (e *exportWriter) flush() error {
+	if !e.written && e.format == ExportCSV {
+		e.written = true
+		e.csv.Write(e.header)
+	}
+	e.csv.Flush()
+	return e.csv.Error()
+}
+
+var adExportHeader = []string{"id", "h1", "h2", "desc", "path", "createdAt", "updatedAt"}
+
+func writeAd(e *exportWriter, ad *Ad) error {
+	record := []string{strconv.FormatInt(ad.ID, 10), ad.H1, ad.H2, ad.Desc, ad.Path, ad.CreatedAt, ad.UpdatedAt}
+	return e.write(record, newStoredAdJSON(ad))
+}
+
+type observationExportJSON struct {
+	ID         int64  `json:"id"`
+	AdId       int64  `json:"adId"`
+	KeywordId  int64  `json:"keywordId"`
+	PageId     int64  `json:"pageId,omitempty"`
+	Position   int    `json:"position"`
+	Block      string `json:"block"`
+	ObservedAt string `json:"observedAt"`
+}
+
+var observationExportHeader = []string{"id", "adId", "keywordId", "pageId", "position", "block", "observedAt"}
+
+func writeObservation(e *exportWriter, o *Observation) error {
+	pageId := ""
+	if o.PageId > 0 {
+		pageId = strconv.FormatInt(o.PageId, 10)
+	}
+	record := []string{
+		strconv.FormatInt(o.ID, 10), strconv.FormatInt(o.AdId, 10), strconv.FormatInt(o.KeywordId, 10),
+		pageId, strconv.Itoa(o.Position), o.Block, o.ObservedAt,
+	}
+	return e.write(record, &observationExportJSON{
+		ID:         o.ID,
+		AdId:       o.AdId,
+		KeywordId:  o.KeywordId,
+		PageId:     o.PageId,
+		Position:   o.Position,
+		Block:      o.Block,
+		ObservedAt: o.ObservedAt,
+	})
+}
+
+type exportHandler struct {
+	keywordsReader keywords.Reader
+	name           string
+	header         []string
+	export         func(q AdQuery, e *exportWriter) error
+}
+
+// ServeHTTP streams every row matching the ads filters, without paging. Once
+// rows are sent errors can't change the status, so the connection is
+// dropped instead, and clients see the export end early.
+func (h *exportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	format, ok := exportFormat(r)
+	if !ok {
+		writeResponse(w, badRequest())
+		return
+	}
+	params := r.URL.Query()
+	q, err := newAdQuery(params)
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+
+	matches := true
+	if v := params.Get("keyword"); v != "" {
+		k, err := h.keywordsReader.FindByValue(v)
+		if err != nil {
+			writeResponse(w, internalServerError(err))
+			return
+		}
+		matches = k != nil
+		if matches {
+			q.KeywordId = k.ID
+		}
+	}
+
+	e := newExportWriter(&deadlineWriter{ResponseWriter: w, rc: http.NewResponseController(w)}, format, h.header)
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", `attachment; filename="`+h.name+"."+format+`"`)
+	if matches {
+		err = h.export(q, e)
+	}
+	if err == nil {
+		err = e.flush()
+	}
+	if err != nil && !e.written {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Del("Content-Disposition")
+		writeResponse(w, internalServerError(err))
+	} else if err != nil {
+		log.Printf("Request %v failed: %v", w.Header().Get(requestIDHeader), err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func exportAds(r *Repositories) http.Handler {
+	return &exportHandler{
+		keywordsReader: r.Keywords,
+		name:           "ads",
+		header:         adExportHeader,
+		export: func(q AdQuery, e *exportWriter) error {
+			return r.Ads.ExportAds(q, func(ad *Ad) error {
+				return writeAd(e, ad)
+			})
+		},
+	}
+}
+
+func exportObservations(r *Repositories) http.Handler {
+	return &exportHandler{
+		keywordsReader: r.Keywords,
+		name:           "observations",
+		header:         observationExportHeader,
+		export: func(q AdQuery, e *exportWriter) error {
+			return r.Ads.ExportObservations(q, func(o *Observation) error {
+				return writeObservation(e, o)
+			})
+		},
+	}
+}
@@ -0,0 +1,100 @@
+package adscraper
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gkats/adscraper/db"
+)
+
+// Readiness is what /readyz checks.
+type Readiness struct {
+	// Store is pinged and checked for pending migrations, unless it's nil.
+	Store Store
+	// MaxIngestionAge is how long the server stays ready without observing
+	// an ad. Zero means forever.
+	MaxIngestionAge time.Duration
+}
+
+// CheckReadiness sets what /readyz checks. Without it the server is ready as
+// soon as it's up.
+func (s *server) CheckReadiness(r Readiness) *server {
+	s.readiness = r
+	return s
+}
+
+type healthJSON struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+func unavailable(body interface{}) response {
+	return &successResponse{status: http.StatusServiceUnavailable, body: body}
+}
+
+// healthz reports that the process is up and serving requests.
+func healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, ok(&healthJSON{Status: "ok"}))
+	}
+}
+
+type readyzHandler struct {
+	readiness Readiness
+	ads       ObservationReader
+}
+
+// ServeHTTP reports whether the database is reachable and migrated, and ads
+// are still coming in. Every check is reported, failures with their error.
+func (h *readyzHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	checks := h.readiness.check(r.Context(), h.ads)
+	for _, result := range checks {
+		if result != "ok" {
+			writeResponse(w, unavailable(&healthJSON{Status: "unavailable", Checks: checks}))
+			return
+		}
+	}
+	writeResponse(w, ok(&healthJSON{Status: "ok", Checks: checks}))
+}
+
+// check runs the checks that are set, returning "ok" or what failed by the
+// name of each.
+func (rd *Readiness) check(ctx context.Context, ads ObservationReader) map[string]string {
+	checks := make(map[string]string)
+	if st := rd.Store; st != nil {
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		checks["database"] = check(st.PingContext(ctx))
+
+		pending, err := db.Pending(st)
+		if err == nil && len(pending) > 0 {
+			checks["migrations"] = "pending " + strings.Join(pending, ", ")
+		} else {
+			checks["migrations"] = check(err)
+		}
+	}
+	if maxAge := rd.MaxIngestionAge; maxAge > 0 {
+		last, err := ads.LastObservedAt()
+		if err != nil {
+			checks["ingestion"] = check(err)
+		} else if t, err := time.Parse(time.RFC3339Nano, last); err != nil || time.Since(t) > maxAge {
+			checks["ingestion"] = "no ads observed in the last " + maxAge.String()
+		} else {
+			checks["ingestion"] = "ok"
+		}
+	}
+	return checks
+}
+
+func check(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
+
+func (s *server) readyz() http.Handler {
+	return &readyzHandler{readiness: s.readiness, ads: s.repos.Ads}
+}
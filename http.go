@@ -2,14 +2,25 @@ package adscraper
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/gkats/adscraper/apikeys"
 	"github.com/gkats/adscraper/keywords"
 	"github.com/gkats/httplog"
 	"github.com/gorilla/mux"
@@ -17,7 +28,13 @@ import (
 
 type Client struct {
 	baseURL string
+	apiKey  string
 	*http.Client
+
+	mu sync.Mutex
+	// version is the API version requests go to, once negotiated
+	version    string
+	negotiated bool
 }
 
 func NewClient(host string) *Client {
@@ -27,34 +44,158 @@ func NewClient(host string) *Client {
 	}
 }
 
+// WithAPIKey sets the key sent with every request, for servers that require
+// one.
+func (c *Client) WithAPIKey(key string) *Client {
+	c.apiKey = key
+	return c
+}
+
+// Version returns the latest API version both the client and the server
+// support, asking the server the first time. It's empty for servers from
+// before versioning, which only have the unprefixed routes.
+func (c *Client) Version() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.negotiated {
+		return c.version, nil
+	}
+
+	req, err := c.request("GET", "/versions", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		c.negotiated = true
+		return c.version, nil
+	} else if resp.StatusCode > 399 {
+		return "", fmt.Errorf("Got error response (%v)", resp.StatusCode)
+	}
+
+	supported := &versionsJSON{}
+	if err = json.NewDecoder(resp.Body).Decode(supported); err != nil {
+		return "", err
+	}
+	for i := len(Versions) - 1; i >= 0 && c.version == ""; i-- {
+		for _, v := range supported.Versions {
+			if v == Versions[i] {
+				c.version = v
+			}
+		}
+	}
+	c.negotiated = true
+	return c.version, nil
+}
+
+// newRequest makes a request to the path in the negotiated API version.
+func (c *Client) newRequest(method string, path string, body io.Reader) (*http.Request, error) {
+	v, err := c.Version()
+	if err != nil {
+		return nil, err
+	}
+	if v != "" {
+		path = "/" + v + path
+	}
+	return c.request(method, path, body)
+}
+
+func (c *Client) request(method string, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	return req, nil
+}
+
 func (c *Client) PostAdKeywords(ad *Ad, k *keywords.Keyword) error {
-	body, err := json.Marshal(newAdWithKeywordJSON(ad, k))
+	v, err := c.Version()
+	if err != nil {
+		return err
+	}
+	var params interface{} = newAdWithKeywordJSON(ad, k)
+	if v == V2 {
+		params = newAdWithKeywordV2JSON(ad, k)
+	}
+	body, err := json.Marshal(params)
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", c.baseURL+"/ad_keywords", bytes.NewBuffer(body))
+	req, err := c.newRequest("POST", "/ad_keywords", bytes.NewBuffer(body))
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	if resp, err := c.Do(req); err != nil {
+	resp, err := c.Do(req)
+	if err != nil {
 		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 399 {
+		return fmt.Errorf("Got error response (%v)", resp.StatusCode)
+	}
+	return nil
+}
+
+// PostPage sends a fetched page to be archived and sets its ID. It returns
+// ErrArchiveDisabled when the server doesn't archive pages.
+func (c *Client) PostPage(p *Page) error {
+	body, err := json.Marshal(newPageJSON(p, true))
+	if err != nil {
+		return err
+	}
+
+	req, err := c.newRequest("POST", "/pages", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotImplemented {
+		return ErrArchiveDisabled
 	} else if resp.StatusCode > 399 {
 		return fmt.Errorf("Got error response (%v)", resp.StatusCode)
 	}
+
+	created := &pageJSON{}
+	if err = json.NewDecoder(resp.Body).Decode(created); err != nil {
+		return err
+	}
+	p.ID, p.Hash, p.FetchedAt = created.ID, created.Hash, created.FetchedAt
 	return nil
 }
 
+// GetKeywords returns the keywords that are due to be scraped.
 func (c *Client) GetKeywords() ([]*keywords.Keyword, error) {
+	return c.getKeywords(url.Values{"due": {"true"}})
+}
+
+// GetGroupKeywords returns the keywords due to be scraped in the group, so
+// each group can be scraped on its own schedule.
+func (c *Client) GetGroupKeywords(group string) ([]*keywords.Keyword, error) {
+	return c.getKeywords(url.Values{"due": {"true"}, "group": {group}})
+}
+
+func (c *Client) getKeywords(params url.Values) ([]*keywords.Keyword, error) {
 	var kws []*keywords.Keyword
 
-	req, err := http.NewRequest("GET", c.baseURL+"/keywords", nil)
+	req, err := c.newRequest("GET", "/keywords?"+params.Encode(), nil)
 	if err != nil {
 		return kws, err
 	}
-	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := c.Do(req)
 	if err != nil {
@@ -74,11 +215,10 @@ func (c *Client) GetKeywords() ([]*keywords.Keyword, error) {
 }
 
 func (c *Client) PatchKeyword(id int64) error {
-	req, err := http.NewRequest("PATCH", c.baseURL+"/keywords/"+strconv.FormatInt(id, 10), nil)
+	req, err := c.newRequest("PATCH", "/keywords/"+strconv.FormatInt(id, 10), nil)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := c.Do(req)
 	if err != nil {
@@ -98,13 +238,77 @@ type adJSON struct {
 	Raw      string `json:"raw"`
 	Rest     string `json:"rest"`
 	Position int    `json:"position"`
+	Block    string `json:"block,omitempty"`
+	PageId   int64  `json:"pageId,omitempty"`
 }
 
 type keywordJSON struct {
-	ID            int64  `json:"id"`
-	Value         string `json:"value"`
-	TimesScraped  int    `json:"timesScraped"`
-	LastScrapedAt string `json:"lastScrapedAt"`
+	ID            int64    `json:"id"`
+	Value         string   `json:"value"`
+	Locale        string   `json:"locale"`
+	Device        string   `json:"device"`
+	Priority      int      `json:"priority"`
+	Paused        bool     `json:"paused"`
+	Cadence       string   `json:"cadence"`
+	WindowStart   string   `json:"windowStart"`
+	WindowEnd     string   `json:"windowEnd"`
+	TimesScraped  int      `json:"timesScraped"`
+	LastScrapedAt string   `json:"lastScrapedAt"`
+	CreatedAt     string   `json:"createdAt,omitempty"`
+	UpdatedAt     string   `json:"updatedAt,omitempty"`
+	DeletedAt     string   `json:"deletedAt,omitempty"`
+	Groups        []string `json:"groups"`
+	Tags          []string `json:"tags"`
+}
+
+// keywordParamsJSON holds the keyword fields that can be set through the API.
+// Missing fields are left alone.
+type keywordParamsJSON struct {
+	Value       *string `json:"value"`
+	Locale      *string `json:"locale"`
+	Device      *string `json:"device"`
+	Priority    *int    `json:"priority"`
+	Paused      *bool   `json:"paused"`
+	Cadence     *string `json:"cadence"`
+	WindowStart *string `json:"windowStart"`
+	WindowEnd   *string `json:"windowEnd"`
+	// Groups and Tags replace the keyword's ones
+	Groups *[]string `json:"groups"`
+	Tags   *[]string `json:"tags"`
+}
+
+func (p *keywordParamsJSON) apply(k *keywords.Keyword) *keywords.Keyword {
+	if p.Value != nil {
+		k.Value = strings.TrimSpace(*p.Value)
+	}
+	if p.Locale != nil {
+		k.Locale = *p.Locale
+	}
+	if p.Device != nil {
+		k.Device = *p.Device
+	}
+	if p.Priority != nil {
+		k.Priority = *p.Priority
+	}
+	if p.Paused != nil {
+		k.Paused = *p.Paused
+	}
+	if p.Cadence != nil {
+		k.Cadence = *p.Cadence
+	}
+	if p.WindowStart != nil {
+		k.WindowStart = *p.WindowStart
+	}
+	if p.WindowEnd != nil {
+		k.WindowEnd = *p.WindowEnd
+	}
+	if p.Groups != nil {
+		k.Groups = keywords.Labels(*p.Groups...)
+	}
+	if p.Tags != nil {
+		k.Tags = keywords.Labels(*p.Tags...)
+	}
+	return k
 }
 
 type adWithKeywordJSON struct {
@@ -112,6 +316,39 @@ type adWithKeywordJSON struct {
 	Keyword keywordJSON `json:"keyword"`
 }
 
+// validate checks the ad a scraper posts, except whether its keyword exists.
+func (p *adWithKeywordJSON) validate() []fieldError {
+	errs := make([]fieldError, 0)
+	text := []struct {
+		field    string
+		value    string
+		required bool
+		max      int
+	}{
+		{"ad.h1", p.Ad.H1, true, MaxHeadlineLength},
+		{"ad.h2", p.Ad.H2, false, MaxHeadlineLength},
+		{"ad.desc", p.Ad.Desc, true, MaxDescLength},
+		{"ad.path", p.Ad.Path, false, MaxPathLength},
+	}
+	for _, f := range text {
+		if f.required && strings.TrimSpace(f.value) == "" {
+			errs = append(errs, fieldError{Field: f.field, Message: "can't be blank"})
+		} else if len(f.value) > f.max {
+			errs = append(errs, fieldError{Field: f.field, Message: "can't be longer than " + strconv.Itoa(f.max) + " characters"})
+		}
+	}
+	if p.Ad.Position <= 0 {
+		errs = append(errs, fieldError{Field: "ad.position", Message: "must be greater than 0"})
+	}
+	if p.Ad.Block != "" && p.Ad.Block != BlockTop && p.Ad.Block != BlockBottom {
+		errs = append(errs, fieldError{Field: "ad.block", Message: "must be " + BlockTop + " or " + BlockBottom})
+	}
+	if p.Keyword.ID <= 0 {
+		errs = append(errs, fieldError{Field: "keyword.id", Message: "is required"})
+	}
+	return errs
+}
+
 func newAdWithKeywordJSON(ad *Ad, keyword *keywords.Keyword) *adWithKeywordJSON {
 	return &adWithKeywordJSON{
 		Ad:      newAdJSON(ad),
@@ -128,6 +365,8 @@ func newAdJSON(ad *Ad) adJSON {
 		Raw:      ad.GetRaw(),
 		Rest:     ad.GetRest(),
 		Position: ad.Position,
+		Block:    ad.Block,
+		PageId:   ad.PageId,
 	}
 }
 
@@ -135,14 +374,34 @@ func newKeywordJSON(k *keywords.Keyword) keywordJSON {
 	return keywordJSON{
 		ID:            k.ID,
 		Value:         k.Value,
+		Locale:        k.Locale,
+		Device:        k.Device,
+		Priority:      k.Priority,
+		Paused:        k.Paused,
+		Cadence:       k.Cadence,
+		WindowStart:   k.WindowStart,
+		WindowEnd:     k.WindowEnd,
 		TimesScraped:  k.TimesScraped,
 		LastScrapedAt: k.LastScrapedAt,
+		CreatedAt:     k.CreatedAt,
+		UpdatedAt:     k.UpdatedAt,
+		DeletedAt:     k.DeletedAt,
+		Groups:        labelsOrEmpty(k.Groups),
+		Tags:          labelsOrEmpty(k.Tags),
 	}
 }
 
+func labelsOrEmpty(labels []string) []string {
+	if labels == nil {
+		return []string{}
+	}
+	return labels
+}
+
 func (a *adJSON) ToAd() *Ad {
 	ad := &Ad{
 		H1: a.H1, H2: a.H2, Desc: a.Desc, Path: a.Path, Position: a.Position,
+		Block: a.Block, PageId: a.PageId,
 	}
 	ad.SetRaw(a.Raw)
 	ad.SetRest(a.Rest)
@@ -153,62 +412,319 @@ func (k *keywordJSON) ToKeyword() *keywords.Keyword {
 	return &keywords.Keyword{
 		ID:            k.ID,
 		Value:         k.Value,
+		Locale:        k.Locale,
+		Device:        k.Device,
+		Priority:      k.Priority,
+		Paused:        k.Paused,
 		TimesScraped:  k.TimesScraped,
 		LastScrapedAt: k.LastScrapedAt,
 	}
 }
 
+type pageJSON struct {
+	ID            int64  `json:"id"`
+	KeywordId     int64  `json:"keywordId"`
+	Hash          string `json:"hash"`
+	ParserVersion string `json:"parserVersion"`
+	FetchedAt     string `json:"fetchedAt"`
+	// Body is base64 encoded, since pages aren't always UTF-8
+	Body []byte `json:"body,omitempty"`
+}
+
+func newPageJSON(p *Page, withBody bool) *pageJSON {
+	pj := &pageJSON{
+		ID:            p.ID,
+		KeywordId:     p.KeywordId,
+		Hash:          p.Hash,
+		ParserVersion: p.ParserVersion,
+		FetchedAt:     p.FetchedAt,
+	}
+	if withBody {
+		pj.Body = p.Body
+	}
+	return pj
+}
+
+func (p *pageJSON) ToPage() *Page {
+	return &Page{KeywordId: p.KeywordId, ParserVersion: p.ParserVersion, Body: p.Body}
+}
+
+type storedAdJSON struct {
+	ID        int64  `json:"id"`
+	H1        string `json:"h1"`
+	H2        string `json:"h2"`
+	Desc      string `json:"desc"`
+	Path      string `json:"path"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+func newStoredAdJSON(ad *Ad) storedAdJSON {
+	return storedAdJSON{
+		ID:        ad.ID,
+		H1:        ad.H1,
+		H2:        ad.H2,
+		Desc:      ad.Desc,
+		Path:      ad.Path,
+		CreatedAt: ad.CreatedAt,
+		UpdatedAt: ad.UpdatedAt,
+	}
+}
+
+type adsPageJSON struct {
+	Ads  []storedAdJSON `json:"ads"`
+	Next string         `json:"next,omitempty"`
+}
+
+type sightingJSON struct {
+	KeywordId   int64  `json:"keywordId"`
+	Position    int    `json:"position"`
+	Count       int    `json:"count"`
+	FirstSeenAt string `json:"firstSeenAt"`
+	LastSeenAt  string `json:"lastSeenAt"`
+}
+
+type adDetailsJSON struct {
+	storedAdJSON
+	Extensions []string       `json:"extensions"`
+	Sightings  []sightingJSON `json:"sightings"`
+}
+
+func newAdDetailsJSON(ad *Ad, aks []AdKeyword) *adDetailsJSON {
+	d := &adDetailsJSON{
+		storedAdJSON: newStoredAdJSON(ad),
+		Extensions:   ad.Extensions(),
+		Sightings:    make([]sightingJSON, 0, len(aks)),
+	}
+	for _, ak := range aks {
+		d.Sightings = append(d.Sightings, sightingJSON{
+			KeywordId:   ak.KeywordId,
+			Position:    ak.Position,
+			Count:       ak.PositionCount,
+			FirstSeenAt: ak.CreatedAt,
+			LastSeenAt:  ak.UpdatedAt,
+		})
+	}
+	return d
+}
+
 type server struct {
-	store  Store
+	repos  *Repositories
 	logger httplog.Logger
+	// keys authenticates requests, when it's set
+	keys      apikeys.Reader
+	metrics   *serverMetrics
+	readiness Readiness
+	feed      *Feed
 }
 
-func NewServer(store Store) *server {
+func NewServer(repos *Repositories) *server {
 	logger := httplog.New(os.Stdout)
-	return &server{store: store, logger: logger}
+	metrics := newServerMetrics()
+	feed := NewFeed()
+	feed.metrics = metrics
+	return &server{repos: repos, logger: logger, metrics: metrics, feed: feed}
 }
 
-func (s *server) Listen(port int) {
+// Feed returns the feed of the live ads stream, to tune how it treats slow
+// clients.
+func (s *server) Feed() *Feed {
+	return s.feed
+}
+
+// RequireAPIKeys makes every endpoint require a key with the right scope.
+// Without it the server is open to anyone who can reach it.
+func (s *server) RequireAPIKeys(keys apikeys.Reader) *server {
+	s.keys = keys
+	return s
+}
+
+func (s *server) Handler() http.Handler {
+	return httplog.WithLogging(withRequestID(jsonContent(s.instrument(s.router()))), s.logger)
+}
+
+func (s *server) router() *mux.Router {
 	r := mux.NewRouter()
-	r.Handle("/ad_keywords", create(s.store)).Methods("POST")
-	r.Handle("/keywords", index(s.store)).Methods("GET")
-	r.Handle("/keywords/{id}", update(s.store)).Methods("PATCH", "PUT")
+	r.Handle("/versions", versions()).Methods("GET")
+	r.Handle("/metrics", s.authorize(s.metricsHandler(), apikeys.Analyst)).Methods("GET")
+	r.Handle("/healthz", healthz()).Methods("GET")
+	r.Handle("/readyz", s.readyz()).Methods("GET")
+	r.Handle("/openapi.json", openAPI()).Methods("GET")
+	r.Handle("/ui", uiRedirect("/ui/keywords")).Methods("GET")
+	s.uiRoutes(r.PathPrefix("/ui").Subrouter())
+	for _, v := range Versions {
+		s.apiRoutes(r.PathPrefix("/"+v).Subrouter(), v)
+	}
+	// The routes from before versioning serve v1
+	legacy := r.NewRoute().Subrouter()
+	legacy.Use(deprecated)
+	s.apiRoutes(legacy, V1)
 	r.HandleFunc("/", root())
-	http.Handle("/", r)
-	http.ListenAndServe(":"+strconv.Itoa(port), httplog.WithLogging(jsonContent(r), s.logger))
+	return r
+}
+
+func (s *server) apiRoutes(r *mux.Router, version string) {
+	// Admin keys are allowed everywhere
+	scraper, analyst := apikeys.Scraper, apikeys.Analyst
+	r.Handle("/ad_keywords", s.authorize(create(s.repos, s.feed, version), scraper)).Methods("POST")
+	r.Handle("/pages", s.authorize(createPage(s.repos), scraper)).Methods("POST")
+	r.Handle("/ads", s.authorize(indexAds(s.repos, version), analyst)).Methods("GET")
+	r.Handle("/ads/{id}", s.authorize(showAd(s.repos, version), analyst)).Methods("GET")
+	r.Handle("/stream/ads", s.authorize(streamAds(s.repos, s.feed, version), analyst)).Methods("GET")
+	r.Handle("/exports/ads", s.authorize(exportAds(s.repos), analyst)).Methods("GET")
+	r.Handle("/exports/observations", s.authorize(exportObservations(s.repos), analyst)).Methods("GET")
+	r.Handle("/analytics/share-of-voice", s.authorize(shareOfVoice(s.repos), analyst)).Methods("GET")
+	r.Handle("/analytics/positions", s.authorize(positions(s.repos), analyst)).Methods("GET")
+	r.Handle("/keywords", s.authorize(index(s.repos), scraper, analyst)).Methods("GET")
+	r.Handle("/keywords", s.authorize(createKeywords(s.repos))).Methods("POST")
+	r.Handle("/keywords/{id}", s.authorize(showKeyword(s.repos), scraper, analyst)).Methods("GET")
+	// Scrapers only mark keywords as scraped, see updateHandler
+	r.Handle("/keywords/{id}", s.authorize(update(s.repos), scraper)).Methods("PATCH", "PUT")
+	r.Handle("/keywords/{id}", s.authorize(deleteKeyword(s.repos))).Methods("DELETE")
+	r.Handle("/groups", s.authorize(indexLabels(s.repos, keywords.Group), scraper, analyst)).Methods("GET")
+	r.Handle("/tags", s.authorize(indexLabels(s.repos, keywords.Tag), scraper, analyst)).Methods("GET")
+	r.Handle("/webhooks", s.authorize(indexWebhooks(s.repos))).Methods("GET")
+	r.Handle("/webhooks", s.authorize(createWebhook(s.repos))).Methods("POST")
+	r.Handle("/webhooks/{id}", s.authorize(showWebhook(s.repos))).Methods("GET")
+	r.Handle("/webhooks/{id}", s.authorize(deleteWebhook(s.repos))).Methods("DELETE")
+	r.Handle("/webhooks/{id}/deliveries", s.authorize(indexDeliveries(s.repos))).Methods("GET")
+	r.Handle("/brands", s.authorize(indexBrands(s.repos), analyst)).Methods("GET")
+	r.Handle("/brands", s.authorize(createBrand(s.repos))).Methods("POST")
+	r.Handle("/brands/{id}", s.authorize(showBrand(s.repos), analyst)).Methods("GET")
+	r.Handle("/brands/{id}", s.authorize(updateBrand(s.repos))).Methods("PUT")
+	r.Handle("/brands/{id}", s.authorize(deleteBrand(s.repos))).Methods("DELETE")
+	r.Handle("/brand-bidding", s.authorize(indexIncidents(s.repos), analyst)).Methods("GET")
+	r.Handle("/brand-bidding/{id}", s.authorize(showIncident(s.repos), analyst)).Methods("GET")
+	r.Handle("/compliance-rules", s.authorize(indexRules(s.repos), analyst)).Methods("GET")
+	// Analysts triage the violations, rules are set with the compliance command
+	r.Handle("/violations", s.authorize(indexViolations(s.repos), analyst)).Methods("GET")
+	r.Handle("/violations/{id}", s.authorize(showViolation(s.repos), analyst)).Methods("GET")
+	r.Handle("/violations/{id}", s.authorize(triageViolation(s.repos), analyst)).Methods("PATCH")
 }
 
 type createHandler struct {
 	adWriter       AdWriter
-	keywordsWriter keywords.Writer
+	keywordsReader keywords.Reader
+	feed           *Feed
+	version        string
 }
 
 func (h *createHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	params := &adWithKeywordJSON{}
-	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
-		writeResponse(w, badRequest())
+	var errs []fieldError
+	var hs []string
+	if h.version == V2 {
+		v2 := &adWithKeywordV2JSON{}
+		if err := json.NewDecoder(r.Body).Decode(v2); err != nil {
+			writeResponse(w, decodeError(err))
+			return
+		}
+		params, errs, hs = v2.v1(), v2.validate(), v2.Ad.Headlines
+	} else {
+		if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+			writeResponse(w, decodeError(err))
+			return
+		}
+		errs = params.validate()
+	}
+
+	var k *keywords.Keyword
+	if params.Keyword.ID > 0 {
+		var err error
+		k, err = h.keywordsReader.Find(params.Keyword.ID)
+		if err != nil {
+			writeResponse(w, internalServerError(err))
+			return
+		} else if k == nil {
+			errs = append(errs, fieldError{Field: "keyword.id", Message: "doesn't match a keyword"})
+		}
+	}
+	if len(errs) > 0 {
+		writeResponse(w, invalid(errs))
 		return
 	}
 
-	if err := h.adWriter.Upsert(params.Ad.ToAd(), params.Keyword.ToKeyword()); err != nil {
-		writeResponse(w, internalServerError())
+	ad := params.Ad.ToAd()
+	ad.Headlines = hs
+	if err := h.adWriter.Upsert(ad, params.Keyword.ToKeyword()); err != nil {
+		writeResponse(w, internalServerError(err))
 		return
 	}
+	h.feed.Publish(ad, k)
 	writeResponse(w, &successResponse{status: http.StatusCreated})
 }
 
-func create(s Store) http.Handler {
-	return &createHandler{adWriter: NewWriter(s), keywordsWriter: keywords.NewWriter(s)}
+func create(r *Repositories, feed *Feed, version string) http.Handler {
+	return &createHandler{adWriter: r.Ads, keywordsReader: r.Keywords, feed: feed, version: version}
+}
+
+type createPageHandler struct {
+	pagesWriter PageWriter
+}
+
+func (h *createPageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := &pageJSON{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		writeResponse(w, decodeError(err))
+		return
+	} else if len(params.Body) == 0 {
+		writeResponse(w, invalid([]fieldError{{Field: "body", Message: "can't be blank"}}))
+		return
+	}
+
+	p := params.ToPage()
+	if p.ParserVersion == "" {
+		p.ParserVersion = ParserVersion
+	}
+	if err := h.pagesWriter.Create(p); err == ErrArchiveDisabled {
+		writeResponse(w, notImplemented())
+		return
+	} else if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	}
+	writeResponse(w, &successResponse{status: http.StatusCreated, body: newPageJSON(p, false)})
+}
+
+func createPage(r *Repositories) http.Handler {
+	return &createPageHandler{pagesWriter: r.Pages}
 }
 
 type indexHandler struct {
 	keywordsReader keywords.Reader
 }
 
+// ServeHTTP lists keywords, least scraped first unless sorted otherwise. The
+// body stays a plain array for the scrapers, the next page is linked in the
+// Link header. With due=true it hands out the keywords due to be scraped,
+// most overdue and important first, in a single page.
 func (h *indexHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	kws, err := h.keywordsReader.GetLeastScraped(20)
+	params := r.URL.Query()
+	q, err := newKeywordsQuery(params)
 	if err != nil {
-		writeResponse(w, internalServerError())
+		writeResponse(w, badRequest())
+		return
+	}
+	due, err := boolParam(params, "due")
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+
+	var (
+		kws  []keywords.Keyword
+		next string
+	)
+	if due {
+		kws, err = h.keywordsReader.GetDue(q, time.Now())
+	} else {
+		kws, next, err = h.keywordsReader.List(q)
+	}
+	if err == keywords.ErrInvalidSort || err == keywords.ErrInvalidCursor {
+		writeResponse(w, badRequest())
+		return
+	} else if err != nil {
+		writeResponse(w, internalServerError(err))
 		return
 	}
 
@@ -216,34 +732,441 @@ func (h *indexHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	for _, k := range kws {
 		kwsJSON = append(kwsJSON, newKeywordJSON(&k))
 	}
+	if next != "" {
+		params.Set("cursor", next)
+		w.Header().Add("Link", "<"+r.URL.Path+"?"+params.Encode()+`>; rel="next"`)
+	}
 	writeResponse(w, ok(kwsJSON))
 }
 
-func index(s Store) http.Handler {
-	return &indexHandler{keywordsReader: keywords.NewReader(s)}
+func newKeywordsQuery(params url.Values) (keywords.Query, error) {
+	q := keywords.Query{
+		Text:   params.Get("q"),
+		Locale: params.Get("locale"),
+		Group:  params.Get("group"),
+		Tag:    params.Get("tag"),
+		Sort:   params.Get("sort"),
+		Cursor: params.Get("cursor"),
+	}
+	if v := params.Get("paused"); v != "" {
+		paused, err := boolParam(params, "paused")
+		if err != nil {
+			return q, err
+		}
+		q.Paused = &paused
+	}
+	limit, err := int64Param(params, "limit")
+	if err != nil {
+		return q, err
+	} else if limit > keywords.MaxLimit {
+		return q, fmt.Errorf("The limit can't be over %v", keywords.MaxLimit)
+	}
+	q.Limit = int(limit)
+	return q, nil
+}
+
+func index(r *Repositories) http.Handler {
+	return &indexHandler{keywordsReader: r.Keywords}
+}
+
+type indexAdsHandler struct {
+	adReader       AdReader
+	keywordsReader keywords.Reader
+	version        string
+}
+
+func (h *indexAdsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q, err := newAdQuery(params)
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+
+	if v := params.Get("keyword"); v != "" {
+		k, err := h.keywordsReader.FindByValue(v)
+		if err != nil {
+			writeResponse(w, internalServerError(err))
+			return
+		} else if k == nil {
+			writeResponse(w, ok(h.page(nil, "")))
+			return
+		}
+		q.KeywordId = k.ID
+	}
+
+	ads, next, err := h.adReader.Search(q)
+	if err == ErrInvalidSort || err == ErrInvalidCursor {
+		writeResponse(w, badRequest())
+		return
+	} else if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	}
+	writeResponse(w, ok(h.page(ads, next)))
+}
+
+func (h *indexAdsHandler) page(ads []Ad, next string) interface{} {
+	if h.version == V2 {
+		page := &adsPageV2JSON{Ads: make([]storedAdV2JSON, 0, len(ads)), Next: next}
+		for i := range ads {
+			page.Ads = append(page.Ads, newStoredAdV2JSON(&ads[i]))
+		}
+		return page
+	}
+	page := &adsPageJSON{Ads: make([]storedAdJSON, 0, len(ads)), Next: next}
+	for i := range ads {
+		page.Ads = append(page.Ads, newStoredAdJSON(&ads[i]))
+	}
+	return page
+}
+
+// newAdQuery reads the ads filters from the query string. Dates are either
+// RFC 3339 timestamps or plain days, with a day in "to" included whole.
+func newAdQuery(params url.Values) (AdQuery, error) {
+	q := AdQuery{
+		Domain: params.Get("domain"),
+		Block:  params.Get("block"),
+		Group:  params.Get("group"),
+		Tag:    params.Get("tag"),
+		Text:   params.Get("q"),
+		Sort:   params.Get("sort"),
+		Cursor: params.Get("cursor"),
+	}
+	if q.Domain == "" {
+		q.Domain = params.Get("advertiser")
+	}
+	if q.Block != "" && q.Block != BlockTop && q.Block != BlockBottom {
+		return q, fmt.Errorf("Unknown block %q", q.Block)
+	}
+
+	var err error
+	if q.KeywordId, err = int64Param(params, "keywordId"); err != nil {
+		return q, err
+	}
+	if q.From, err = timeParam(params, "from", false); err != nil {
+		return q, err
+	}
+	if q.To, err = timeParam(params, "to", true); err != nil {
+		return q, err
+	}
+	for name, n := range map[string]*int{"minPosition": &q.MinPosition, "maxPosition": &q.MaxPosition, "limit": &q.Limit} {
+		v, err := int64Param(params, name)
+		if err != nil {
+			return q, err
+		}
+		*n = int(v)
+	}
+	if q.Limit > MaxAdsLimit {
+		return q, fmt.Errorf("The limit can't be over %v", MaxAdsLimit)
+	}
+	return q, nil
+}
+
+func boolParam(params url.Values, name string) (bool, error) {
+	v := params.Get(name)
+	if v == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+func int64Param(params url.Values, name string) (int64, error) {
+	v := params.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid %v %q", name, v)
+	}
+	return n, nil
+}
+
+func timeParam(params url.Values, name string, wholeDay bool) (time.Time, error) {
+	v := params.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		if wholeDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+func indexAds(r *Repositories, version string) http.Handler {
+	return &indexAdsHandler{adReader: r.Ads, keywordsReader: r.Keywords, version: version}
+}
+
+type showAdHandler struct {
+	adReader          AdReader
+	observationReader ObservationReader
+	version           string
+}
+
+func (h *showAdHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+
+	ad, err := h.adReader.Find(id)
+	if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	} else if ad == nil {
+		writeResponse(w, notFound())
+		return
+	}
+	aks, err := h.observationReader.GetAdKeywords(ad.ID)
+	if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	}
+	if h.version != V2 {
+		writeResponse(w, ok(newAdDetailsJSON(ad, aks)))
+		return
+	}
+	obs, err := h.observationReader.GetObservations(ad.ID)
+	if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	}
+	writeResponse(w, ok(newAdDetailsV2JSON(ad, aks, obs)))
+}
+
+func showAd(r *Repositories, version string) http.Handler {
+	return &showAdHandler{adReader: r.Ads, observationReader: r.Ads, version: version}
+}
+
+// MaxKeywordsBatch is the most keywords created in a single request.
+const MaxKeywordsBatch = 1000
+
+type createKeywordsHandler struct {
+	keywordsWriter keywords.Writer
+}
+
+// ServeHTTP creates a single keyword from an object or many from an array.
+// A batch is created whole or not at all.
+func (h *createKeywordsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+
+	if body = bytes.TrimSpace(body); len(body) > 0 && body[0] == '[' {
+		params := make([]keywordParamsJSON, 0)
+		if err = json.Unmarshal(body, &params); err != nil {
+			writeResponse(w, decodeError(err))
+			return
+		} else if len(params) == 0 {
+			writeResponse(w, badRequest())
+			return
+		} else if len(params) > MaxKeywordsBatch {
+			writeResponse(w, unprocessableEntity(fmt.Sprintf("Can't create more than %v keywords at once", MaxKeywordsBatch)))
+			return
+		}
+
+		ks := make([]*keywords.Keyword, 0, len(params))
+		for i := range params {
+			k := params[i].apply(&keywords.Keyword{})
+			if err = k.Validate(); err != nil {
+				writeResponse(w, unprocessableEntity(fmt.Sprintf("Keyword %v: %v", i, err)))
+				return
+			}
+			ks = append(ks, k)
+		}
+		if ks, err = h.keywordsWriter.CreateMany(ks); err != nil {
+			writeResponse(w, keywordError(err))
+			return
+		}
+		kwsJSON := make([]keywordJSON, 0, len(ks))
+		for _, k := range ks {
+			kwsJSON = append(kwsJSON, newKeywordJSON(k))
+		}
+		writeResponse(w, &successResponse{status: http.StatusCreated, body: kwsJSON})
+		return
+	}
+
+	params := &keywordParamsJSON{}
+	if err = json.Unmarshal(body, params); err != nil {
+		writeResponse(w, decodeError(err))
+		return
+	}
+	k := params.apply(&keywords.Keyword{})
+	if err = k.Validate(); err != nil {
+		writeResponse(w, unprocessableEntity(err.Error()))
+		return
+	}
+	if k, err = h.keywordsWriter.Create(k); err != nil {
+		writeResponse(w, keywordError(err))
+		return
+	}
+	writeResponse(w, &successResponse{status: http.StatusCreated, body: newKeywordJSON(k)})
+}
+
+func createKeywords(r *Repositories) http.Handler {
+	return &createKeywordsHandler{keywordsWriter: r.Keywords}
+}
+
+// keywordError is the response for errors from the keywords writer.
+func keywordError(err error) response {
+	switch err {
+	case keywords.ErrNotFound:
+		return notFound()
+	case keywords.ErrDuplicate:
+		return conflict()
+	}
+	return internalServerError(err)
+}
+
+type showKeywordHandler struct {
+	keywordsReader keywords.Reader
+}
+
+func (h *showKeywordHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+
+	k, err := h.keywordsReader.Find(id)
+	if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	} else if k == nil {
+		writeResponse(w, notFound())
+		return
+	}
+	writeResponse(w, ok(newKeywordJSON(k)))
+}
+
+func showKeyword(r *Repositories) http.Handler {
+	return &showKeywordHandler{keywordsReader: r.Keywords}
 }
 
 type updateHandler struct {
+	keywordsReader keywords.Reader
 	keywordsWriter keywords.Writer
+	adWriter       AdWriter
 }
 
+// ServeHTTP updates a keyword. PATCH sets the fields in the body, PUT
+// replaces all of them. Without a body the keyword is marked as scraped, which
+// is what scrapers do after each run, and the run's ads are compared with the
+// previous run's. Only admin keys can change fields.
 func (h *updateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		writeResponse(w, badRequest())
 		return
 	}
 
-	if k, err := h.keywordsWriter.UpdateScraped(&keywords.Keyword{ID: int64(id)}); err != nil {
-		writeResponse(w, internalServerError())
+	if len(bytes.TrimSpace(body)) == 0 {
+		if k, err := h.keywordsWriter.UpdateScraped(&keywords.Keyword{ID: id}); err != nil {
+			writeResponse(w, keywordError(err))
+		} else if err = h.adWriter.EndScrape(k); err != nil {
+			writeResponse(w, internalServerError(err))
+		} else {
+			writeResponse(w, ok(newKeywordJSON(k)))
+		}
+		return
+	}
+
+	if key := apiKey(r); key != nil && !key.Allows(apikeys.Admin) {
+		writeResponse(w, forbidden())
+		return
+	}
+	params := &keywordParamsJSON{}
+	if err = json.Unmarshal(body, params); err != nil {
+		writeResponse(w, decodeError(err))
+		return
+	}
+	k := &keywords.Keyword{ID: id, Groups: []string{}, Tags: []string{}}
+	if r.Method == "PATCH" {
+		if k, err = h.keywordsReader.Find(id); err != nil {
+			writeResponse(w, internalServerError(err))
+			return
+		} else if k == nil {
+			writeResponse(w, notFound())
+			return
+		}
+	}
+	if err = params.apply(k).Validate(); err != nil {
+		writeResponse(w, unprocessableEntity(err.Error()))
+		return
+	}
+	if k, err = h.keywordsWriter.Update(k); err != nil {
+		writeResponse(w, keywordError(err))
+		return
+	}
+	writeResponse(w, ok(newKeywordJSON(k)))
+}
+
+func update(r *Repositories) http.Handler {
+	return &updateHandler{keywordsReader: r.Keywords, keywordsWriter: r.Keywords, adWriter: r.Ads}
+}
+
+type labelJSON struct {
+	Name     string `json:"name"`
+	Keywords int    `json:"keywords"`
+}
+
+type indexLabelsHandler struct {
+	kind           string
+	keywordsReader keywords.Reader
+}
+
+// ServeHTTP lists the groups or tags with the number of keywords in each.
+func (h *indexLabelsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ls, err := h.keywordsReader.Labels(h.kind)
+	if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	}
+	lsJSON := make([]labelJSON, 0, len(ls))
+	for _, l := range ls {
+		lsJSON = append(lsJSON, labelJSON{Name: l.Name, Keywords: l.Keywords})
+	}
+	writeResponse(w, ok(lsJSON))
+}
+
+func indexLabels(r *Repositories, kind string) http.Handler {
+	return &indexLabelsHandler{kind: kind, keywordsReader: r.Keywords}
+}
+
+type deleteKeywordHandler struct {
+	keywordsWriter keywords.Writer
+}
+
+func (h *deleteKeywordHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+
+	k, err := h.keywordsWriter.Delete(id)
+	if err != nil {
+		writeResponse(w, keywordError(err))
 		return
-	} else {
-		writeResponse(w, ok(k))
 	}
+	writeResponse(w, ok(newKeywordJSON(k)))
 }
 
-func update(s Store) http.Handler {
-	return &updateHandler{keywordsWriter: keywords.NewWriter(s)}
+func deleteKeyword(r *Repositories) http.Handler {
+	return &deleteKeywordHandler{keywordsWriter: r.Keywords}
 }
 
 type response interface {
@@ -252,6 +1175,12 @@ type response interface {
 }
 
 func writeResponse(w http.ResponseWriter, r response) {
+	if e, ok := r.(*errorResponse); ok {
+		e.RequestID = w.Header().Get(requestIDHeader)
+		if e.err != nil {
+			log.Printf("Request %v failed: %v", e.RequestID, e.err)
+		}
+	}
 	w.WriteHeader(r.Status())
 	encoder := json.NewEncoder(w)
 	if err := encoder.Encode(r.Body()); err != nil {
@@ -260,8 +1189,18 @@ func writeResponse(w http.ResponseWriter, r response) {
 }
 
 type errorResponse struct {
+	Message   string       `json:"message"`
+	RequestID string       `json:"requestId,omitempty"`
+	Errors    []fieldError `json:"errors,omitempty"`
+	status    int
+	err       error
+}
+
+// fieldError is the problem with a field of the request body, by its JSON
+// path like ad.h1. Field is empty for problems with the body as a whole.
+type fieldError struct {
+	Field   string `json:"field,omitempty"`
 	Message string `json:"message"`
-	status  int
 }
 
 func (r *errorResponse) Status() int {
@@ -280,12 +1219,70 @@ func badRequest() response {
 	return &errorResponse{status: http.StatusBadRequest, Message: "Bad request"}
 }
 
+func unauthorized() response {
+	return &errorResponse{status: http.StatusUnauthorized, Message: "Unauthorized"}
+}
+
+func forbidden() response {
+	return &errorResponse{status: http.StatusForbidden, Message: "Forbidden"}
+}
+
 func notFound() response {
 	return &errorResponse{status: http.StatusNotFound, Message: "Not found"}
 }
 
-func internalServerError() response {
-	return &errorResponse{status: http.StatusInternalServerError, Message: "Something went wrong"}
+func conflict() response {
+	return &errorResponse{status: http.StatusConflict, Message: "Conflict"}
+}
+
+func unprocessableEntity(message string) response {
+	return &errorResponse{status: http.StatusUnprocessableEntity, Message: message}
+}
+
+func notImplemented() response {
+	return &errorResponse{status: http.StatusNotImplemented, Message: "Not implemented"}
+}
+
+// internalServerError hides err from the client, it's logged along with the
+// request ID instead.
+func internalServerError(err error) response {
+	return &errorResponse{status: http.StatusInternalServerError, Message: "Something went wrong", err: err}
+}
+
+// invalid is an unprocessableEntity with the problem of each field.
+func invalid(errs []fieldError) response {
+	return &errorResponse{status: http.StatusUnprocessableEntity, Message: "Validation failed", Errors: errs}
+}
+
+// decodeError is a badRequest saying why the body couldn't be decoded.
+func decodeError(err error) response {
+	fe := fieldError{Message: err.Error()}
+	switch e := err.(type) {
+	case *json.UnmarshalTypeError:
+		fe = fieldError{Field: e.Field, Message: "must be " + jsonType(e.Type.Kind())}
+	case *json.SyntaxError:
+		fe.Message = "invalid JSON at offset " + strconv.FormatInt(e.Offset, 10)
+	}
+	if err == io.EOF {
+		fe.Message = "the body can't be empty"
+	} else if err == io.ErrUnexpectedEOF {
+		fe.Message = "the body ends unexpectedly"
+	}
+	return &errorResponse{status: http.StatusBadRequest, Message: "Bad request", Errors: []fieldError{fe}}
+}
+
+func jsonType(k reflect.Kind) string {
+	switch k {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map, reflect.Ptr:
+		return "an object"
+	}
+	return "a number"
 }
 
 type successResponse struct {
@@ -307,6 +1304,25 @@ func root() http.HandlerFunc {
 	}
 }
 
+const requestIDHeader = "X-Request-ID"
+
+var requestID = regexp.MustCompile(`^[\w.-]{1,64}$`)
+
+// withRequestID sets the X-Request-ID response header, to the one in the
+// request if there's a sane one, so errors can be matched with the logs.
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestID.MatchString(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(requestIDHeader, id)
+		h.ServeHTTP(w, r)
+	})
+}
+
 func jsonContent(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -316,3 +1332,55 @@ func jsonContent(h http.Handler) http.Handler {
 		h.ServeHTTP(w, r)
 	})
 }
+
+type apiKeyContextKey struct{}
+
+// apiKey returns the key the request was authenticated with, nil when the
+// server doesn't require keys.
+func apiKey(r *http.Request) *apikeys.Key {
+	k, _ := r.Context().Value(apiKeyContextKey{}).(*apikeys.Key)
+	return k
+}
+
+// authorize lets through requests with a key that has one of the scopes.
+// Keys are sent as bearer tokens in the Authorization header, in the
+// X-API-Key header or as the password of basic auth.
+func (s *server) authorize(h http.Handler, scopes ...string) http.Handler {
+	return s.authorizeWith("Bearer", `Bearer error="invalid_token"`, h, scopes...)
+}
+
+// authorizeWith is authorize, challenging requests without a key and with an
+// invalid one with the WWW-Authenticate headers given.
+func (s *server) authorizeWith(missing string, invalid string, h http.Handler, scopes ...string) http.Handler {
+	if s.keys == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-API-Key")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+		} else if _, password, ok := r.BasicAuth(); ok {
+			// Browsers send the key as the password
+			token = password
+		}
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", missing)
+			writeResponse(w, unauthorized())
+			return
+		}
+
+		k, err := s.keys.Authenticate(token)
+		if err != nil {
+			writeResponse(w, internalServerError(err))
+			return
+		} else if k == nil {
+			w.Header().Set("WWW-Authenticate", invalid)
+			writeResponse(w, unauthorized())
+			return
+		} else if !k.Allows(scopes...) {
+			writeResponse(w, forbidden())
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, k)))
+	})
+}
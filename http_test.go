@@ -0,0 +1,1322 @@
+package adscraper_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gkats/adscraper"
+	"github.com/gkats/adscraper/apikeys"
+	"github.com/gkats/adscraper/keywords"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, *adscraper.Repositories) {
+	repos := adscraper.NewMemoryRepositories()
+	ts := httptest.NewServer(adscraper.NewServer(repos).Handler())
+	t.Cleanup(ts.Close)
+	return ts, repos
+}
+
+func do(t *testing.T, method string, url string, body string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestServerStatusCodes(t *testing.T) {
+	ts, repos := newTestServer(t)
+	k, _ := repos.Keywords.Upsert(keywords.New("reebok women shoes"))
+
+	adKeyword := `{"ad":{"h1":"Women Shoes","h2":"Reebok.com","desc":"Flash Sale","path":"www.reebok.com","position":1},"keyword":{"id":1}}`
+
+	testCases := []struct {
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"POST", "/ad_keywords", adKeyword, http.StatusCreated},
+		{"POST", "/ad_keywords", `{"ad":`, http.StatusBadRequest},
+		{"GET", "/keywords", "", http.StatusOK},
+		{"PATCH", "/keywords/1", "", http.StatusOK},
+		{"PUT", "/keywords/1", "", http.StatusOK},
+		{"PATCH", "/keywords/abc", "", http.StatusBadRequest},
+		{"PATCH", "/keywords/42", "", http.StatusNotFound},
+		{"GET", "/", "", http.StatusNotFound},
+	}
+
+	for i, tc := range testCases {
+		if got := do(t, tc.method, ts.URL+tc.path, tc.body).StatusCode; got != tc.want {
+			t.Errorf("(%v) %v %v: expected %v, got %v", i, tc.method, tc.path, tc.want, got)
+		}
+	}
+
+	ks, _ := repos.Keywords.GetLeastScraped(1)
+	if ks[0].ID != k.ID || ks[0].TimesScraped != 2 {
+		t.Errorf("Expected keyword to be scraped 2 times, got %v", ks[0].TimesScraped)
+	}
+}
+
+func TestServerCreateAdKeywords(t *testing.T) {
+	ts, repos := newTestServer(t)
+	k, _ := repos.Keywords.Upsert(keywords.New("reebok women shoes"))
+	client := adscraper.NewClient(ts.URL)
+
+	ad := &adscraper.Ad{H1: "Women Shoes", H2: "Reebok.com", Desc: "Flash Sale", Position: 1}
+	ad.SetRaw("<li>raw</li>")
+	for _, pos := range []int{1, 1, 2} {
+		ad.Position = pos
+		if err := client.PostAdKeywords(ad, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stored, err := repos.Ads.Find(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored == nil || stored.H1 != ad.H1 || stored.GetRaw() != ad.GetRaw() {
+		t.Fatalf("Expected ad %v to be stored, got %v", ad, stored)
+	}
+	if missing, _ := repos.Ads.Find(2); missing != nil {
+		t.Errorf("Expected a single ad, got %v", missing)
+	}
+
+	aks, err := repos.Ads.GetAdKeywords(stored.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{2, len(aks)},
+		{1, aks[0].Position},
+		{2, aks[0].PositionCount},
+		{2, aks[1].Position},
+		{1, aks[1].PositionCount},
+		{k.ID, aks[1].KeywordId},
+	}
+	for i, tc := range testCases {
+		if tc.got != tc.want {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
+
+func TestServerIndexKeywords(t *testing.T) {
+	ts, repos := newTestServer(t)
+	for _, v := range []string{"book flights", "flight booking", "book a flight"} {
+		repos.Keywords.Upsert(keywords.New(v))
+	}
+	repos.Keywords.UpdateScraped(&keywords.Keyword{ID: 1})
+
+	var ks []map[string]interface{}
+	resp := do(t, "GET", ts.URL+"/keywords", "")
+	if err := json.NewDecoder(resp.Body).Decode(&ks); err != nil {
+		t.Fatal(err)
+	}
+	if len(ks) != 3 {
+		t.Fatalf("Expected 3 keywords, got %v", len(ks))
+	}
+	if got := ks[2]["value"]; got != "book flights" {
+		t.Errorf("Expected the most scraped keyword last, got %v", got)
+	}
+}
+
+func TestServerAds(t *testing.T) {
+	ts, repos := newTestServer(t)
+	k, _ := repos.Keywords.Upsert(keywords.New("reebok women shoes"))
+	ad := &adscraper.Ad{H1: "Women Shoes", H2: "Reebok.com", Desc: "Flash Sale", Path: "www.reebok.com", Position: 1, Block: adscraper.BlockTop}
+	ad.SetRest(`<div class="ellip">Free returns - Official Store</div>`)
+	for _, pos := range []int{1, 1, 4} {
+		ad.Position = pos
+		repos.Ads.Upsert(ad, k)
+	}
+	repos.Ads.Upsert(&adscraper.Ad{H1: "Running", H2: "Nike", Desc: "Just do it", Path: "nike.com", Position: 2}, k)
+
+	testCases := []struct {
+		path  string
+		want  int
+		count int
+	}{
+		{"/ads", http.StatusOK, 2},
+		{"/ads?keyword=reebok+women+shoes&block=top", http.StatusOK, 1},
+		{"/ads?keyword=unknown", http.StatusOK, 0},
+		{"/ads?advertiser=reebok.com", http.StatusOK, 1},
+		{"/ads?q=just&minPosition=1&maxPosition=3", http.StatusOK, 1},
+		{"/ads?minPosition=3", http.StatusOK, 1},
+		{"/ads?from=2017-01-01&to=2017-01-31", http.StatusOK, 0},
+		{"/ads?sort=-updatedAt&limit=1", http.StatusOK, 1},
+		{"/ads?block=middle", http.StatusBadRequest, 0},
+		{"/ads?from=yesterday", http.StatusBadRequest, 0},
+		{"/ads?limit=-1", http.StatusBadRequest, 0},
+		{"/ads?limit=1000", http.StatusBadRequest, 0},
+		{"/ads?sort=h1", http.StatusBadRequest, 0},
+		{"/ads?cursor=abc", http.StatusBadRequest, 0},
+	}
+	for i, tc := range testCases {
+		resp := do(t, "GET", ts.URL+tc.path, "")
+		if resp.StatusCode != tc.want {
+			t.Errorf("(%v) GET %v: expected %v, got %v", i, tc.path, tc.want, resp.StatusCode)
+			continue
+		}
+		if tc.want != http.StatusOK {
+			continue
+		}
+		page := struct {
+			Ads  []map[string]interface{} `json:"ads"`
+			Next string                   `json:"next"`
+		}{}
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Ads) != tc.count {
+			t.Errorf("(%v) GET %v: expected %v ads, got %v", i, tc.path, tc.count, len(page.Ads))
+		}
+	}
+
+	var details struct {
+		ID         int64    `json:"id"`
+		Extensions []string `json:"extensions"`
+		Sightings  []struct {
+			KeywordId int64 `json:"keywordId"`
+			Position  int   `json:"position"`
+			Count     int   `json:"count"`
+		} `json:"sightings"`
+	}
+	resp := do(t, "GET", ts.URL+"/ads/1", "")
+	if err := json.NewDecoder(resp.Body).Decode(&details); err != nil {
+		t.Fatal(err)
+	}
+	detailCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{http.StatusOK, resp.StatusCode},
+		{int64(1), details.ID},
+		{1, len(details.Extensions)},
+		{2, len(details.Sightings)},
+		{k.ID, details.Sightings[0].KeywordId},
+		{2, details.Sightings[0].Count},
+		{4, details.Sightings[1].Position},
+		{http.StatusNotFound, do(t, "GET", ts.URL+"/ads/42", "").StatusCode},
+		{http.StatusBadRequest, do(t, "GET", ts.URL+"/ads/abc", "").StatusCode},
+	}
+	for i, tc := range detailCases {
+		if tc.got != tc.want {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
+
+func TestServerKeywords(t *testing.T) {
+	ts, repos := newTestServer(t)
+
+	testCases := []struct {
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"POST", "/keywords", `{"value":" book flights ","locale":"en-GB","priority":10}`, http.StatusCreated},
+		{"POST", "/keywords", `[{"value":"flight booking"},{"value":"cheap flights","paused":true}]`, http.StatusCreated},
+		{"POST", "/keywords", `{"value":"book flights"}`, http.StatusConflict},
+		{"POST", "/keywords", `[{"value":"hotels"},{"value":"book flights"}]`, http.StatusConflict},
+		{"POST", "/keywords", `[{"value":"hotels"},{"value":""}]`, http.StatusUnprocessableEntity},
+		{"POST", "/keywords", `{"value":"hotels","locale":"english"}`, http.StatusUnprocessableEntity},
+		{"POST", "/keywords", `{"value":"hotels","priority":101}`, http.StatusUnprocessableEntity},
+		{"POST", "/keywords", `{"value":"hotels","cadence":"fortnightly"}`, http.StatusUnprocessableEntity},
+		{"POST", "/keywords", `{"value":"hotels","cadence":"30s"}`, http.StatusUnprocessableEntity},
+		{"POST", "/keywords", `{"value":"hotels","windowStart":"09:00"}`, http.StatusUnprocessableEntity},
+		{"POST", "/keywords", `[]`, http.StatusBadRequest},
+		{"POST", "/keywords", `{"value":`, http.StatusBadRequest},
+		{"GET", "/keywords/1", "", http.StatusOK},
+		{"GET", "/keywords/42", "", http.StatusNotFound},
+		{"GET", "/keywords/abc", "", http.StatusBadRequest},
+		{"PATCH", "/keywords/2", `{"priority":5,"cadence":"hourly","windowStart":"22:00","windowEnd":"06:00"}`, http.StatusOK},
+		{"PATCH", "/keywords/2", `{"value":"book flights"}`, http.StatusConflict},
+		{"PATCH", "/keywords/2", `{"value":"  "}`, http.StatusUnprocessableEntity},
+		{"PATCH", "/keywords/42", `{"priority":5}`, http.StatusNotFound},
+		{"PUT", "/keywords/2", `{"priority":5}`, http.StatusUnprocessableEntity},
+		{"PUT", "/keywords/2", `{"value":"flights booking"}`, http.StatusOK},
+		{"DELETE", "/keywords/3", "", http.StatusOK},
+		{"DELETE", "/keywords/3", "", http.StatusNotFound},
+		{"GET", "/keywords/3", "", http.StatusNotFound},
+		{"PATCH", "/keywords/3", `{"paused":false}`, http.StatusNotFound},
+		{"GET", "/keywords?sort=h1", "", http.StatusBadRequest},
+		{"GET", "/keywords?paused=maybe", "", http.StatusBadRequest},
+		{"GET", "/keywords?limit=1000", "", http.StatusBadRequest},
+		{"GET", "/keywords?due=soon", "", http.StatusBadRequest},
+	}
+	for i, tc := range testCases {
+		if got := do(t, tc.method, ts.URL+tc.path, tc.body).StatusCode; got != tc.want {
+			t.Errorf("(%v) %v %v: expected %v, got %v", i, tc.method, tc.path, tc.want, got)
+		}
+	}
+
+	k, _ := repos.Keywords.Find(1)
+	if k.Value != "book flights" || k.Locale != "en-GB" || k.Priority != 10 {
+		t.Errorf("Expected the keyword fields to be stored, got %+v", k)
+	}
+	k, _ = repos.Keywords.Find(2)
+	if k.Value != "flights booking" || k.Priority != 0 {
+		t.Errorf("Expected PUT to replace the keyword fields, got %+v", k)
+	}
+
+	// Deleted keywords can be created again
+	if got := do(t, "POST", ts.URL+"/keywords", `{"value":"cheap flights"}`).StatusCode; got != http.StatusCreated {
+		t.Errorf("Expected a deleted keyword to be created again, got %v", got)
+	}
+	if k, _ = repos.Keywords.Find(3); k == nil || k.Paused {
+		t.Errorf("Expected the deleted keyword to be restored, got %+v", k)
+	}
+
+	// Page through the keywords two at a time
+	var values []string
+	next := ts.URL + "/v1/keywords?limit=2&sort=value"
+	for next != "" {
+		resp := do(t, "GET", next, "")
+		var ks []map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&ks); err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range ks {
+			values = append(values, k["value"].(string))
+		}
+		next = ""
+		if link := resp.Header.Get("Link"); link != "" {
+			next = ts.URL + strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+		}
+	}
+	if got := strings.Join(values, ","); got != "book flights,cheap flights,flights booking" {
+		t.Errorf("Expected every keyword in order, got %v", got)
+	}
+
+	repos.Keywords.Update(&keywords.Keyword{ID: 1, Value: "book flights", Paused: true})
+	ks, err := adscraper.NewClient(ts.URL).GetKeywords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ks) != 2 {
+		t.Errorf("Expected paused keywords not to be scraped, got %v", len(ks))
+	}
+}
+
+func TestServerGroups(t *testing.T) {
+	ts, repos := newTestServer(t)
+
+	testCases := []struct {
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"POST", "/keywords", `[{"value":"women shoes","groups":["acme"],"tags":["shoes"]},{"value":"cheap flights","groups":["globex"]}]`, http.StatusCreated},
+		{"POST", "/keywords", `{"value":"hotels","groups":["` + strings.Repeat("a", keywords.MaxLabelLength+1) + `"]}`, http.StatusUnprocessableEntity},
+		{"PATCH", "/keywords/2", `{"tags":[" flights ",""]}`, http.StatusOK},
+	}
+	for i, tc := range testCases {
+		if got := do(t, tc.method, ts.URL+tc.path, tc.body).StatusCode; got != tc.want {
+			t.Errorf("(%v) %v %v: expected %v, got %v", i, tc.method, tc.path, tc.want, got)
+		}
+	}
+	k, _ := repos.Keywords.Find(2)
+	repos.Ads.Upsert(&adscraper.Ad{H1: "Cheap Flights", Path: "globex.com", Position: 1}, k)
+
+	get := func(path string) string {
+		var body interface{}
+		if err := json.NewDecoder(do(t, "GET", ts.URL+path, "").Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		b, _ := json.Marshal(body)
+		return string(b)
+	}
+	bodies := []struct {
+		path string
+		want string
+	}{
+		{"/groups", `[{"keywords":1,"name":"acme"},{"keywords":1,"name":"globex"}]`},
+		{"/tags", `[{"keywords":1,"name":"flights"},{"keywords":1,"name":"shoes"}]`},
+	}
+	for _, tc := range bodies {
+		if got := get(tc.path); got != tc.want {
+			t.Errorf("GET %v: expected %v, got %v", tc.path, tc.want, got)
+		}
+	}
+	if got := get("/keywords?group=globex&tag=flights"); !strings.Contains(got, `"groups":["globex"],"id":2`) {
+		t.Errorf("Expected the globex keyword, got %v", got)
+	}
+	if got := get("/ads?group=acme"); got != `{"ads":[]}` {
+		t.Errorf("Expected no acme ads, got %v", got)
+	}
+	if got := get("/ads?tag=flights"); !strings.Contains(got, `"id":1`) {
+		t.Errorf("Expected the ads seen for flights, got %v", got)
+	}
+
+	ks, err := adscraper.NewClient(ts.URL).GetGroupKeywords("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ks) != 1 || ks[0].Value != "women shoes" {
+		t.Errorf("Expected to scrape the acme keywords only, got %v", ks)
+	}
+}
+
+func TestServerAPIKeys(t *testing.T) {
+	repos := adscraper.NewMemoryRepositories()
+	keys := apikeys.NewMemoryReaderWriter()
+	ts := httptest.NewServer(adscraper.NewServer(repos).RequireAPIKeys(keys).Handler())
+	t.Cleanup(ts.Close)
+	repos.Keywords.Upsert(keywords.New("reebok women shoes"))
+
+	tokens := make(map[string]string)
+	for _, scope := range apikeys.Scopes {
+		_, token, err := keys.Create(scope+" key", scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[scope] = token
+	}
+	revoked, token, _ := keys.Create("old scraper", apikeys.Scraper)
+	keys.Revoke(revoked.ID)
+	tokens["revoked"] = token
+
+	adKeyword := `{"ad":{"h1":"Women Shoes","h2":"Reebok.com","desc":"Flash Sale","path":"www.reebok.com","position":1},"keyword":{"id":1}}`
+	testCases := []struct {
+		key    string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"", "GET", "/keywords", "", http.StatusUnauthorized},
+		{"revoked", "GET", "/keywords", "", http.StatusUnauthorized},
+		{"scraper", "GET", "/keywords", "", http.StatusOK},
+		{"scraper", "POST", "/ad_keywords", adKeyword, http.StatusCreated},
+		{"scraper", "PATCH", "/keywords/1", "", http.StatusOK},
+		{"scraper", "PATCH", "/keywords/1", `{"priority":5}`, http.StatusForbidden},
+		{"scraper", "GET", "/ads", "", http.StatusForbidden},
+		{"scraper", "POST", "/keywords", `{"value":"nike"}`, http.StatusForbidden},
+		{"analyst", "GET", "/ads", "", http.StatusOK},
+		{"analyst", "GET", "/groups", "", http.StatusOK},
+		{"", "GET", "/metrics", "", http.StatusUnauthorized},
+		{"scraper", "GET", "/metrics", "", http.StatusForbidden},
+		{"analyst", "GET", "/metrics", "", http.StatusOK},
+		{"analyst", "POST", "/ad_keywords", adKeyword, http.StatusForbidden},
+		{"analyst", "PATCH", "/keywords/1", "", http.StatusForbidden},
+		{"admin", "POST", "/keywords", `{"value":"nike"}`, http.StatusCreated},
+		{"admin", "PATCH", "/keywords/1", `{"priority":5}`, http.StatusOK},
+		{"admin", "DELETE", "/keywords/2", "", http.StatusOK},
+	}
+	for i, tc := range testCases {
+		req, _ := http.NewRequest(tc.method, ts.URL+tc.path, strings.NewReader(tc.body))
+		if tc.key == "analyst" {
+			req.Header.Set("X-API-Key", tokens[tc.key])
+		} else if tc.key != "" {
+			req.Header.Set("Authorization", "Bearer "+tokens[tc.key])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("(%v) %v %v %v: expected %v, got %v", i, tc.key, tc.method, tc.path, tc.want, resp.StatusCode)
+		}
+	}
+
+	if _, err := adscraper.NewClient(ts.URL).GetKeywords(); err == nil {
+		t.Error("Expected requests without a key to fail")
+	}
+	if _, err := adscraper.NewClient(ts.URL).WithAPIKey(tokens[apikeys.Scraper]).GetKeywords(); err != nil {
+		t.Error(err)
+	}
+}
+
+// writeCert writes a self-signed certificate for name and its key to dir.
+func writeCert(t *testing.T, dir string, name string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	files := map[string]*pem.Block{
+		"cert.pem": {Type: "CERTIFICATE", Bytes: der},
+		"key.pem":  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	}
+	for f, b := range files {
+		path := filepath.Join(dir, f)
+		if err = os.WriteFile(path, pem.EncodeToMemory(b), 0600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, modTime, modTime)
+	}
+}
+
+func TestServerServe(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, dir, "first", time.Now().Add(-time.Minute))
+	c := adscraper.ServerConfig{
+		CertFile:        filepath.Join(dir, "cert.pem"),
+		KeyFile:         filepath.Join(dir, "key.pem"),
+		ShutdownTimeout: time.Second,
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- adscraper.NewServer(adscraper.NewMemoryRepositories()).Serve(ctx, l, c) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}}
+	url := "https://" + l.Addr().String() + "/keywords"
+	served := func() string {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected %v, got %v", http.StatusOK, resp.StatusCode)
+		}
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	first := served()
+	writeCert(t, dir, "second", time.Now())
+	second := served()
+
+	cancel()
+	shutdownErr := <-errs
+	_, afterShutdown := client.Get(url)
+
+	c.KeyFile = ""
+	l, _ = net.Listen("tcp", "127.0.0.1:0")
+	missingKey := adscraper.NewServer(adscraper.NewMemoryRepositories()).Serve(context.Background(), l, c)
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{"first", first},
+		{"second", second},
+		{nil, shutdownErr},
+		{true, afterShutdown != nil},
+		{true, missingKey != nil},
+	}
+	for i, tc := range testCases {
+		if tc.got != tc.want {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	ts, repos := newTestServer(t)
+	repos.Keywords.Upsert(keywords.New("reebok women shoes"))
+
+	adKeyword := `{"ad":{"h1":"Women Shoes","h2":"Reebok.com","desc":"Flash Sale","path":"www.reebok.com","position":1},"keyword":{"id":1}}`
+	do(t, "POST", ts.URL+"/ad_keywords", adKeyword)
+	do(t, "POST", ts.URL+"/ad_keywords", `{"ad":`)
+	do(t, "PATCH", ts.URL+"/keywords/1", "")
+	do(t, "GET", ts.URL+"/missing", "")
+
+	resp := do(t, "GET", ts.URL+"/metrics", "")
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`adscraper_http_requests_total{code="201",method="POST",route="/ad_keywords"} 1`,
+		`adscraper_http_requests_total{code="400",method="POST",route="/ad_keywords"} 1`,
+		`adscraper_http_requests_total{code="200",method="PATCH",route="/keywords/{id}"} 1`,
+		`adscraper_http_requests_total{code="404",method="GET",route="other"} 1`,
+		`adscraper_http_request_duration_seconds_count{method="PATCH",route="/keywords/{id}"} 1`,
+		`adscraper_ingested_total{kind="ad",result="stored"} 1`,
+		`adscraper_ingested_total{kind="ad",result="failed"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Expected the metrics to contain %v", want)
+		}
+	}
+}
+
+func TestServerValidationErrors(t *testing.T) {
+	ts, repos := newTestServer(t)
+	repos.Keywords.Upsert(keywords.New("reebok women shoes"))
+
+	testCases := []struct {
+		body   string
+		status int
+		want   string
+	}{
+		{
+			`{"ad":{"h1":" ","desc":"Flash Sale","position":0,"block":"side"},"keyword":{"id":0}}`,
+			http.StatusUnprocessableEntity,
+			`{"message":"Validation failed","errors":[{"field":"ad.h1","message":"can't be blank"},{"field":"ad.position","message":"must be greater than 0"},{"field":"ad.block","message":"must be top or bottom"},{"field":"keyword.id","message":"is required"}]}`,
+		},
+		{
+			`{"ad":{"h1":"Women Shoes","desc":"` + strings.Repeat("a", adscraper.MaxDescLength+1) + `","position":1},"keyword":{"id":42}}`,
+			http.StatusUnprocessableEntity,
+			`{"message":"Validation failed","errors":[{"field":"ad.desc","message":"can't be longer than 1000 characters"},{"field":"keyword.id","message":"doesn't match a keyword"}]}`,
+		},
+		{
+			`{"ad":{"h1":"Women Shoes","position":"1"}}`,
+			http.StatusBadRequest,
+			`{"message":"Bad request","errors":[{"field":"ad.position","message":"must be a number"}]}`,
+		},
+		{`{"ad":`, http.StatusBadRequest, `{"message":"Bad request","errors":[{"message":"the body ends unexpectedly"}]}`},
+		{``, http.StatusBadRequest, `{"message":"Bad request","errors":[{"message":"the body can't be empty"}]}`},
+	}
+	for i, tc := range testCases {
+		resp := do(t, "POST", ts.URL+"/ad_keywords", tc.body)
+		body := map[string]interface{}{}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		id := resp.Header.Get("X-Request-ID")
+		if id == "" || body["requestId"] != id {
+			t.Errorf("(%v) Expected the request ID %q in the body, got %v", i, id, body["requestId"])
+		}
+		delete(body, "requestId")
+		if got, _ := json.Marshal(body); resp.StatusCode != tc.status || !jsonEqual(t, string(got), tc.want) {
+			t.Errorf("(%v) Expected %v %v, got %v %s", i, tc.status, tc.want, resp.StatusCode, got)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/keywords/42", nil)
+	req.Header.Set("X-Request-ID", "scraper-1.42")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Request-ID"); got != "scraper-1.42" {
+		t.Errorf("Expected the request ID to be passed through, got %v", got)
+	}
+}
+
+func jsonEqual(t *testing.T, a string, b string) bool {
+	var va, vb interface{}
+	if err := json.Unmarshal([]byte(a), &va); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(b), &vb); err != nil {
+		t.Fatal(err)
+	}
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return string(ja) == string(jb)
+}
+
+func TestServerVersions(t *testing.T) {
+	ts, repos := newTestServer(t)
+	k, _ := repos.Keywords.Upsert(keywords.New("reebok women shoes"))
+
+	legacy := do(t, "GET", ts.URL+"/keywords", "")
+	v1 := do(t, "GET", ts.URL+"/v1/keywords", "")
+	created := do(t, "POST", ts.URL+"/v2/ad_keywords", `{"ad":{"headlines":["Women Shoes","Reebok.com","Official Store"],"desc":"Flash Sale","position":1},"keyword":{"id":1}}`)
+	tooMany := do(t, "POST", ts.URL+"/v2/ad_keywords", `{"ad":{"headlines":["a","b","c","d"],"desc":"Flash Sale","position":1},"keyword":{"id":1}}`)
+	ad, _ := repos.Ads.Find(1)
+
+	// Headlines are stored as posted
+	sqlite := adscraper.NewRepositories(newSQLiteStore(t))
+	sk, _ := sqlite.Keywords.Upsert(keywords.New("reebok women shoes"))
+	posted := &adscraper.Ad{H1: "Women Shoes", H2: "Reebok.com - Official Store", Headlines: []string{"Women Shoes", "Reebok.com", "Official Store"}, Desc: "Flash Sale", Position: 1}
+	if err := sqlite.Ads.Upsert(posted, sk); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := sqlite.Ads.Find(posted.ID)
+
+	var page struct {
+		Ads []struct {
+			Headlines  []string `json:"headlines"`
+			H1         string   `json:"h1"`
+			Extensions []string `json:"extensions"`
+		} `json:"ads"`
+	}
+	if err := json.NewDecoder(do(t, "GET", ts.URL+"/v2/ads", "").Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	var details struct {
+		Observations []struct {
+			KeywordId int64 `json:"keywordId"`
+			Position  int   `json:"position"`
+		} `json:"observations"`
+	}
+	if err := json.NewDecoder(do(t, "GET", ts.URL+"/v2/ads/1", "").Body).Decode(&details); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{http.StatusOK, legacy.StatusCode},
+		{"true", legacy.Header.Get("Deprecation")},
+		{`</v1/keywords>; rel="successor-version"`, legacy.Header.Get("Link")},
+		{http.StatusOK, v1.StatusCode},
+		{"", v1.Header.Get("Deprecation")},
+		{http.StatusCreated, created.StatusCode},
+		{http.StatusUnprocessableEntity, tooMany.StatusCode},
+		{"Women Shoes", ad.H1},
+		{"Reebok.com - Official Store", ad.H2},
+		{"Women Shoes,Reebok.com,Official Store", strings.Join(ad.Headlines, ",")},
+		{"Women Shoes,Reebok.com,Official Store", strings.Join(stored.Headlines, ",")},
+		{1, len(page.Ads)},
+		{"Women Shoes,Reebok.com,Official Store", strings.Join(page.Ads[0].Headlines, ",")},
+		{"", page.Ads[0].H1},
+		{0, len(page.Ads[0].Extensions)},
+		{1, len(details.Observations)},
+		{k.ID, details.Observations[0].KeywordId},
+		{1, details.Observations[0].Position},
+	}
+	for i, tc := range testCases {
+		if tc.want != tc.got {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
+
+func TestClientVersions(t *testing.T) {
+	k := &keywords.Keyword{ID: 1, Value: "reebok women shoes"}
+	ad := &adscraper.Ad{H1: "Women Shoes", H2: "Reebok.com", Desc: "Flash Sale", Position: 1}
+
+	testCases := []struct {
+		supported []string
+		want      string
+		body      string
+	}{
+		{[]string{"v1", "v2"}, "v2", `"headlines":["Women Shoes","Reebok.com"]`},
+		{[]string{"v1", "v2", "v3"}, "v2", `"headlines":["Women Shoes","Reebok.com"]`},
+		{[]string{"v1"}, "v1", `"h1":"Women Shoes","h2":"Reebok.com"`},
+		// a server from before versioning
+		{nil, "", `"h1":"Women Shoes","h2":"Reebok.com"`},
+	}
+	for i, tc := range testCases {
+		var paths []string
+		var body []byte
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.Method+" "+r.URL.Path)
+			if r.URL.Path != "/versions" {
+				if r.Method == "POST" {
+					body, _ = io.ReadAll(r.Body)
+				}
+				w.WriteHeader(http.StatusCreated)
+			} else if tc.supported == nil {
+				w.WriteHeader(http.StatusNotFound)
+			} else {
+				json.NewEncoder(w).Encode(map[string]interface{}{"versions": tc.supported})
+			}
+		}))
+		client := adscraper.NewClient(ts.URL)
+		if err := client.PostAdKeywords(ad, k); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.PatchKeyword(k.ID); err != nil {
+			t.Fatal(err)
+		}
+		version, err := client.Version()
+		ts.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		prefix := ""
+		if tc.want != "" {
+			prefix = "/" + tc.want
+		}
+		if got, want := strings.Join(paths, ","), "GET /versions,POST "+prefix+"/ad_keywords,PATCH "+prefix+"/keywords/1"; got != want {
+			t.Errorf("(%v) Expected requests %v, got %v", i, want, got)
+		}
+		if version != tc.want {
+			t.Errorf("(%v) Expected version %v, got %v", i, tc.want, version)
+		}
+		if !strings.Contains(string(body), tc.body) {
+			t.Errorf("(%v) Expected the ad to contain %v, got %s", i, tc.body, body)
+		}
+	}
+}
+
+func TestServerExports(t *testing.T) {
+	ts, repos := newTestServer(t)
+	k, _ := repos.Keywords.Upsert(keywords.New("reebok women shoes"))
+	repos.Ads.Upsert(&adscraper.Ad{H1: "Women Shoes", H2: "Reebok.com", Desc: "Flash Sale, 50% off", Path: "www.reebok.com", Position: 1, Block: adscraper.BlockTop}, k)
+	repos.Ads.Upsert(&adscraper.Ad{H1: "Running", H2: "Nike", Desc: "Just do it", Path: "nike.com", Position: 2}, k)
+	ad, _ := repos.Ads.Find(1)
+	obs, _ := repos.Ads.GetObservations(1)
+
+	read := func(resp *http.Response) string {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+	csvAds := do(t, "GET", ts.URL+"/v1/exports/ads?advertiser=reebok.com", "")
+	ndjson := do(t, "GET", ts.URL+"/v2/exports/observations?format=ndjson&block=top", "")
+	req, _ := http.NewRequest("GET", ts.URL+"/v1/exports/ads?keyword=unknown", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	accepted, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Body.Close()
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{http.StatusOK, csvAds.StatusCode},
+		{"text/csv; charset=utf-8", csvAds.Header.Get("Content-Type")},
+		{`attachment; filename="ads.csv"`, csvAds.Header.Get("Content-Disposition")},
+		{"id,h1,h2,desc,path,createdAt,updatedAt\n1,Women Shoes,Reebok.com,\"Flash Sale, 50% off\",www.reebok.com," + ad.CreatedAt + "," + ad.UpdatedAt + "\n", read(csvAds)},
+		{"application/x-ndjson", ndjson.Header.Get("Content-Type")},
+		{`{"id":1,"adId":1,"keywordId":1,"position":1,"block":"top","observedAt":"` + obs[0].ObservedAt + `"}` + "\n", read(ndjson)},
+		{"application/x-ndjson", accepted.Header.Get("Content-Type")},
+		{"", read(accepted)},
+		{"id,adId,keywordId,pageId,position,block,observedAt\n", read(do(t, "GET", ts.URL+"/v1/exports/observations?from=2017-01-01&to=2017-01-02", ""))},
+		{http.StatusBadRequest, do(t, "GET", ts.URL+"/v1/exports/ads?format=xml", "").StatusCode},
+		{http.StatusBadRequest, do(t, "GET", ts.URL+"/v1/exports/ads?from=yesterday", "").StatusCode},
+	}
+	for i, tc := range testCases {
+		if tc.want != tc.got {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
+
+// slowAds exports its ads one every delay.
+type slowAds struct {
+	adscraper.AdReaderWriter
+	delay time.Duration
+}
+
+func (a *slowAds) ExportAds(q adscraper.AdQuery, fn func(*adscraper.Ad) error) error {
+	return a.AdReaderWriter.ExportAds(q, func(ad *adscraper.Ad) error {
+		time.Sleep(a.delay)
+		return fn(ad)
+	})
+}
+
+func TestServerLongExports(t *testing.T) {
+	repos := adscraper.NewMemoryRepositories()
+	k, _ := repos.Keywords.Upsert(keywords.New("reebok women shoes"))
+	for i := 0; i < 20; i++ {
+		desc := strconv.Itoa(i) + strings.Repeat(" Flash Sale", 90)
+		repos.Ads.Upsert(&adscraper.Ad{H1: "Women Shoes", H2: "Reebok.com", Desc: desc, Path: "www.reebok.com", Position: 1}, k)
+	}
+	repos.Ads = &slowAds{AdReaderWriter: repos.Ads, delay: 25 * time.Millisecond}
+
+	ts := httptest.NewUnstartedServer(adscraper.NewServer(repos).Handler())
+	ts.Config.WriteTimeout = 100 * time.Millisecond
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	// Every middleware of the handler has to let the deadlines through
+	for path, want := range map[string]int{
+		"/v1/exports/ads":               21,
+		"/v2/exports/ads?format=ndjson": 20,
+		"/exports/ads":                  21,
+	} {
+		start := time.Now()
+		resp := do(t, "GET", ts.URL+path, "")
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Expected the export of %v to outlast the write timeout, got %v", path, err)
+		}
+		if lines := strings.Count(string(body), "\n"); lines != want {
+			t.Errorf("Expected %v lines from %v, got %v", want, path, lines)
+		}
+		if elapsed := time.Since(start); elapsed < ts.Config.WriteTimeout {
+			t.Errorf("Expected the export of %v to take longer than %v, took %v", path, ts.Config.WriteTimeout, elapsed)
+		}
+	}
+}
+
+func TestServerWebhooks(t *testing.T) {
+	ts, repos := newTestServer(t)
+	k, _ := repos.Keywords.Upsert(keywords.New("reebok women shoes"))
+
+	decode := func(resp *http.Response, v interface{}) {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	created := struct {
+		ID         int64    `json:"id"`
+		Events     []string `json:"events"`
+		Advertiser string   `json:"advertiser"`
+		Secret     string   `json:"secret"`
+	}{}
+	resp := do(t, "POST", ts.URL+"/v1/webhooks", `{"url":"https://example.com/hooks","events":["ad.new","ad.new"],"advertiser":"www.Reebok.com"}`)
+	decode(resp, &created)
+	invalid := struct {
+		Errors []struct {
+			Field string `json:"field"`
+		} `json:"errors"`
+	}{}
+	decode(do(t, "POST", ts.URL+"/v1/webhooks", `{"url":"example.com","secret":"short","events":["ad.gone"]}`), &invalid)
+	fields := make([]string, 0)
+	for _, e := range invalid.Errors {
+		fields = append(fields, e.Field)
+	}
+	listed := make([]map[string]interface{}, 0)
+	decode(do(t, "GET", ts.URL+"/v1/webhooks", ""), &listed)
+
+	repos.Ads.Upsert(&adscraper.Ad{H1: "Women Shoes", H2: "Reebok.com", Desc: "Flash Sale", Path: "www.reebok.com", Position: 1}, k)
+	repos.Ads.Upsert(&adscraper.Ad{H1: "Running", H2: "Nike", Desc: "Just do it", Path: "nike.com", Position: 2}, k)
+	deliveries := make([]struct {
+		Event   string `json:"event"`
+		Status  string `json:"status"`
+		Payload struct {
+			Keyword struct {
+				Value string `json:"value"`
+			} `json:"keyword"`
+			Ad struct {
+				H1 string `json:"h1"`
+			} `json:"ad"`
+		} `json:"payload"`
+	}, 0)
+	decode(do(t, "GET", ts.URL+"/v2/webhooks/1/deliveries", ""), &deliveries)
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{http.StatusCreated, resp.StatusCode},
+		{int64(1), created.ID},
+		{"[ad.new]", fmt.Sprint(created.Events)},
+		{"reebok.com", created.Advertiser},
+		{true, strings.HasPrefix(created.Secret, "whsec_")},
+		{"[url secret events[0]]", fmt.Sprint(fields)},
+		{1, len(listed)},
+		{nil, listed[0]["secret"]},
+		{http.StatusOK, do(t, "GET", ts.URL+"/v1/webhooks/1", "").StatusCode},
+		{http.StatusNotFound, do(t, "GET", ts.URL+"/v1/webhooks/42", "").StatusCode},
+		{1, len(deliveries)},
+		{"ad.new pending reebok women shoes Women Shoes", fmt.Sprintf("%v %v %v %v", deliveries[0].Event, deliveries[0].Status, deliveries[0].Payload.Keyword.Value, deliveries[0].Payload.Ad.H1)},
+		{http.StatusBadRequest, do(t, "GET", ts.URL+"/v1/webhooks/1/deliveries?limit=5000", "").StatusCode},
+		{http.StatusOK, do(t, "DELETE", ts.URL+"/v1/webhooks/1", "").StatusCode},
+		{http.StatusNotFound, do(t, "DELETE", ts.URL+"/v1/webhooks/1", "").StatusCode},
+		{http.StatusNotFound, do(t, "GET", ts.URL+"/v1/webhooks/1/deliveries", "").StatusCode},
+	}
+	for i, tc := range testCases {
+		if tc.want != tc.got {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	repos := adscraper.NewMemoryRepositories()
+	srv := adscraper.NewServer(repos)
+	srv.Feed().Heartbeat = 20 * time.Millisecond
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	repos.Keywords.Upsert(keywords.New("reebok women shoes"))
+	repos.Keywords.Upsert(keywords.New("nike basketball"))
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	stream := func(path string) (*http.Response, *bufio.Reader) {
+		resp, err := client.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp, bufio.NewReader(resp.Body)
+	}
+	// events reads n events, besides heartbeats, and the heartbeats before
+	// them.
+	events := func(r *bufio.Reader, n int) ([]string, int) {
+		evs, heartbeats, lines := make([]string, 0), 0, make([]string, 0)
+		for len(evs) < n {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return append(evs, err.Error()), heartbeats
+			}
+			if line = strings.TrimSuffix(line, "\n"); line != "" {
+				lines = append(lines, line)
+				continue
+			}
+			if len(lines) == 1 && lines[0] == ": heartbeat" {
+				heartbeats++
+			} else if len(lines) > 0 && !strings.HasPrefix(lines[0], "retry:") {
+				evs = append(evs, strings.Join(lines, "\n"))
+			}
+			lines = lines[:0]
+		}
+		return evs, heartbeats
+	}
+	type liveAd struct {
+		Ad struct {
+			ID        int64    `json:"id"`
+			H1        string   `json:"h1"`
+			Headlines []string `json:"headlines"`
+		} `json:"ad"`
+		Keyword struct {
+			ID    int64  `json:"id"`
+			Value string `json:"value"`
+		} `json:"keyword"`
+		Position int    `json:"position"`
+		Block    string `json:"block"`
+	}
+	data := func(event string) *liveAd {
+		a := &liveAd{}
+		for _, line := range strings.Split(event, "\n") {
+			if strings.HasPrefix(line, "data: ") {
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), a); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		return a
+	}
+
+	all, allEvents := stream("/v2/stream/ads")
+	_, reebokEvents := stream("/v1/stream/ads?advertiser=reebok.com")
+	_, nikeEvents := stream("/stream/ads?keyword=nike+basketball")
+	time.Sleep(50 * time.Millisecond)
+	for _, body := range []string{
+		`{"ad":{"h1":"Women Shoes","h2":"Reebok.com","desc":"Flash Sale","path":"www.reebok.com","position":1,"block":"top"},"keyword":{"id":1}}`,
+		`{"ad":{"h1":"Basketball","h2":"Nike","desc":"Just do it","path":"nike.com","position":2,"block":"top"},"keyword":{"id":2}}`,
+		`{"ad":{"h1":"Court Shoes","h2":"Reebok.com","desc":"New season","path":"shop.reebok.com/court","position":3,"block":"bottom"},"keyword":{"id":2}}`,
+	} {
+		if resp := do(t, "POST", ts.URL+"/v1/ad_keywords", body); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected %v, got %v", http.StatusCreated, resp.StatusCode)
+		}
+	}
+	got, heartbeats := events(allEvents, 3)
+	reebok, _ := events(reebokEvents, 2)
+	nike, _ := events(nikeEvents, 2)
+	first := data(got[0])
+
+	srv.Feed().Close()
+	closed, _ := events(allEvents, 1)
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{http.StatusOK, all.StatusCode},
+		{"text/event-stream", all.Header.Get("Content-Type")},
+		{true, heartbeats > 0},
+		{true, strings.HasPrefix(got[0], "id: 1\nevent: observation\n")},
+		{true, first.Ad.ID > 0},
+		{"[Women Shoes Reebok.com]", fmt.Sprint(first.Ad.Headlines)},
+		{"1 reebok women shoes 1 top", fmt.Sprintf("%v %v %v %v", first.Keyword.ID, first.Keyword.Value, first.Position, first.Block)},
+		{"Basketball Court Shoes", data(got[1]).Ad.Headlines[0] + " " + data(got[2]).Ad.Headlines[0]},
+		{"Women Shoes Court Shoes", data(reebok[0]).Ad.H1 + " " + data(reebok[1]).Ad.H1},
+		{"Basketball 2 Court Shoes 3", fmt.Sprintf("%v %v %v %v", data(nike[0]).Ad.H1, data(nike[0]).Position, data(nike[1]).Ad.H1, data(nike[1]).Position)},
+		{"[EOF]", fmt.Sprint(closed)},
+		{http.StatusBadRequest, do(t, "GET", ts.URL+"/v1/stream/ads?keywordId=x", "").StatusCode},
+		{http.StatusNotFound, do(t, "GET", ts.URL+"/v1/stream/ads?keyword=cheap+flights", "").StatusCode},
+	}
+	for i, tc := range testCases {
+		if tc.want != tc.got {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
+
+// unflushedWriter is a response writer that can't be flushed.
+type unflushedWriter struct {
+	header http.Header
+	body   strings.Builder
+}
+
+func (w *unflushedWriter) Header() http.Header         { return w.header }
+func (w *unflushedWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
+func (w *unflushedWriter) WriteHeader(status int)      {}
+
+func TestServerStreamUnflushed(t *testing.T) {
+	srv := adscraper.NewServer(adscraper.NewMemoryRepositories())
+	w := &unflushedWriter{header: make(http.Header)}
+	done := make(chan struct{})
+	go func() {
+		srv.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/v1/stream/ads", nil))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the stream to end when it can't be flushed")
+	}
+	if body := w.body.String(); !strings.HasPrefix(body, "retry: ") {
+		t.Errorf("Expected the retry event, got %q", body)
+	}
+}
+
+func TestServerDashboard(t *testing.T) {
+	repos := adscraper.NewMemoryRepositories()
+	keys := apikeys.NewMemoryReaderWriter()
+	ts := httptest.NewServer(adscraper.NewServer(repos).RequireAPIKeys(keys).Handler())
+	t.Cleanup(ts.Close)
+	_, analyst, _ := keys.Create("analyst key", apikeys.Analyst)
+	_, scraper, _ := keys.Create("scraper key", apikeys.Scraper)
+
+	k, _ := repos.Keywords.Create(&keywords.Keyword{Value: "reebok women shoes", Groups: []string{"acme"}})
+	repos.Keywords.Create(&keywords.Keyword{Value: "nike basketball", Paused: true})
+	ad := &adscraper.Ad{H1: "Women Shoes", H2: "Reebok.com", Desc: "Flash Sale", Path: "www.reebok.com/shoes", Position: 1, Block: adscraper.BlockTop}
+	ad.SetRaw(`<li><script>alert("x")</script>Women Shoes</li>`)
+	repos.Ads.Upsert(ad, k)
+	repos.Ads.Upsert(&adscraper.Ad{H1: "Basketball", Desc: "Just do it", Path: "nike.com", Position: 1, Block: adscraper.BlockBottom}, k)
+
+	noRedirects := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	get := func(path string, password string) (*http.Response, string) {
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		if password != "" {
+			req.SetBasicAuth("", password)
+		}
+		resp, err := noRedirects.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+	page := func(path string) string {
+		resp, body := get(path, analyst)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/html; charset=utf-8" {
+			t.Errorf("%v: got %v %v", path, resp.Status, resp.Header.Get("Content-Type"))
+		}
+		return body
+	}
+
+	unauthorized, _ := get("/ui/keywords", "")
+	forbidden, _ := get("/ui/keywords", scraper)
+	redirect, _ := get("/ui", "")
+	found, _ := get("/ui/advertisers?domain=www.Reebok.com", analyst)
+	missing, missingBody := get("/ui/ads/42", analyst)
+	css, _ := get("/ui/static/style.css", "")
+	keywordsPage := page("/ui/keywords")
+	paused := page("/ui/keywords?paused=true")
+	timeline := page(fmt.Sprintf("/ui/keywords/%v", k.ID))
+	adPage := page("/ui/ads/1")
+	advertiser := page("/ui/advertisers/reebok.com")
+	health := page("/ui/health")
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{http.StatusUnauthorized, unauthorized.StatusCode},
+		{true, strings.HasPrefix(unauthorized.Header.Get("WWW-Authenticate"), "Basic ")},
+		{http.StatusForbidden, forbidden.StatusCode},
+		{"/ui/keywords", redirect.Header.Get("Location")},
+		{"/ui/advertisers/reebok.com", found.Header.Get("Location")},
+		{http.StatusNotFound, missing.StatusCode},
+		{true, strings.Contains(missingBody, "404 Not Found")},
+		{"text/css; charset=utf-8", css.Header.Get("Content-Type")},
+		{true, strings.Contains(keywordsPage, `<a href="/ui/keywords/1">reebok women shoes</a>`)},
+		{true, strings.Contains(keywordsPage, `<span class="status due">due</span>`)},
+		{true, strings.Contains(keywordsPage, `<span class="status paused">paused</span>`)},
+		{false, strings.Contains(paused, "reebok women shoes")},
+		// Both ads were observed in the same scrape
+		{1, strings.Count(timeline, `<ol class="serp">`) / 2},
+		{true, strings.Contains(timeline, `<a class="advertiser" href="/ui/advertisers/nike.com">nike.com</a>`)},
+		{true, strings.Contains(adPage, `<iframe class="raw" sandbox srcdoc="&lt;li&gt;&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;Women Shoes&lt;/li&gt;"`)},
+		{false, strings.Contains(adPage, "<script>")},
+		{true, strings.Contains(adPage, `<a href="/ui/keywords/1">reebok women shoes</a>`)},
+		{true, strings.Contains(advertiser, `<a href="/ui/ads/1">Women Shoes | Reebok.com</a>`)},
+		{false, strings.Contains(advertiser, "Basketball")},
+		{true, strings.Contains(advertiser, `<td class="num">100%</td>`)},
+		{true, strings.Contains(health, "<tr><th>Keywords due</th><td>1</td></tr>")},
+	}
+	for i, tc := range testCases {
+		if tc.want != tc.got {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
+
+func TestServerAnalytics(t *testing.T) {
+	ts, repos := newTestServer(t)
+	shoes, _ := repos.Keywords.Create(&keywords.Keyword{Value: "reebok women shoes", Groups: []string{"acme"}})
+	basketball, _ := repos.Keywords.Create(&keywords.Keyword{Value: "nike basketball", Groups: []string{"acme"}})
+	running, _ := repos.Keywords.Create(&keywords.Keyword{Value: "running shoes"})
+	repos.Ads.Upsert(&adscraper.Ad{H1: "Women Shoes", Desc: "Flash Sale", Path: "www.reebok.com", Position: 1, Block: adscraper.BlockTop}, shoes)
+	repos.Ads.Upsert(&adscraper.Ad{H1: "Basketball", Desc: "Just do it", Path: "nike.com", Position: 2, Block: adscraper.BlockBottom}, shoes)
+	repos.Ads.Upsert(&adscraper.Ad{H1: "Basketball", Desc: "Just do it", Path: "nike.com", Position: 1, Block: adscraper.BlockTop}, basketball)
+	repos.Ads.Upsert(&adscraper.Ad{H1: "Running", Desc: "Impossible is nothing", Path: "adidas.com", Position: 1, Block: adscraper.BlockTop}, running)
+
+	type stats struct {
+		Advertiser      string         `json:"advertiser"`
+		SERPs           int            `json:"serps"`
+		ImpressionShare float64        `json:"impressionShare"`
+		AveragePosition float64        `json:"averagePosition"`
+		TopRate         float64        `json:"topRate"`
+		Positions       map[string]int `json:"positions"`
+	}
+	format := func(ss []stats) string {
+		lines := make([]string, 0)
+		for _, s := range ss {
+			lines = append(lines, fmt.Sprintf("%v %v %v %v %v %v", s.Advertiser, s.SERPs, s.ImpressionShare, s.AveragePosition, s.TopRate, s.Positions))
+		}
+		return strings.Join(lines, ",")
+	}
+	sov := struct {
+		SERPs       int     `json:"serps"`
+		Advertisers []stats `json:"advertisers"`
+	}{}
+	if err := json.NewDecoder(do(t, "GET", ts.URL+"/v1/analytics/share-of-voice?group=acme", "").Body).Decode(&sov); err != nil {
+		t.Fatal(err)
+	}
+	positions := struct {
+		Keywords []struct {
+			KeywordId   int64   `json:"keywordId"`
+			Keyword     string  `json:"keyword"`
+			SERPs       int     `json:"serps"`
+			Advertisers []stats `json:"advertisers"`
+		} `json:"keywords"`
+	}{}
+	if err := json.NewDecoder(do(t, "GET", ts.URL+"/v1/analytics/positions?advertiser=nike.com", "").Body).Decode(&positions); err != nil {
+		t.Fatal(err)
+	}
+	keywordPositions := make([]string, 0)
+	for _, kp := range positions.Keywords {
+		keywordPositions = append(keywordPositions, fmt.Sprintf("%v %v %v [%v]", kp.KeywordId, kp.Keyword, kp.SERPs, format(kp.Advertisers)))
+	}
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{2, sov.SERPs},
+		{"nike.com 2 1 1.5 0.5 map[1:1 2:1],reebok.com 1 0.5 1 1 map[1:1]", format(sov.Advertisers)},
+		{strings.Join([]string{
+			"1 reebok women shoes 1 [nike.com 1 1 2 0 map[2:1]]",
+			"2 nike basketball 1 [nike.com 1 1 1 1 map[1:1]]",
+			"3 running shoes 1 []",
+		}, ","), strings.Join(keywordPositions, ",")},
+		{http.StatusBadRequest, do(t, "GET", ts.URL+"/v1/analytics/share-of-voice?from=2017-05-31&to=2017-05-01", "").StatusCode},
+		{http.StatusBadRequest, do(t, "GET", ts.URL+"/v1/analytics/share-of-voice?from=2016-01-01&to=2017-05-01", "").StatusCode},
+		{http.StatusOK, do(t, "GET", ts.URL+"/v1/analytics/share-of-voice?from=2017-01-01&to=2017-05-01", "").StatusCode},
+		{http.StatusOK, do(t, "GET", ts.URL+"/v1/analytics/positions?keyword=cheap+flights", "").StatusCode},
+	}
+	for i, tc := range testCases {
+		if tc.want != tc.got {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
+
+func TestServerBrandBidding(t *testing.T) {
+	ts, repos := newTestServer(t)
+	k, _ := repos.Keywords.Create(&keywords.Keyword{Value: "reebok women shoes"})
+	decode := func(resp *http.Response, v interface{}) {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	created := struct {
+		ID         int64    `json:"id"`
+		Advertiser string   `json:"advertiser"`
+		Terms      []string `json:"terms"`
+	}{}
+	resp := do(t, "POST", ts.URL+"/v1/brands", `{"advertiser":"www.Reebok.com","terms":["reebok"," Reebok ","rbk"]}`)
+	decode(resp, &created)
+	do(t, "POST", ts.URL+"/v1/webhooks", `{"url":"mailto:brand@reebok.com","events":["brand.bidding"],"advertiser":"reebok.com"}`)
+
+	ad := &adscraper.Ad{H1: "Women Shoes", Desc: "Just do it", Path: "nike.com", Position: 2, Block: adscraper.BlockTop}
+	ad.SetRaw(`<li>Women Shoes</li>`)
+	repos.Ads.Upsert(ad, k)
+	repos.Ads.Upsert(&adscraper.Ad{H1: "Women Shoes", Desc: "Flash Sale", Path: "www.reebok.com", Position: 1, Block: adscraper.BlockTop}, k)
+	repos.Ads.Upsert(ad, k)
+
+	type incident struct {
+		Brand      string `json:"brand"`
+		Term       string `json:"term"`
+		Keyword    string `json:"keyword"`
+		Advertiser string `json:"advertiser"`
+		Position   int    `json:"position"`
+		Evidence   string `json:"evidence"`
+	}
+	format := func(is []incident) string {
+		lines := make([]string, 0)
+		for _, i := range is {
+			lines = append(lines, fmt.Sprintf("%v %v %v %v %v %v", i.Brand, i.Term, i.Keyword, i.Advertiser, i.Position, i.Evidence))
+		}
+		return strings.Join(lines, ",")
+	}
+	all, byBrand, byBidder, none := []incident{}, []incident{}, []incident{}, []incident{}
+	decode(do(t, "GET", ts.URL+"/v1/brand-bidding", ""), &all)
+	decode(do(t, "GET", ts.URL+"/v1/brand-bidding?brand=reebok.com", ""), &byBrand)
+	decode(do(t, "GET", ts.URL+"/v1/brand-bidding?advertiser=www.nike.com", ""), &byBidder)
+	decode(do(t, "GET", ts.URL+"/v1/brand-bidding?brand=adidas.com", ""), &none)
+	deliveries := make([]struct {
+		Event string `json:"event"`
+	}, 0)
+	decode(do(t, "GET", ts.URL+"/v1/webhooks/1/deliveries", ""), &deliveries)
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{http.StatusCreated, resp.StatusCode},
+		{"reebok.com [reebok rbk]", fmt.Sprint(created.Advertiser, " ", created.Terms)},
+		{http.StatusConflict, do(t, "POST", ts.URL+"/v1/brands", `{"advertiser":"reebok.com","terms":["reebok"]}`).StatusCode},
+		{http.StatusUnprocessableEntity, do(t, "POST", ts.URL+"/v1/brands", `{"advertiser":"adidas.com","terms":[]}`).StatusCode},
+		{"reebok.com reebok reebok women shoes nike.com 2 <li>Women Shoes</li>", format(all)},
+		{format(all), format(byBrand)},
+		{format(all), format(byBidder)},
+		{0, len(none)},
+		{1, len(deliveries)},
+		{"brand.bidding", deliveries[0].Event},
+		{http.StatusOK, do(t, "GET", ts.URL+"/v1/brand-bidding/1", "").StatusCode},
+		{http.StatusBadRequest, do(t, "GET", ts.URL+"/v1/brand-bidding?limit=5000", "").StatusCode},
+		{http.StatusOK, do(t, "GET", ts.URL+"/ui/brand-bidding?brand=reebok.com", "").StatusCode},
+		{http.StatusNotFound, do(t, "GET", ts.URL+"/ui/brand-bidding?brand=adidas.com", "").StatusCode},
+		{http.StatusOK, do(t, "DELETE", ts.URL+"/v1/brands/1", "").StatusCode},
+		{http.StatusNotFound, do(t, "GET", ts.URL+"/v1/brand-bidding/1", "").StatusCode},
+	}
+	for i, tc := range testCases {
+		if tc.want != tc.got {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
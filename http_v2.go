@@ -0,0 +1,191 @@
+package adscraper
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gkats/adscraper/keywords"
+)
+
+// API versions. v1 keeps the original payloads, v2 has the richer ad models
+// with headlines, extensions and observations.
+const (
+	V1 = "v1"
+	V2 = "v2"
+)
+
+// Versions are the API versions the server serves, oldest first.
+var Versions = []string{V1, V2}
+
+// MaxHeadlines is the most headlines a v2 ad can have. They're stored as they
+// were posted, and for v1 as the first headline and the rest joined with
+// " - ", like results pages show them.
+const MaxHeadlines = 3
+
+type versionsJSON struct {
+	Versions []string `json:"versions"`
+	Latest   string   `json:"latest"`
+}
+
+type adV2JSON struct {
+	Headlines []string `json:"headlines"`
+	Desc      string   `json:"desc"`
+	Path      string   `json:"path"`
+	Raw       string   `json:"raw"`
+	Rest      string   `json:"rest"`
+	Position  int      `json:"position"`
+	Block     string   `json:"block,omitempty"`
+	PageId    int64    `json:"pageId,omitempty"`
+}
+
+type adWithKeywordV2JSON struct {
+	Ad      adV2JSON    `json:"ad"`
+	Keyword keywordJSON `json:"keyword"`
+}
+
+func headlines(ad *Ad) []string {
+	if len(ad.Headlines) > 0 {
+		return ad.Headlines
+	}
+	if ad.H2 == "" {
+		return []string{ad.H1}
+	}
+	return []string{ad.H1, ad.H2}
+}
+
+func newAdWithKeywordV2JSON(ad *Ad, keyword *keywords.Keyword) *adWithKeywordV2JSON {
+	return &adWithKeywordV2JSON{
+		Ad: adV2JSON{
+			Headlines: headlines(ad),
+			Desc:      ad.Desc,
+			Path:      ad.Path,
+			Raw:       ad.GetRaw(),
+			Rest:      ad.GetRest(),
+			Position:  ad.Position,
+			Block:     ad.Block,
+			PageId:    ad.PageId,
+		},
+		Keyword: newKeywordJSON(keyword),
+	}
+}
+
+// v1 returns the v1 payload with the same ad.
+func (p *adWithKeywordV2JSON) v1() *adWithKeywordJSON {
+	a := p.Ad
+	ad := adJSON{Desc: a.Desc, Path: a.Path, Raw: a.Raw, Rest: a.Rest, Position: a.Position, Block: a.Block, PageId: a.PageId}
+	if len(a.Headlines) > 0 {
+		ad.H1, ad.H2 = a.Headlines[0], strings.Join(a.Headlines[1:], " - ")
+	}
+	return &adWithKeywordJSON{Ad: ad, Keyword: p.Keyword}
+}
+
+func (p *adWithKeywordV2JSON) validate() []fieldError {
+	errs := make([]fieldError, 0)
+	hs := p.Ad.Headlines
+	if len(hs) == 0 || strings.TrimSpace(hs[0]) == "" {
+		errs = append(errs, fieldError{Field: "ad.headlines", Message: "needs at least one headline"})
+	} else if len(hs) > MaxHeadlines {
+		errs = append(errs, fieldError{Field: "ad.headlines", Message: "can't have more than " + strconv.Itoa(MaxHeadlines) + " headlines"})
+	}
+	for i, h := range hs {
+		if len(h) > MaxHeadlineLength {
+			errs = append(errs, fieldError{
+				Field:   "ad.headlines[" + strconv.Itoa(i) + "]",
+				Message: "can't be longer than " + strconv.Itoa(MaxHeadlineLength) + " characters",
+			})
+		}
+	}
+	// the headlines were checked above
+	for _, e := range p.v1().validate() {
+		if e.Field != "ad.h1" && e.Field != "ad.h2" {
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
+
+type storedAdV2JSON struct {
+	ID         int64    `json:"id"`
+	Headlines  []string `json:"headlines"`
+	Desc       string   `json:"desc"`
+	Path       string   `json:"path"`
+	Extensions []string `json:"extensions"`
+	CreatedAt  string   `json:"createdAt"`
+	UpdatedAt  string   `json:"updatedAt"`
+}
+
+func newStoredAdV2JSON(ad *Ad) storedAdV2JSON {
+	return storedAdV2JSON{
+		ID:         ad.ID,
+		Headlines:  headlines(ad),
+		Desc:       ad.Desc,
+		Path:       ad.Path,
+		Extensions: ad.Extensions(),
+		CreatedAt:  ad.CreatedAt,
+		UpdatedAt:  ad.UpdatedAt,
+	}
+}
+
+type adsPageV2JSON struct {
+	Ads  []storedAdV2JSON `json:"ads"`
+	Next string           `json:"next,omitempty"`
+}
+
+type observationJSON struct {
+	KeywordId  int64  `json:"keywordId"`
+	PageId     int64  `json:"pageId,omitempty"`
+	Position   int    `json:"position"`
+	Block      string `json:"block,omitempty"`
+	ObservedAt string `json:"observedAt"`
+}
+
+type adDetailsV2JSON struct {
+	storedAdV2JSON
+	Sightings    []sightingJSON    `json:"sightings"`
+	Observations []observationJSON `json:"observations"`
+}
+
+func newAdDetailsV2JSON(ad *Ad, aks []AdKeyword, obs []Observation) *adDetailsV2JSON {
+	d := &adDetailsV2JSON{
+		storedAdV2JSON: newStoredAdV2JSON(ad),
+		Sightings:      newAdDetailsJSON(ad, aks).Sightings,
+		Observations:   make([]observationJSON, 0, len(obs)),
+	}
+	for _, o := range obs {
+		d.Observations = append(d.Observations, observationJSON{
+			KeywordId:  o.KeywordId,
+			PageId:     o.PageId,
+			Position:   o.Position,
+			Block:      o.Block,
+			ObservedAt: o.ObservedAt,
+		})
+	}
+	return d
+}
+
+func versions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, ok(&versionsJSON{Versions: Versions, Latest: Versions[len(Versions)-1]}))
+	}
+}
+
+// deprecated marks responses of the unprefixed routes as deprecated, and
+// points to the same route under /v1.
+func deprecated(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Add("Link", "</"+V1+r.URL.Path+`>; rel="successor-version"`)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// unversioned returns the route without its version prefix.
+func unversioned(route string) string {
+	for _, v := range Versions {
+		if strings.HasPrefix(route, "/"+v+"/") {
+			return strings.TrimPrefix(route, "/"+v)
+		}
+	}
+	return route
+}
@@ -0,0 +1,164 @@
+package keywords
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Expansion generates keywords from seed terms and lists of modifiers.
+// Templates combine them through placeholders: {seed} for the seeds and
+// {name} for the list with that name, like "{modifier} {seed} {city}". Every
+// combination of the lists in a template is generated.
+type Expansion struct {
+	Seeds []string
+	Lists map[string][]string
+	// Templates default to "{seed}", along with "{name} {seed}" and
+	// "{seed} {name}" for each list.
+	Templates []string
+	// Plurals adds the seeds with their last word pluralized, "book flight"
+	// gives "book flights".
+	Plurals bool
+	// Reorder adds the seeds with their words in every other order, up to
+	// MaxReorderWords words.
+	Reorder bool
+}
+
+const MaxReorderWords = 3
+
+var placeholder = regexp.MustCompile(`\{(\w+)\}`)
+
+// Validate checks that every placeholder has a list.
+func (e *Expansion) Validate() error {
+	for _, t := range e.templates() {
+		for _, m := range placeholder.FindAllStringSubmatch(t, -1) {
+			if _, ok := e.Lists[m[1]]; !ok && m[1] != "seed" {
+				return fmt.Errorf("template %q uses {%v}, but there's no %v list", t, m[1], m[1])
+			}
+		}
+	}
+	return nil
+}
+
+func (e *Expansion) templates() []string {
+	if len(e.Templates) > 0 {
+		return e.Templates
+	}
+	names := make([]string, 0, len(e.Lists))
+	for name := range e.Lists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ts := []string{"{seed}"}
+	for _, name := range names {
+		ts = append(ts, "{"+name+"} {seed}", "{seed} {"+name+"}")
+	}
+	return ts
+}
+
+// Keywords returns the normalized keywords, without duplicates, in the order
+// they're generated.
+func (e *Expansion) Keywords() []string {
+	lists := make(map[string][]string, len(e.Lists)+1)
+	for name, l := range e.Lists {
+		lists[name] = l
+	}
+	lists["seed"] = e.seeds()
+
+	ks := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, t := range e.templates() {
+		for _, k := range expand(t, lists) {
+			if k = Normalize(k); k != "" && !seen[k] {
+				seen[k] = true
+				ks = append(ks, k)
+			}
+		}
+	}
+	return ks
+}
+
+// seeds returns the seeds along with their plural and word order variants.
+func (e *Expansion) seeds() []string {
+	seeds := make([]string, 0, len(e.Seeds))
+	for _, s := range e.Seeds {
+		variants := []string{s}
+		if e.Plurals {
+			variants = append(variants, pluralize(s))
+		}
+		if e.Reorder {
+			for _, v := range append([]string{}, variants...) {
+				variants = append(variants, reorder(v)...)
+			}
+		}
+		seeds = append(seeds, variants...)
+	}
+	return seeds
+}
+
+// expand fills in the first placeholder of t with each value of its list,
+// and the rest of them recursively.
+func expand(t string, lists map[string][]string) []string {
+	loc := placeholder.FindStringSubmatchIndex(t)
+	if loc == nil {
+		return []string{t}
+	}
+	ks := make([]string, 0)
+	for _, v := range lists[t[loc[2]:loc[3]]] {
+		ks = append(ks, expand(t[:loc[0]]+v+t[loc[1]:], lists)...)
+	}
+	return ks
+}
+
+// pluralize applies the regular English plural rules to the last word of s.
+// Words ending in a plain "s" are taken to be plural already, "flights", while
+// "ss" and "us" endings aren't, "pass" or "bus".
+func pluralize(s string) string {
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		return s
+	}
+	w := words[len(words)-1]
+	switch {
+	case strings.HasSuffix(w, "ss") || strings.HasSuffix(w, "us") || strings.HasSuffix(w, "x") || strings.HasSuffix(w, "z") ||
+		strings.HasSuffix(w, "ch") || strings.HasSuffix(w, "sh"):
+		w += "es"
+	case strings.HasSuffix(w, "s"):
+	case strings.HasSuffix(w, "y") && len(w) > 1 && !strings.ContainsAny(w[len(w)-2:len(w)-1], "aeiou"):
+		w = w[:len(w)-1] + "ies"
+	default:
+		w += "s"
+	}
+	words[len(words)-1] = w
+	return strings.Join(words, " ")
+}
+
+// reorder returns the other orders of the words in s.
+func reorder(s string) []string {
+	words := strings.Fields(s)
+	if len(words) < 2 || len(words) > MaxReorderWords {
+		return nil
+	}
+	orders := make([]string, 0)
+	for _, p := range permutations(words) {
+		if o := strings.Join(p, " "); o != strings.Join(words, " ") {
+			orders = append(orders, o)
+		}
+	}
+	return orders
+}
+
+func permutations(words []string) [][]string {
+	if len(words) <= 1 {
+		return [][]string{words}
+	}
+	ps := make([][]string, 0)
+	for i, w := range words {
+		rest := append(append([]string{}, words[:i]...), words[i+1:]...)
+		for _, p := range permutations(rest) {
+			ps = append(ps, append([]string{w}, p...))
+		}
+	}
+	return ps
+}
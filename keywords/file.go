@@ -0,0 +1,374 @@
+package keywords
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Formats of keyword files. Lines files hold a keyword per line, CSV and TSV
+// files start with a header naming the columns and JSON lines files hold an
+// object per line.
+const (
+	Lines = "lines"
+	CSV   = "csv"
+	TSV   = "tsv"
+	JSONL = "jsonl"
+)
+
+// Columns are the keyword fields a file can set.
+var Columns = []string{"value", "locale", "device", "group", "tags", "priority", "cadence"}
+
+// FormatOf guesses the format of a keyword file from its name.
+func FormatOf(filename string) string {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".csv":
+		return CSV
+	case ".tsv", ".tab":
+		return TSV
+	case ".jsonl", ".ndjson":
+		return JSONL
+	}
+	return Lines
+}
+
+// Normalize collapses whitespace in values. Searches aren't case sensitive,
+// so values are lowercased too.
+func Normalize(value string) string {
+	return strings.ToLower(strings.Join(strings.Fields(value), " "))
+}
+
+// Record is a keyword read from a file.
+type Record struct {
+	Line    int
+	Keyword *Keyword
+	// Columns holds the fields the file sets. Existing keywords only get
+	// these.
+	Columns map[string]bool
+	Err     error
+}
+
+// Read reads the keywords in r. Records that can't be parsed or aren't valid
+// keywords have an error, problems with the file as a whole are returned.
+func Read(r io.Reader, format string) ([]Record, error) {
+	var (
+		records []Record
+		err     error
+	)
+	switch format {
+	case Lines:
+		records, err = readLines(r)
+	case CSV:
+		records, err = readCSV(r, ',')
+	case TSV:
+		records, err = readCSV(r, '\t')
+	case JSONL:
+		records, err = readJSONL(r)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	for i := range records {
+		rec := &records[i]
+		if rec.Err == nil {
+			rec.Keyword.Value = Normalize(rec.Keyword.Value)
+			rec.Keyword.Groups, rec.Keyword.Tags = Labels(rec.Keyword.Groups...), Labels(rec.Keyword.Tags...)
+			rec.Err = rec.Keyword.Validate()
+		}
+	}
+	return records, err
+}
+
+// readLines reads a keyword per line, escaped like in search URLs,
+// "book+a+flight", as keywords.dat has always been.
+func readLines(r io.Reader) ([]Record, error) {
+	records := make([]Record, 0)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		v := strings.TrimSpace(scanner.Text())
+		if v == "" || strings.HasPrefix(v, "#") {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(v); err == nil {
+			v = unescaped
+		}
+		records = append(records, Record{Line: line, Keyword: New(v), Columns: map[string]bool{"value": true}})
+	}
+	return records, scanner.Err()
+}
+
+func readCSV(r io.Reader, comma rune) ([]Record, error) {
+	cr := csv.NewReader(r)
+	cr.Comma, cr.Comment, cr.FieldsPerRecord = comma, '#', -1
+	cr.LazyQuotes = comma == '\t'
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return []Record{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for i, name := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(name))
+		if header[i] == "keyword" {
+			header[i] = "value"
+		} else if header[i] == "groups" {
+			header[i] = "group"
+		}
+		if !isIn(Columns, header[i]) {
+			return nil, fmt.Errorf("unknown column %q, expected some of %v", name, strings.Join(Columns, ", "))
+		}
+	}
+	if !isIn(header, "value") {
+		return nil, errors.New("missing the value column")
+	}
+
+	records := make([]Record, 0)
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return records, err
+		}
+		line, _ := cr.FieldPos(0)
+		rec := Record{Line: line, Keyword: &Keyword{}, Columns: make(map[string]bool)}
+		for i, v := range row {
+			if i >= len(header) {
+				rec.Err = fmt.Errorf("expected %v columns, got %v", len(header), len(row))
+				break
+			}
+			if err = rec.set(header[i], strings.TrimSpace(v)); err != nil {
+				rec.Err = err
+				break
+			}
+		}
+		records = append(records, rec)
+	}
+}
+
+// set sets the column from a CSV field, unless it's empty. Groups and tags
+// are separated by commas or semicolons.
+func (rec *Record) set(column string, v string) error {
+	if v == "" {
+		return nil
+	}
+	k := rec.Keyword
+	rec.Columns[column] = true
+	switch column {
+	case "value":
+		k.Value = v
+	case "locale":
+		k.Locale = v
+	case "device":
+		k.Device = strings.ToLower(v)
+	case "group":
+		k.Groups = splitLabels(v)
+	case "tags":
+		k.Tags = splitLabels(v)
+	case "priority":
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("Priority must be a number, got %q", v)
+		}
+		k.Priority = n
+	case "cadence":
+		k.Cadence = strings.ToLower(v)
+	}
+	return nil
+}
+
+func splitLabels(v string) []string {
+	return strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ';' })
+}
+
+func isIn(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// recordJSON is a keyword in a JSON lines file. The group can be a single
+// name or a list of them.
+type recordJSON struct {
+	Value    *string         `json:"value"`
+	Locale   *string         `json:"locale"`
+	Device   *string         `json:"device"`
+	Group    json.RawMessage `json:"group"`
+	Groups   []string        `json:"groups"`
+	Tags     []string        `json:"tags"`
+	Priority *int            `json:"priority"`
+	Cadence  *string         `json:"cadence"`
+}
+
+func readJSONL(r io.Reader) ([]Record, error) {
+	records := make([]Record, 0)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		b := strings.TrimSpace(scanner.Text())
+		if b == "" {
+			continue
+		}
+		rec := Record{Line: line, Keyword: &Keyword{}, Columns: make(map[string]bool)}
+		if err := rec.setJSON(b); err != nil {
+			rec.Err = err
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+func (rec *Record) setJSON(line string) error {
+	p := recordJSON{}
+	d := json.NewDecoder(strings.NewReader(line))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&p); err != nil {
+		return err
+	}
+	k := rec.Keyword
+	for column, v := range map[string]*string{"value": p.Value, "locale": p.Locale, "device": p.Device, "cadence": p.Cadence} {
+		if v != nil {
+			rec.set(column, strings.TrimSpace(*v))
+		}
+	}
+	if p.Priority != nil {
+		rec.Columns["priority"], k.Priority = true, *p.Priority
+	}
+	if len(p.Group) > 0 {
+		var group string
+		if err := json.Unmarshal(p.Group, &group); err == nil {
+			p.Groups = append(p.Groups, group)
+		} else if err = json.Unmarshal(p.Group, &p.Groups); err != nil {
+			return errors.New("group must be a name or a list of names")
+		}
+	}
+	if p.Groups != nil {
+		rec.Columns["group"], k.Groups = true, p.Groups
+	}
+	if p.Tags != nil {
+		rec.Columns["tags"], k.Tags = true, p.Tags
+	}
+	return nil
+}
+
+// ImportOptions add every keyword in a file to Group and Tags. With Sync,
+// active keywords missing from the file are paused, only the ones in Group
+// when it's set, and keywords in the file are resumed.
+type ImportOptions struct {
+	Group string
+	Tags  []string
+	Sync  bool
+}
+
+// Diff is what importing a file changes.
+type Diff struct {
+	New      []*Keyword
+	Existing []*Keyword
+	Invalid  []Record
+	// Duplicates counts the records repeating a keyword earlier in the file.
+	Duplicates int
+	Paused     []Keyword
+}
+
+func (d *Diff) String() string {
+	return fmt.Sprintf("%v new, %v existing, %v invalid, %v duplicates, %v paused",
+		len(d.New), len(d.Existing), len(d.Invalid), d.Duplicates, len(d.Paused))
+}
+
+// Plan works out what importing the records changes, without changing
+// anything. Existing keywords only get the fields set in the file, and keep
+// their groups and tags.
+func Plan(r Reader, records []Record, o ImportOptions) (*Diff, error) {
+	d := &Diff{}
+	seen := make(map[string]bool)
+	for _, rec := range records {
+		if rec.Err != nil {
+			d.Invalid = append(d.Invalid, rec)
+			// keep what was probably meant from being paused
+			if rec.Keyword != nil {
+				seen[Normalize(rec.Keyword.Value)] = true
+			}
+			continue
+		}
+		k := rec.Keyword
+		if seen[k.Value] {
+			d.Duplicates++
+			continue
+		}
+		seen[k.Value] = true
+		k.Groups = Labels(append(append([]string{}, k.Groups...), o.Group)...)
+		k.Tags = Labels(append(append([]string{}, k.Tags...), o.Tags...)...)
+
+		e, err := r.FindByValue(k.Value)
+		if err != nil {
+			return d, err
+		} else if e == nil {
+			d.New = append(d.New, k)
+			continue
+		}
+		merge(e, rec)
+		if o.Sync {
+			e.Paused = false
+		}
+		d.Existing = append(d.Existing, e)
+	}
+
+	if !o.Sync {
+		return d, nil
+	}
+	active := false
+	q := Query{Group: o.Group, Paused: &active, Sort: "id", Limit: MaxLimit}
+	for {
+		ks, next, err := r.List(q)
+		if err != nil {
+			return d, err
+		}
+		for _, k := range ks {
+			if !seen[k.Value] {
+				d.Paused = append(d.Paused, k)
+			}
+		}
+		if next == "" {
+			return d, nil
+		}
+		q.Cursor = next
+	}
+}
+
+// merge sets the fields of e that are in the record, adding its groups and
+// tags to the ones e has.
+func merge(e *Keyword, rec Record) {
+	k := rec.Keyword
+	if rec.Columns["locale"] {
+		e.Locale = k.Locale
+	}
+	if rec.Columns["device"] {
+		e.Device = k.Device
+	}
+	if rec.Columns["priority"] {
+		e.Priority = k.Priority
+	}
+	if rec.Columns["cadence"] {
+		e.Cadence = k.Cadence
+	}
+	e.Groups = Labels(append(append([]string{}, e.Groups...), k.Groups...)...)
+	e.Tags = Labels(append(append([]string{}, e.Tags...), k.Tags...)...)
+}
+
+// Apply imports the keywords in d through w, all or none.
+func (d *Diff) Apply(w Writer) error {
+	pause := make([]int64, 0, len(d.Paused))
+	for _, k := range d.Paused {
+		pause = append(pause, k.ID)
+	}
+	return w.Import(d.New, d.Existing, pause)
+}
@@ -2,13 +2,40 @@ package keywords
 
 import (
 	"database/sql"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/gkats/adscraper/db"
+)
+
+var (
+	ErrNotFound  = errors.New("keyword not found")
+	ErrDuplicate = errors.New("keyword already exists")
 )
 
 type Writer interface {
 	Upsert(*Keyword) (*Keyword, error)
 	UpdateScraped(*Keyword) (*Keyword, error)
+	// Create stores a new keyword. A deleted keyword with the same value is
+	// restored instead.
+	Create(*Keyword) (*Keyword, error)
+	// CreateMany creates all the keywords or none of them.
+	CreateMany([]*Keyword) ([]*Keyword, error)
+	// Update saves the value, locale, priority, paused state and schedule.
+	// Groups and tags are replaced unless they're nil.
+	Update(*Keyword) (*Keyword, error)
+	// AddLabels adds the keyword to the groups and tags, keeping the ones it
+	// already has.
+	AddLabels(id int64, groups []string, tags []string) error
+	// Import creates the new keywords, updates the existing ones and pauses
+	// the ones with the pause IDs, all or none.
+	Import(news []*Keyword, existing []*Keyword, pause []int64) error
+	// Delete marks the keyword as deleted. Deleted keywords are never listed
+	// or scraped, but their ads are kept.
+	Delete(id int64) (*Keyword, error)
 }
 
 func NewWriter(s Store) Writer {
@@ -17,6 +44,18 @@ func NewWriter(s Store) Writer {
 
 type Reader interface {
 	GetLeastScraped(int) ([]Keyword, error)
+	// Find returns nil when there's no keyword with the id.
+	Find(id int64) (*Keyword, error)
+	// FindByValue returns nil when there's no keyword with the value.
+	FindByValue(string) (*Keyword, error)
+	// List returns a page of keywords matching q and the cursor of the next
+	// page, empty on the last one.
+	List(q Query) ([]Keyword, string, error)
+	// Labels returns every group or tag, by kind, sorted by name.
+	Labels(kind string) ([]Label, error)
+	// GetDue returns the keywords matching q that are due at now, most
+	// overdue and important first. Sort and Cursor are ignored.
+	GetDue(q Query, now time.Time) ([]Keyword, error)
 }
 
 func NewReader(s Store) Reader {
@@ -35,42 +74,85 @@ func NewReaderWriter(s Store) ReaderWriter {
 type Keyword struct {
 	ID            int64
 	Value         string
+	Locale        string
+	Device        string
+	Priority      int
+	Paused        bool
+	Cadence       string
+	WindowStart   string
+	WindowEnd     string
 	TimesScraped  int
 	CreatedAt     string
 	UpdatedAt     string
 	LastScrapedAt string
+	DeletedAt     string
+	Groups        []string
+	Tags          []string
 }
 
 func New(value string) *Keyword {
 	return &Keyword{Value: value}
 }
 
-type Store interface {
-	Close() error
-	QueryRow(string, ...interface{}) *sql.Row
-	Query(string, ...interface{}) (*sql.Rows, error)
-}
+const (
+	MaxValueLength = 255
+	MaxPriority    = 100
+)
 
-func NewStore(url string) (Store, error) {
-	db, err := sql.Open("postgres", "postgres://"+url+"?sslmode=require")
-	if err != nil {
-		return nil, err
-	}
-	if err = db.Ping(); err != nil {
-		db.Close()
-		return nil, err
+// Devices a keyword can be searched from. Keywords without one are searched
+// from a desktop.
+const (
+	Desktop = "desktop"
+	Mobile  = "mobile"
+)
+
+var locale = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+
+// Validate checks the fields that can be set through the API.
+func (k *Keyword) Validate() error {
+	if strings.TrimSpace(k.Value) == "" {
+		return errors.New("Value can't be blank")
+	} else if len(k.Value) > MaxValueLength {
+		return errors.New("Value can't be longer than " + strconv.Itoa(MaxValueLength) + " characters")
+	} else if k.Locale != "" && !locale.MatchString(k.Locale) {
+		return errors.New("Locale must be a language code, optionally with a region, like en or en-US")
+	} else if k.Device != "" && k.Device != Desktop && k.Device != Mobile {
+		return errors.New("Device must be " + Desktop + " or " + Mobile)
+	} else if k.Priority < 0 || k.Priority > MaxPriority {
+		return errors.New("Priority must be between 0 and " + strconv.Itoa(MaxPriority))
+	} else if _, err := ParseCadence(k.Cadence); err != nil {
+		return err
+	} else if err := validateWindow(k.WindowStart, k.WindowEnd); err != nil {
+		return err
+	} else if err := validateLabels(Group, k.Groups); err != nil {
+		return err
 	}
-	return &store{DB: db}, nil
+	return validateLabels(Tag, k.Tags)
 }
 
-type store struct {
-	*sql.DB
-}
+type Store = db.Store
 
 type repository struct {
 	Store
 }
 
+const columns = `id, value, locale, device, priority, paused, cadence, window_start, window_end,
+    times_scraped, last_scraped_at, created_at, updated_at, deleted_at`
+
+type scanner interface {
+	Scan(...interface{}) error
+}
+
+func scan(s scanner, k *Keyword) error {
+	lastScrapedAt, deletedAt := sql.NullString{}, sql.NullString{}
+	err := s.Scan(
+		&k.ID, &k.Value, &k.Locale, &k.Device, &k.Priority, &k.Paused, &k.Cadence, &k.WindowStart, &k.WindowEnd,
+		&k.TimesScraped, &lastScrapedAt, &k.CreatedAt, &k.UpdatedAt, &deletedAt,
+	)
+	k.LastScrapedAt, k.DeletedAt = lastScrapedAt.String, deletedAt.String
+	return err
+}
+
 func (r *repository) Upsert(k *Keyword) (*Keyword, error) {
 	err := r.Store.QueryRow(
 		`
@@ -85,43 +167,351 @@ func (r *repository) Upsert(k *Keyword) (*Keyword, error) {
 	return k, err
 }
 
+func (r *repository) Create(k *Keyword) (*Keyword, error) {
+	tx, err := r.Store.Begin()
+	if err != nil {
+		return k, err
+	}
+	if _, err = create(tx, k); err != nil {
+		tx.Rollback()
+		return k, err
+	}
+	return k, tx.Commit()
+}
+
+func (r *repository) CreateMany(ks []*Keyword) ([]*Keyword, error) {
+	tx, err := r.Store.Begin()
+	if err != nil {
+		return ks, err
+	}
+	for _, k := range ks {
+		if _, err = create(tx, k); err != nil {
+			tx.Rollback()
+			return ks, err
+		}
+	}
+	return ks, tx.Commit()
+}
+
+func create(q db.Querier, k *Keyword) (*Keyword, error) {
+	saved := Keyword{}
+	err := scan(q.QueryRow(
+		`
+    INSERT INTO keywords (value, locale, device, priority, paused, cadence, window_start, window_end)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    ON CONFLICT (value) DO UPDATE
+    SET locale = EXCLUDED.locale, device = EXCLUDED.device, priority = EXCLUDED.priority, paused = EXCLUDED.paused,
+      cadence = EXCLUDED.cadence, window_start = EXCLUDED.window_start, window_end = EXCLUDED.window_end,
+      deleted_at = NULL, updated_at = CURRENT_TIMESTAMP
+    WHERE keywords.deleted_at IS NOT NULL
+    RETURNING `+columns+`
+    `,
+		k.Value, k.Locale, k.Device, k.Priority, k.Paused, k.Cadence, k.WindowStart, k.WindowEnd,
+	), &saved)
+	if err == sql.ErrNoRows {
+		return k, ErrDuplicate
+	} else if err != nil {
+		return k, err
+	}
+	saved.Groups, saved.Tags = k.Groups, k.Tags
+	if err = setLabels(q, &saved); err != nil {
+		return k, err
+	}
+	*k = saved
+	return k, loadLabels(q, k)
+}
+
+func (r *repository) Update(k *Keyword) (*Keyword, error) {
+	tx, err := r.Store.Begin()
+	if err != nil {
+		return k, err
+	}
+	if _, err = update(tx, k); err != nil {
+		tx.Rollback()
+		return k, err
+	}
+	return k, tx.Commit()
+}
+
+func update(q db.Querier, k *Keyword) (*Keyword, error) {
+	var n int
+	err := q.QueryRow(`SELECT COUNT(*) FROM keywords WHERE value = $1 AND id <> $2`, k.Value, k.ID).Scan(&n)
+	if err != nil {
+		return k, err
+	} else if n > 0 {
+		return k, ErrDuplicate
+	}
+
+	labels := Keyword{ID: k.ID, Groups: k.Groups, Tags: k.Tags}
+	err = scan(q.QueryRow(
+		`
+    UPDATE keywords
+    SET value = $1, locale = $2, device = $3, priority = $4, paused = $5,
+      cadence = $6, window_start = $7, window_end = $8, updated_at = CURRENT_TIMESTAMP
+    WHERE id = $9
+    AND deleted_at IS NULL
+    RETURNING `+columns+`
+    `,
+		k.Value, k.Locale, k.Device, k.Priority, k.Paused, k.Cadence, k.WindowStart, k.WindowEnd, k.ID,
+	), k)
+	if err == sql.ErrNoRows {
+		return k, ErrNotFound
+	} else if err != nil {
+		return k, err
+	}
+	if err = setLabels(q, &labels); err != nil {
+		return k, err
+	}
+	return k, loadLabels(q, k)
+}
+
+func (r *repository) Import(news []*Keyword, existing []*Keyword, pause []int64) error {
+	tx, err := r.Store.Begin()
+	if err != nil {
+		return err
+	}
+	for _, k := range news {
+		if _, err = create(tx, k); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, k := range existing {
+		if _, err = update(tx, k); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, id := range pause {
+		_, err = tx.Exec(`UPDATE keywords SET paused = TRUE, updated_at = CURRENT_TIMESTAMP WHERE id = $1`, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *repository) Delete(id int64) (*Keyword, error) {
+	k := &Keyword{}
+	err := scan(r.Store.QueryRow(
+		`
+    UPDATE keywords
+    SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+    WHERE id = $1
+    AND deleted_at IS NULL
+    RETURNING `+columns+`
+    `,
+		id,
+	), k)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return k, err
+	}
+	return k, loadLabels(r.Store, k)
+}
+
 func (r *repository) GetLeastScraped(limit int) ([]Keyword, error) {
 	ks := make([]Keyword, 0)
 
 	rows, err := r.Store.Query(
 		`
-	   SELECT id, value, times_scraped, last_scraped_at, created_at, updated_at
+	   SELECT `+columns+`
 	   FROM keywords
+	   WHERE deleted_at IS NULL
+	   AND NOT paused
 	   ORDER BY times_scraped ASC
 	   LIMIT $1
 	   `,
 		limit,
 	)
+	if err != nil {
+		return ks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		k := Keyword{}
+		if err = scan(rows, &k); err != nil {
+			return ks, err
+		}
+		ks = append(ks, k)
+	}
+	return ks, rows.Err()
+}
+
+func (r *repository) Find(id int64) (*Keyword, error) {
+	k := &Keyword{}
+	err := scan(r.Store.QueryRow(
+		`
+    SELECT `+columns+`
+    FROM keywords
+    WHERE id = $1
+    AND deleted_at IS NULL
+    `,
+		id,
+	), k)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return k, err
+	}
+	return k, loadLabels(r.Store, k)
+}
+
+func (r *repository) FindByValue(value string) (*Keyword, error) {
+	k := &Keyword{}
+	err := scan(r.Store.QueryRow(
+		`
+    SELECT `+columns+`
+    FROM keywords
+    WHERE value = $1
+    AND deleted_at IS NULL
+    `,
+		value,
+	), k)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return k, err
+	}
+	return k, loadLabels(r.Store, k)
+}
+
+func (r *repository) List(q Query) ([]Keyword, string, error) {
+	ks := make([]Keyword, 0)
+	o, err := q.order()
+	if err != nil {
+		return ks, "", err
+	}
+
+	args := make([]interface{}, 0)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where := q.where(arg)
+
+	cmp, dir := ">", "ASC"
+	if o.desc {
+		cmp, dir = "<", "DESC"
+	}
+	if c := o.after; c != nil {
+		if o.field == "id" {
+			where = append(where, "id "+cmp+" "+arg(c.ID))
+		} else {
+			v := arg(c.value)
+			where = append(where, "("+o.column+" "+cmp+" "+v+" OR ("+o.column+" = "+v+" AND id "+cmp+" "+arg(c.ID)+"))")
+		}
+	}
+
+	rows, err := r.Store.Query(
+		`
+    SELECT `+columns+`
+    FROM keywords
+    WHERE `+strings.Join(where, "\n    AND ")+`
+    ORDER BY `+o.column+" "+dir+", id "+dir+`
+    LIMIT `+arg(q.limit()+1),
+		args...,
+	)
+	if err != nil {
+		return ks, "", err
+	}
 	defer rows.Close()
+
+	for rows.Next() {
+		k := Keyword{}
+		if err = scan(rows, &k); err != nil {
+			return ks, "", err
+		}
+		ks = append(ks, k)
+	}
+	if err = rows.Err(); err != nil {
+		return ks, "", err
+	}
+	ks, next := o.page(ks, q.limit())
+	loaded := make([]*Keyword, 0, len(ks))
+	for i := range ks {
+		loaded = append(loaded, &ks[i])
+	}
+	return ks, next, loadLabels(r.Store, loaded...)
+}
+
+// where returns the conditions for the filters of q, adding their values with
+// arg.
+func (q *Query) where(arg func(interface{}) string) []string {
+	where := []string{"deleted_at IS NULL"}
+	if q.Text != "" {
+		where = append(where, db.Like("LOWER(value)", arg("%"+db.EscapeLike(strings.ToLower(q.Text))+"%")))
+	}
+	if q.Locale != "" {
+		where = append(where, "locale = "+arg(q.Locale))
+	}
+	if q.Paused != nil {
+		where = append(where, "paused = "+arg(*q.Paused))
+	}
+	if q.Group != "" {
+		where = append(where, labeled("id", arg(Group), arg(q.Group)))
+	}
+	if q.Tag != "" {
+		where = append(where, labeled("id", arg(Tag), arg(q.Tag)))
+	}
+	return where
+}
+
+func (r *repository) GetDue(q Query, now time.Time) ([]Keyword, error) {
+	ks := make([]Keyword, 0)
+
+	args := make([]interface{}, 0)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	rows, err := r.Store.Query(
+		`
+    SELECT `+columns+`
+    FROM keywords
+    WHERE `+strings.Join(append(q.where(arg), "NOT paused"), "\n    AND ")+`
+    `,
+		args...,
+	)
 	if err != nil {
 		return ks, err
 	}
+	defer rows.Close()
 
-	k := Keyword{}
 	for rows.Next() {
-		rows.Scan(
-			&k.ID, &k.Value, &k.TimesScraped, &k.LastScrapedAt, &k.CreatedAt, &k.UpdatedAt,
-		)
+		k := Keyword{}
+		if err = scan(rows, &k); err != nil {
+			return ks, err
+		}
 		ks = append(ks, k)
 	}
-	return ks, nil
+	if err = rows.Err(); err != nil {
+		return ks, err
+	}
+	ks = Schedule(ks, now, q.limit())
+	loaded := make([]*Keyword, 0, len(ks))
+	for i := range ks {
+		loaded = append(loaded, &ks[i])
+	}
+	return ks, loadLabels(r.Store, loaded...)
 }
 
 func (r *repository) UpdateScraped(k *Keyword) (*Keyword, error) {
 	err := r.Store.QueryRow(
 		`
     UPDATE keywords
-    SET times_scraped = times_scraped + 1, last_scraped_at = NOW()
+    SET times_scraped = times_scraped + 1, last_scraped_at = CURRENT_TIMESTAMP
     WHERE id = $1
     RETURNING times_scraped
     `,
 		k.ID,
 	).Scan(&k.TimesScraped)
-
+	if err == sql.ErrNoRows {
+		return k, ErrNotFound
+	}
 	return k, err
 }
@@ -0,0 +1,228 @@
+package keywords
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Keywords are grouped by project or client and tagged freely. Both are
+// labels, a keyword can have any number of each.
+const (
+	Group = "group"
+	Tag   = "tag"
+)
+
+const MaxLabelLength = 100
+
+type Label struct {
+	Kind string
+	Name string
+	// Keywords counts the keywords with the label, deleted ones excluded.
+	Keywords int
+}
+
+// Labels trims and sorts names, dropping blanks and duplicates. It keeps an
+// empty slice non-nil, since that clears the labels on Update.
+func Labels(names ...string) []string {
+	if names == nil {
+		return nil
+	}
+	labels := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name != "" && indexOf(labels, name) < 0 {
+			labels = append(labels, name)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func validateLabels(kind string, names []string) error {
+	for _, name := range names {
+		if len(name) > MaxLabelLength {
+			return errors.New("A " + kind + " can't be longer than " + strconv.Itoa(MaxLabelLength) + " characters")
+		}
+	}
+	return nil
+}
+
+type execer interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}
+
+// addLabels links the keyword to the labels, creating the missing ones.
+func addLabels(e execer, id int64, kind string, names []string) error {
+	for _, name := range names {
+		_, err := e.Exec(
+			`
+    INSERT INTO labels (kind, name)
+    VALUES ($1, $2)
+    ON CONFLICT (kind, name) DO NOTHING
+    `,
+			kind, name,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = e.Exec(
+			`
+    INSERT INTO keyword_labels (keyword_id, label_id)
+    SELECT $1, id FROM labels WHERE kind = $2 AND name = $3
+    ON CONFLICT DO NOTHING
+    `,
+			id, kind, name,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setLabels replaces the groups and tags of k with the ones it holds. Nil
+// slices are left alone.
+func setLabels(e execer, k *Keyword) error {
+	for kind, names := range map[string][]string{Group: k.Groups, Tag: k.Tags} {
+		if names == nil {
+			continue
+		}
+		_, err := e.Exec(
+			`
+    DELETE FROM keyword_labels
+    WHERE keyword_id = $1
+    AND label_id IN (SELECT id FROM labels WHERE kind = $2)
+    `,
+			k.ID, kind,
+		)
+		if err != nil {
+			return err
+		}
+		if err = addLabels(e, k.ID, kind, Labels(names...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type rowsQuerier interface {
+	Query(string, ...interface{}) (*sql.Rows, error)
+}
+
+// loadLabels fills in the groups and tags of the keywords.
+func loadLabels(q rowsQuerier, ks ...*Keyword) error {
+	if len(ks) == 0 {
+		return nil
+	}
+	byID := make(map[int64]*Keyword, len(ks))
+	params := make([]string, 0, len(ks))
+	args := make([]interface{}, 0, len(ks))
+	for _, k := range ks {
+		k.Groups, k.Tags = []string{}, []string{}
+		byID[k.ID] = k
+		args = append(args, k.ID)
+		params = append(params, "$"+strconv.Itoa(len(args)))
+	}
+
+	rows, err := q.Query(
+		`
+    SELECT kl.keyword_id, l.kind, l.name
+    FROM keyword_labels kl
+    JOIN labels l ON l.id = kl.label_id
+    WHERE kl.keyword_id IN (`+strings.Join(params, ", ")+`)
+    ORDER BY l.name
+    `,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id         int64
+			kind, name string
+		)
+		if err = rows.Scan(&id, &kind, &name); err != nil {
+			return err
+		}
+		if k := byID[id]; kind == Group {
+			k.Groups = append(k.Groups, name)
+		} else if kind == Tag {
+			k.Tags = append(k.Tags, name)
+		}
+	}
+	return rows.Err()
+}
+
+// labeled returns the condition for keyword IDs in column having the label.
+func labeled(column string, kind string, name string) string {
+	return column + " IN (SELECT kl.keyword_id FROM keyword_labels kl JOIN labels l ON l.id = kl.label_id WHERE l.kind = " +
+		kind + " AND l.name = " + name + ")"
+}
+
+func (r *repository) AddLabels(id int64, groups []string, tags []string) error {
+	tx, err := r.Store.Begin()
+	if err != nil {
+		return err
+	}
+	for kind, names := range map[string][]string{Group: Labels(groups...), Tag: Labels(tags...)} {
+		if err = addLabels(tx, id, kind, names); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *repository) Labels(kind string) ([]Label, error) {
+	ls := make([]Label, 0)
+
+	rows, err := r.Store.Query(
+		`
+    SELECT l.kind, l.name, COUNT(k.id)
+    FROM labels l
+    LEFT JOIN keyword_labels kl ON kl.label_id = l.id
+    LEFT JOIN keywords k ON k.id = kl.keyword_id AND k.deleted_at IS NULL
+    WHERE l.kind = $1
+    GROUP BY l.kind, l.name
+    ORDER BY l.name
+    `,
+		kind,
+	)
+	if err != nil {
+		return ls, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		l := Label{}
+		if err = rows.Scan(&l.Kind, &l.Name, &l.Keywords); err != nil {
+			return ls, err
+		}
+		ls = append(ls, l)
+	}
+	return ls, rows.Err()
+}
+
+func (k *Keyword) labels(kind string) []string {
+	if kind == Group {
+		return k.Groups
+	} else if kind == Tag {
+		return k.Tags
+	}
+	return nil
+}
@@ -0,0 +1,386 @@
+package keywords
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewMemoryReaderWriter returns a ReaderWriter that keeps keywords in memory.
+// It's safe for concurrent use and meant for tests and dry runs.
+func NewMemoryReaderWriter() ReaderWriter {
+	return &memory{}
+}
+
+type memory struct {
+	mu       sync.RWMutex
+	keywords []Keyword
+	// labels holds the names of every group and tag by kind
+	labels map[string]map[string]bool
+}
+
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+func (m *memory) Upsert(k *Keyword) (*Keyword, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing := m.indexOf(k.Value); existing >= 0 {
+		e := m.keywords[existing]
+		k.ID, k.CreatedAt, k.UpdatedAt = e.ID, e.CreatedAt, e.UpdatedAt
+		k.TimesScraped = e.TimesScraped
+		return k, nil
+	}
+
+	k.ID = int64(len(m.keywords) + 1)
+	k.CreatedAt, k.UpdatedAt = now(), now()
+	k.TimesScraped = 0
+	m.keywords = append(m.keywords, *k)
+	m.keywords[len(m.keywords)-1].Groups, m.keywords[len(m.keywords)-1].Tags = nil, nil
+	return k, nil
+}
+
+func (m *memory) indexOf(value string) int {
+	for i, k := range m.keywords {
+		if k.Value == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *memory) Create(k *Keyword) (*Keyword, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.create(k)
+}
+
+func (m *memory) CreateMany(ks []*Keyword) ([]*Keyword, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := append([]Keyword(nil), m.keywords...)
+	for _, k := range ks {
+		if _, err := m.create(k); err != nil {
+			m.keywords = saved
+			return ks, err
+		}
+	}
+	return ks, nil
+}
+
+func (m *memory) create(k *Keyword) (*Keyword, error) {
+	if i := m.indexOf(k.Value); i >= 0 {
+		e := &m.keywords[i]
+		if e.DeletedAt == "" {
+			return k, ErrDuplicate
+		}
+		e.Locale, e.Device, e.Priority, e.Paused = k.Locale, k.Device, k.Priority, k.Paused
+		e.Cadence, e.WindowStart, e.WindowEnd = k.Cadence, k.WindowStart, k.WindowEnd
+		e.DeletedAt, e.UpdatedAt = "", now()
+		m.setLabels(e, k)
+		*k = *copied(e)
+		return k, nil
+	}
+
+	k.ID = int64(len(m.keywords) + 1)
+	k.CreatedAt, k.UpdatedAt = now(), now()
+	k.TimesScraped, k.LastScrapedAt, k.DeletedAt = 0, "", ""
+	m.keywords = append(m.keywords, Keyword{})
+	e := &m.keywords[len(m.keywords)-1]
+	*e = *k
+	e.Groups, e.Tags = nil, nil
+	m.setLabels(e, k)
+	*k = *copied(e)
+	return k, nil
+}
+
+func (m *memory) Update(k *Keyword) (*Keyword, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.update(k)
+}
+
+func (m *memory) Import(news []*Keyword, existing []*Keyword, pause []int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := append([]Keyword(nil), m.keywords...)
+	for _, k := range news {
+		if _, err := m.create(k); err != nil {
+			m.keywords = saved
+			return err
+		}
+	}
+	for _, k := range existing {
+		if _, err := m.update(k); err != nil {
+			m.keywords = saved
+			return err
+		}
+	}
+	for _, id := range pause {
+		if id >= 1 && id <= int64(len(m.keywords)) {
+			m.keywords[id-1].Paused, m.keywords[id-1].UpdatedAt = true, now()
+		}
+	}
+	return nil
+}
+
+func (m *memory) update(k *Keyword) (*Keyword, error) {
+	e := m.find(k.ID)
+	if e == nil {
+		return k, ErrNotFound
+	}
+	if i := m.indexOf(k.Value); i >= 0 && m.keywords[i].ID != k.ID {
+		return k, ErrDuplicate
+	}
+	e.Value, e.Locale, e.Device, e.Priority, e.Paused = k.Value, k.Locale, k.Device, k.Priority, k.Paused
+	e.Cadence, e.WindowStart, e.WindowEnd = k.Cadence, k.WindowStart, k.WindowEnd
+	e.UpdatedAt = now()
+	m.setLabels(e, k)
+	*k = *copied(e)
+	return k, nil
+}
+
+func (m *memory) Delete(id int64) (*Keyword, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.find(id)
+	if e == nil {
+		return nil, ErrNotFound
+	}
+	e.DeletedAt, e.UpdatedAt = now(), now()
+	return copied(e), nil
+}
+
+// find returns the stored keyword with the id, unless it's deleted.
+func (m *memory) find(id int64) *Keyword {
+	if id < 1 || id > int64(len(m.keywords)) || m.keywords[id-1].DeletedAt != "" {
+		return nil
+	}
+	return &m.keywords[id-1]
+}
+
+func (m *memory) GetLeastScraped(limit int) ([]Keyword, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ks := make([]Keyword, 0, len(m.keywords))
+	for _, k := range m.keywords {
+		if k.DeletedAt == "" && !k.Paused {
+			ks = append(ks, k)
+		}
+	}
+	sort.SliceStable(ks, func(i, j int) bool {
+		return ks[i].TimesScraped < ks[j].TimesScraped
+	})
+	if len(ks) > limit {
+		ks = ks[:limit]
+	}
+	return ks, nil
+}
+
+func (m *memory) Find(id int64) (*Keyword, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if e := m.find(id); e != nil {
+		return copied(e), nil
+	}
+	return nil, nil
+}
+
+func (m *memory) FindByValue(value string) (*Keyword, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if i := m.indexOf(value); i >= 0 && m.keywords[i].DeletedAt == "" {
+		return copied(&m.keywords[i]), nil
+	}
+	return nil, nil
+}
+
+func (m *memory) List(q Query) ([]Keyword, string, error) {
+	ks := make([]Keyword, 0)
+	o, err := q.order()
+	if err != nil {
+		return ks, "", err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, k := range m.keywords {
+		if !q.matches(&k) {
+			continue
+		}
+		if o.after != nil && o.compare(&k, o.after.value, o.after.ID) <= 0 {
+			continue
+		}
+		ks = append(ks, *copied(&k))
+	}
+	sort.SliceStable(ks, func(i, j int) bool {
+		return o.compare(&ks[i], o.sortValue(&ks[j]), ks[j].ID) < 0
+	})
+
+	ks, next := o.page(ks, q.limit())
+	return ks, next, nil
+}
+
+func (q *Query) matches(k *Keyword) bool {
+	text := strings.ToLower(q.Text)
+	return k.DeletedAt == "" &&
+		(text == "" || strings.Contains(strings.ToLower(k.Value), text)) &&
+		(q.Locale == "" || k.Locale == q.Locale) &&
+		(q.Paused == nil || k.Paused == *q.Paused) &&
+		hasLabel(k.Groups, q.Group) && hasLabel(k.Tags, q.Tag)
+}
+
+func (m *memory) GetDue(q Query, now time.Time) ([]Keyword, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ks := make([]Keyword, 0)
+	for _, k := range m.keywords {
+		if q.matches(&k) {
+			ks = append(ks, *copied(&k))
+		}
+	}
+	return Schedule(ks, now, q.limit()), nil
+}
+
+func (o *order) sortValue(k *Keyword) interface{} {
+	switch o.field {
+	case "timesScraped":
+		return int64(k.TimesScraped)
+	case "value":
+		return k.Value
+	case "priority":
+		return int64(k.Priority)
+	}
+	return k.ID
+}
+
+// compare orders k against the keyword with the sort field value and id.
+func (o *order) compare(k *Keyword, value interface{}, id int64) int {
+	c := 0
+	switch v := o.sortValue(k).(type) {
+	case string:
+		c = strings.Compare(v, value.(string))
+	case int64:
+		c = compareInt(v, value.(int64))
+	}
+	if c == 0 {
+		c = compareInt(k.ID, id)
+	}
+	if o.desc {
+		return -c
+	}
+	return c
+}
+
+func compareInt(a int64, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func (m *memory) UpdateScraped(k *Keyword) (*Keyword, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if k.ID < 1 || k.ID > int64(len(m.keywords)) {
+		return k, ErrNotFound
+	}
+	existing := &m.keywords[k.ID-1]
+	existing.TimesScraped++
+	existing.LastScrapedAt = now()
+	k.TimesScraped = existing.TimesScraped
+	return k, nil
+}
+
+func (m *memory) AddLabels(id int64, groups []string, tags []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > int64(len(m.keywords)) {
+		return ErrNotFound
+	}
+	k := &m.keywords[id-1]
+	k.Groups = Labels(append(append([]string{}, k.Groups...), groups...)...)
+	k.Tags = Labels(append(append([]string{}, k.Tags...), tags...)...)
+	m.remember(k)
+	return nil
+}
+
+// setLabels replaces the labels of the stored keyword e with the ones in k,
+// unless they're nil.
+func (m *memory) setLabels(e *Keyword, k *Keyword) {
+	if k.Groups != nil {
+		e.Groups = Labels(k.Groups...)
+	}
+	if k.Tags != nil {
+		e.Tags = Labels(k.Tags...)
+	}
+	m.remember(e)
+}
+
+// remember keeps the labels of k, which outlive their keywords like they do
+// in the database.
+func (m *memory) remember(k *Keyword) {
+	if m.labels == nil {
+		m.labels = make(map[string]map[string]bool)
+	}
+	for _, kind := range []string{Group, Tag} {
+		if m.labels[kind] == nil {
+			m.labels[kind] = make(map[string]bool)
+		}
+		for _, name := range k.labels(kind) {
+			m.labels[kind][name] = true
+		}
+	}
+}
+
+// copied returns a copy of the stored keyword e that shares no slices with it.
+func copied(e *Keyword) *Keyword {
+	k := *e
+	k.Groups = append([]string{}, e.Groups...)
+	k.Tags = append([]string{}, e.Tags...)
+	return &k
+}
+
+// hasLabel is true when name is in names or empty, matching any keyword.
+func hasLabel(names []string, name string) bool {
+	return name == "" || indexOf(names, name) >= 0
+}
+
+func (m *memory) Labels(kind string) ([]Label, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, k := range m.keywords {
+		if k.DeletedAt == "" {
+			for _, name := range k.labels(kind) {
+				counts[name]++
+			}
+		}
+	}
+	ls := make([]Label, 0, len(m.labels[kind]))
+	for name := range m.labels[kind] {
+		ls = append(ls, Label{Kind: kind, Name: name, Keywords: counts[name]})
+	}
+	sort.Slice(ls, func(i, j int) bool {
+		return ls[i].Name < ls[j].Name
+	})
+	return ls, nil
+}
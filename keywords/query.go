@@ -0,0 +1,136 @@
+package keywords
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidSort   = errors.New("invalid sort")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 500
+)
+
+// Query filters, orders and pages through keywords. Deleted keywords are
+// never listed.
+type Query struct {
+	// Text is searched for in the keyword value.
+	Text   string
+	Locale string
+	// Paused lists only paused or only active keywords when set.
+	Paused *bool
+	// Group and Tag list the keywords with the label.
+	Group string
+	Tag   string
+	// Sort is "timesScraped", "id", "value" or "priority", prefixed with "-"
+	// for descending order. The least scraped keywords come first by default.
+	Sort string
+	// Cursor continues after the last keyword of a previous page.
+	Cursor string
+	Limit  int
+}
+
+func (q *Query) limit() int {
+	if q.Limit <= 0 {
+		return DefaultLimit
+	} else if q.Limit > MaxLimit {
+		return MaxLimit
+	}
+	return q.Limit
+}
+
+var sorts = map[string]string{
+	"timesScraped": "times_scraped",
+	"id":           "id",
+	"value":        "value",
+	"priority":     "priority",
+}
+
+type order struct {
+	field  string
+	column string
+	desc   bool
+	after  *cursor
+}
+
+// cursor points at the last keyword of a page. Keywords are ordered by the
+// sort field and then by ID, so the pair is unique.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+	// value is Value converted to the sort field type
+	value interface{}
+}
+
+func (q *Query) order() (*order, error) {
+	sort := q.Sort
+	if sort == "" {
+		sort = "timesScraped"
+	}
+	o := &order{field: strings.TrimPrefix(sort, "-"), desc: strings.HasPrefix(sort, "-")}
+	column, ok := sorts[o.field]
+	if !ok {
+		return nil, ErrInvalidSort
+	}
+	o.column = column
+
+	if q.Cursor == "" {
+		return o, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	o.after = &cursor{}
+	if err = json.Unmarshal(b, o.after); err != nil || o.after.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+	o.after.value = o.after.Value
+	if o.field != "value" {
+		n, err := strconv.ParseInt(o.after.Value, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		o.after.value = n
+	}
+	return o, nil
+}
+
+func (o *order) value(k *Keyword) string {
+	switch o.field {
+	case "timesScraped":
+		return strconv.Itoa(k.TimesScraped)
+	case "value":
+		return k.Value
+	case "priority":
+		return strconv.Itoa(k.Priority)
+	}
+	return strconv.FormatInt(k.ID, 10)
+}
+
+func (o *order) cursor(k *Keyword) string {
+	sort := o.field
+	if o.desc {
+		sort = "-" + sort
+	}
+	b, _ := json.Marshal(&cursor{Sort: sort, Value: o.value(k), ID: k.ID})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// page trims keywords fetched with an extra row down to limit and returns
+// the cursor of the next page, if there is one.
+func (o *order) page(ks []Keyword, limit int) ([]Keyword, string) {
+	if len(ks) <= limit {
+		return ks, ""
+	}
+	ks = ks[:limit]
+	return ks, o.cursor(&ks[limit-1])
+}
@@ -0,0 +1,121 @@
+package keywords
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// A keyword is scraped once every cadence, within its daily scrape window
+// (WindowStart to WindowEnd) if it has one.
+
+// Cadences are the named scrape intervals. Any other cadence is a duration
+// like "6h" or "90m".
+var Cadences = map[string]time.Duration{
+	"hourly": time.Hour,
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+const (
+	// DefaultCadence is used for keywords without one.
+	DefaultCadence = 24 * time.Hour
+	MinCadence     = time.Minute
+)
+
+var ErrInvalidCadence = errors.New("Cadence must be hourly, daily, weekly or a duration like 6h")
+
+func ParseCadence(cadence string) (time.Duration, error) {
+	if cadence == "" {
+		return DefaultCadence, nil
+	} else if d, ok := Cadences[cadence]; ok {
+		return d, nil
+	}
+	d, err := time.ParseDuration(cadence)
+	if err != nil || d < MinCadence {
+		return 0, ErrInvalidCadence
+	}
+	return d, nil
+}
+
+const windowLayout = "15:04"
+
+var ErrInvalidWindow = errors.New("The scrape window must have both a start and an end, as UTC times like 09:00")
+
+func validateWindow(start string, end string) error {
+	if start == "" && end == "" {
+		return nil
+	}
+	_, errStart := time.Parse(windowLayout, start)
+	_, errEnd := time.Parse(windowLayout, end)
+	if errStart != nil || errEnd != nil {
+		return ErrInvalidWindow
+	}
+	return nil
+}
+
+// InWindow is true when now falls in the keyword's daily scrape window, or
+// when there's none. Windows are in UTC and may wrap around midnight, like
+// 22:00 to 06:00.
+func (k *Keyword) InWindow(now time.Time) bool {
+	if k.WindowStart == "" || k.WindowEnd == "" {
+		return true
+	}
+	t := now.UTC().Format(windowLayout)
+	if k.WindowStart <= k.WindowEnd {
+		return t >= k.WindowStart && t < k.WindowEnd
+	}
+	return t >= k.WindowStart || t < k.WindowEnd
+}
+
+// Overdue is how many cadences have passed since the keyword was last
+// scraped, at least 1 when it's due. Keywords never scraped are always due,
+// as overdue as the cadences passed since they were created.
+func (k *Keyword) Overdue(now time.Time) float64 {
+	cadence, err := ParseCadence(k.Cadence)
+	if err != nil {
+		cadence = DefaultCadence
+	}
+	if last, err := time.Parse(time.RFC3339Nano, k.LastScrapedAt); err == nil {
+		return float64(now.Sub(last)) / float64(cadence)
+	}
+	created, err := time.Parse(time.RFC3339Nano, k.CreatedAt)
+	if err != nil {
+		return 1
+	}
+	return math.Max(1, float64(now.Sub(created))/float64(cadence))
+}
+
+// Due is true when the keyword should be scraped at now.
+func (k *Keyword) Due(now time.Time) bool {
+	return !k.Paused && k.DeletedAt == "" && k.InWindow(now) && k.Overdue(now) >= 1
+}
+
+// Weight scales how overdue a keyword is by its priority, so a keyword with
+// priority 100 is scheduled like one that's 11 times as overdue.
+func (k *Keyword) Weight(now time.Time) float64 {
+	return k.Overdue(now) * (1 + float64(k.Priority)/10)
+}
+
+// Schedule returns up to limit of the keywords that are due at now, the
+// highest weighted first.
+func Schedule(ks []Keyword, now time.Time, limit int) []Keyword {
+	due := make([]Keyword, 0)
+	for _, k := range ks {
+		if k.Due(now) {
+			due = append(due, k)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		wi, wj := due[i].Weight(now), due[j].Weight(now)
+		if wi != wj {
+			return wi > wj
+		}
+		return due[i].ID < due[j].ID
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due
+}
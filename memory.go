@@ -0,0 +1,490 @@
+package adscraper
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gkats/adscraper/brands"
+	"github.com/gkats/adscraper/compliance"
+	"github.com/gkats/adscraper/keywords"
+	"github.com/gkats/adscraper/webhooks"
+)
+
+// NewMemoryReaderWriter returns an AdReaderWriter that keeps everything in
+// memory. It's safe for concurrent use and meant for tests and dry runs.
+// Searching by group or tag needs the keywords, webhook events need the
+// webhooks, detecting brand bidding the brands and checking compliance the
+// rules too, see
+// NewMemoryRepositories.
+func NewMemoryReaderWriter() AdReaderWriter {
+	return &memoryAds{}
+}
+
+type memoryAds struct {
+	// keywords looks up the groups and tags of sighted keywords
+	keywords     keywords.Reader
+	webhooks     webhooks.Writer
+	brands       brands.ReaderWriter
+	compliance   compliance.ReaderWriter
+	mu           sync.RWMutex
+	ads          []Ad
+	adKeywords   []AdKeyword
+	observations []Observation
+	// IDs of removed rows aren't reused
+	lastAdKeywordId   int64
+	lastObservationId int64
+	// the ads in the last and current scrape of each keyword
+	lastScrape    map[int64]map[int64]bool
+	currentScrape map[int64]map[int64]bool
+	// the advertisers seen for each keyword
+	advertisers map[int64]map[string]bool
+}
+
+func (m *memoryAds) Upsert(ad *Ad, k *keywords.Keyword) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := m.upsert(ad, k, false)
+	if err := m.detectBrandBidding(ad, k, true); err != nil {
+		return err
+	}
+	if contains(events, webhooks.NewAd) {
+		if err := m.checkCompliance(ad); err != nil {
+			return err
+		}
+	}
+	return m.emit(events, ad, k)
+}
+
+func (m *memoryAds) EndScrape(k *keywords.Keyword) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.currentScrape[k.ID]) == 0 {
+		return nil
+	}
+	gone := make([]int64, 0)
+	for id := range m.lastScrape[k.ID] {
+		if !m.currentScrape[k.ID][id] {
+			gone = append(gone, id)
+		}
+	}
+	sort.Slice(gone, func(i, j int) bool { return gone[i] < gone[j] })
+	if m.lastScrape == nil {
+		m.lastScrape = make(map[int64]map[int64]bool)
+	}
+	m.lastScrape[k.ID] = m.currentScrape[k.ID]
+	delete(m.currentScrape, k.ID)
+
+	for _, id := range gone {
+		ad := m.ads[id-1]
+		if err := m.emit([]string{webhooks.AdDisappeared}, &ad, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryAds) emit(events []string, ad *Ad, k *keywords.Keyword) error {
+	if len(events) == 0 || m.webhooks == nil {
+		return nil
+	}
+	ek, err := m.eventKeyword(k)
+	if err != nil {
+		return err
+	}
+	return emitEvents(events, ad, ek, m.webhooks.Emit)
+}
+
+func (m *memoryAds) eventKeyword(k *keywords.Keyword) (webhooks.Keyword, error) {
+	ek := webhooks.Keyword{ID: k.ID, Groups: []string{}}
+	if m.keywords != nil {
+		if found, err := m.keywords.Find(k.ID); err != nil {
+			return ek, err
+		} else if found != nil {
+			ek.Value, ek.Groups = found.Value, append(ek.Groups, found.Groups...)
+		}
+	}
+	return ek, nil
+}
+
+// detectBrandBidding is detectBrandBidding, for an ad just upserted.
+func (m *memoryAds) detectBrandBidding(ad *Ad, k *keywords.Keyword, alert bool) error {
+	if m.brands == nil {
+		return nil
+	}
+	bs, err := m.brands.List()
+	if err != nil || len(bs) == 0 {
+		return err
+	}
+	ek, err := m.eventKeyword(k)
+	if err != nil {
+		return err
+	}
+	for _, inc := range brands.Detect(bs, ek.Value, ad.Advertiser()) {
+		inc.KeywordId, inc.AdId, inc.Position, inc.Block, inc.Evidence = k.ID, ad.ID, ad.Position, ad.Block, ad.GetRaw()
+		created, err := m.brands.Record(&inc, time.Now())
+		if err != nil {
+			return err
+		}
+		if created && alert && m.webhooks != nil {
+			if err = m.webhooks.Emit(newBrandBiddingEvent(&inc, ad, ek)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkCompliance is checkCompliance, for an ad just upserted.
+func (m *memoryAds) checkCompliance(ad *Ad) error {
+	if m.compliance == nil {
+		return nil
+	}
+	rs, err := m.compliance.Rules()
+	if err != nil || len(rs) == 0 {
+		return err
+	}
+	for _, v := range compliance.Check(rs, adCopy(ad)) {
+		v.AdId = ad.ID
+		if _, err = m.compliance.Record(&v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryAds) ReplacePageAds(p *Page, ads []*Ad) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	observations := m.observations[:0]
+	for _, o := range m.observations {
+		if o.PageId != p.ID {
+			observations = append(observations, o)
+			continue
+		}
+		if i := m.indexOfAdKeyword(o.AdId, o.KeywordId, o.Position); i >= 0 {
+			m.adKeywords[i].PositionCount--
+		}
+	}
+	m.observations = observations
+
+	adKeywords := m.adKeywords[:0]
+	for _, ak := range m.adKeywords {
+		if ak.PositionCount > 0 {
+			adKeywords = append(adKeywords, ak)
+		}
+	}
+	m.adKeywords = adKeywords
+
+	k := &keywords.Keyword{ID: p.KeywordId}
+	for _, ad := range ads {
+		ad.PageId = p.ID
+		m.upsert(ad, k, true)
+		if err := m.detectBrandBidding(ad, k, false); err != nil {
+			return err
+		}
+		if err := m.checkCompliance(ad); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsert returns the webhook events of the observation, see upsert.
+func (m *memoryAds) upsert(ad *Ad, k *keywords.Keyword, replace bool) []string {
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	i := m.indexOfAd(ad)
+	if i < 0 {
+		a := *ad
+		a.Headlines = append([]string(nil), ad.Headlines...)
+		a.ID = int64(len(m.ads) + 1)
+		a.CreatedAt, a.UpdatedAt = now, now
+		m.ads = append(m.ads, a)
+		ad.ID, ad.CreatedAt, ad.UpdatedAt = a.ID, now, now
+	} else {
+		ad.ID, ad.CreatedAt = m.ads[i].ID, m.ads[i].CreatedAt
+		if len(ad.Headlines) > 0 && len(m.ads[i].Headlines) == 0 {
+			m.ads[i].Headlines = append([]string{}, ad.Headlines...)
+		}
+		if replace {
+			stored := &m.ads[i]
+			stored.Path, stored.Rest, stored.Raw = ad.Path, ad.Rest, ad.Raw
+			stored.UpdatedAt = now
+		}
+		ad.UpdatedAt = m.ads[i].UpdatedAt
+	}
+
+	events := make([]string, 0)
+	if !replace {
+		if i < 0 {
+			events = append(events, webhooks.NewAd)
+		}
+		if d := ad.Advertiser(); d != "" && !m.advertisers[k.ID][d] {
+			if m.advertisers == nil {
+				m.advertisers = make(map[int64]map[string]bool)
+			}
+			if m.advertisers[k.ID] == nil {
+				m.advertisers[k.ID] = make(map[string]bool)
+			}
+			m.advertisers[k.ID][d] = true
+			events = append(events, webhooks.NewAdvertiser)
+		}
+		if m.currentScrape == nil {
+			m.currentScrape = make(map[int64]map[int64]bool)
+		}
+		if m.currentScrape[k.ID] == nil {
+			m.currentScrape[k.ID] = make(map[int64]bool)
+		}
+		m.currentScrape[k.ID][ad.ID] = true
+	}
+
+	ak := newAdKeyword(ad, k)
+	if i := m.indexOfAdKeyword(ak.AdId, ak.KeywordId, ak.Position); i >= 0 {
+		m.adKeywords[i].PositionCount++
+	} else {
+		m.lastAdKeywordId++
+		ak.ID = m.lastAdKeywordId
+		ak.PositionCount = 1
+		ak.CreatedAt, ak.UpdatedAt = now, now
+		m.adKeywords = append(m.adKeywords, *ak)
+	}
+
+	m.lastObservationId++
+	m.observations = append(m.observations, Observation{
+		ID:         m.lastObservationId,
+		AdId:       ad.ID,
+		KeywordId:  k.ID,
+		PageId:     ad.PageId,
+		Position:   ad.Position,
+		Block:      ad.Block,
+		ObservedAt: now,
+	})
+	return events
+}
+
+func (m *memoryAds) indexOfAd(ad *Ad) int {
+	for i, a := range m.ads {
+		if a.H1 == ad.H1 && a.H2 == ad.H2 && a.Desc == ad.Desc {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *memoryAds) indexOfAdKeyword(adId int64, keywordId int64, position int) int {
+	for i, ak := range m.adKeywords {
+		if ak.AdId == adId && ak.KeywordId == keywordId && ak.Position == position {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *memoryAds) Find(id int64) (*Ad, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if id < 1 || id > int64(len(m.ads)) {
+		return nil, nil
+	}
+	ad := m.ads[id-1]
+	return &ad, nil
+}
+
+func (m *memoryAds) Search(q AdQuery) ([]Ad, string, error) {
+	ads := make([]Ad, 0)
+	o, err := q.order()
+	if err != nil {
+		return ads, "", err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, ad := range m.ads {
+		if !m.matches(&q, &ad) {
+			continue
+		}
+		if o.after != nil && o.compare(&ad, o.after.Value, o.after.ID) <= 0 {
+			continue
+		}
+		ads = append(ads, ad)
+	}
+	sort.SliceStable(ads, func(i, j int) bool {
+		return o.compare(&ads[i], o.value(&ads[j]), ads[j].ID) < 0
+	})
+
+	ads, next := o.page(ads, q.limit())
+	return ads, next, nil
+}
+
+func (m *memoryAds) ExportAds(q AdQuery, fn func(*Ad) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, ad := range m.ads {
+		if !m.matches(&q, &ad) {
+			continue
+		}
+		if err := fn(&ad); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryAds) ExportObservations(q AdQuery, fn func(*Observation) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// the other filters apply to the observation itself
+	adFilters := AdQuery{Domain: q.Domain, Text: q.Text}
+	obs := make([]Observation, 0)
+	for _, o := range m.observations {
+		if m.sighting(&q, &o) && m.matches(&adFilters, &m.ads[o.AdId-1]) {
+			obs = append(obs, o)
+		}
+	}
+	if q.Sort == ObservationsByKeyword {
+		sort.SliceStable(obs, func(i, j int) bool {
+			if obs[i].KeywordId != obs[j].KeywordId {
+				return obs[i].KeywordId < obs[j].KeywordId
+			}
+			return obs[i].ObservedAt < obs[j].ObservedAt
+		})
+	}
+	for _, o := range obs {
+		o.Advertiser = m.ads[o.AdId-1].Advertiser()
+		if err := fn(&o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches reports whether the ad matches every filter of q.
+func (m *memoryAds) matches(q *AdQuery, ad *Ad) bool {
+	text := strings.ToLower(q.Text)
+	if q.Domain != "" && !matchesDomain(ad.Path, q.Domain) {
+		return false
+	}
+	if text != "" && !strings.Contains(strings.ToLower(ad.H1), text) &&
+		!strings.Contains(strings.ToLower(ad.H2), text) &&
+		!strings.Contains(strings.ToLower(ad.Desc), text) {
+		return false
+	}
+	return !q.sighted() || m.sighted(q, ad.ID)
+}
+
+func (m *memoryAds) sighted(q *AdQuery, adId int64) bool {
+	for _, o := range m.observations {
+		if o.AdId == adId && m.sighting(q, &o) {
+			return true
+		}
+	}
+	return false
+}
+
+// sighting reports whether the observation matches the sighting filters of q.
+func (m *memoryAds) sighting(q *AdQuery, o *Observation) bool {
+	if (q.KeywordId > 0 && o.KeywordId != q.KeywordId) ||
+		(q.Block != "" && o.Block != q.Block) ||
+		(q.MinPosition > 0 && o.Position < q.MinPosition) ||
+		(q.MaxPosition > 0 && o.Position > q.MaxPosition) ||
+		!m.labeled(q, o.KeywordId) {
+		return false
+	}
+	observedAt, _ := time.Parse(time.RFC3339, o.ObservedAt)
+	return (q.From.IsZero() || !observedAt.Before(q.From)) && (q.To.IsZero() || observedAt.Before(q.To))
+}
+
+func (m *memoryAds) labeled(q *AdQuery, keywordId int64) bool {
+	if q.Group == "" && q.Tag == "" {
+		return true
+	} else if m.keywords == nil {
+		return false
+	}
+	k, err := m.keywords.Find(keywordId)
+	if err != nil || k == nil {
+		return false
+	}
+	return (q.Group == "" || contains(k.Groups, q.Group)) && (q.Tag == "" || contains(k.Tags, q.Tag))
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesDomain(path string, domain string) bool {
+	host := strings.ToLower(strings.SplitN(path, "/", 2)[0])
+	domain = strings.ToLower(domain)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// compare orders ad against the ad with the sort field value and id. Memory
+// timestamps are all RFC 3339 in UTC, so they compare as strings.
+func (o *adOrder) compare(ad *Ad, value string, id int64) int {
+	c := 0
+	if o.field != "id" {
+		c = strings.Compare(o.value(ad), value)
+	}
+	if c == 0 && ad.ID != id {
+		c = 1
+		if ad.ID < id {
+			c = -1
+		}
+	}
+	if o.desc {
+		return -c
+	}
+	return c
+}
+
+func (m *memoryAds) GetAdKeywords(adId int64) ([]AdKeyword, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	aks := make([]AdKeyword, 0)
+	for _, ak := range m.adKeywords {
+		if ak.AdId == adId {
+			aks = append(aks, ak)
+		}
+	}
+	return aks, nil
+}
+
+func (m *memoryAds) LastObservedAt() (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	last := ""
+	for _, o := range m.observations {
+		if o.ObservedAt > last {
+			last = o.ObservedAt
+		}
+	}
+	return last, nil
+}
+
+func (m *memoryAds) GetObservations(adId int64) ([]Observation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	obs := make([]Observation, 0)
+	for _, o := range m.observations {
+		if o.AdId == adId {
+			obs = append(obs, o)
+		}
+	}
+	return obs, nil
+}
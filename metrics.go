@@ -0,0 +1,125 @@
+package adscraper
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serverMetrics are the server's Prometheus metrics. Each server has its own
+// registry, so they can be served separately.
+type serverMetrics struct {
+	registry  *prometheus.Registry
+	requests  *prometheus.CounterVec
+	durations *prometheus.HistogramVec
+	ingested  *prometheus.CounterVec
+	// streamClients and streamDropped are the live stream's clients and the
+	// sightings dropped for being too slow.
+	streamClients prometheus.Gauge
+	streamDropped prometheus.Counter
+}
+
+// ingestions are the routes scrapers post to, by what they ingest, in every
+// version.
+var ingestions = map[string]string{
+	"/ad_keywords": "ad",
+	"/pages":       "page",
+}
+
+func newServerMetrics() *serverMetrics {
+	m := &serverMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "adscraper",
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "adscraper",
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latencies by route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		ingested: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "adscraper",
+			Name:      "ingested_total",
+			Help:      "Ads and pages posted by scrapers, by kind and whether they were stored.",
+		}, []string{"kind", "result"}),
+		streamClients: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "adscraper",
+			Name:      "stream_clients",
+			Help:      "Clients connected to the live ads stream.",
+		}),
+		streamDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "adscraper",
+			Name:      "stream_dropped_total",
+			Help:      "Ads not sent to live stream clients that fell behind.",
+		}),
+	}
+	m.registry.MustRegister(
+		m.requests, m.durations, m.ingested, m.streamClients, m.streamDropped,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Metrics returns the registry served at /metrics, to register more
+// collectors, like the database pool stats.
+func (s *server) Metrics() *prometheus.Registry {
+	return s.metrics.registry
+}
+
+func (s *server) metricsHandler() http.Handler {
+	return promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{})
+}
+
+// statusRecorder keeps the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the connection through the
+// recorder, to flush streams and extend the write deadline of exports.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// instrument counts and times requests by the route template they match, so
+// /keywords/1 and /keywords/2 are both /keywords/{id}.
+func (s *server) instrument(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "other"
+		match := &mux.RouteMatch{}
+		if router.Match(r, match) && match.Route != nil {
+			if tmpl, err := match.Route.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		router.ServeHTTP(rec, r)
+
+		s.metrics.durations.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		s.metrics.requests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		if kind, ok := ingestions[unversioned(route)]; ok && r.Method == "POST" {
+			result := "stored"
+			if rec.status > 399 {
+				result = "failed"
+			}
+			s.metrics.ingested.WithLabelValues(kind, result).Inc()
+		}
+	})
+}
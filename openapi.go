@@ -0,0 +1,135 @@
+package adscraper
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// openAPISource is openapi.json, which describes the API routes once, under
+// /v1, and the unversioned routes.
+//
+//go:embed openapi.json
+var openAPISource []byte
+
+// OpenAPI is the OpenAPI 3 document describing every route of the server.
+// It's openapi.json with the /v1 operations also under the later versions
+// and the deprecated unprefixed aliases, see versionedSpec. Update
+// openapi.json along with the routes, the contract tests check they match.
+var OpenAPI = versionedSpec(openAPISource)
+
+func openAPI() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Write(OpenAPI)
+	}
+}
+
+const schemaRef = "#/components/schemas/"
+
+// versionedSpec adds the operations of the /v1 paths of the spec to the
+// other versions and to the unprefixed aliases, the way router serves them.
+// Versions get their own schema wherever there's one named after the v1
+// schema with the version as a suffix, like AdsPageV2, and operation IDs with
+// the same suffix. Aliases are deprecated and get operation IDs prefixed with
+// legacy.
+func versionedSpec(source []byte) []byte {
+	spec := make(map[string]interface{})
+	if err := json.Unmarshal(source, &spec); err != nil {
+		panic(err)
+	}
+	paths := spec["paths"].(map[string]interface{})
+	schemas := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+
+	for path, ops := range paths {
+		if !strings.HasPrefix(path, "/"+V1+"/") {
+			continue
+		}
+		route := strings.TrimPrefix(path, "/"+V1)
+		for _, v := range Versions[1:] {
+			suffix := strings.ToUpper(v)
+			paths["/"+v+route] = copyOperations(ops, func(op map[string]interface{}) {
+				op["operationId"] = op["operationId"].(string) + suffix
+			}, func(ref string) string {
+				if _, ok := schemas[strings.TrimPrefix(ref, schemaRef)+suffix]; ok {
+					return ref + suffix
+				}
+				return ref
+			})
+		}
+		paths[route] = copyOperations(ops, func(op map[string]interface{}) {
+			id := op["operationId"].(string)
+			op["operationId"] = "legacy" + strings.ToUpper(id[:1]) + id[1:]
+			op["deprecated"] = true
+			successor := "Serves " + path + ", which it links to as the successor version."
+			if d, ok := op["description"].(string); ok {
+				successor = d + " " + successor
+			}
+			op["description"] = successor
+		}, nil)
+	}
+
+	b, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return append(b, '\n')
+}
+
+// copyOperations returns a deep copy of the operations of a path, with
+// change applied to each operation and the schema references renamed by ref,
+// unless it's nil.
+func copyOperations(ops interface{}, change func(map[string]interface{}), ref func(string) string) map[string]interface{} {
+	copied := make(map[string]interface{})
+	for method, op := range ops.(map[string]interface{}) {
+		o := copyJSON(op, ref).(map[string]interface{})
+		change(o)
+		copied[method] = o
+	}
+	return copied
+}
+
+func copyJSON(v interface{}, ref func(string) string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			if s, ok := e.(string); ok && k == "$ref" && ref != nil {
+				copied[k] = ref(s)
+			} else {
+				copied[k] = copyJSON(e, ref)
+			}
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, e := range v {
+			copied[i] = copyJSON(e, ref)
+		}
+		return copied
+	}
+	return v
+}
+
+// Routes lists the server's routes like the OpenAPI document does, as
+// "METHOD /path/{param}", sorted. The dashboard's pages aren't part of the
+// API, so they're left out.
+func (s *server) Routes() []string {
+	routes := make([]string, 0)
+	s.router().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || path == "/" || path == "/ui" || strings.HasPrefix(path, "/ui/") {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		for _, m := range methods {
+			routes = append(routes, strings.ToUpper(m)+" "+path)
+		}
+		return nil
+	})
+	sort.Strings(routes)
+	return routes
+}
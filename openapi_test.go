@@ -0,0 +1,396 @@
+package adscraper_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gkats/adscraper"
+	"github.com/gkats/adscraper/brands"
+	"github.com/gkats/adscraper/compliance"
+	"github.com/gkats/adscraper/keywords"
+	"github.com/gkats/adscraper/webhooks"
+)
+
+type openAPISpec struct {
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components struct {
+		Schemas map[string]*schema `json:"schemas"`
+	} `json:"components"`
+}
+
+type operation struct {
+	RequestBody *struct {
+		Content map[string]struct {
+			Schema *schema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Content map[string]struct {
+			Schema *schema `json:"schema"`
+		} `json:"content"`
+	} `json:"responses"`
+}
+
+// schema holds the parts of OpenAPI schemas the spec uses.
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	Items                *schema            `json:"items"`
+	Enum                 []interface{}      `json:"enum"`
+	AllOf                []*schema          `json:"allOf"`
+	OneOf                []*schema          `json:"oneOf"`
+	AdditionalProperties interface{}        `json:"additionalProperties"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MaxLength            *int               `json:"maxLength"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+}
+
+// resolve follows references and merges allOf schemas into one.
+func (spec *openAPISpec) resolve(s *schema) *schema {
+	for s.Ref != "" {
+		s = spec.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	if len(s.AllOf) == 0 {
+		return s
+	}
+	merged := &schema{Type: "object", Properties: make(map[string]*schema)}
+	for _, sub := range s.AllOf {
+		sub = spec.resolve(sub)
+		for name, p := range sub.Properties {
+			merged.Properties[name] = p
+		}
+		merged.Required = append(merged.Required, sub.Required...)
+	}
+	return merged
+}
+
+// validate returns the ways v doesn't match s. Objects can't have properties
+// missing from their schema, so undocumented fields are caught too.
+func (spec *openAPISpec) validate(path string, s *schema, v interface{}) []string {
+	s = spec.resolve(s)
+	if len(s.OneOf) > 0 {
+		for _, sub := range s.OneOf {
+			if len(spec.validate(path, sub, v)) == 0 {
+				return nil
+			}
+		}
+		return []string{path + ": matches none of oneOf"}
+	}
+
+	errs := make([]string, 0)
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			found = found || e == v
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%v: %v isn't one of %v", path, v, s.Enum))
+		}
+	}
+	switch s.Type {
+	case "object":
+		o, ok := v.(map[string]interface{})
+		if !ok {
+			return append(errs, fmt.Sprintf("%v: expected an object, got %v", path, v))
+		}
+		for _, name := range s.Required {
+			if _, ok := o[name]; !ok {
+				errs = append(errs, path+"."+name+": is required")
+			}
+		}
+		for name, pv := range o {
+			if p, ok := s.Properties[name]; ok {
+				errs = append(errs, spec.validate(path+"."+name, p, pv)...)
+			} else if additional, ok := s.AdditionalProperties.(map[string]interface{}); ok {
+				b, _ := json.Marshal(additional)
+				p := &schema{}
+				json.Unmarshal(b, p)
+				errs = append(errs, spec.validate(path+"."+name, p, pv)...)
+			} else if s.AdditionalProperties != true {
+				errs = append(errs, path+"."+name+": isn't documented")
+			}
+		}
+	case "array":
+		a, ok := v.([]interface{})
+		if !ok {
+			return append(errs, fmt.Sprintf("%v: expected an array, got %v", path, v))
+		}
+		if (s.MinItems != nil && len(a) < *s.MinItems) || (s.MaxItems != nil && len(a) > *s.MaxItems) {
+			errs = append(errs, fmt.Sprintf("%v: has %v items", path, len(a)))
+		}
+		for i, item := range a {
+			errs = append(errs, spec.validate(fmt.Sprintf("%v[%v]", path, i), s.Items, item)...)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return append(errs, fmt.Sprintf("%v: expected a string, got %v", path, v))
+		}
+		if s.MaxLength != nil && len(str) > *s.MaxLength {
+			errs = append(errs, fmt.Sprintf("%v: longer than %v", path, *s.MaxLength))
+		}
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok || (s.Type == "integer" && n != float64(int64(n))) {
+			return append(errs, fmt.Sprintf("%v: expected an %v, got %v", path, s.Type, v))
+		}
+		if (s.Minimum != nil && n < *s.Minimum) || (s.Maximum != nil && n > *s.Maximum) {
+			errs = append(errs, fmt.Sprintf("%v: %v is out of range", path, n))
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			errs = append(errs, fmt.Sprintf("%v: expected a boolean, got %v", path, v))
+		}
+	}
+	return errs
+}
+
+func loadSpec(t *testing.T) *openAPISpec {
+	spec := &openAPISpec{}
+	if err := json.Unmarshal(adscraper.OpenAPI, spec); err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	spec := loadSpec(t)
+	documented := make([]string, 0)
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(documented)
+
+	routes := adscraper.NewServer(adscraper.NewMemoryRepositories()).Routes()
+	if got, want := strings.Join(routes, "\n"), strings.Join(documented, "\n"); got != want {
+		t.Errorf("Expected the routes to be documented, got routes\n%v\n\nand documented\n%v", got, want)
+	}
+}
+
+// exchange is a request to the server and its response.
+type exchange struct {
+	method  string
+	path    string
+	request []byte
+	status  int
+	body    []byte
+}
+
+// TestOpenAPIContract runs requests to every documented operation, through
+// the Client where it has a method for it, and checks the requests and
+// responses against the spec.
+func TestOpenAPIContract(t *testing.T) {
+	spec := loadSpec(t)
+	repos := adscraper.NewMemoryRepositories()
+	h := adscraper.NewServer(repos).Handler()
+
+	exchanges := make([]exchange, 0)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(req))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+		exchanges = append(exchanges, exchange{r.Method, r.URL.Path, req, rec.Code, rec.Body.Bytes()})
+	}))
+	defer ts.Close()
+
+	k, _ := repos.Keywords.Create(&keywords.Keyword{Value: "reebok women shoes", Groups: []string{"acme"}})
+	// The webhook gets deliveries of the ad posted below
+	repos.Webhooks.Create(&webhooks.Webhook{URL: "http://localhost/hooks"})
+	// The ad posted below makes a claim
+	rules, err := compliance.ParseRules(strings.NewReader("rules:\n  - name: sale\n    severity: high\n    terms: [flash sale]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos.Compliance.SetRules(rules)
+	client := adscraper.NewClient(ts.URL)
+	ad := &adscraper.Ad{H1: "Women Shoes", H2: "Reebok.com", Desc: "Flash Sale", Path: "www.reebok.com", Position: 1, Block: adscraper.BlockTop}
+	ad.SetRaw("<li>raw</li>")
+	ad.SetRest(`<ul><li>Free Shipping</li></ul>`)
+	if err := client.PostAdKeywords(ad, k); err != nil {
+		t.Fatal(err)
+	}
+	// A competitor bidding on the brand
+	repos.Brands.Create(&brands.Brand{Advertiser: "reebok.com", Terms: []string{"reebok"}})
+	competitor := &adscraper.Ad{H1: "Women Shoes", Desc: "Just do it", Path: "nike.com", Position: 2, Block: adscraper.BlockTop}
+	competitor.SetRaw("<li>nike</li>")
+	if err := client.PostAdKeywords(competitor, k); err != nil {
+		t.Fatal(err)
+	}
+	page := &adscraper.Page{KeywordId: k.ID, ParserVersion: adscraper.ParserVersion, Body: []byte("<html></html>")}
+	if err := client.PostPage(page); err != nil && err != adscraper.ErrArchiveDisabled {
+		t.Fatal(err)
+	}
+	if _, err := client.GetKeywords(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetGroupKeywords("acme"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.PatchKeyword(k.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The API routes are run in every version, and unprefixed
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"POST", "/ad_keywords", `{"ad":{"h1":"","position":0},"keyword":{"id":0}}`},
+		{"POST", "/ad_keywords", `{"ad":`},
+		{"POST", "/pages", `{"keywordId":1}`},
+		{"GET", "/ads?keyword=reebok+women+shoes&block=top&limit=1", ""},
+		{"GET", "/ads?limit=1000", ""},
+		{"GET", "/ads/1", ""},
+		{"GET", "/ads/42", ""},
+		{"GET", "/stream/ads?keywordId=x", ""},
+		{"GET", "/stream/ads?keyword=cheap+flights", ""},
+		{"GET", "/exports/ads?keyword=reebok+women+shoes", ""},
+		{"GET", "/exports/ads?format=xml", ""},
+		{"GET", "/exports/observations?format=ndjson&block=top", ""},
+		{"GET", "/analytics/share-of-voice?group=acme", ""},
+		{"GET", "/analytics/share-of-voice?from=2017-05-31&to=2017-05-01", ""},
+		{"GET", "/analytics/positions?keyword=reebok+women+shoes&advertiser=reebok.com", ""},
+		{"GET", "/keywords?limit=1", ""},
+		{"POST", "/keywords", `{"value":"nike basketball","device":"mobile","tags":["shoes"]}`},
+		{"POST", "/keywords", `[{"value":"cheap flights","cadence":"hourly"},{"value":"hotels"}]`},
+		{"POST", "/keywords", `{"value":"nike basketball"}`},
+		{"POST", "/keywords", `{"value":""}`},
+		{"GET", "/keywords/2", ""},
+		{"GET", "/keywords/42", ""},
+		{"PATCH", "/keywords/2", `{"priority":10,"groups":["acme"]}`},
+		{"PUT", "/keywords/2", `{"value":"nike basketball shoes"}`},
+		{"PUT", "/keywords/2", ""},
+		{"DELETE", "/keywords/3", ""},
+		{"GET", "/groups", ""},
+		{"GET", "/tags", ""},
+		{"POST", "/webhooks", `{"url":"https://example.com/hooks","events":["ad.new"],"advertiser":"reebok.com"}`},
+		{"POST", "/webhooks", `{"url":"ftp://example.com","events":["ad.gone"]}`},
+		{"GET", "/webhooks", ""},
+		{"GET", "/webhooks/1", ""},
+		{"GET", "/webhooks/42", ""},
+		{"GET", "/webhooks/1/deliveries?limit=10", ""},
+		{"GET", "/webhooks/1/deliveries?limit=5000", ""},
+		{"DELETE", "/webhooks/42", ""},
+		{"POST", "/brands", `{"advertiser":"new-balance.com","terms":["new balance"]}`},
+		{"POST", "/brands", `{"advertiser":"","terms":[]}`},
+		{"GET", "/brands", ""},
+		{"GET", "/brands/1", ""},
+		{"GET", "/brands/42", ""},
+		{"PUT", "/brands/1", `{"terms":["reebok","rbk"]}`},
+		{"PUT", "/brands/42", `{"terms":["adidas"]}`},
+		{"DELETE", "/brands/42", ""},
+		{"GET", "/brand-bidding?brand=reebok.com&from=2017-05-01", ""},
+		{"GET", "/brand-bidding?limit=5000", ""},
+		{"GET", "/brand-bidding/1", ""},
+		{"GET", "/brand-bidding/42", ""},
+		{"GET", "/compliance-rules", ""},
+		{"GET", "/violations?severity=high&status=open&advertiser=reebok.com", ""},
+		{"GET", "/violations?status=closed", ""},
+		{"GET", "/violations/1", ""},
+		{"GET", "/violations/42", ""},
+		{"PATCH", "/violations/1", `{"status":"confirmed","note":"Not an approved claim"}`},
+		{"PATCH", "/violations/1", `{"status":"closed"}`},
+		{"PATCH", "/violations/42", `{"note":"Approved"}`},
+	}
+	for _, prefix := range []string{"", "/v1", "/v2"} {
+		for _, r := range requests {
+			do(t, r.method, ts.URL+prefix+r.path, r.body)
+		}
+	}
+	others := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"POST", "/v1/ad_keywords", `{"ad":{"h1":"Cheap Flights","desc":"Book now","position":2},"keyword":{"id":1}}`},
+		{"POST", "/v2/ad_keywords", `{"ad":{"headlines":["a","b","c","d"],"desc":"Book now","position":1},"keyword":{"id":1}}`},
+		{"DELETE", "/v2/webhooks/2", ""},
+		{"DELETE", "/v2/brands/2", ""},
+		{"GET", "/versions", ""},
+		{"GET", "/healthz", ""},
+		{"GET", "/readyz", ""},
+		{"GET", "/metrics", ""},
+		{"GET", "/openapi.json", ""},
+	}
+	for _, r := range others {
+		do(t, r.method, ts.URL+r.path, r.body)
+	}
+
+	templates := make(map[string]*regexp.Regexp)
+	for path := range spec.Paths {
+		templates[path] = regexp.MustCompile("^" + regexp.MustCompile(`\{\w+\}`).ReplaceAllString(path, `[^/]+`) + "$")
+	}
+	exercised := make(map[string]bool)
+	for _, e := range exchanges {
+		var op *operation
+		for path, re := range templates {
+			if re.MatchString(e.path) {
+				op = spec.Paths[path][strings.ToLower(e.method)]
+				exercised[e.method+" "+path] = true
+			}
+		}
+		name := fmt.Sprintf("%v %v", e.method, e.path)
+		if op == nil {
+			t.Errorf("%v: isn't documented", name)
+			continue
+		}
+
+		if op.RequestBody != nil && len(bytes.TrimSpace(e.request)) > 0 && e.status < 400 {
+			var v interface{}
+			if err := json.Unmarshal(e.request, &v); err != nil {
+				t.Errorf("%v: %v", name, err)
+			} else {
+				for _, err := range spec.validate("request", op.RequestBody.Content["application/json"].Schema, v) {
+					t.Errorf("%v: %v", name, err)
+				}
+			}
+		}
+
+		res, ok := op.Responses[fmt.Sprint(e.status)]
+		if !ok {
+			t.Errorf("%v: status %v isn't documented", name, e.status)
+			continue
+		}
+		content, ok := res.Content["application/json"]
+		if !ok {
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal(e.body, &v); err != nil {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+		for _, err := range spec.validate("response", content.Schema, v) {
+			t.Errorf("%v: %v", name, err)
+		}
+	}
+
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			if op := strings.ToUpper(method) + " " + path; !exercised[op] {
+				t.Errorf("%v isn't exercised by the contract test", op)
+			}
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package adscraper
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gkats/adscraper/archive"
+)
+
+var (
+	ErrArchiveDisabled = errors.New("page archive is disabled")
+	ErrPageNotFound    = errors.New("page not found")
+)
+
+// Page is a fetched search results page. Its body lives in the archive,
+// addressed by Hash.
+type Page struct {
+	ID            int64
+	KeywordId     int64
+	Hash          string
+	ParserVersion string
+	FetchedAt     string
+	CreatedAt     string
+	UpdatedAt     string
+	Body          []byte
+}
+
+type PageReader interface {
+	Find(int64) (*Page, error)
+	// GetAfter returns up to limit pages with IDs greater than id, in order.
+	GetAfter(id int64, limit int) ([]Page, error)
+	// ReadBody loads the page body from the archive.
+	ReadBody(*Page) error
+}
+
+type PageWriter interface {
+	// Create archives the page body and stores the page.
+	Create(*Page) error
+	UpdateParserVersion(*Page) error
+}
+
+type PageReaderWriter interface {
+	PageReader
+	PageWriter
+}
+
+// NewPageReaderWriter returns a PageReaderWriter storing bodies in a. With a
+// nil archive, pages can't be created or read.
+func NewPageReaderWriter(s Store, a *archive.Archive) PageReaderWriter {
+	return &pagesStore{Store: s, archive: a}
+}
+
+type pagesStore struct {
+	Store
+	archive *archive.Archive
+}
+
+func (s *pagesStore) Create(p *Page) error {
+	if s.archive == nil {
+		return ErrArchiveDisabled
+	}
+
+	hash, err := s.archive.Put(p.Body)
+	if err != nil {
+		return err
+	}
+	p.Hash = hash
+
+	return s.QueryRow(
+		`
+    INSERT INTO pages (keyword_id, hash, parser_version)
+    VALUES($1, $2, $3)
+    RETURNING id, fetched_at, created_at, updated_at
+    `,
+		p.KeywordId, p.Hash, p.ParserVersion,
+	).Scan(&p.ID, &p.FetchedAt, &p.CreatedAt, &p.UpdatedAt)
+}
+
+func (s *pagesStore) UpdateParserVersion(p *Page) error {
+	err := s.QueryRow(
+		`
+    UPDATE pages
+    SET parser_version = $1, updated_at = CURRENT_TIMESTAMP
+    WHERE id = $2
+    RETURNING updated_at
+    `,
+		p.ParserVersion, p.ID,
+	).Scan(&p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrPageNotFound
+	}
+	return err
+}
+
+func (s *pagesStore) Find(id int64) (*Page, error) {
+	p := &Page{}
+	err := s.QueryRow(
+		`
+    SELECT id, keyword_id, hash, parser_version, fetched_at, created_at, updated_at
+    FROM pages
+    WHERE id = $1
+    `,
+		id,
+	).Scan(&p.ID, &p.KeywordId, &p.Hash, &p.ParserVersion, &p.FetchedAt, &p.CreatedAt, &p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+func (s *pagesStore) GetAfter(id int64, limit int) ([]Page, error) {
+	ps := make([]Page, 0)
+
+	rows, err := s.Query(
+		`
+    SELECT id, keyword_id, hash, parser_version, fetched_at, created_at, updated_at
+    FROM pages
+    WHERE id > $1
+    ORDER BY id ASC
+    LIMIT $2
+    `,
+		id, limit,
+	)
+	if err != nil {
+		return ps, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p := Page{}
+		err = rows.Scan(&p.ID, &p.KeywordId, &p.Hash, &p.ParserVersion, &p.FetchedAt, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return ps, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, rows.Err()
+}
+
+func (s *pagesStore) ReadBody(p *Page) error {
+	return readBody(s.archive, p)
+}
+
+func readBody(a *archive.Archive, p *Page) error {
+	if a == nil {
+		return ErrArchiveDisabled
+	}
+	body, err := a.Get(p.Hash)
+	if err != nil {
+		return err
+	}
+	p.Body = body
+	return nil
+}
+
+// NewMemoryPageReaderWriter returns a PageReaderWriter that keeps pages and
+// their archive in memory. It's safe for concurrent use and meant for tests
+// and dry runs.
+func NewMemoryPageReaderWriter() PageReaderWriter {
+	a, _ := archive.New(archive.NewMemoryStore(), archive.Zstd)
+	return &memoryPages{archive: a}
+}
+
+type memoryPages struct {
+	mu      sync.RWMutex
+	pages   []Page
+	archive *archive.Archive
+}
+
+func (m *memoryPages) Create(p *Page) error {
+	hash, err := m.archive.Put(p.Body)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	p.ID = int64(len(m.pages) + 1)
+	p.Hash = hash
+	p.FetchedAt, p.CreatedAt, p.UpdatedAt = now, now, now
+	stored := *p
+	stored.Body = nil
+	m.pages = append(m.pages, stored)
+	return nil
+}
+
+func (m *memoryPages) UpdateParserVersion(p *Page) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if p.ID < 1 || p.ID > int64(len(m.pages)) {
+		return ErrPageNotFound
+	}
+	stored := &m.pages[p.ID-1]
+	stored.ParserVersion = p.ParserVersion
+	stored.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	p.UpdatedAt = stored.UpdatedAt
+	return nil
+}
+
+func (m *memoryPages) Find(id int64) (*Page, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if id < 1 || id > int64(len(m.pages)) {
+		return nil, nil
+	}
+	p := m.pages[id-1]
+	return &p, nil
+}
+
+func (m *memoryPages) GetAfter(id int64, limit int) ([]Page, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ps := make([]Page, 0)
+	for _, p := range m.pages {
+		if p.ID > id && len(ps) < limit {
+			ps = append(ps, p)
+		}
+	}
+	return ps, nil
+}
+
+func (m *memoryPages) ReadBody(p *Page) error {
+	return readBody(m.archive, p)
+}
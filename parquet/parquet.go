@@ -0,0 +1,334 @@
+// Package parquet writes flat Parquet files, for exports to data lakes.
+//
+// Only what exports need is supported: required or optional columns of
+// strings, integers and timestamps, plain encoded in a single Snappy
+// compressed data page per column chunk.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+// Column types
+type Type int
+
+const (
+	String Type = iota
+	Int32
+	Int64
+	// Timestamp columns take time.Time values, stored as milliseconds since
+	// the Unix epoch.
+	Timestamp
+)
+
+// DefaultRowGroupSize is how many rows a Writer buffers before writing them.
+const DefaultRowGroupSize = 100000
+
+var magic = []byte("PAR1")
+
+// Parquet format enums
+const (
+	typeInt32     = 1
+	typeInt64     = 2
+	typeByteArray = 6
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecSnappy  = 1
+	pageTypeData = 0
+)
+
+type Column struct {
+	Name string
+	Type Type
+	// Optional columns take nil values, written as nulls.
+	Optional bool
+}
+
+func (c *Column) physicalType() int32 {
+	switch c.Type {
+	case String:
+		return typeByteArray
+	case Int32:
+		return typeInt32
+	}
+	return typeInt64
+}
+
+// columnChunk buffers the values of a column in the current row group.
+type columnChunk struct {
+	values bytes.Buffer
+	// levels are the definition levels of optional columns, 0 for nulls
+	levels []byte
+}
+
+type columnMeta struct {
+	offset       int64
+	uncompressed int64
+	compressed   int64
+}
+
+type rowGroupMeta struct {
+	rows    int64
+	columns []columnMeta
+}
+
+// Writer writes rows to a Parquet file. Rows are buffered in memory until
+// there are RowGroupSize of them, so memory use doesn't grow with the file.
+type Writer struct {
+	RowGroupSize int
+
+	w         io.Writer
+	columns   []Column
+	chunks    []columnChunk
+	rows      int
+	offset    int64
+	rowGroups []rowGroupMeta
+}
+
+func NewWriter(w io.Writer, columns []Column) *Writer {
+	return &Writer{
+		RowGroupSize: DefaultRowGroupSize,
+		w:            w,
+		columns:      columns,
+		chunks:       make([]columnChunk, len(columns)),
+	}
+}
+
+// Write adds a row, with a value for each column: a string, an int32 or int,
+// an int64 or int, or a time.Time, or nil for optional columns.
+func (w *Writer) Write(values ...interface{}) error {
+	if len(values) != len(w.columns) {
+		return fmt.Errorf("parquet: expected %v values, got %v", len(w.columns), len(values))
+	}
+	// check every value first, so rows are written whole or not at all
+	for i, v := range values {
+		if err := w.columns[i].check(v); err != nil {
+			return err
+		}
+	}
+	for i, v := range values {
+		w.chunks[i].add(&w.columns[i], v)
+	}
+	w.rows++
+	if w.rows >= w.RowGroupSize {
+		return w.flush()
+	}
+	return nil
+}
+
+func (c *Column) check(v interface{}) error {
+	ok := false
+	switch v.(type) {
+	case nil:
+		ok = c.Optional
+	case string:
+		ok = c.Type == String
+	case int32:
+		ok = c.Type == Int32
+	case int64:
+		ok = c.Type == Int64
+	case int:
+		ok = c.Type == Int32 || c.Type == Int64
+	case time.Time:
+		ok = c.Type == Timestamp
+	}
+	if !ok {
+		return fmt.Errorf("parquet: column %v can't take %#v", c.Name, v)
+	}
+	return nil
+}
+
+func (c *columnChunk) add(col *Column, v interface{}) {
+	if col.Optional {
+		level := byte(1)
+		if v == nil {
+			level = 0
+		}
+		c.levels = append(c.levels, level)
+	}
+	var b [8]byte
+	switch v := v.(type) {
+	case string:
+		binary.LittleEndian.PutUint32(b[:4], uint32(len(v)))
+		c.values.Write(b[:4])
+		c.values.WriteString(v)
+	case int32:
+		binary.LittleEndian.PutUint32(b[:4], uint32(v))
+		c.values.Write(b[:4])
+	case int:
+		if col.Type == Int32 {
+			binary.LittleEndian.PutUint32(b[:4], uint32(v))
+			c.values.Write(b[:4])
+		} else {
+			binary.LittleEndian.PutUint64(b[:], uint64(v))
+			c.values.Write(b[:])
+		}
+	case int64:
+		binary.LittleEndian.PutUint64(b[:], uint64(v))
+		c.values.Write(b[:])
+	case time.Time:
+		binary.LittleEndian.PutUint64(b[:], uint64(v.UnixMilli()))
+		c.values.Write(b[:])
+	}
+}
+
+// encodeLevels encodes definition levels with the RLE hybrid encoding, as
+// runs of the same level, after their length.
+func encodeLevels(levels []byte) []byte {
+	b := make([]byte, 4)
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		b = binary.AppendUvarint(b, uint64(j-i)<<1)
+		b = append(b, levels[i])
+		i = j
+	}
+	binary.LittleEndian.PutUint32(b, uint32(len(b)-4))
+	return b
+}
+
+func (w *Writer) write(b []byte) error {
+	n, err := w.w.Write(b)
+	w.offset += int64(n)
+	return err
+}
+
+// flush writes the buffered rows as a row group.
+func (w *Writer) flush() error {
+	if w.offset == 0 {
+		if err := w.write(magic); err != nil {
+			return err
+		}
+	}
+	if w.rows == 0 {
+		return nil
+	}
+
+	rg := rowGroupMeta{rows: int64(w.rows)}
+	for i := range w.chunks {
+		chunk := &w.chunks[i]
+		page := chunk.values.Bytes()
+		if w.columns[i].Optional {
+			page = append(encodeLevels(chunk.levels), page...)
+		}
+		compressed := snappy.Encode(nil, page)
+
+		header := &compact{}
+		header.begin(0)
+		header.i32(1, pageTypeData)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(compressed)))
+		header.begin(5)
+		header.i32(1, int32(w.rows))
+		header.i32(2, encodingPlain)
+		header.i32(3, encodingRLE)
+		header.i32(4, encodingRLE)
+		header.end()
+		header.end()
+
+		meta := columnMeta{
+			offset:       w.offset,
+			uncompressed: int64(len(header.b) + len(page)),
+			compressed:   int64(len(header.b) + len(compressed)),
+		}
+		if err := w.write(header.b); err != nil {
+			return err
+		}
+		if err := w.write(compressed); err != nil {
+			return err
+		}
+		rg.columns = append(rg.columns, meta)
+		chunk.values.Reset()
+		chunk.levels = chunk.levels[:0]
+	}
+	w.rowGroups = append(w.rowGroups, rg)
+	w.rows = 0
+	return nil
+}
+
+// Close writes the buffered rows and the file metadata. It doesn't close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+
+	var rows int64
+	for _, rg := range w.rowGroups {
+		rows += rg.rows
+	}
+	m := &compact{}
+	m.begin(0)
+	m.i32(1, 1)
+	m.list(2, compactStruct, len(w.columns)+1)
+	m.begin(0)
+	m.string(4, "schema")
+	m.i32(5, int32(len(w.columns)))
+	m.end()
+	for _, c := range w.columns {
+		m.begin(0)
+		m.i32(1, c.physicalType())
+		repetition := int32(repetitionRequired)
+		if c.Optional {
+			repetition = repetitionOptional
+		}
+		m.i32(3, repetition)
+		m.string(4, c.Name)
+		if c.Type == String {
+			m.i32(6, convertedUTF8)
+		} else if c.Type == Timestamp {
+			m.i32(6, convertedTimestampMillis)
+		}
+		m.end()
+	}
+	m.i64(3, rows)
+	m.list(4, compactStruct, len(w.rowGroups))
+	for _, rg := range w.rowGroups {
+		m.begin(0)
+		m.list(1, compactStruct, len(rg.columns))
+		var size int64
+		for i, cm := range rg.columns {
+			size += cm.uncompressed
+			m.begin(0)
+			m.i64(2, cm.offset)
+			m.begin(3)
+			m.i32(1, w.columns[i].physicalType())
+			m.list(2, compactI32, 2)
+			m.varint(encodingPlain)
+			m.varint(encodingRLE)
+			m.list(3, compactBinary, 1)
+			m.stringElem(w.columns[i].Name)
+			m.i32(4, codecSnappy)
+			m.i64(5, rg.rows)
+			m.i64(6, cm.uncompressed)
+			m.i64(7, cm.compressed)
+			m.i64(9, cm.offset)
+			m.end()
+			m.end()
+		}
+		m.i64(2, size)
+		m.i64(3, rg.rows)
+		m.end()
+	}
+	m.string(6, "adscraper")
+	m.end()
+
+	footer := binary.LittleEndian.AppendUint32(m.b, uint32(len(m.b)))
+	return w.write(append(footer, magic...))
+}
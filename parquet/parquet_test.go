@@ -0,0 +1,234 @@
+package parquet_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gkats/adscraper/parquet"
+	"github.com/klauspost/compress/snappy"
+)
+
+// thrift decodes the Thrift compact protocol into maps by field id, slices,
+// int64s and byte slices.
+type thrift struct {
+	b []byte
+}
+
+func (t *thrift) uvarint() uint64 {
+	v, n := binary.Uvarint(t.b)
+	t.b = t.b[n:]
+	return v
+}
+
+func (t *thrift) varint() int64 {
+	v := t.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (t *thrift) value(typ byte) interface{} {
+	switch typ {
+	case 5, 6:
+		return t.varint()
+	case 8:
+		n := t.uvarint()
+		v := t.b[:n]
+		t.b = t.b[n:]
+		return v
+	case 9:
+		h := t.b[0]
+		t.b = t.b[1:]
+		n := uint64(h >> 4)
+		if n == 15 {
+			n = t.uvarint()
+		}
+		l := make([]interface{}, n)
+		for i := range l {
+			l[i] = t.value(h & 0x0f)
+		}
+		return l
+	case 12:
+		s := make(map[int64]interface{})
+		var id int64
+		for t.b[0] != 0 {
+			h := t.b[0]
+			t.b = t.b[1:]
+			if h>>4 == 0 {
+				id = t.varint()
+			} else {
+				id += int64(h >> 4)
+			}
+			s[id] = t.value(h & 0x0f)
+		}
+		t.b = t.b[1:]
+		return s
+	}
+	panic(fmt.Sprintf("unexpected type %v", typ))
+}
+
+// read returns the metadata and the values of every column of a file.
+func read(t *testing.T, b []byte) (map[int64]interface{}, map[string][]interface{}) {
+	if !bytes.HasPrefix(b, []byte("PAR1")) || !bytes.HasSuffix(b, []byte("PAR1")) {
+		t.Fatalf("Expected the Parquet magic number, got %q", b)
+	}
+	n := binary.LittleEndian.Uint32(b[len(b)-8:])
+	meta := (&thrift{b[len(b)-8-int(n) : len(b)-8]}).value(12).(map[int64]interface{})
+
+	schema := meta[2].([]interface{})
+	columns := make(map[string][]interface{})
+	for _, rg := range meta[4].([]interface{}) {
+		for i, cc := range rg.(map[int64]interface{})[1].([]interface{}) {
+			el := schema[i+1].(map[int64]interface{})
+			cm := cc.(map[int64]interface{})[3].(map[int64]interface{})
+			r := &thrift{b[cm[9].(int64):]}
+			header := r.value(12).(map[int64]interface{})
+			page, err := snappy.Decode(nil, r.b[:header[3].(int64)])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rows := header[5].(map[int64]interface{})[1].(int64)
+			levels := make([]byte, 0, rows)
+			if el[3].(int64) == 1 {
+				n := binary.LittleEndian.Uint32(page)
+				l := &thrift{page[4 : 4+n]}
+				for len(l.b) > 0 {
+					run := l.uvarint() >> 1
+					levels = append(levels, bytes.Repeat(l.b[:1], int(run))...)
+					l.b = l.b[1:]
+				}
+				page = page[4+n:]
+			} else {
+				levels = append(levels, bytes.Repeat([]byte{1}, int(rows))...)
+			}
+
+			name := string(el[4].([]byte))
+			for _, level := range levels {
+				if level == 0 {
+					columns[name] = append(columns[name], nil)
+					continue
+				}
+				switch el[1].(int64) {
+				case 1:
+					columns[name] = append(columns[name], int32(binary.LittleEndian.Uint32(page)))
+					page = page[4:]
+				case 2:
+					columns[name] = append(columns[name], int64(binary.LittleEndian.Uint64(page)))
+					page = page[8:]
+				case 6:
+					n := binary.LittleEndian.Uint32(page)
+					columns[name] = append(columns[name], string(page[4:4+n]))
+					page = page[4+n:]
+				}
+			}
+		}
+	}
+	return meta, columns
+}
+
+var columns = []parquet.Column{
+	{Name: "id", Type: parquet.Int64},
+	{Name: "h1", Type: parquet.String},
+	{Name: "position", Type: parquet.Int32},
+	{Name: "pageId", Type: parquet.Int64, Optional: true},
+	{Name: "observedAt", Type: parquet.Timestamp},
+}
+
+func TestWriter(t *testing.T) {
+	observedAt := time.Date(2026, 10, 19, 16, 0, 0, 0, time.UTC)
+	buf := &bytes.Buffer{}
+	w := parquet.NewWriter(buf, columns)
+	w.RowGroupSize = 2
+	for i := 1; i <= 5; i++ {
+		var pageId interface{}
+		if i%2 == 0 {
+			pageId = int64(i * 10)
+		}
+		if err := w.Write(int64(i), fmt.Sprintf("Women Shoes %v", i), i, pageId, observedAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Write("1", "Women Shoes", 1, nil, observedAt); err == nil {
+		t.Error("Expected an error for a value of the wrong type")
+	}
+	if err := w.Write(int64(6), "Women Shoes", 1, nil); err == nil {
+		t.Error("Expected an error for a missing value")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, values := read(t, buf.Bytes())
+	names := make([]string, 0)
+	for _, el := range meta[2].([]interface{}) {
+		names = append(names, string(el.(map[int64]interface{})[4].([]byte)))
+	}
+	ms := observedAt.UnixMilli()
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{int64(5), meta[3]},
+		{3, len(meta[4].([]interface{}))},
+		{"schema,id,h1,position,pageId,observedAt", strings.Join(names, ",")},
+		{"[1 2 3 4 5]", fmt.Sprint(values["id"])},
+		{"Women Shoes 5", values["h1"][4]},
+		{"[1 2 3 4 5]", fmt.Sprint(values["position"])},
+		{"[<nil> 20 <nil> 40 <nil>]", fmt.Sprint(values["pageId"])},
+		{fmt.Sprint([]int64{ms, ms, ms, ms, ms}), fmt.Sprint(values["observedAt"])},
+	}
+	for i, tc := range testCases {
+		if fmt.Sprint(tc.want) != fmt.Sprint(tc.got) {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+
+	// Files without rows are still valid
+	buf.Reset()
+	if err := parquet.NewWriter(buf, columns).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if meta, _ := read(t, buf.Bytes()); meta[3] != int64(0) {
+		t.Errorf("Expected no rows, got %v", meta[3])
+	}
+}
+
+func TestPartitions(t *testing.T) {
+	dir := t.TempDir()
+	p := parquet.NewPartitions(dir, columns)
+	p.MaxOpen = 2
+	for i, day := range []string{"2026-10-17", "2026-10-18", "2026-10-18", "2026-10-19", "2026-10-17"} {
+		observedAt, _ := time.Parse("2006-01-02", day)
+		if err := p.Write("date="+day, int64(i), "Women Shoes", 1, nil, observedAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := make([]string, 0)
+	for _, f := range p.Files {
+		rel, _ := filepath.Rel(dir, f)
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, values := read(t, b)
+		files = append(files, fmt.Sprintf("%v %v", rel, values["id"]))
+	}
+	// The 17th was completed to start the 19th
+	want := "date=2026-10-17/part-00000.parquet [0],date=2026-10-17/part-00001.parquet [4]," +
+		"date=2026-10-18/part-00000.parquet [1 2],date=2026-10-19/part-00000.parquet [3]"
+	if got := strings.Join(files, ","); got != want {
+		t.Errorf("Expected files %v, got %v", want, got)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(dir, "*", "*.tmp")); len(tmp) > 0 {
+		t.Errorf("Expected no temporary files, got %v", tmp)
+	}
+}
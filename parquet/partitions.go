@@ -0,0 +1,124 @@
+package parquet
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DefaultMaxOpen is how many files Partitions writes at once by default.
+const DefaultMaxOpen = 32
+
+// Partitions writes rows to a file per partition key, Hive style, like
+// dir/date=2026-10-19/part-00000.parquet. Files are written under a
+// temporary name and only renamed once they're complete.
+type Partitions struct {
+	// MaxOpen is how many files are written at once. Rows for another key
+	// complete the least recently written file, and later rows for its key
+	// go to a new part.
+	MaxOpen      int
+	RowGroupSize int
+	// Files are the files completed so far.
+	Files []string
+
+	dir     string
+	columns []Column
+	open    map[string]*partition
+	parts   map[string]int
+	writes  int
+}
+
+type partition struct {
+	path      string
+	f         *os.File
+	w         *Writer
+	lastWrite int
+}
+
+func NewPartitions(dir string, columns []Column) *Partitions {
+	return &Partitions{
+		MaxOpen:      DefaultMaxOpen,
+		RowGroupSize: DefaultRowGroupSize,
+		dir:          dir,
+		columns:      columns,
+		open:         make(map[string]*partition),
+		parts:        make(map[string]int),
+	}
+}
+
+// Write adds a row to the partition with the key, see Writer.Write.
+func (p *Partitions) Write(key string, values ...interface{}) error {
+	part, ok := p.open[key]
+	if !ok {
+		if len(p.open) >= p.MaxOpen {
+			if err := p.closeOldest(); err != nil {
+				return err
+			}
+		}
+		var err error
+		if part, err = p.create(key); err != nil {
+			return err
+		}
+	}
+	p.writes++
+	part.lastWrite = p.writes
+	return part.w.Write(values...)
+}
+
+func (p *Partitions) create(key string) (*partition, error) {
+	dir := filepath.Join(p.dir, key)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("part-%05d.parquet", p.parts[key]))
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	p.parts[key]++
+
+	w := NewWriter(f, p.columns)
+	w.RowGroupSize = p.RowGroupSize
+	part := &partition{path: path, f: f, w: w}
+	p.open[key] = part
+	return part, nil
+}
+
+func (p *Partitions) closeOldest() error {
+	oldest := ""
+	for key, part := range p.open {
+		if oldest == "" || part.lastWrite < p.open[oldest].lastWrite {
+			oldest = key
+		}
+	}
+	return p.close(oldest)
+}
+
+func (p *Partitions) close(key string) error {
+	part := p.open[key]
+	delete(p.open, key)
+	if err := part.w.Close(); err != nil {
+		part.f.Close()
+		return err
+	}
+	if err := part.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(part.path+".tmp", part.path); err != nil {
+		return err
+	}
+	p.Files = append(p.Files, part.path)
+	return nil
+}
+
+// Close completes every open file.
+func (p *Partitions) Close() error {
+	for key := range p.open {
+		if err := p.close(key); err != nil {
+			return err
+		}
+	}
+	sort.Strings(p.Files)
+	return nil
+}
@@ -0,0 +1,84 @@
+package parquet
+
+import "encoding/binary"
+
+// Thrift compact protocol types
+const (
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+// compact encodes the Thrift structs of Parquet metadata with the compact
+// protocol. Fields have to be written in increasing id order.
+type compact struct {
+	b []byte
+	// last holds the last field id written in each open struct
+	last []int16
+}
+
+func (c *compact) uvarint(v uint64) {
+	c.b = binary.AppendUvarint(c.b, v)
+}
+
+func (c *compact) varint(v int64) {
+	c.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (c *compact) field(id int16, t byte) {
+	last := &c.last[len(c.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		c.b = append(c.b, byte(delta)<<4|t)
+	} else {
+		c.b = append(c.b, t)
+		c.varint(int64(id))
+	}
+	*last = id
+}
+
+func (c *compact) i32(id int16, v int32) {
+	c.field(id, compactI32)
+	c.varint(int64(v))
+}
+
+func (c *compact) i64(id int16, v int64) {
+	c.field(id, compactI64)
+	c.varint(v)
+}
+
+func (c *compact) string(id int16, s string) {
+	c.field(id, compactBinary)
+	c.stringElem(s)
+}
+
+func (c *compact) stringElem(s string) {
+	c.uvarint(uint64(len(s)))
+	c.b = append(c.b, s...)
+}
+
+// list starts a list of n elements of type t, which are written next.
+func (c *compact) list(id int16, t byte, n int) {
+	c.field(id, compactList)
+	if n < 15 {
+		c.b = append(c.b, byte(n)<<4|t)
+	} else {
+		c.b = append(c.b, 0xf0|t)
+		c.uvarint(uint64(n))
+	}
+}
+
+// begin starts a struct field, or a struct element of a list or the top
+// level struct with id 0.
+func (c *compact) begin(id int16) {
+	if id > 0 {
+		c.field(id, compactStruct)
+	}
+	c.last = append(c.last, 0)
+}
+
+func (c *compact) end() {
+	c.b = append(c.b, 0)
+	c.last = c.last[:len(c.last)-1]
+}
@@ -0,0 +1,140 @@
+package adscraper
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSort   = errors.New("invalid sort")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+const (
+	DefaultAdsLimit = 50
+	MaxAdsLimit     = 500
+)
+
+// ObservationsByKeyword is the Sort exporting observations by keyword, then
+// by when they were made.
+const ObservationsByKeyword = "keyword"
+
+// AdQuery filters, orders and pages through ads. The keyword, date, block and
+// position filters match ads with at least one sighting meeting all of them.
+type AdQuery struct {
+	KeywordId int64
+	// Group and Tag match sightings for keywords with the label.
+	Group string
+	Tag   string
+	// Domain matches the host of the ad's visible URL and its subdomains.
+	Domain string
+	// From and To limit sightings to [From, To).
+	From        time.Time
+	To          time.Time
+	Block       string
+	MinPosition int
+	MaxPosition int
+	// Text is searched for in the headlines and the description.
+	Text string
+	// Sort is "id", "createdAt" or "updatedAt", prefixed with "-" for
+	// descending order. Newest ads come first by default. Observations are
+	// exported in ID order unless it's ObservationsByKeyword.
+	Sort string
+	// Cursor continues after the last ad of a previous page.
+	Cursor string
+	Limit  int
+}
+
+func (q *AdQuery) limit() int {
+	if q.Limit <= 0 {
+		return DefaultAdsLimit
+	} else if q.Limit > MaxAdsLimit {
+		return MaxAdsLimit
+	}
+	return q.Limit
+}
+
+func (q *AdQuery) sighted() bool {
+	return q.KeywordId > 0 || !q.From.IsZero() || !q.To.IsZero() || q.Block != "" ||
+		q.MinPosition > 0 || q.MaxPosition > 0 || q.Group != "" || q.Tag != ""
+}
+
+var adSorts = map[string]string{
+	"id":        "id",
+	"createdAt": "created_at",
+	"updatedAt": "updated_at",
+}
+
+type adOrder struct {
+	field  string
+	column string
+	desc   bool
+	after  *adCursor
+}
+
+// adCursor points at the last ad of a page. Ads are ordered by the sort field
+// and then by ID, so the pair is unique.
+type adCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func (q *AdQuery) order() (*adOrder, error) {
+	sort := q.Sort
+	if sort == "" {
+		sort = "-id"
+	}
+	o := &adOrder{field: strings.TrimPrefix(sort, "-"), desc: strings.HasPrefix(sort, "-")}
+	column, ok := adSorts[o.field]
+	if !ok {
+		return nil, ErrInvalidSort
+	}
+	o.column = column
+
+	if q.Cursor == "" {
+		return o, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	o.after = &adCursor{}
+	if err = json.Unmarshal(b, o.after); err != nil || o.after.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+	return o, nil
+}
+
+func (o *adOrder) value(ad *Ad) string {
+	switch o.field {
+	case "createdAt":
+		return ad.CreatedAt
+	case "updatedAt":
+		return ad.UpdatedAt
+	}
+	return strconv.FormatInt(ad.ID, 10)
+}
+
+func (o *adOrder) cursor(ad *Ad) string {
+	sort := o.field
+	if o.desc {
+		sort = "-" + sort
+	}
+	b, _ := json.Marshal(&adCursor{Sort: sort, Value: o.value(ad), ID: ad.ID})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// page trims ads fetched with an extra row down to limit and returns the
+// cursor of the next page, if there is one.
+func (o *adOrder) page(ads []Ad, limit int) ([]Ad, string) {
+	if len(ads) <= limit {
+		return ads, ""
+	}
+	ads = ads[:limit]
+	return ads, o.cursor(&ads[limit-1])
+}
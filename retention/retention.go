@@ -0,0 +1,287 @@
+package retention
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/gkats/adscraper/archive"
+	"github.com/gkats/adscraper/db"
+)
+
+// Policy says how long data is kept around. Zero keeps data forever.
+type Policy struct {
+	// RawDays is how long the raw and rest HTML of ads that haven't been
+	// observed since is kept.
+	RawDays int
+	// ObservationDays is how long individual observations and ad keyword
+	// positions are kept. Older ones are rolled up into daily aggregates.
+	ObservationDays int
+	// ExportURL is where pruned data is exported before it's deleted.
+	ExportURL string
+}
+
+func (p *Policy) Flags(fs *flag.FlagSet) {
+	fs.IntVar(&p.RawDays, "retain-raw-days", 0, "Days to keep the raw HTML of ads that haven't been seen since. Zero keeps it forever.")
+	fs.IntVar(&p.ObservationDays, "retain-observations-days", 0, "Days to keep individual observations before rolling them up into daily aggregates. Zero keeps them forever.")
+	fs.StringVar(&p.ExportURL, "retention-export", os.Getenv("RETENTION_EXPORT_URL"), "Where to export pruned data as gzipped NDJSON before deleting it. Either a directory path, 'file:///path/to/dir' or 's3://bucket/prefix?endpoint=host:port&secure=false'.")
+}
+
+func (p Policy) Enabled() bool {
+	return p.RawDays > 0 || p.ObservationDays > 0
+}
+
+type Report struct {
+	RawCleared           int64
+	ObservationsRolledUp int64
+	AdKeywordsPruned     int64
+	Exports              []string
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf(
+		"cleared raw HTML of %v ads, rolled up %v observations, pruned %v ad keywords, exported %v",
+		r.RawCleared, r.ObservationsRolledUp, r.AdKeywordsPruned, r.Exports,
+	)
+}
+
+type Pruner struct {
+	store   db.Store
+	exports archive.BlobStore
+	policy  Policy
+}
+
+func New(s db.Store, exports archive.BlobStore, p Policy) *Pruner {
+	return &Pruner{store: s, exports: exports, policy: p}
+}
+
+// Open returns a Pruner exporting to p.ExportURL.
+func Open(s db.Store, p Policy) (*Pruner, error) {
+	if p.ExportURL == "" {
+		return nil, errors.New("Retention needs an export location for pruned data")
+	}
+	exports, err := archive.OpenStore(p.ExportURL)
+	if err != nil {
+		return nil, err
+	}
+	return New(s, exports, p), nil
+}
+
+// Every applies the policy every interval until stop is closed, handing the
+// outcome of each run to done.
+func (p *Pruner) Every(interval time.Duration, stop <-chan struct{}, done func(*Report, error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-t.C:
+			done(p.Run(now))
+		}
+	}
+}
+
+// Run applies the policy as of now. Data is exported before it's deleted, a
+// failed export leaves the data in place.
+func (p *Pruner) Run(now time.Time) (*Report, error) {
+	r := &Report{Exports: make([]string, 0)}
+	now = now.UTC()
+	stamp := now.Format("20060102T150405Z")
+
+	if p.policy.RawDays > 0 {
+		if err := p.clearRaw(r, now.AddDate(0, 0, -p.policy.RawDays), stamp); err != nil {
+			return r, err
+		}
+	}
+	if p.policy.ObservationDays > 0 {
+		if err := p.rollUp(r, now.AddDate(0, 0, -p.policy.ObservationDays), stamp); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+const staleRaw = `
+    (raw IS NOT NULL OR rest IS NOT NULL)
+    AND updated_at < $1
+    AND NOT EXISTS (
+      SELECT 1 FROM observations
+      WHERE observations.ad_id = ads.id
+      AND observations.observed_at >= $1
+    )
+    `
+
+type rawJSON struct {
+	ID   int64   `json:"id"`
+	Raw  *string `json:"raw"`
+	Rest *string `json:"rest"`
+}
+
+func (p *Pruner) clearRaw(r *Report, cutoff time.Time, stamp string) error {
+	key := "ads-raw/" + stamp + ".ndjson.gz"
+	err := p.export(r, key, `SELECT id, raw, rest FROM ads WHERE `+staleRaw, cutoff, func(rows *sql.Rows) (interface{}, error) {
+		v := rawJSON{}
+		return v, rows.Scan(&v.ID, &v.Raw, &v.Rest)
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := p.store.Exec(`UPDATE ads SET raw = NULL, rest = NULL WHERE `+staleRaw, cutoff)
+	if err != nil {
+		return err
+	}
+	r.RawCleared, err = res.RowsAffected()
+	return err
+}
+
+type observationJSON struct {
+	ID         int64  `json:"id"`
+	AdId       int64  `json:"adId"`
+	KeywordId  int64  `json:"keywordId"`
+	PageId     *int64 `json:"pageId"`
+	Position   int    `json:"position"`
+	Block      string `json:"block"`
+	ObservedAt string `json:"observedAt"`
+}
+
+type adKeywordJSON struct {
+	ID            int64  `json:"id"`
+	AdId          int64  `json:"adId"`
+	KeywordId     int64  `json:"keywordId"`
+	Position      int    `json:"position"`
+	PositionCount int    `json:"positionCount"`
+	CreatedAt     string `json:"createdAt"`
+	UpdatedAt     string `json:"updatedAt"`
+}
+
+func (p *Pruner) rollUp(r *Report, cutoff time.Time, stamp string) error {
+	err := p.export(
+		r, "observations/"+stamp+".ndjson.gz",
+		`SELECT id, ad_id, keyword_id, page_id, position, block, observed_at FROM observations WHERE observed_at < $1`,
+		cutoff,
+		func(rows *sql.Rows) (interface{}, error) {
+			v := observationJSON{}
+			pageId := sql.NullInt64{}
+			err := rows.Scan(&v.ID, &v.AdId, &v.KeywordId, &pageId, &v.Position, &v.Block, &v.ObservedAt)
+			if pageId.Valid {
+				v.PageId = &pageId.Int64
+			}
+			return v, err
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	err = p.export(
+		r, "ad_keywords/"+stamp+".ndjson.gz",
+		`SELECT id, ad_id, keyword_id, position, position_count, created_at, updated_at FROM ad_keywords WHERE updated_at < $1`,
+		cutoff,
+		func(rows *sql.Rows) (interface{}, error) {
+			v := adKeywordJSON{}
+			return v, rows.Scan(&v.ID, &v.AdId, &v.KeywordId, &v.Position, &v.PositionCount, &v.CreatedAt, &v.UpdatedAt)
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	tx, err := p.store.Begin()
+	if err != nil {
+		return err
+	}
+	if err = p.rollUpTx(tx, r, cutoff); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *Pruner) rollUpTx(tx *sql.Tx, r *Report, cutoff time.Time) error {
+	_, err := tx.Exec(
+		`
+    INSERT INTO daily_observations (day, ad_id, keyword_id, position, observations_count)
+    SELECT `+db.Day(p.store.Dialect(), "observed_at")+`, ad_id, keyword_id, position, COUNT(*)
+    FROM observations
+    WHERE observed_at < $1
+    GROUP BY 1, 2, 3, 4
+    ON CONFLICT (day, ad_id, keyword_id, position)
+    DO UPDATE SET observations_count = daily_observations.observations_count + EXCLUDED.observations_count
+    `,
+		cutoff,
+	)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`DELETE FROM observations WHERE observed_at < $1`, cutoff)
+	if err != nil {
+		return err
+	}
+	if r.ObservationsRolledUp, err = res.RowsAffected(); err != nil {
+		return err
+	}
+
+	res, err = tx.Exec(`DELETE FROM ad_keywords WHERE updated_at < $1`, cutoff)
+	if err != nil {
+		return err
+	}
+	r.AdKeywordsPruned, err = res.RowsAffected()
+	return err
+}
+
+// export streams the rows of q as gzipped NDJSON to the exports store under
+// key. Nothing is written when there are no rows.
+func (p *Pruner) export(r *Report, key string, q string, cutoff time.Time, row func(*sql.Rows) (interface{}, error)) error {
+	var n int
+	if err := p.store.QueryRow(`SELECT COUNT(*) FROM (`+q+`) AS pruned`, cutoff).Scan(&n); err != nil {
+		return err
+	} else if n == 0 {
+		return nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(p.encode(pw, q, cutoff, row))
+	}()
+	err := p.exports.Put(key, pr)
+	// Unblock the encoder if the store gave up early
+	pr.CloseWithError(err)
+	if err != nil {
+		return err
+	}
+	r.Exports = append(r.Exports, key)
+	return nil
+}
+
+func (p *Pruner) encode(w io.Writer, q string, cutoff time.Time, row func(*sql.Rows) (interface{}, error)) error {
+	rows, err := p.store.Query(q, cutoff)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	for rows.Next() {
+		v, err := row(rows)
+		if err != nil {
+			return err
+		}
+		if err = enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
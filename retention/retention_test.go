@@ -0,0 +1,150 @@
+package retention_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gkats/adscraper/archive"
+	"github.com/gkats/adscraper/db"
+	"github.com/gkats/adscraper/db/dbtest"
+	"github.com/gkats/adscraper/retention"
+)
+
+var now = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+const seed = `
+INSERT INTO keywords (id, value) VALUES (1, 'book flights');
+INSERT INTO ads (id, headline1, headline2, description, path, rest, raw, updated_at) VALUES
+  (1, 'Old', 'old.com', 'Gone', 'old.com', '<p>rest</p>', '<li>old</li>', '2026-08-01 10:00:00'),
+  (2, 'Seen', 'seen.com', 'Still around', 'seen.com', NULL, '<li>seen</li>', '2026-08-01 10:00:00'),
+  (3, 'New', 'new.com', 'Fresh', 'new.com', NULL, '<li>new</li>', '2026-10-18 10:00:00');
+INSERT INTO observations (ad_id, keyword_id, position, observed_at) VALUES
+  (1, 1, 1, '2026-08-01 10:00:00'),
+  (1, 1, 1, '2026-08-01 11:00:00'),
+  (1, 1, 2, '2026-08-02 10:00:00'),
+  (2, 1, 1, '2026-10-18 10:00:00');
+INSERT INTO ad_keywords (ad_id, keyword_id, position, position_count, updated_at) VALUES
+  (1, 1, 1, 2, '2026-08-01 11:00:00'),
+  (1, 1, 2, 1, '2026-08-02 10:00:00'),
+  (2, 1, 1, 1, '2026-10-18 10:00:00');
+`
+
+func count(t *testing.T, store db.Store, q string) int {
+	var n int
+	if err := store.QueryRow(q).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func readExport(t *testing.T, blobs archive.BlobStore, key string) []map[string]interface{} {
+	rc, err := blobs.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	gz, err := gzip.NewReader(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := make([]map[string]interface{}, 0)
+	s := bufio.NewScanner(gz)
+	for s.Scan() {
+		line := make(map[string]interface{})
+		if err = json.Unmarshal(s.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestRun(t *testing.T) {
+	store := dbtest.NewStore(t, seed)
+	blobs := archive.NewMemoryStore()
+	p := retention.New(store, blobs, retention.Policy{RawDays: 30, ObservationDays: 30})
+
+	r, err := p.Run(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{int64(1), r.RawCleared},
+		{int64(3), r.ObservationsRolledUp},
+		{int64(2), r.AdKeywordsPruned},
+		{3, len(r.Exports)},
+		{1, count(t, store, `SELECT COUNT(*) FROM ads WHERE raw IS NULL AND rest IS NULL`)},
+		{0, count(t, store, `SELECT COUNT(*) FROM ads WHERE id = 2 AND raw IS NULL`)},
+		{1, count(t, store, `SELECT COUNT(*) FROM observations`)},
+		{1, count(t, store, `SELECT COUNT(*) FROM ad_keywords`)},
+		{2, count(t, store, `SELECT observations_count FROM daily_observations WHERE day = '2026-08-01' AND position = 1`)},
+		{1, count(t, store, `SELECT observations_count FROM daily_observations WHERE day = '2026-08-02' AND position = 2`)},
+		{1, len(readExport(t, blobs, "ads-raw/20261019T120000Z.ndjson.gz"))},
+		{3, len(readExport(t, blobs, "observations/20261019T120000Z.ndjson.gz"))},
+		{2, len(readExport(t, blobs, "ad_keywords/20261019T120000Z.ndjson.gz"))},
+	}
+	for i, tc := range testCases {
+		if tc.got != tc.want {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+
+	raw := readExport(t, blobs, "ads-raw/20261019T120000Z.ndjson.gz")[0]
+	if raw["raw"] != "<li>old</li>" || raw["rest"] != "<p>rest</p>" {
+		t.Errorf("Expected the raw HTML to be exported, got %v", raw)
+	}
+}
+
+func TestRunAddsToDailyAggregates(t *testing.T) {
+	store := dbtest.NewStore(t, seed)
+	p := retention.New(store, archive.NewMemoryStore(), retention.Policy{ObservationDays: 30})
+	if _, err := p.Run(now); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := store.Exec(`INSERT INTO observations (ad_id, keyword_id, position, observed_at) VALUES (1, 1, 1, '2026-08-01 12:00:00')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := p.Run(now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ObservationsRolledUp != 1 {
+		t.Errorf("Expected 1 observation rolled up, got %v", r.ObservationsRolledUp)
+	}
+	if n := count(t, store, `SELECT observations_count FROM daily_observations WHERE day = '2026-08-01' AND position = 1`); n != 3 {
+		t.Errorf("Expected 3 observations on the day, got %v", n)
+	}
+}
+
+func TestRunWithoutStaleData(t *testing.T) {
+	store := dbtest.NewStore(t, seed)
+	blobs := archive.NewMemoryStore()
+	p := retention.New(store, blobs, retention.Policy{RawDays: 365, ObservationDays: 365})
+
+	r, err := p.Run(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Exports) != 0 || r.RawCleared != 0 || r.ObservationsRolledUp != 0 {
+		t.Errorf("Expected nothing to be pruned, got %v", r)
+	}
+	if ok, _ := blobs.Exists("observations/20261019T120000Z.ndjson.gz"); ok {
+		t.Errorf("Expected no empty exports")
+	}
+}
+
+func TestOpenNeedsExportURL(t *testing.T) {
+	if _, err := retention.Open(dbtest.NewStore(t, seed), retention.Policy{RawDays: 30}); err == nil {
+		t.Errorf("Expected an error without an export URL")
+	}
+}
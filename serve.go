@@ -0,0 +1,136 @@
+package adscraper
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+type ServerConfig struct {
+	Addr string
+	// TLS is served when both files are set. They're reloaded when they
+	// change, so certificates can be renewed without a restart.
+	CertFile     string
+	KeyFile      string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// ShutdownTimeout is how long in-flight requests get to finish.
+	ShutdownTimeout time.Duration
+}
+
+// Flags registers the server flags on fs. The address defaults to the
+// LISTEN_ADDR environment variable, or port 3000.
+func (c *ServerConfig) Flags(fs *flag.FlagSet) {
+	addr := os.Getenv("LISTEN_ADDR")
+	if addr == "" {
+		addr = ":3000"
+	}
+	fs.StringVar(&c.Addr, "addr", addr, "The address to listen on, like :3000 or 127.0.0.1:8080. Defaults to the LISTEN_ADDR environment variable.")
+	fs.StringVar(&c.CertFile, "tls-cert", os.Getenv("TLS_CERT_FILE"), "Path to a PEM certificate, along with -tls-key, to serve HTTPS. Reloaded when it changes.")
+	fs.StringVar(&c.KeyFile, "tls-key", os.Getenv("TLS_KEY_FILE"), "Path to the PEM private key of -tls-cert.")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", 30*time.Second, "Maximum duration for reading a request, including the body.")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", 60*time.Second, "Maximum duration for writing a response.")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", 2*time.Minute, "Maximum duration keep-alive connections are kept idle.")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "How long in-flight requests get to finish on shutdown.")
+}
+
+// Listen serves the API on c.Addr until ctx is done, then shuts down
+// gracefully. It returns nil after a graceful shutdown.
+func (s *server) Listen(ctx context.Context, c ServerConfig) error {
+	l, err := net.Listen("tcp", c.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, l, c)
+}
+
+// Serve is Listen on an open listener, which is closed when it returns.
+func (s *server) Serve(ctx context.Context, l net.Listener, c ServerConfig) error {
+	srv := &http.Server{
+		Handler:      s.Handler(),
+		ReadTimeout:  c.ReadTimeout,
+		WriteTimeout: c.WriteTimeout,
+		IdleTimeout:  c.IdleTimeout,
+	}
+	// Streams never finish on their own, so shutdown ends them
+	srv.RegisterOnShutdown(s.feed.Close)
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		l.Close()
+		return errors.New("TLS needs both a certificate and a key")
+	} else if c.CertFile != "" {
+		certs := &certReloader{certFile: c.CertFile, keyFile: c.KeyFile}
+		if _, err := certs.GetCertificate(nil); err != nil {
+			l.Close()
+			return err
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS12}
+		l = tls.NewListener(l, srv.TLSConfig)
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- srv.Serve(l) }()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), c.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdown); err != nil {
+		return err
+	}
+	if err := <-errs; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// certReloader loads the certificate again whenever its files change.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime := time.Time{}
+	for _, f := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			if r.cert != nil {
+				// keep serving the last certificate while files are replaced
+				return r.cert, nil
+			}
+			return nil, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if r.cert != nil && modTime.Equal(r.modTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, err
+	}
+	r.cert, r.modTime = &cert, modTime
+	return r.cert, nil
+}
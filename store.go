@@ -1,30 +1,83 @@
 package adscraper
 
 import (
-	"database/sql"
-	// load the postgresql database/sql driver
-	_ "github.com/lib/pq"
+	"github.com/gkats/adscraper/brands"
+	"github.com/gkats/adscraper/compliance"
+	"github.com/gkats/adscraper/db"
+	"github.com/gkats/adscraper/keywords"
+	"github.com/gkats/adscraper/webhooks"
 )
 
-type Store interface {
-	Close() error
-	QueryRow(string, ...interface{}) *sql.Row
-	Query(string, ...interface{}) (*sql.Rows, error)
-	Begin() (*sql.Tx, error)
-}
+type Store = db.Store
 
-type store struct {
-	*sql.DB
+type Repositories struct {
+	Ads        AdReaderWriter
+	Keywords   keywords.ReaderWriter
+	Pages      PageReaderWriter
+	Webhooks   webhooks.ReaderWriter
+	Brands     brands.ReaderWriter
+	Compliance compliance.ReaderWriter
 }
 
-func NewStore(url string) (Store, error) {
-	db, err := sql.Open("postgres", "postgres://"+url+"?sslmode=require")
-	if err != nil {
-		return nil, err
+// NewRepositories returns the repositories backed by s. Pages aren't
+// archived, set Pages to a NewPageReaderWriter with an archive to enable it.
+// Ads cache the brands and compliance rules they're checked against, writing
+// them through Brands and Compliance updates them.
+func NewRepositories(s Store) *Repositories {
+	ads := newAdsStore(s)
+	return &Repositories{
+		Ads:        ads,
+		Keywords:   keywords.NewReaderWriter(s),
+		Pages:      NewPageReaderWriter(s, nil),
+		Webhooks:   webhooks.NewReaderWriter(s),
+		Brands:     &cachedBrands{brands.NewReaderWriter(s), ads.brands},
+		Compliance: &cachedRules{compliance.NewReaderWriter(s), ads.rules},
 	}
-	if err = db.Ping(); err != nil {
-		db.Close()
-		return nil, err
+}
+
+// cachedBrands invalidates the cache of the brands on writes.
+type cachedBrands struct {
+	brands.ReaderWriter
+	cache *cache
+}
+
+func (b *cachedBrands) Create(brand *brands.Brand) error {
+	defer b.cache.invalidate()
+	return b.ReaderWriter.Create(brand)
+}
+
+func (b *cachedBrands) Update(brand *brands.Brand) error {
+	defer b.cache.invalidate()
+	return b.ReaderWriter.Update(brand)
+}
+
+func (b *cachedBrands) Delete(id int64) (*brands.Brand, error) {
+	defer b.cache.invalidate()
+	return b.ReaderWriter.Delete(id)
+}
+
+func NewMemoryRepositories() *Repositories {
+	ks := keywords.NewMemoryReaderWriter()
+	ws := webhooks.NewMemoryReaderWriter()
+	bs := brands.NewMemoryReaderWriter()
+	cs := compliance.NewMemoryReaderWriter()
+	return &Repositories{
+		Ads:        &memoryAds{keywords: ks, webhooks: ws, brands: bs, compliance: cs},
+		Keywords:   ks,
+		Pages:      NewMemoryPageReaderWriter(),
+		Webhooks:   ws,
+		Brands:     bs,
+		Compliance: cs,
 	}
-	return &store{DB: db}, nil
+}
+
+// cachedRules invalidates the cache of the compliance rules when they're set.
+type cachedRules struct {
+	compliance.ReaderWriter
+	cache *cache
+}
+
+func (c *cachedRules) SetRules(rules []compliance.Rule) error {
+	defer c.cache.invalidate()
+	return c.ReaderWriter.SetRules(rules)
 }
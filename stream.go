@@ -0,0 +1,249 @@
+package adscraper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gkats/adscraper/keywords"
+)
+
+const (
+	DefaultFeedBuffer    = 256
+	DefaultFeedHeartbeat = 15 * time.Second
+	// streamWriteTimeout disconnects clients that stop reading, instead of the
+	// server's write timeout, which would end every stream.
+	streamWriteTimeout = 10 * time.Second
+	// streamRetry is how long browsers wait to reconnect, in milliseconds.
+	streamRetry = 5000
+)
+
+// liveAd is an ad as it was just observed for a keyword.
+type liveAd struct {
+	seq        int64
+	ad         Ad
+	keyword    keywords.Keyword
+	observedAt string
+}
+
+// Feed fans out the ads scrapers post to the clients of the live stream.
+// Publishing never waits for a client: when a client's buffer is full its
+// ads are dropped, and it's told how many it missed.
+type Feed struct {
+	// Buffer is how many ads wait for each client.
+	Buffer int
+	// Heartbeat is how often idle streams get a comment, so proxies don't
+	// close them.
+	Heartbeat time.Duration
+
+	mu          sync.Mutex
+	seq         int64
+	closed      bool
+	subscribers map[*subscriber]struct{}
+	metrics     *serverMetrics
+}
+
+func NewFeed() *Feed {
+	return &Feed{
+		Buffer:      DefaultFeedBuffer,
+		Heartbeat:   DefaultFeedHeartbeat,
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
+
+type subscriber struct {
+	keywordId int64
+	domain    string
+	ads       chan *liveAd
+	dropped   int64
+}
+
+func (sub *subscriber) matches(s *liveAd) bool {
+	return (sub.keywordId == 0 || sub.keywordId == s.keyword.ID) &&
+		(sub.domain == "" || matchesDomain(s.ad.Path, sub.domain))
+}
+
+// Publish sends ad, just observed for k, to the clients that want it.
+func (f *Feed) Publish(ad *Ad, k *keywords.Keyword) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed || len(f.subscribers) == 0 {
+		return
+	}
+	f.seq++
+	s := &liveAd{seq: f.seq, ad: *ad, keyword: *k, observedAt: time.Now().UTC().Format(time.RFC3339)}
+	for sub := range f.subscribers {
+		if !sub.matches(s) {
+			continue
+		}
+		select {
+		case sub.ads <- s:
+		default:
+			atomic.AddInt64(&sub.dropped, 1)
+			if f.metrics != nil {
+				f.metrics.streamDropped.Inc()
+			}
+		}
+	}
+}
+
+// subscribe returns a subscriber to the ads observed for a keyword and
+// advertiser, when they're set. Its channel is closed when the feed is.
+func (f *Feed) subscribe(keywordId int64, domain string) *subscriber {
+	sub := &subscriber{keywordId: keywordId, domain: domain, ads: make(chan *liveAd, f.Buffer)}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		close(sub.ads)
+		return sub
+	}
+	f.subscribers[sub] = struct{}{}
+	if f.metrics != nil {
+		f.metrics.streamClients.Inc()
+	}
+	return sub
+}
+
+func (f *Feed) unsubscribe(sub *subscriber) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.subscribers[sub]; ok {
+		delete(f.subscribers, sub)
+		if f.metrics != nil {
+			f.metrics.streamClients.Dec()
+		}
+	}
+}
+
+// Close ends every stream, so the server can shut down without waiting for
+// them.
+func (f *Feed) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	for sub := range f.subscribers {
+		close(sub.ads)
+	}
+}
+
+type keywordRefJSON struct {
+	ID    int64  `json:"id"`
+	Value string `json:"value"`
+}
+
+type liveAdJSON struct {
+	Ad         interface{}    `json:"ad"`
+	Keyword    keywordRefJSON `json:"keyword"`
+	Position   int            `json:"position"`
+	Block      string         `json:"block"`
+	PageId     int64          `json:"pageId,omitempty"`
+	ObservedAt string         `json:"observedAt"`
+}
+
+func newLiveAdJSON(s *liveAd, version string) *liveAdJSON {
+	var ad interface{} = newStoredAdJSON(&s.ad)
+	if version == V2 {
+		ad = newStoredAdV2JSON(&s.ad)
+	}
+	return &liveAdJSON{
+		Ad:         ad,
+		Keyword:    keywordRefJSON{ID: s.keyword.ID, Value: s.keyword.Value},
+		Position:   s.ad.Position,
+		Block:      s.ad.Block,
+		PageId:     s.ad.PageId,
+		ObservedAt: s.observedAt,
+	}
+}
+
+type streamAdsHandler struct {
+	feed           *Feed
+	keywordsReader keywords.Reader
+	version        string
+}
+
+// ServeHTTP streams the ads scrapers post as server-sent events, until the
+// client goes away or the server shuts down. Each is an "observation" event;
+// a "dropped" event tells a client that was too slow how many it missed.
+func (h *streamAdsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	keywordId, err := int64Param(params, "keywordId")
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+	if v := params.Get("keyword"); v != "" {
+		k, err := h.keywordsReader.FindByValue(v)
+		if err != nil {
+			writeResponse(w, internalServerError(err))
+			return
+		} else if k == nil {
+			writeResponse(w, notFound())
+			return
+		}
+		keywordId = k.ID
+	}
+	domain := params.Get("advertiser")
+	if domain == "" {
+		domain = params.Get("domain")
+	}
+
+	sub := h.feed.subscribe(keywordId, domain)
+	defer h.feed.unsubscribe(sub)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keeps nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	// send fails when the event can't be written or flushed, like when the
+	// writer doesn't support flushing, which ends the stream
+	send := func(event string) error {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := fmt.Fprint(w, event); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	if err := send(fmt.Sprintf("retry: %v\n\n", streamRetry)); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(h.feed.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		event := ""
+		select {
+		case <-r.Context().Done():
+			return
+		case s, ok := <-sub.ads:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(newLiveAdJSON(s, h.version))
+			if err != nil {
+				return
+			}
+			event = fmt.Sprintf("id: %v\nevent: observation\ndata: %s\n\n", s.seq, data)
+		case <-heartbeat.C:
+			event = ": heartbeat\n\n"
+		}
+		if n := atomic.SwapInt64(&sub.dropped, 0); n > 0 {
+			event = fmt.Sprintf("event: dropped\ndata: {\"count\":%v}\n\n", n) + event
+		}
+		if err := send(event); err != nil {
+			return
+		}
+	}
+}
+
+func streamAds(r *Repositories, feed *Feed, version string) http.Handler {
+	return &streamAdsHandler{feed: feed, keywordsReader: r.Keywords, version: version}
+}
@@ -0,0 +1,675 @@
+package adscraper
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gkats/adscraper/analytics"
+	"github.com/gkats/adscraper/apikeys"
+	"github.com/gkats/adscraper/brands"
+	"github.com/gkats/adscraper/keywords"
+	"github.com/gorilla/mux"
+)
+
+// The dashboard's templates and assets, under ui/.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// Limits of the dashboard's pages.
+const (
+	uiPageSize = 50
+	// uiMaxDays is the longest period the timeline and advertiser pages
+	// read observations for.
+	uiMaxDays = 90
+	// uiMaxScrapes is how many scrapes the timeline shows.
+	uiMaxScrapes = 100
+)
+
+// uiChallenge makes browsers ask for an API key, sent as the password.
+const uiChallenge = `Basic realm="adscraper", charset="UTF-8"`
+
+// uiPolicy keeps the raw HTML of ads from loading anything, on top of the
+// sandbox it's shown in.
+const uiPolicy = "default-src 'self'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; frame-ancestors 'none'"
+
+var uiTemplates = parseUITemplates("keywords", "timeline", "ad", "advertiser", "health", "brandbidding", "error")
+
+func parseUITemplates(pages ...string) map[string]*template.Template {
+	funcs := template.FuncMap{
+		"ago":        ago,
+		"percent":    func(f float64) string { return strconv.FormatFloat(f*100, 'f', 0, 64) + "%" },
+		"decimal":    func(f float64) string { return strconv.FormatFloat(f, 'f', 1, 64) },
+		"pathEscape": url.PathEscape,
+	}
+	ts := make(map[string]*template.Template)
+	for _, p := range pages {
+		ts[p] = template.Must(template.New("layout.html").Funcs(funcs).ParseFS(uiFiles, "ui/templates/layout.html", "ui/templates/"+p+".html"))
+	}
+	return ts
+}
+
+// ago is how long before now a timestamp was, like 3h ago.
+func ago(ts string) string {
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if ts == "" {
+		return "never"
+	} else if err != nil {
+		return ts
+	}
+	switch d := time.Since(t); {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%vm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%vh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%vd ago", int(d.Hours()/24))
+	}
+}
+
+// render writes a page of the dashboard. Pages are rendered whole before
+// they're written, so a failing template gets an error page.
+func render(w http.ResponseWriter, status int, page string, data interface{}) {
+	buf := &bytes.Buffer{}
+	if err := uiTemplates[page].Execute(buf, data); err != nil {
+		renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", uiPolicy)
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+type uiError struct {
+	Title     string
+	Status    int
+	Message   string
+	RequestID string
+}
+
+// renderError writes the error page, logging err like writeResponse.
+func renderError(w http.ResponseWriter, status int, err error) {
+	e := &uiError{Status: status, Message: http.StatusText(status), RequestID: w.Header().Get(requestIDHeader)}
+	e.Title = e.Message
+	if err != nil {
+		log.Printf("Request %v failed: %v", e.RequestID, err)
+	}
+	buf := &bytes.Buffer{}
+	if err := uiTemplates["error"].Execute(buf, e); err != nil {
+		log.Printf("Request %v failed: %v", e.RequestID, err)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", uiPolicy)
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+func (s *server) uiRoutes(r *mux.Router) {
+	page := func(h http.Handler) http.Handler {
+		return s.authorizeWith(uiChallenge, uiChallenge, h, apikeys.Analyst)
+	}
+	r.Handle("/static/{file}", uiStatic()).Methods("GET")
+	r.Handle("/", uiRedirect("/ui/keywords")).Methods("GET")
+	r.Handle("/keywords", page(uiKeywords(s.repos))).Methods("GET")
+	r.Handle("/keywords/{id}", page(uiTimeline(s.repos))).Methods("GET")
+	r.Handle("/ads/{id}", page(uiAd(s.repos))).Methods("GET")
+	r.Handle("/advertisers", page(uiFindAdvertiser())).Methods("GET")
+	r.Handle("/advertisers/{domain}", page(uiAdvertiser(s.repos))).Methods("GET")
+	r.Handle("/health", page(uiHealth(s.repos, s.readiness))).Methods("GET")
+	r.Handle("/brand-bidding", page(uiBrandBidding(s.repos))).Methods("GET")
+}
+
+// uiRedirect redirects to a page, with a body in HTML rather than the JSON
+// the API's responses default to.
+func uiRedirect(to string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Del("Content-Type")
+		http.Redirect(w, r, to, http.StatusFound)
+	}
+}
+
+func uiStatic() http.Handler {
+	static, _ := fs.Sub(uiFiles, "ui/static")
+	files := http.StripPrefix("/ui/static/", http.FileServer(http.FS(static)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The file server only sets the type when it's not set already
+		w.Header().Del("Content-Type")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		files.ServeHTTP(w, r)
+	})
+}
+
+// uiKeyword is a keyword with its scraping status at the time of the request.
+type uiKeyword struct {
+	keywords.Keyword
+	Status  string
+	Overdue float64
+}
+
+func newUIKeyword(k *keywords.Keyword, now time.Time) uiKeyword {
+	uk := uiKeyword{Keyword: *k, Overdue: k.Overdue(now)}
+	switch {
+	case k.Paused:
+		uk.Status = "paused"
+	case k.Due(now):
+		uk.Status = "due"
+	case !k.InWindow(now):
+		uk.Status = "outside window"
+	default:
+		uk.Status = "scheduled"
+	}
+	return uk
+}
+
+type uiKeywordsHandler struct {
+	keywordsReader keywords.Reader
+}
+
+// ServeHTTP lists keywords with their schedule and when they were last
+// scraped, filtered like GET /keywords.
+func (h *uiKeywordsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := keywords.Query{
+		Text:   params.Get("q"),
+		Group:  params.Get("group"),
+		Sort:   "value",
+		Cursor: params.Get("cursor"),
+		Limit:  uiPageSize,
+	}
+	if v := params.Get("paused"); v != "" {
+		paused, err := boolParam(params, "paused")
+		if err != nil {
+			renderError(w, http.StatusBadRequest, nil)
+			return
+		}
+		q.Paused = &paused
+	}
+
+	ks, next, err := h.keywordsReader.List(q)
+	if err == keywords.ErrInvalidCursor {
+		renderError(w, http.StatusBadRequest, nil)
+		return
+	} else if err != nil {
+		renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+	groups, err := h.keywordsReader.Labels(keywords.Group)
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	now := time.Now()
+	uks := make([]uiKeyword, 0, len(ks))
+	for i := range ks {
+		uks = append(uks, newUIKeyword(&ks[i], now))
+	}
+	nextURL := ""
+	if next != "" {
+		params.Set("cursor", next)
+		nextURL = "/ui/keywords?" + params.Encode()
+	}
+	render(w, http.StatusOK, "keywords", map[string]interface{}{
+		"Title":    "Keywords",
+		"Keywords": uks,
+		"Groups":   groups,
+		"Q":        q.Text,
+		"Group":    q.Group,
+		"Paused":   params.Get("paused"),
+		"Next":     nextURL,
+	})
+}
+
+func uiKeywords(r *Repositories) http.Handler {
+	return &uiKeywordsHandler{keywordsReader: r.Keywords}
+}
+
+// daysParam is the number of days to look back from now, def unless it's
+// given.
+func daysParam(params url.Values, def int) (int, error) {
+	days, err := int64Param(params, "days")
+	if err != nil || days > uiMaxDays {
+		return 0, fmt.Errorf("Invalid days %q", params.Get("days"))
+	} else if days == 0 {
+		return def, nil
+	}
+	return int(days), nil
+}
+
+// serpAd is an ad as it was shown on a results page.
+type serpAd struct {
+	Position int
+	Ad       *Ad
+}
+
+// scrape is the ads observed in a single scrape of a keyword.
+type scrape struct {
+	At     string
+	PageId int64
+	Top    []serpAd
+	Bottom []serpAd
+}
+
+// newScrape lays out the ads of a results page by block and position.
+func newScrape(serp *analytics.SERP, ads *adCache) (*scrape, error) {
+	s := &scrape{At: serp.At.UTC().Format(time.RFC3339), PageId: serp.PageId}
+	for _, o := range serp.Observations {
+		ad, err := ads.find(o.AdId)
+		if err != nil {
+			return nil, err
+		}
+		a := serpAd{Position: o.Position, Ad: ad}
+		if o.Block == BlockBottom {
+			s.Bottom = append(s.Bottom, a)
+		} else {
+			s.Top = append(s.Top, a)
+		}
+	}
+	for _, block := range [][]serpAd{s.Top, s.Bottom} {
+		sort.SliceStable(block, func(i, j int) bool { return block[i].Position < block[j].Position })
+	}
+	return s, nil
+}
+
+// adCache finds each ad once.
+type adCache struct {
+	adReader AdReader
+	ads      map[int64]*Ad
+}
+
+func newAdCache(r AdReader) *adCache {
+	return &adCache{adReader: r, ads: make(map[int64]*Ad)}
+}
+
+func (c *adCache) find(id int64) (*Ad, error) {
+	if ad, ok := c.ads[id]; ok {
+		return ad, nil
+	}
+	ad, err := c.adReader.Find(id)
+	if err != nil {
+		return nil, err
+	} else if ad == nil {
+		ad = &Ad{ID: id}
+	}
+	c.ads[id] = ad
+	return ad, nil
+}
+
+type uiTimelineHandler struct {
+	keywordsReader    keywords.Reader
+	adReader          AdReader
+	observationReader ObservationReader
+}
+
+// ServeHTTP shows the results pages of a keyword's scrapes, newest first, as
+// the ads in each block by position.
+func (h *uiTimelineHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, nil)
+		return
+	}
+	days, err := daysParam(r.URL.Query(), 7)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, nil)
+		return
+	}
+	k, err := h.keywordsReader.Find(id)
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, err)
+		return
+	} else if k == nil {
+		renderError(w, http.StatusNotFound, nil)
+		return
+	}
+
+	// Only the latest scrapes are shown
+	serps := make([]analytics.SERP, 0, uiMaxScrapes+1)
+	err = exportSERPs(h.observationReader, AdQuery{KeywordId: k.ID, From: time.Now().AddDate(0, 0, -days)}, func(serp *analytics.SERP) {
+		if serps = append(serps, *serp); len(serps) > uiMaxScrapes {
+			serps = serps[1:]
+		}
+	})
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// Ads are found once the export is done, it can't use the store
+	ads := newAdCache(h.adReader)
+	ss := make([]*scrape, 0, uiMaxScrapes)
+	for i := len(serps) - 1; i >= 0 && len(ss) < uiMaxScrapes; i-- {
+		s, err := newScrape(&serps[i], ads)
+		if err != nil {
+			renderError(w, http.StatusInternalServerError, err)
+			return
+		}
+		ss = append(ss, s)
+	}
+
+	render(w, http.StatusOK, "timeline", map[string]interface{}{
+		"Title":   k.Value,
+		"Keyword": newUIKeyword(k, time.Now()),
+		"Days":    days,
+		"Scrapes": ss,
+	})
+}
+
+func uiTimeline(r *Repositories) http.Handler {
+	return &uiTimelineHandler{keywordsReader: r.Keywords, adReader: r.Ads, observationReader: r.Ads}
+}
+
+type uiSighting struct {
+	AdKeyword
+	Keyword string
+}
+
+type uiAdHandler struct {
+	adReader          AdReader
+	observationReader ObservationReader
+	keywordsReader    keywords.Reader
+}
+
+// ServeHTTP shows an ad with the keywords it was seen for, its latest
+// observations and its raw HTML, in a sandboxed frame that can't run scripts
+// or load anything.
+func (h *uiAdHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, nil)
+		return
+	}
+	ad, err := h.adReader.Find(id)
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, err)
+		return
+	} else if ad == nil {
+		renderError(w, http.StatusNotFound, nil)
+		return
+	}
+	aks, err := h.observationReader.GetAdKeywords(ad.ID)
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+	obs, err := h.observationReader.GetObservations(ad.ID)
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	values := make(map[int64]string)
+	sightings := make([]uiSighting, 0, len(aks))
+	for _, ak := range aks {
+		if _, ok := values[ak.KeywordId]; !ok {
+			k, err := h.keywordsReader.Find(ak.KeywordId)
+			if err != nil {
+				renderError(w, http.StatusInternalServerError, err)
+				return
+			} else if k != nil {
+				values[k.ID] = k.Value
+			}
+		}
+		sightings = append(sightings, uiSighting{AdKeyword: ak, Keyword: values[ak.KeywordId]})
+	}
+	latest := make([]Observation, 0, uiPageSize)
+	for i := len(obs) - 1; i >= 0 && len(latest) < uiPageSize; i-- {
+		latest = append(latest, obs[i])
+	}
+
+	render(w, http.StatusOK, "ad", map[string]interface{}{
+		"Title":        ad.H1,
+		"Ad":           ad,
+		"Advertiser":   ad.Advertiser(),
+		"Extensions":   ad.Extensions(),
+		"Sightings":    sightings,
+		"Observations": latest,
+		"Keywords":     values,
+	})
+}
+
+func uiAd(r *Repositories) http.Handler {
+	return &uiAdHandler{adReader: r.Ads, observationReader: r.Ads, keywordsReader: r.Keywords}
+}
+
+// uiFindAdvertiser sends the advertiser search form to the advertiser's page.
+func uiFindAdvertiser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		domain := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(r.URL.Query().Get("domain"))), "www.")
+		if domain == "" || strings.ContainsAny(domain, "/ ") {
+			renderError(w, http.StatusBadRequest, nil)
+			return
+		}
+		uiRedirect("/ui/advertisers/"+url.PathEscape(domain)).ServeHTTP(w, r)
+	}
+}
+
+// advertiserKeyword sums up how an advertiser's ads were shown for a keyword.
+type advertiserKeyword struct {
+	KeywordId    int64
+	Keyword      string
+	Observations int
+	AvgPosition  float64
+	BestPosition int
+	TopRate      float64
+	FirstSeenAt  string
+	LastSeenAt   string
+	positions    int
+	top          int
+}
+
+type uiAdvertiserHandler struct {
+	adReader          AdReader
+	observationReader ObservationReader
+	keywordsReader    keywords.Reader
+}
+
+// ServeHTTP shows the keywords an advertiser's ads were observed for
+// recently, with their positions, and a page of the advertiser's ads.
+func (h *uiAdvertiserHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	domain := strings.ToLower(mux.Vars(r)["domain"])
+	params := r.URL.Query()
+	days, err := daysParam(params, 30)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, nil)
+		return
+	}
+
+	byKeyword := make(map[int64]*advertiserKeyword)
+	q := AdQuery{Domain: domain, From: time.Now().AddDate(0, 0, -days)}
+	err = h.observationReader.ExportObservations(q, func(o *Observation) error {
+		ak, ok := byKeyword[o.KeywordId]
+		if !ok {
+			ak = &advertiserKeyword{KeywordId: o.KeywordId, BestPosition: math.MaxInt32, FirstSeenAt: o.ObservedAt}
+			byKeyword[o.KeywordId] = ak
+		}
+		ak.Observations++
+		ak.positions += o.Position
+		if o.Position < ak.BestPosition {
+			ak.BestPosition = o.Position
+		}
+		if o.Block != BlockBottom {
+			ak.top++
+		}
+		ak.LastSeenAt = o.ObservedAt
+		return nil
+	})
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+	kws := make([]*advertiserKeyword, 0, len(byKeyword))
+	for _, ak := range byKeyword {
+		k, err := h.keywordsReader.Find(ak.KeywordId)
+		if err != nil {
+			renderError(w, http.StatusInternalServerError, err)
+			return
+		} else if k != nil {
+			ak.Keyword = k.Value
+		}
+		ak.AvgPosition = float64(ak.positions) / float64(ak.Observations)
+		ak.TopRate = float64(ak.top) / float64(ak.Observations)
+		kws = append(kws, ak)
+	}
+	sort.Slice(kws, func(i, j int) bool {
+		if kws[i].Observations != kws[j].Observations {
+			return kws[i].Observations > kws[j].Observations
+		}
+		return kws[i].KeywordId < kws[j].KeywordId
+	})
+
+	ads, next, err := h.adReader.Search(AdQuery{Domain: domain, Cursor: params.Get("cursor"), Limit: uiPageSize})
+	if err == ErrInvalidCursor {
+		renderError(w, http.StatusBadRequest, nil)
+		return
+	} else if err != nil {
+		renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+	nextURL := ""
+	if next != "" {
+		params.Set("cursor", next)
+		nextURL = "/ui/advertisers/" + url.PathEscape(domain) + "?" + params.Encode()
+	}
+
+	render(w, http.StatusOK, "advertiser", map[string]interface{}{
+		"Title":    domain,
+		"Domain":   domain,
+		"Days":     days,
+		"Keywords": kws,
+		"Ads":      ads,
+		"Next":     nextURL,
+	})
+}
+
+func uiAdvertiser(r *Repositories) http.Handler {
+	return &uiAdvertiserHandler{adReader: r.Ads, observationReader: r.Ads, keywordsReader: r.Keywords}
+}
+
+type uiHealthHandler struct {
+	readiness         Readiness
+	keywordsReader    keywords.Reader
+	observationReader ObservationReader
+}
+
+// ServeHTTP shows whether scrapers keep up: when an ad was last observed, the
+// readiness checks and the keywords waiting to be scraped.
+func (h *uiHealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	last, err := h.observationReader.LastObservedAt()
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+	now := time.Now()
+	due, err := h.keywordsReader.GetDue(keywords.Query{Limit: keywords.MaxLimit}, now)
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+	checks := h.readiness.check(r.Context(), h.observationReader)
+	names := make([]string, 0, len(checks))
+	healthy := true
+	for name, result := range checks {
+		names = append(names, name)
+		healthy = healthy && result == "ok"
+	}
+	sort.Strings(names)
+
+	overdue := make([]uiKeyword, 0, uiPageSize)
+	for i := 0; i < len(due) && i < uiPageSize; i++ {
+		overdue = append(overdue, newUIKeyword(&due[i], now))
+	}
+	status := http.StatusOK
+	if !healthy {
+		status = http.StatusServiceUnavailable
+	}
+	render(w, status, "health", map[string]interface{}{
+		"Title":          "Scraper health",
+		"Healthy":        healthy,
+		"LastObservedAt": last,
+		"Checks":         checks,
+		"CheckNames":     names,
+		"Due":            len(due),
+		"MoreDue":        len(due) == keywords.MaxLimit,
+		"Overdue":        overdue,
+	})
+}
+
+func uiHealth(r *Repositories, rd Readiness) http.Handler {
+	return &uiHealthHandler{readiness: rd, keywordsReader: r.Keywords, observationReader: r.Ads}
+}
+
+// uiIncident is a brand-bidding incident with the value of its keyword.
+type uiIncident struct {
+	brands.Incident
+	Keyword string
+}
+
+type uiBrandBiddingHandler struct {
+	brandsReader   brands.Reader
+	keywordsReader keywords.Reader
+}
+
+// ServeHTTP reports the latest incidents of advertisers bidding on other
+// advertisers' brands, filtered by brand and bidder, with the ads they were
+// caught with.
+func (h *uiBrandBiddingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	bs, err := h.brandsReader.List()
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+	q := brands.IncidentQuery{Bidder: strings.TrimPrefix(params.Get("advertiser"), "www."), Limit: uiPageSize}
+	b, err := findBrand(h.brandsReader, params.Get("brand"))
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, err)
+		return
+	} else if b == nil && params.Get("brand") != "" {
+		renderError(w, http.StatusNotFound, nil)
+		return
+	} else if b != nil {
+		q.BrandId = b.ID
+	}
+	is, err := h.brandsReader.Incidents(q)
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	values := make(map[int64]string)
+	incidents := make([]uiIncident, 0, len(is))
+	for _, i := range is {
+		v, err := keywordValue(h.keywordsReader, values, i.KeywordId)
+		if err != nil {
+			renderError(w, http.StatusInternalServerError, err)
+			return
+		}
+		incidents = append(incidents, uiIncident{Incident: i, Keyword: v})
+	}
+
+	render(w, http.StatusOK, "brandbidding", map[string]interface{}{
+		"Title":      "Brand bidding",
+		"Brands":     bs,
+		"Brand":      params.Get("brand"),
+		"Advertiser": params.Get("advertiser"),
+		"Incidents":  incidents,
+	})
+}
+
+func uiBrandBidding(r *Repositories) http.Handler {
+	return &uiBrandBiddingHandler{brandsReader: r.Brands, keywordsReader: r.Keywords}
+}
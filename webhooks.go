@@ -0,0 +1,272 @@
+package adscraper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gkats/adscraper/webhooks"
+	"github.com/gorilla/mux"
+)
+
+// Limits of the webhook delivery log.
+const (
+	DefaultDeliveriesLimit = 100
+	MaxDeliveriesLimit     = 1000
+)
+
+// minSecretLength keeps webhook secrets chosen by hand from being guessable.
+const minSecretLength = 16
+
+type webhookJSON struct {
+	ID         int64    `json:"id"`
+	URL        string   `json:"url"`
+	Events     []string `json:"events"`
+	Group      string   `json:"group"`
+	Advertiser string   `json:"advertiser"`
+	// Secret is only shown when the webhook is created.
+	Secret    string `json:"secret,omitempty"`
+	CreatedAt string `json:"createdAt"`
+}
+
+func newWebhookJSON(w *webhooks.Webhook) webhookJSON {
+	return webhookJSON{
+		ID:         w.ID,
+		URL:        w.URL,
+		Events:     w.Events,
+		Group:      w.Group,
+		Advertiser: w.Advertiser,
+		CreatedAt:  w.CreatedAt,
+	}
+}
+
+type webhookParamsJSON struct {
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret"`
+	Events     []string `json:"events"`
+	Group      string   `json:"group"`
+	Advertiser string   `json:"advertiser"`
+}
+
+func (p *webhookParamsJSON) validate() []fieldError {
+	errs := make([]fieldError, 0)
+	if u, err := url.Parse(p.URL); strings.TrimSpace(p.URL) == "" {
+		errs = append(errs, fieldError{Field: "url", Message: "can't be blank"})
+	} else if err == nil && u.Scheme == "mailto" {
+		if a, err := mail.ParseAddress(u.Opaque); err != nil || a.Address != u.Opaque {
+			errs = append(errs, fieldError{Field: "url", Message: "must be a single email address, like mailto:alerts@example.com"})
+		}
+	} else if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fieldError{Field: "url", Message: "must be an http, https or mailto URL"})
+	}
+	if p.Secret != "" && len(p.Secret) < minSecretLength {
+		errs = append(errs, fieldError{Field: "secret", Message: "can't be shorter than " + strconv.Itoa(minSecretLength) + " characters"})
+	}
+	for i, e := range p.Events {
+		if !contains(webhooks.Events, e) {
+			errs = append(errs, fieldError{
+				Field:   fmt.Sprintf("events[%v]", i),
+				Message: "must be " + strings.Join(webhooks.Events, ", "),
+			})
+		}
+	}
+	if strings.ContainsAny(p.Advertiser, "/ ") {
+		errs = append(errs, fieldError{Field: "advertiser", Message: "must be a domain, like example.com"})
+	}
+	return errs
+}
+
+func (p *webhookParamsJSON) ToWebhook() *webhooks.Webhook {
+	events := make([]string, 0)
+	for _, e := range p.Events {
+		if !contains(events, e) {
+			events = append(events, e)
+		}
+	}
+	return &webhooks.Webhook{
+		URL:        strings.TrimSpace(p.URL),
+		Secret:     p.Secret,
+		Events:     events,
+		Group:      strings.TrimSpace(p.Group),
+		Advertiser: strings.TrimPrefix(strings.TrimSpace(p.Advertiser), "www."),
+	}
+}
+
+type deliveryJSON struct {
+	ID             int64           `json:"id"`
+	WebhookId      int64           `json:"webhookId"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"responseStatus"`
+	LastError      string          `json:"lastError"`
+	NextAttemptAt  string          `json:"nextAttemptAt"`
+	CreatedAt      string          `json:"createdAt"`
+	DeliveredAt    string          `json:"deliveredAt"`
+}
+
+func newDeliveryJSON(d *webhooks.Delivery) deliveryJSON {
+	return deliveryJSON{
+		ID:             d.ID,
+		WebhookId:      d.WebhookId,
+		Event:          d.Event,
+		Payload:        json.RawMessage(d.Payload),
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		ResponseStatus: d.ResponseStatus,
+		LastError:      d.LastError,
+		NextAttemptAt:  d.NextAttemptAt,
+		CreatedAt:      d.CreatedAt,
+		DeliveredAt:    d.DeliveredAt,
+	}
+}
+
+type indexWebhooksHandler struct {
+	webhooksReader webhooks.Reader
+}
+
+func (h *indexWebhooksHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := h.webhooksReader.List()
+	if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	}
+	wsJSON := make([]webhookJSON, 0, len(ws))
+	for i := range ws {
+		wsJSON = append(wsJSON, newWebhookJSON(&ws[i]))
+	}
+	writeResponse(w, ok(wsJSON))
+}
+
+func indexWebhooks(r *Repositories) http.Handler {
+	return &indexWebhooksHandler{webhooksReader: r.Webhooks}
+}
+
+type createWebhookHandler struct {
+	webhooksWriter webhooks.Writer
+}
+
+// ServeHTTP subscribes a URL to events. The secret deliveries are signed with
+// is generated unless it's given, and only shown in this response.
+func (h *createWebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := &webhookParamsJSON{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		writeResponse(w, decodeError(err))
+		return
+	}
+	if errs := params.validate(); len(errs) > 0 {
+		writeResponse(w, invalid(errs))
+		return
+	}
+
+	wh := params.ToWebhook()
+	if err := h.webhooksWriter.Create(wh); err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	}
+	body := newWebhookJSON(wh)
+	body.Secret = wh.Secret
+	writeResponse(w, &successResponse{status: http.StatusCreated, body: body})
+}
+
+func createWebhook(r *Repositories) http.Handler {
+	return &createWebhookHandler{webhooksWriter: r.Webhooks}
+}
+
+type showWebhookHandler struct {
+	webhooksReader webhooks.Reader
+}
+
+func (h *showWebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+	wh, err := h.webhooksReader.Find(id)
+	if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	} else if wh == nil {
+		writeResponse(w, notFound())
+		return
+	}
+	writeResponse(w, ok(newWebhookJSON(wh)))
+}
+
+func showWebhook(r *Repositories) http.Handler {
+	return &showWebhookHandler{webhooksReader: r.Webhooks}
+}
+
+type deleteWebhookHandler struct {
+	webhooksWriter webhooks.Writer
+}
+
+func (h *deleteWebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+	wh, err := h.webhooksWriter.Delete(id)
+	if err == webhooks.ErrNotFound {
+		writeResponse(w, notFound())
+		return
+	} else if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	}
+	writeResponse(w, ok(newWebhookJSON(wh)))
+}
+
+func deleteWebhook(r *Repositories) http.Handler {
+	return &deleteWebhookHandler{webhooksWriter: r.Webhooks}
+}
+
+type indexDeliveriesHandler struct {
+	webhooksReader webhooks.Reader
+}
+
+// ServeHTTP lists the latest deliveries to a webhook, newest first, with the
+// outcome of their last attempt.
+func (h *indexDeliveriesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeResponse(w, badRequest())
+		return
+	}
+	limit, err := int64Param(r.URL.Query(), "limit")
+	if err != nil || limit > MaxDeliveriesLimit {
+		writeResponse(w, badRequest())
+		return
+	} else if limit == 0 {
+		limit = DefaultDeliveriesLimit
+	}
+
+	if wh, err := h.webhooksReader.Find(id); err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	} else if wh == nil {
+		writeResponse(w, notFound())
+		return
+	}
+	ds, err := h.webhooksReader.Deliveries(id, int(limit))
+	if err != nil {
+		writeResponse(w, internalServerError(err))
+		return
+	}
+	dsJSON := make([]deliveryJSON, 0, len(ds))
+	for i := range ds {
+		dsJSON = append(dsJSON, newDeliveryJSON(&ds[i]))
+	}
+	writeResponse(w, ok(dsJSON))
+}
+
+func indexDeliveries(r *Repositories) http.Handler {
+	return &indexDeliveriesHandler{webhooksReader: r.Webhooks}
+}
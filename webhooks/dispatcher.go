@@ -0,0 +1,209 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of deliveries. Receivers can tell retries of a delivery apart from
+// new ones by the delivery ID.
+const (
+	SignatureHeader = "X-Adscraper-Signature"
+	EventHeader     = "X-Adscraper-Event"
+	DeliveryHeader  = "X-Adscraper-Delivery"
+)
+
+const (
+	DefaultMaxAttempts = 10
+	DefaultTimeout     = 10 * time.Second
+	// batchSize is how many deliveries a dispatcher takes from the queue at
+	// once.
+	batchSize = 20
+)
+
+var ErrInvalidSignature = errors.New("Invalid webhook signature")
+
+// Sign returns the signature header of a body sent at t, like
+// t=1760889600,v1=<hex>. v1 is the HMAC-SHA256 of the Unix time, a dot and
+// the body, keyed with the webhook's secret.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + signature(secret, ts, body)
+}
+
+func signature(secret string, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature header of a delivery, for receivers. Deliveries
+// signed more than tolerance ago are rejected, so they can't be replayed,
+// unless tolerance is zero.
+func Verify(secret string, header string, body []byte, tolerance time.Duration) error {
+	ts, sig := "", ""
+	for _, part := range strings.Split(header, ",") {
+		if kv := strings.SplitN(part, "=", 2); len(kv) == 2 {
+			switch kv[0] {
+			case "t":
+				ts = kv[1]
+			case "v1":
+				sig = kv[1]
+			}
+		}
+	}
+	t, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || !hmac.Equal([]byte(sig), []byte(signature(secret, ts, body))) {
+		return ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(t, 0)); tolerance > 0 && (age > tolerance || age < -tolerance) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Backoff doubles the wait after each failed attempt, from 30 seconds up to
+// 6 hours.
+func Backoff(attempts int) time.Duration {
+	d := 30 * time.Second
+	for i := 1; i < attempts && d < 6*time.Hour; i++ {
+		d *= 2
+	}
+	if d > 6*time.Hour {
+		d = 6 * time.Hour
+	}
+	return d
+}
+
+type Report struct {
+	Delivered int
+	Retried   int
+	Failed    int
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("delivered %v events, %v to retry, %v failed", r.Delivered, r.Retried, r.Failed)
+}
+
+// Dispatcher POSTs queued events to webhooks, or emails them to mailto:
+// ones. Deliveries that don't get a 2xx response or can't be emailed are
+// retried after Backoff, up to MaxAttempts times.
+type Dispatcher struct {
+	Client      *http.Client
+	MaxAttempts int
+	// Backoff is how long to wait after the nth failed attempt.
+	Backoff func(attempts int) time.Duration
+	// Mail emails a delivery, like Mailer.Send. Deliveries to mailto:
+	// webhooks fail until it's set.
+	Mail func(to string, d *Delivery) error
+
+	queue Queue
+}
+
+func NewDispatcher(q Queue) *Dispatcher {
+	return &Dispatcher{
+		Client:      &http.Client{Timeout: DefaultTimeout},
+		MaxAttempts: DefaultMaxAttempts,
+		Backoff:     Backoff,
+		queue:       q,
+	}
+}
+
+// Every attempts the due deliveries every interval until stop is closed,
+// handing the outcome of each run to done.
+func (d *Dispatcher) Every(interval time.Duration, stop <-chan struct{}, done func(*Report, error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-t.C:
+			done(d.Run(now))
+		}
+	}
+}
+
+// Run attempts every delivery due by now once.
+func (d *Dispatcher) Run(now time.Time) (*Report, error) {
+	r := &Report{}
+	// Deliveries are taken for longer than attempting a whole batch can take
+	until := now.Add(batchSize*d.Client.Timeout + time.Minute)
+	for {
+		ds, err := d.queue.Due(now, until, batchSize)
+		if err != nil {
+			return r, err
+		}
+		for i := range ds {
+			a := d.attempt(&ds[i], now)
+			if err = d.queue.Record(ds[i].ID, a); err != nil {
+				return r, err
+			}
+			switch a.status() {
+			case Delivered:
+				r.Delivered++
+			case Pending:
+				r.Retried++
+			default:
+				r.Failed++
+			}
+		}
+		if len(ds) < batchSize {
+			return r, nil
+		}
+	}
+}
+
+func (d *Dispatcher) attempt(dl *Delivery, now time.Time) *Attempt {
+	a := &Attempt{At: now}
+	var err error
+	if to := strings.TrimPrefix(dl.url, "mailto:"); to != dl.url {
+		err = errors.New("Emails aren't configured")
+		if d.Mail != nil {
+			err = d.Mail(to, dl)
+		}
+	} else {
+		err = d.post(dl, a)
+	}
+	if err != nil {
+		a.Error = err.Error()
+		if dl.Attempts+1 < d.MaxAttempts {
+			a.RetryAt = now.Add(d.Backoff(dl.Attempts + 1))
+		}
+	}
+	return a
+}
+
+// post POSTs the delivery, recording the response status of the attempt.
+func (d *Dispatcher) post(dl *Delivery, a *Attempt) error {
+	req, err := http.NewRequest("POST", dl.url, strings.NewReader(dl.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "adscraper-webhooks")
+	req.Header.Set(EventHeader, dl.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(dl.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(dl.secret, time.Now(), []byte(dl.Payload)))
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	res.Body.Close()
+	a.ResponseStatus = res.StatusCode
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Unexpected response status %v", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Mailer emails deliveries to mailto: webhooks over SMTP.
+type Mailer struct {
+	// Addr is the host:port of the SMTP server.
+	Addr string
+	From string
+	// Username and Password authenticate with the server, when it needs them.
+	Username string
+	Password string
+	// Timeout is how long sending an email can take, DefaultTimeout when
+	// it's zero.
+	Timeout time.Duration
+}
+
+func (m *Mailer) Flags(fs *flag.FlagSet) {
+	fs.StringVar(&m.Addr, "smtp-addr", "", "The host:port of the SMTP server emailing events to mailto: webhooks. Empty disables emails.")
+	fs.StringVar(&m.From, "smtp-from", "adscraper@localhost", "The address webhook emails are sent from.")
+	fs.StringVar(&m.Username, "smtp-username", "", "The user name to authenticate with the SMTP server, if it needs one.")
+	fs.StringVar(&m.Password, "smtp-password", os.Getenv("SMTP_PASSWORD"), "The password to authenticate with the SMTP server. Defaults to the SMTP_PASSWORD environment variable.")
+	fs.DurationVar(&m.Timeout, "smtp-timeout", DefaultTimeout, "How long sending an email can take before it's retried.")
+}
+
+func (m *Mailer) Enabled() bool {
+	return m.Addr != ""
+}
+
+// Send emails the delivery's event, with a summary of it as the subject. It
+// gives up after the Timeout, so servers that stop answering don't hold up
+// the other deliveries.
+func (m *Mailer) Send(to string, d *Delivery) error {
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return err
+	}
+	conn, err := (&net.Dialer{Timeout: timeout}).Dial("tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", m.Username, m.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(m.From); err != nil {
+		return err
+	}
+	if err = c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(Message(m.From, to, d)); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// Message returns the email of a delivery, with the event as indented JSON.
+func Message(from string, to string, d *Delivery) []byte {
+	body := &bytes.Buffer{}
+	if err := json.Indent(body, []byte(d.Payload), "", "  "); err != nil {
+		body.Reset()
+		body.WriteString(d.Payload)
+	}
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %v\r\n", from)
+	fmt.Fprintf(msg, "To: %v\r\n", to)
+	fmt.Fprintf(msg, "Subject: %v\r\n", mime.QEncoding.Encode("utf-8", Subject(d)))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(msg, "%v: %v\r\n", EventHeader, d.Event)
+	fmt.Fprintf(msg, "%v: %v\r\n", DeliveryHeader, strconv.FormatInt(d.ID, 10))
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	msg.WriteString("\r\n")
+	return msg.Bytes()
+}
+
+// Subject summarizes the event of a delivery, like: New ad of nike.com for
+// "reebok women shoes".
+func Subject(d *Delivery) string {
+	e := &Event{}
+	if err := json.Unmarshal([]byte(d.Payload), e); err != nil {
+		return "adscraper: " + d.Event
+	}
+	switch e.Type {
+	case NewAd:
+		return fmt.Sprintf("New ad of %v for %q", e.Advertiser, e.Keyword.Value)
+	case NewAdvertiser:
+		return fmt.Sprintf("New advertiser %v for %q", e.Advertiser, e.Keyword.Value)
+	case AdDisappeared:
+		return fmt.Sprintf("Ad of %v disappeared for %q", e.Advertiser, e.Keyword.Value)
+	case BrandBidding:
+		return fmt.Sprintf("%v is bidding on %v's brand for %q", e.Advertiser, e.Brand, e.Keyword.Value)
+	}
+	return "adscraper: " + d.Event
+}
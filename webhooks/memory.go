@@ -0,0 +1,168 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewMemoryReaderWriter returns a ReaderWriter that keeps webhooks and their
+// deliveries in memory. It's safe for concurrent use and meant for tests.
+func NewMemoryReaderWriter() ReaderWriter {
+	return &memory{}
+}
+
+type memory struct {
+	mu         sync.Mutex
+	webhooks   []Webhook
+	deliveries []Delivery
+}
+
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (m *memory) Create(w *Webhook) error {
+	if w.Secret == "" {
+		var err error
+		if w.Secret, err = newSecret(); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.ID = int64(len(m.webhooks) + 1)
+	w.Advertiser = strings.ToLower(w.Advertiser)
+	w.Events = append([]string{}, w.Events...)
+	w.CreatedAt, w.DeletedAt = timestamp(time.Now()), ""
+	m.webhooks = append(m.webhooks, *w)
+	return nil
+}
+
+func (m *memory) Find(id int64) (*Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > int64(len(m.webhooks)) || m.webhooks[id-1].DeletedAt != "" {
+		return nil, nil
+	}
+	w := m.webhooks[id-1]
+	return &w, nil
+}
+
+func (m *memory) List() ([]Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ws := make([]Webhook, 0)
+	for _, w := range m.webhooks {
+		if w.DeletedAt == "" {
+			ws = append(ws, w)
+		}
+	}
+	return ws, nil
+}
+
+func (m *memory) Delete(id int64) (*Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > int64(len(m.webhooks)) || m.webhooks[id-1].DeletedAt != "" {
+		return nil, ErrNotFound
+	}
+	w := &m.webhooks[id-1]
+	w.DeletedAt = timestamp(time.Now())
+	for i := range m.deliveries {
+		if d := &m.deliveries[i]; d.WebhookId == id && d.Status == Pending {
+			d.Status, d.LastError = Failed, "Webhook deleted"
+		}
+	}
+	deleted := *w
+	return &deleted, nil
+}
+
+func (m *memory) Emit(e *Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var payload []byte
+	for _, w := range m.webhooks {
+		if w.DeletedAt != "" || !w.Matches(e) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			if payload, err = json.Marshal(e); err != nil {
+				return err
+			}
+		}
+		now := timestamp(time.Now())
+		m.deliveries = append(m.deliveries, Delivery{
+			ID:            int64(len(m.deliveries) + 1),
+			WebhookId:     w.ID,
+			Event:         e.Type,
+			Payload:       string(payload),
+			Status:        Pending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+	return nil
+}
+
+func (m *memory) Deliveries(webhookId int64, limit int) ([]Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ds := make([]Delivery, 0)
+	for i := len(m.deliveries) - 1; i >= 0 && len(ds) < limit; i-- {
+		if m.deliveries[i].WebhookId == webhookId {
+			ds = append(ds, m.deliveries[i])
+		}
+	}
+	return ds, nil
+}
+
+func (m *memory) Due(now time.Time, until time.Time, limit int) ([]Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ds := make([]Delivery, 0)
+	for i := range m.deliveries {
+		d := &m.deliveries[i]
+		w := m.webhooks[d.WebhookId-1]
+		next, _ := time.Parse(time.RFC3339, d.NextAttemptAt)
+		if len(ds) >= limit || d.Status != Pending || next.After(now) || w.DeletedAt != "" {
+			continue
+		}
+		d.NextAttemptAt = timestamp(until)
+		due := *d
+		due.url, due.secret = w.URL, w.Secret
+		ds = append(ds, due)
+	}
+	return ds, nil
+}
+
+func (m *memory) Record(id int64, a *Attempt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > int64(len(m.deliveries)) {
+		return nil
+	}
+	d := &m.deliveries[id-1]
+	d.Status, d.ResponseStatus, d.LastError = a.status(), a.ResponseStatus, a.Error
+	d.Attempts++
+	if a.RetryAt.IsZero() {
+		d.NextAttemptAt = timestamp(a.At)
+	} else {
+		d.NextAttemptAt = timestamp(a.RetryAt)
+	}
+	if a.Error == "" {
+		d.DeliveredAt = timestamp(a.At)
+	}
+	return nil
+}
@@ -0,0 +1,468 @@
+// Package webhooks notifies subscribers of changes to the ads shown for
+// keywords, with signed HTTP POSTs or emails that are retried until they're
+// accepted.
+package webhooks
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gkats/adscraper/db"
+)
+
+var ErrNotFound = errors.New("Webhook not found")
+
+// Event types. NewAd is an ad seen for the first time, NewAdvertiser an ad
+// of a domain never seen before for the keyword, AdDisappeared an ad shown
+// in the previous scrape of the keyword but not in the one that just ended
+// and BrandBidding an ad seen for the first time for a keyword with another
+// advertiser's brand in it.
+const (
+	NewAd         = "ad.new"
+	NewAdvertiser = "advertiser.new"
+	AdDisappeared = "ad.disappeared"
+	BrandBidding  = "brand.bidding"
+)
+
+var Events = []string{NewAd, NewAdvertiser, AdDisappeared, BrandBidding}
+
+// Delivery statuses
+const (
+	Pending   = "pending"
+	Delivered = "delivered"
+	Failed    = "failed"
+)
+
+type Webhook struct {
+	ID int64
+	// URL is where events are POSTed, or a mailto: URL to email them to.
+	URL string
+	// Secret is the key deliveries are signed with, see Sign.
+	Secret string
+	// Events are the types of events delivered, all of them when empty.
+	Events []string
+	// Group and Advertiser limit deliveries to events of keywords in the
+	// group and of ads on the domain or its subdomains, or bidding on its
+	// brand, when they're set.
+	Group      string
+	Advertiser string
+	CreatedAt  string
+	DeletedAt  string
+}
+
+// Matches reports whether the webhook is subscribed to the event.
+func (w *Webhook) Matches(e *Event) bool {
+	if len(w.Events) > 0 && !contains(w.Events, e.Type) {
+		return false
+	}
+	if w.Group != "" && !contains(e.Keyword.Groups, w.Group) {
+		return false
+	}
+	if d := strings.ToLower(w.Advertiser); d != "" {
+		return onDomain(e.Advertiser, d) || onDomain(e.Brand, d)
+	}
+	return true
+}
+
+func onDomain(advertiser string, domain string) bool {
+	return advertiser == domain || strings.HasSuffix(advertiser, "."+domain)
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is the body of deliveries, as JSON.
+type Event struct {
+	Type       string  `json:"type"`
+	OccurredAt string  `json:"occurredAt"`
+	Keyword    Keyword `json:"keyword"`
+	// Advertiser is the host of the ad's visible URL, without www.
+	Advertiser string `json:"advertiser"`
+	// Ad is the ad the event is about, the way the API shows it.
+	Ad interface{} `json:"ad"`
+	// Brand is the advertiser whose brand was bid on and Incident the
+	// brand-bidding incident the way the API shows it, for BrandBidding
+	// events.
+	Brand    string      `json:"brand,omitempty"`
+	Incident interface{} `json:"incident,omitempty"`
+}
+
+type Keyword struct {
+	ID     int64    `json:"id"`
+	Value  string   `json:"value"`
+	Groups []string `json:"groups"`
+}
+
+type Delivery struct {
+	ID        int64
+	WebhookId int64
+	Event     string
+	// Payload is the event, as it's POSTed.
+	Payload  string
+	Status   string
+	Attempts int
+	// ResponseStatus is the HTTP status of the last attempt, 0 when it got no
+	// response.
+	ResponseStatus int
+	LastError      string
+	NextAttemptAt  string
+	CreatedAt      string
+	DeliveredAt    string
+
+	// url and secret of the webhook, set on due deliveries
+	url    string
+	secret string
+}
+
+// Attempt is the outcome of delivering an event once.
+type Attempt struct {
+	ResponseStatus int
+	// Error is why the attempt failed, empty when the event was delivered.
+	Error string
+	// RetryAt is when to attempt a failed delivery again, zero to give up.
+	RetryAt time.Time
+	// At is when the attempt was made.
+	At time.Time
+}
+
+func (a *Attempt) status() string {
+	if a.Error == "" {
+		return Delivered
+	} else if a.RetryAt.IsZero() {
+		return Failed
+	}
+	return Pending
+}
+
+type Reader interface {
+	// Find returns the webhook with the id, nil when there's none or it's
+	// been deleted.
+	Find(id int64) (*Webhook, error)
+	List() ([]Webhook, error)
+	// Deliveries returns the latest deliveries to the webhook, newest first.
+	Deliveries(webhookId int64, limit int) ([]Delivery, error)
+}
+
+func NewReader(s Store) Reader {
+	return &repository{s}
+}
+
+type Writer interface {
+	// Create stores a webhook, with a random secret unless it has one.
+	Create(*Webhook) error
+	// Delete stops deliveries to the webhook, pending ones fail.
+	Delete(id int64) (*Webhook, error)
+	// Emit queues a delivery of the event to every webhook subscribed to it.
+	Emit(*Event) error
+}
+
+func NewWriter(s Store) Writer {
+	return &repository{s}
+}
+
+// Queue hands deliveries to a Dispatcher.
+type Queue interface {
+	// Due returns up to limit pending deliveries due by now. They aren't due
+	// again until then, so dispatchers sharing the queue don't attempt the
+	// same deliveries.
+	Due(now time.Time, until time.Time, limit int) ([]Delivery, error)
+	// Record stores the outcome of an attempt of the delivery.
+	Record(id int64, a *Attempt) error
+}
+
+func NewQueue(s Store) Queue {
+	return &repository{s}
+}
+
+type ReaderWriter interface {
+	Reader
+	Writer
+	Queue
+}
+
+func NewReaderWriter(s Store) ReaderWriter {
+	return &repository{s}
+}
+
+type Store = db.Store
+
+type repository struct {
+	Store
+}
+
+// newSecret returns a random secret for a webhook created without one.
+func newSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+const columns = `id, url, secret, events, keyword_group, advertiser, created_at, deleted_at`
+
+func scan(s interface{ Scan(...interface{}) error }, w *Webhook) error {
+	events, deletedAt := "", sql.NullString{}
+	err := s.Scan(&w.ID, &w.URL, &w.Secret, &events, &w.Group, &w.Advertiser, &w.CreatedAt, &deletedAt)
+	w.Events, w.DeletedAt = splitEvents(events), deletedAt.String
+	return err
+}
+
+func splitEvents(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+func (r *repository) Create(w *Webhook) error {
+	if w.Secret == "" {
+		var err error
+		if w.Secret, err = newSecret(); err != nil {
+			return err
+		}
+	}
+	w.Advertiser = strings.ToLower(w.Advertiser)
+	return scan(r.Store.QueryRow(
+		`
+    INSERT INTO webhooks (url, secret, events, keyword_group, advertiser)
+    VALUES ($1, $2, $3, $4, $5)
+    RETURNING `+columns+`
+    `,
+		w.URL, w.Secret, strings.Join(w.Events, ","), w.Group, w.Advertiser,
+	), w)
+}
+
+func (r *repository) Find(id int64) (*Webhook, error) {
+	w := &Webhook{}
+	err := scan(r.Store.QueryRow(`SELECT `+columns+` FROM webhooks WHERE id = $1 AND deleted_at IS NULL`, id), w)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return w, err
+}
+
+func (r *repository) List() ([]Webhook, error) {
+	return list(r.Store)
+}
+
+func list(q db.Querier) ([]Webhook, error) {
+	rows, err := q.Query(`SELECT ` + columns + ` FROM webhooks WHERE deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ws := make([]Webhook, 0)
+	for rows.Next() {
+		w := Webhook{}
+		if err = scan(rows, &w); err != nil {
+			return ws, err
+		}
+		ws = append(ws, w)
+	}
+	return ws, rows.Err()
+}
+
+func (r *repository) Delete(id int64) (*Webhook, error) {
+	tx, err := r.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	w := &Webhook{}
+	err = scan(tx.QueryRow(
+		`
+    UPDATE webhooks
+    SET deleted_at = CURRENT_TIMESTAMP
+    WHERE id = $1
+    AND deleted_at IS NULL
+    RETURNING `+columns+`
+    `,
+		id,
+	), w)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(
+		`UPDATE webhook_deliveries SET status = $1, last_error = $2 WHERE webhook_id = $3 AND status = $4`,
+		Failed, "Webhook deleted", id, Pending,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return w, tx.Commit()
+}
+
+func (r *repository) Emit(e *Event) error {
+	return Emit(r.Store, e)
+}
+
+// Subscribed reports whether there are any webhooks, so events aren't put
+// together for nobody.
+func Subscribed(q db.Querier) (bool, error) {
+	var subscribed bool
+	err := q.QueryRow(`SELECT EXISTS (SELECT 1 FROM webhooks WHERE deleted_at IS NULL)`).Scan(&subscribed)
+	return subscribed, err
+}
+
+// Emit is Writer.Emit with q, usually the transaction storing what the event
+// is about, so deliveries are only queued along with it.
+func Emit(q db.Querier, e *Event) error {
+	ws, err := list(q)
+	if err != nil {
+		return err
+	}
+	var payload []byte
+	for _, w := range ws {
+		if !w.Matches(e) {
+			continue
+		}
+		if payload == nil {
+			if payload, err = json.Marshal(e); err != nil {
+				return err
+			}
+		}
+		_, err = q.Exec(
+			`INSERT INTO webhook_deliveries (webhook_id, event, payload) VALUES ($1, $2, $3)`,
+			w.ID, e.Type, string(payload),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const deliveryColumns = `d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.response_status,
+    d.last_error, d.next_attempt_at, d.created_at, d.delivered_at`
+
+func scanDelivery(s interface{ Scan(...interface{}) error }, d *Delivery, dest ...interface{}) error {
+	deliveredAt := sql.NullString{}
+	err := s.Scan(append([]interface{}{
+		&d.ID, &d.WebhookId, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.ResponseStatus,
+		&d.LastError, &d.NextAttemptAt, &d.CreatedAt, &deliveredAt,
+	}, dest...)...)
+	d.DeliveredAt = deliveredAt.String
+	return err
+}
+
+func (r *repository) Deliveries(webhookId int64, limit int) ([]Delivery, error) {
+	rows, err := r.Store.Query(
+		`
+    SELECT `+deliveryColumns+`
+    FROM webhook_deliveries d
+    WHERE d.webhook_id = $1
+    ORDER BY d.id DESC
+    LIMIT $2
+    `,
+		webhookId, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ds := make([]Delivery, 0)
+	for rows.Next() {
+		d := Delivery{}
+		if err = scanDelivery(rows, &d); err != nil {
+			return ds, err
+		}
+		ds = append(ds, d)
+	}
+	return ds, rows.Err()
+}
+
+func (r *repository) Due(now time.Time, until time.Time, limit int) ([]Delivery, error) {
+	ts := func(expr string) string {
+		return db.Timestamp(r.Dialect(), expr)
+	}
+	rows, err := r.Store.Query(
+		`
+    SELECT `+deliveryColumns+`, w.url, w.secret
+    FROM webhook_deliveries d
+    JOIN webhooks w ON w.id = d.webhook_id
+    WHERE d.status = $1
+    AND `+ts("d.next_attempt_at")+` <= `+ts("$2")+`
+    AND w.deleted_at IS NULL
+    ORDER BY d.id ASC
+    LIMIT $3
+    `,
+		Pending, now.UTC(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]Delivery, 0)
+	for rows.Next() {
+		d := Delivery{}
+		if err = scanDelivery(rows, &d, &d.url, &d.secret); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates = append(candidates, d)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Only one dispatcher gets to push a delivery back
+	ds := make([]Delivery, 0, len(candidates))
+	for _, d := range candidates {
+		res, err := r.Store.Exec(
+			`
+    UPDATE webhook_deliveries
+    SET next_attempt_at = $1
+    WHERE id = $2
+    AND status = $3
+    AND `+ts("next_attempt_at")+` <= `+ts("$4")+`
+    `,
+			until.UTC(), d.ID, Pending, now.UTC(),
+		)
+		if err != nil {
+			return ds, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return ds, err
+		} else if n == 1 {
+			ds = append(ds, d)
+		}
+	}
+	return ds, nil
+}
+
+func (r *repository) Record(id int64, a *Attempt) error {
+	next, deliveredAt := a.RetryAt, sql.NullTime{}
+	if next.IsZero() {
+		next = a.At
+	}
+	if a.Error == "" {
+		deliveredAt = sql.NullTime{Time: a.At.UTC(), Valid: true}
+	}
+	_, err := r.Store.Exec(
+		`
+    UPDATE webhook_deliveries
+    SET status = $1, attempts = attempts + 1, response_status = $2, last_error = $3,
+      next_attempt_at = $4, delivered_at = $5
+    WHERE id = $6
+    `,
+		a.status(), a.ResponseStatus, a.Error, next.UTC(), deliveredAt, id,
+	)
+	return err
+}
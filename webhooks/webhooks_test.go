@@ -0,0 +1,209 @@
+package webhooks_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gkats/adscraper/db/dbtest"
+	"github.com/gkats/adscraper/webhooks"
+)
+
+const secret = "whsec_0123456789abcdef"
+
+func TestSignature(t *testing.T) {
+	body := []byte(`{"type":"ad.new"}`)
+	header := webhooks.Sign(secret, time.Now(), body)
+	old := webhooks.Sign(secret, time.Now().Add(-time.Hour), body)
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{nil, webhooks.Verify(secret, header, body, 5*time.Minute)},
+		{webhooks.ErrInvalidSignature, webhooks.Verify(secret, header, []byte(`{"type":"ad.disappeared"}`), 0)},
+		{webhooks.ErrInvalidSignature, webhooks.Verify("whsec_another", header, body, 0)},
+		{webhooks.ErrInvalidSignature, webhooks.Verify(secret, "", body, 0)},
+		{webhooks.ErrInvalidSignature, webhooks.Verify(secret, old, body, 5*time.Minute)},
+		{nil, webhooks.Verify(secret, old, body, 0)},
+		{30 * time.Second, webhooks.Backoff(1)},
+		{4 * time.Minute, webhooks.Backoff(4)},
+		{6 * time.Hour, webhooks.Backoff(20)},
+	}
+	for i, tc := range testCases {
+		if tc.want != tc.got {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}
+
+// receiver records the deliveries it's sent, failing the first ones.
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	events   []string
+	errors   []error
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	if err := webhooks.Verify(secret, r.Header.Get(webhooks.SignatureHeader), body, time.Minute); err != nil {
+		rc.errors = append(rc.errors, err)
+	}
+	if rc.failures > 0 {
+		rc.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	rc.events = append(rc.events, r.Header.Get(webhooks.DeliveryHeader)+" "+r.Header.Get(webhooks.EventHeader))
+}
+
+func TestDispatcher(t *testing.T) {
+	stores := map[string]webhooks.ReaderWriter{
+		"memory": webhooks.NewMemoryReaderWriter(),
+		"sqlite": webhooks.NewReaderWriter(dbtest.NewStore(t)),
+	}
+	for name, ws := range stores {
+		t.Run(name, func(t *testing.T) {
+			rc := &receiver{failures: 1}
+			ok := httptest.NewServer(rc)
+			defer ok.Close()
+			down := httptest.NewServer(http.NotFoundHandler())
+			down.Close()
+
+			subscriptions := []*webhooks.Webhook{
+				{URL: ok.URL, Secret: secret, Events: []string{webhooks.NewAd}, Group: "acme", Advertiser: "Reebok.com"},
+				{URL: ok.URL, Secret: secret, Events: []string{webhooks.AdDisappeared}},
+				{URL: down.URL},
+				{URL: ok.URL, Group: "globex"},
+			}
+			for _, w := range subscriptions {
+				if err := ws.Create(w); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := ws.Delete(4); err != nil {
+				t.Fatal(err)
+			}
+
+			k := webhooks.Keyword{ID: 1, Value: "reebok women shoes", Groups: []string{"acme", "globex"}}
+			for _, e := range []*webhooks.Event{
+				{Type: webhooks.NewAd, Keyword: k, Advertiser: "shop.reebok.com"},
+				{Type: webhooks.NewAd, Keyword: k, Advertiser: "nike.com"},
+				{Type: webhooks.NewAdvertiser, Keyword: webhooks.Keyword{ID: 2}, Advertiser: "reebok.com"},
+			} {
+				if err := ws.Emit(e); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			d := webhooks.NewDispatcher(ws)
+			d.MaxAttempts = 2
+			now := time.Now()
+			reports := make([]string, 0)
+			for _, at := range []time.Time{now, now, now.Add(time.Minute), now.Add(time.Hour)} {
+				r, err := d.Run(at)
+				if err != nil {
+					t.Fatal(err)
+				}
+				reports = append(reports, r.String())
+			}
+
+			deliveries := make([]string, 0)
+			for _, id := range []int64{1, 2, 3, 4} {
+				ds, err := ws.Deliveries(id, 10)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, dl := range ds {
+					deliveries = append(deliveries, fmt.Sprintf("%v %v %v %v %v", dl.WebhookId, dl.Status, dl.Attempts, dl.ResponseStatus, dl.DeliveredAt != ""))
+				}
+			}
+			last, _ := ws.Deliveries(3, 1)
+			testCases := []struct {
+				want interface{}
+				got  interface{}
+			}{
+				{"delivered 0 events, 4 to retry, 0 failed", reports[0]},
+				{"delivered 0 events, 0 to retry, 0 failed", reports[1]},
+				{"delivered 1 events, 0 to retry, 3 failed", reports[2]},
+				{"delivered 0 events, 0 to retry, 0 failed", reports[3]},
+				// The first webhook matches the first event, the third all of them
+				{"1 delivered 2 200 true,3 failed 2 0 false,3 failed 2 0 false,3 failed 2 0 false", strings.Join(deliveries, ",")},
+				{"1 ad.new", strings.Join(rc.events, ",")},
+				{0, len(rc.errors)},
+				{true, len(last) == 1 && strings.Contains(last[0].LastError, "connection refused")},
+				{true, len(last) == 1 && strings.Contains(last[0].Payload, `"advertiser":"reebok.com"`)},
+			}
+			for i, tc := range testCases {
+				if fmt.Sprint(tc.want) != fmt.Sprint(tc.got) {
+					t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+				}
+			}
+		})
+	}
+}
+
+func TestMail(t *testing.T) {
+	ws := webhooks.NewMemoryReaderWriter()
+	for _, w := range []*webhooks.Webhook{
+		{URL: "mailto:alerts@reebok.com", Events: []string{webhooks.BrandBidding}, Advertiser: "reebok.com"},
+		{URL: "mailto:alerts@nike.com", Events: []string{webhooks.BrandBidding}, Advertiser: "adidas.com"},
+	} {
+		if err := ws.Create(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	k := webhooks.Keyword{ID: 1, Value: "reebok women shoes", Groups: []string{}}
+	if err := ws.Emit(&webhooks.Event{Type: webhooks.BrandBidding, Keyword: k, Advertiser: "nike.com", Brand: "reebok.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	unconfigured, _ := webhooks.NewDispatcher(ws).Run(time.Now())
+	sent := make([]string, 0)
+	d := webhooks.NewDispatcher(ws)
+	d.Mail = func(to string, dl *webhooks.Delivery) error {
+		sent = append(sent, string(webhooks.Message("adscraper@example.com", to, dl)))
+		return nil
+	}
+	r, _ := d.Run(time.Now().Add(time.Hour))
+
+	// A server that never answers, connections only wait in its backlog
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	m := &webhooks.Mailer{Addr: l.Addr().String(), From: "adscraper@example.com", Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	timedOut := m.Send("alerts@reebok.com", &webhooks.Delivery{Event: webhooks.BrandBidding, Payload: "{}"})
+	took := time.Since(start)
+
+	testCases := []struct {
+		want interface{}
+		got  interface{}
+	}{
+		{"delivered 0 events, 1 to retry, 0 failed", unconfigured.String()},
+		{"delivered 1 events, 0 to retry, 0 failed", r.String()},
+		{1, len(sent)},
+		{true, len(sent) == 1 && strings.Contains(sent[0], "To: alerts@reebok.com\r\n")},
+		{true, len(sent) == 1 && strings.Contains(sent[0], "Subject: nike.com is bidding on reebok.com's brand for \"reebok women shoes\"\r\n")},
+		{true, len(sent) == 1 && strings.Contains(sent[0], "\r\n\r\n{\n  \"type\": \"brand.bidding\",")},
+		{true, timedOut != nil},
+		{true, took < time.Second},
+	}
+	for i, tc := range testCases {
+		if fmt.Sprint(tc.want) != fmt.Sprint(tc.got) {
+			t.Errorf("(%v) Expected %v, got %v", i, tc.want, tc.got)
+		}
+	}
+}